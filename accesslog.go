@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+// AccessLogFileConfig writes the access log as JSON lines to Path instead
+// of the server log, for shipping to Loki or Elasticsearch. Once the file
+// would grow past MaxSizeMB it is renamed to Path.1, older files move up
+// one number, and only MaxBackups of them are kept.
+type AccessLogFileConfig struct {
+	Path       string `json:"path"`
+	MaxSizeMB  int    `json:"max_size_mb"`
+	MaxBackups int    `json:"max_backups"`
+}
+
+// AccessLogEntry is one request in the access log. Upstream is nil when
+// the site scraper doesn't count its upstream requests.
+type AccessLogEntry struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	DurationMS float64   `json:"duration_ms"`
+	Bytes      int64     `json:"bytes"`
+	Upstream   *int64    `json:"upstream_requests"`
+}
+
+// logAccessText writes entry to the server log.
+func logAccessText(entry AccessLogEntry) {
+	upstream := "-"
+	if entry.Upstream != nil {
+		upstream = strconv.FormatInt(*entry.Upstream, 10)
+	}
+	duration := time.Duration(entry.DurationMS * float64(time.Millisecond)).Round(time.Microsecond)
+	log.Printf("access: [%s] %s %s %d %s %dB upstream=%s", entry.RequestID, entry.Method, entry.Path, entry.Status, duration, entry.Bytes, upstream)
+}
+
+// rotatingFile is an append-only file that is rotated by size.
+type rotatingFile struct {
+	config AccessLogFileConfig
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(config AccessLogFileConfig) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(config.Path), 0o755); err != nil {
+		return nil, err
+	}
+	f := &rotatingFile{config: config}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.config.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// rotate moves the current file to Path.1, shifting older ones up and
+// dropping the oldest, and starts a new file. The caller holds f.mu.
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	os.Remove(fmt.Sprintf("%s.%d", f.config.Path, f.config.MaxBackups))
+	for i := f.config.MaxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", f.config.Path, i), fmt.Sprintf("%s.%d", f.config.Path, i+1))
+	}
+	if f.config.MaxBackups > 0 {
+		if err := os.Rename(f.config.Path, f.config.Path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(f.config.Path); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.size > 0 && f.size+int64(len(p)) > int64(f.config.MaxSizeMB)<<20 {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// jsonAccessLog returns a sink writing entries to file as JSON lines.
+func jsonAccessLog(file *rotatingFile) func(AccessLogEntry) {
+	return func(entry AccessLogEntry) {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			log.Printf("access log: %v", err)
+			return
+		}
+		if _, err := file.Write(append(line, '\n')); err != nil {
+			log.Printf("access log: %v", err)
+		}
+	}
+}
+
+// accessLogWriter records the status and body size of a response.
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *accessLogWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack hands the connection over for a WebSocket, which is logged as
+// switching protocols.
+func (w *accessLogWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return hijack(w.ResponseWriter)
+}
+
+// accessLogMiddleware passes every request to sink: its ID, method, path,
+// status, duration, bytes written after compression, and the upstream
+// requests made for it. Sites that can bind their scraper to a request
+// count those exactly; for others the requests made while it was served
+// are counted, including those of requests served at the same time and of
+// crawls running meanwhile.
+func accessLogMiddleware(sink func(AccessLogEntry)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			trace := partasala.FetchTraceFrom(r.Context())
+			if _, ok := scraper.(ContextBinder); !ok {
+				trace = nil
+			}
+			counter, _ := scraper.(TrafficCounter)
+			var before int64
+			if counter != nil {
+				before = counter.UpstreamRequests()
+			}
+			started := time.Now()
+			lw := &accessLogWriter{ResponseWriter: w}
+			next.ServeHTTP(lw, r)
+
+			entry := AccessLogEntry{
+				Time:       started.UTC(),
+				RequestID:  requestID(r),
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				Status:     lw.status,
+				DurationMS: float64(time.Since(started).Microseconds()) / 1000,
+				Bytes:      lw.bytes,
+			}
+			if entry.Status == 0 {
+				entry.Status = http.StatusOK
+			}
+			if trace != nil {
+				upstream := trace.Fetches()
+				entry.Upstream = &upstream
+			} else if counter != nil {
+				upstream := counter.UpstreamRequests() - before
+				entry.Upstream = &upstream
+			}
+			sink(entry)
+		})
+	}
+}
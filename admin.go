@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// requestKey returns the API key or token r was sent with, passed as
+// "Authorization: Bearer <key>", "X-API-Key: <key>", or as the password of
+// HTTP basic auth, or "" if none.
+func requestKey(r *http.Request) string {
+	// Browsers send the key as the password of HTTP basic auth, so the
+	// dashboard can be opened without extensions
+	provided := r.Header.Get("X-API-Key")
+	if _, password, ok := r.BasicAuth(); ok {
+		provided = password
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		provided = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return provided
+}
+
+type SelfCheckResult struct {
+	Parser     string `json:"parser"`
+	Target     string `json:"target"`
+	Passed     bool   `json:"passed"`
+	DurationMS int64  `json:"duration_ms"`
+	Count      int    `json:"count"`
+	Error      string `json:"error,omitempty"`
+}
+
+type SelfCheckResponse struct {
+	Success bool              `json:"success"`
+	Checks  []SelfCheckResult `json:"checks"`
+}
+
+// selfCheckHandler scrapes the homepage, one brand, and one car live and
+// checks that each parser still fills in the fields it is expected to. The
+// brand and car come from the selfcheck config, or else the first brand and
+// car found.
+func selfCheckHandler(w http.ResponseWriter, r *http.Request) {
+	checks := []SelfCheckResult{}
+	brandSlug := selfCheckConfig.Brand
+	carSlug := selfCheckConfig.Car
+	site := siteFor(r)
+
+	start := time.Now()
+	brands, err := site.GetBrands()
+	result := SelfCheckResult{Parser: "brands", Target: "homepage", Count: len(brands)}
+	if err == nil {
+		err = checkFields(len(brands), func(i int) []string {
+			return []string{brands[i].Name, brands[i].Slug, brands[i].URL}
+		})
+	}
+	checks = append(checks, finishCheck(result, start, err))
+	if brandSlug == "" && len(brands) > 0 {
+		brandSlug = brands[0].Slug
+	}
+
+	if brandSlug != "" {
+		start = time.Now()
+		cars, err := site.GetBrandCars(brandSlug)
+		result = SelfCheckResult{Parser: "brand_cars", Target: brandSlug, Count: len(cars)}
+		if err == nil {
+			err = checkFields(len(cars), func(i int) []string {
+				return []string{cars[i].Name, cars[i].Slug, cars[i].URL}
+			})
+		}
+		checks = append(checks, finishCheck(result, start, err))
+		if carSlug == "" && len(cars) > 0 {
+			carSlug = cars[0].Slug
+		}
+	}
+
+	if carSlug != "" {
+		start = time.Now()
+		details, err := site.GetCarDetails(carSlug)
+		result = SelfCheckResult{Parser: "car_details", Target: carSlug}
+		if err == nil {
+			result.Count = details.ImageCount
+			switch {
+			case details.Name == "":
+				err = fmt.Errorf("name is empty")
+			case details.Brand == nil || *details.Brand == "":
+				err = fmt.Errorf("brand is empty")
+			case details.Description == nil || *details.Description == "":
+				err = fmt.Errorf("description is empty")
+			case details.ImageCount == 0:
+				err = fmt.Errorf("no images found")
+			}
+		}
+		checks = append(checks, finishCheck(result, start, err))
+	}
+
+	success := len(checks) == 3
+	for _, check := range checks {
+		success = success && check.Passed
+	}
+	if !success {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(SelfCheckResponse{
+		Success: success,
+		Checks:  checks,
+	})
+}
+
+// checkFields fails if there are no items or any item has an empty field.
+func checkFields(count int, fields func(i int) []string) error {
+	if count == 0 {
+		return fmt.Errorf("parsed to zero items")
+	}
+	for i := 0; i < count; i++ {
+		for _, field := range fields(i) {
+			if field == "" {
+				return fmt.Errorf("item %d has an empty field", i)
+			}
+		}
+	}
+	return nil
+}
+
+func finishCheck(result SelfCheckResult, start time.Time, err error) SelfCheckResult {
+	result.DurationMS = time.Since(start).Milliseconds()
+	result.Passed = err == nil
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
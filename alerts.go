@@ -0,0 +1,426 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+	"github.com/gorilla/mux"
+)
+
+// Alert is a saved search that is evaluated against newly listed cars.
+// Owner is the hash of the API token that saved it.
+type Alert struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"-"`
+	Query     string    `json:"q"`
+	Brand     string    `json:"brand,omitempty"`
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type AlertMatch struct {
+	Car       Car       `json:"car"`
+	MatchedAt time.Time `json:"matched_at"`
+}
+
+// CarFilter selects cars by a search query and an optional brand slug.
+type CarFilter struct {
+	Query string `json:"q"`
+	Brand string `json:"brand,omitempty"`
+}
+
+// Matches reports whether car satisfies the filter's brand and its query,
+// parsed the way /search parses it. An empty query matches every car.
+func (f CarFilter) Matches(car Car) bool {
+	if f.Brand != "" && !strings.EqualFold(f.Brand, car.Brand) {
+		return false
+	}
+	if strings.TrimSpace(f.Query) == "" {
+		return true
+	}
+	return partasala.ParseQuery(f.Query, searchSynonyms, searchFolding).MatchCar(car.Brand, car) != ""
+}
+
+// Matches reports whether car satisfies the alert's query and brand filter.
+func (a Alert) Matches(car Car) bool {
+	return CarFilter{Query: a.Query, Brand: a.Brand}.Matches(car)
+}
+
+const (
+	bucketAlerts = "alerts"
+	// bucketAlertMatches is keyed by alert id and car slug
+	bucketAlertMatches = "alert_matches"
+)
+
+// storedAlert is an Alert as it is kept in the store, owner and all.
+type storedAlert struct {
+	Alert
+	Owner string `json:"owner"`
+}
+
+// AlertStore keeps saved searches and their matches in the store.
+type AlertStore struct {
+	store Store
+
+	// mu keeps Evaluate from matching a car twice, or to an alert being
+	// deleted
+	mu sync.Mutex
+}
+
+func NewAlertStore(store Store) *AlertStore {
+	return &AlertStore{store: store}
+}
+
+func (s *AlertStore) Add(owner, query, brand, email string) (Alert, error) {
+	id, err := newID()
+	if err != nil {
+		return Alert{}, err
+	}
+
+	alert := Alert{
+		ID:        id,
+		Owner:     owner,
+		Query:     query,
+		Brand:     brand,
+		Email:     email,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := putJSON(s.store, bucketAlerts, id, storedAlert{Alert: alert, Owner: owner}); err != nil {
+		return Alert{}, err
+	}
+	return alert, nil
+}
+
+// Get returns the alert with id, or ErrNotFound.
+func (s *AlertStore) Get(id string) (Alert, error) {
+	var stored storedAlert
+	if err := getJSON(s.store, bucketAlerts, id, &stored); err != nil {
+		return Alert{}, err
+	}
+	alert := stored.Alert
+	alert.Owner = stored.Owner
+	return alert, nil
+}
+
+// List returns the alerts of owner, oldest first.
+func (s *AlertStore) List(owner string) ([]Alert, error) {
+	all, err := s.all()
+	alerts := []Alert{}
+	for _, alert := range all {
+		if alert.Owner == owner {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts, err
+}
+
+// Len returns how many alerts there are, of every owner.
+func (s *AlertStore) Len() (int, error) {
+	all, err := s.all()
+	return len(all), err
+}
+
+// all returns every alert, oldest first.
+func (s *AlertStore) all() ([]Alert, error) {
+	alerts := []Alert{}
+	err := s.store.ForEach(bucketAlerts, func(key string, value []byte) error {
+		var stored storedAlert
+		if err := json.Unmarshal(value, &stored); err != nil {
+			return fmt.Errorf("alert %s: %v", key, err)
+		}
+		alert := stored.Alert
+		alert.Owner = stored.Owner
+		alerts = append(alerts, alert)
+		return nil
+	})
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].CreatedAt.Before(alerts[j].CreatedAt)
+	})
+	return alerts, err
+}
+
+// Delete deletes the alert with id and its matches, or returns
+// ErrNotFound.
+func (s *AlertStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.store.Get(bucketAlerts, id); err != nil {
+		return err
+	}
+	matches := []string{}
+	err := s.store.ForEach(bucketAlertMatches, func(key string, value []byte) error {
+		if strings.HasPrefix(key, id+"/") {
+			matches = append(matches, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.store.Batch(bucketAlertMatches, nil, matches); err != nil {
+		return err
+	}
+	return s.store.Delete(bucketAlerts, id)
+}
+
+// DeleteOwner deletes every alert of owner.
+func (s *AlertStore) DeleteOwner(owner string) error {
+	alerts, err := s.List(owner)
+	if err != nil {
+		return err
+	}
+	for _, alert := range alerts {
+		if err := s.Delete(alert.ID); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// Matches returns the matches of the alert with id, oldest first.
+func (s *AlertStore) Matches(id string) ([]AlertMatch, error) {
+	matches := []AlertMatch{}
+	err := s.store.ForEach(bucketAlertMatches, func(key string, value []byte) error {
+		if !strings.HasPrefix(key, id+"/") {
+			return nil
+		}
+		var match AlertMatch
+		if err := json.Unmarshal(value, &match); err != nil {
+			return fmt.Errorf("alert match %s: %v", key, err)
+		}
+		matches = append(matches, match)
+		return nil
+	})
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].MatchedAt.Before(matches[j].MatchedAt)
+	})
+	return matches, err
+}
+
+// AlertNotification pairs an alert with a car that newly matched it.
+type AlertNotification struct {
+	Alert Alert
+	Car   Car
+}
+
+// Evaluate records a match for every alert satisfied by one of cars and
+// returns the new matches. A car is only recorded once per alert, even if it
+// is relisted later. On an error, the matches recorded before it are
+// returned with it.
+func (s *AlertStore) Evaluate(cars []Car) ([]AlertNotification, error) {
+	now := time.Now().UTC()
+	notifications := []AlertNotification{}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alerts, err := s.all()
+	if err != nil {
+		return notifications, err
+	}
+	for _, alert := range alerts {
+		puts := map[string][]byte{}
+		var matched []AlertNotification
+		for _, car := range cars {
+			key := alert.ID + "/" + car.Slug
+			if !alert.Matches(car) || puts[key] != nil {
+				continue
+			}
+			if _, err := s.store.Get(bucketAlertMatches, key); err != ErrNotFound {
+				if err != nil {
+					return notifications, err
+				}
+				continue
+			}
+			data, err := json.Marshal(AlertMatch{Car: car, MatchedAt: now})
+			if err != nil {
+				return notifications, err
+			}
+			puts[key] = data
+			matched = append(matched, AlertNotification{Alert: alert, Car: car})
+		}
+		if len(puts) == 0 {
+			continue
+		}
+		if err := s.store.Batch(bucketAlertMatches, puts, nil); err != nil {
+			return notifications, err
+		}
+		notifications = append(notifications, matched...)
+	}
+	return notifications, nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+type AlertMatchesResponse struct {
+	Success bool         `json:"success"`
+	Alert   Alert        `json:"alert"`
+	Count   int          `json:"count"`
+	Data    []AlertMatch `json:"data"`
+}
+
+func createAlertHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query string `json:"q"`
+		Brand string `json:"brand"`
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "Request body must be JSON with a \"q\" field",
+		})
+		return
+	}
+
+	req.Query = strings.TrimSpace(req.Query)
+	if req.Query == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "Missing search query field \"q\"",
+		})
+		return
+	}
+
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Email != "" {
+		if mailer == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Error:   "Email notifications are not configured on this server",
+			})
+			return
+		}
+		addr, err := mail.ParseAddress(req.Email)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Error:   "Invalid email address",
+			})
+			return
+		}
+		// A display name, as in "Jón" <jon@example.is>, is dropped; the
+		// bare address is what goes to the mail server
+		req.Email = addr.Address
+	}
+
+	alert, err := alerts.Add(requestOwner(r), req.Query, strings.TrimSpace(req.Brand), req.Email)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	startCrawler()
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Data:    alert,
+	})
+}
+
+func listAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := alerts.List(requestOwner(r))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Count:   len(list),
+		Data:    list,
+	})
+}
+
+// ownAlert returns the alert with the request's id, or writes a 404 if
+// there is none. Other tokens' alerts are answered as if they didn't exist.
+func ownAlert(w http.ResponseWriter, r *http.Request) (Alert, bool) {
+	alert, err := alerts.Get(mux.Vars(r)["id"])
+	if err == ErrNotFound || err == nil && alert.Owner != requestOwner(r) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "Alert not found",
+		})
+		return alert, false
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return alert, false
+	}
+	return alert, true
+}
+
+func deleteAlertHandler(w http.ResponseWriter, r *http.Request) {
+	alert, ok := ownAlert(w, r)
+	if !ok {
+		return
+	}
+	if err := alerts.Delete(alert.ID); err != nil && err != ErrNotFound {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+	})
+}
+
+func getAlertMatchesHandler(w http.ResponseWriter, r *http.Request) {
+	alert, ok := ownAlert(w, r)
+	if !ok {
+		return
+	}
+
+	matches, err := alerts.Matches(alert.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(rewriteImageURLs(AlertMatchesResponse{
+		Success: true,
+		Alert:   alert,
+		Count:   len(matches),
+		Data:    matches,
+	}))
+}
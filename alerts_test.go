@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+// TestCarFilterMatches checks that saved searches match cars the way
+// /search does.
+func TestCarFilterMatches(t *testing.T) {
+	year := 2014
+	car := Car{Name: "TOYOTA YARIS HYBRID", Slug: "toyota-yaris-hybrid", Brand: "toyota", Year: &year}
+	tests := []struct {
+		filter CarFilter
+		want   bool
+	}{
+		{CarFilter{}, true},
+		{CarFilter{Brand: "toyota"}, true},
+		{CarFilter{Brand: "audi"}, false},
+		{CarFilter{Query: "yaris"}, true},
+		{CarFilter{Query: "toyota"}, true},
+		{CarFilter{Query: "hybrid yaris"}, true},
+		{CarFilter{Query: "yaris 2014"}, true},
+		{CarFilter{Query: "yaris 2015"}, false},
+		{CarFilter{Query: `"hybrid yaris"`}, false},
+		{CarFilter{Query: "corolla OR yaris"}, true},
+		{CarFilter{Query: "yaris", Brand: "audi"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Matches(car); got != tt.want {
+			t.Errorf("%+v.Matches(%q) = %v, want %v", tt.filter, car.Name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const bucketAnalytics = "analytics"
+
+const (
+	// analyticsRetentionDays is how long daily request counts are kept.
+	analyticsRetentionDays = 90
+	defaultAnalyticsDays   = 7
+)
+
+// The names requests are counted under when they weren't made with an
+// issued token.
+const (
+	analyticsAdminKey = "admin"
+	analyticsNoKey    = "anonymous"
+)
+
+// RequestCounts are the requests counted for a day, endpoint, or key.
+type RequestCounts struct {
+	Requests     int64 `json:"requests"`
+	ClientErrors int64 `json:"client_errors"`
+	ServerErrors int64 `json:"server_errors"`
+	// DurationMS is the time spent serving them all, for the average
+	DurationMS float64 `json:"duration_ms"`
+}
+
+func (c *RequestCounts) add(other RequestCounts) {
+	c.Requests += other.Requests
+	c.ClientErrors += other.ClientErrors
+	c.ServerErrors += other.ServerErrors
+	c.DurationMS += other.DurationMS
+}
+
+// Analytics counts the API's requests per UTC day, endpoint, and API key.
+// Like UsageMeter it keeps today's counts in memory and saves them to the
+// store from Run, so a restart loses at most one interval of them. Counts
+// are keyed by the day, the endpoint's method and route, and the key, e.g.
+// "2024-05-01 GET /cars/{car_slug} anonymous".
+type Analytics struct {
+	store Store
+
+	mu     sync.Mutex
+	day    string
+	counts map[string]RequestCounts
+	dirty  map[string]bool
+}
+
+var analytics *Analytics
+
+func NewAnalytics(store Store) *Analytics {
+	return &Analytics{store: store, counts: map[string]RequestCounts{}, dirty: map[string]bool{}}
+}
+
+// cell returns the counts under key, reading them from the store the
+// first time. The caller holds a.mu.
+func (a *Analytics) cell(key string) RequestCounts {
+	if c, ok := a.counts[key]; ok {
+		return c
+	}
+	var c RequestCounts
+	if err := getJSON(a.store, bucketAnalytics, key, &c); err != nil && err != ErrNotFound {
+		log.Printf("analytics: %v", err)
+	}
+	a.counts[key] = c
+	return c
+}
+
+// forget drops the counts in memory, flushed or not, for when the stored
+// ones are replaced, as by a restore.
+func (a *Analytics) forget() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.counts, a.dirty = map[string]RequestCounts{}, map[string]bool{}
+}
+
+// Record counts a request to endpoint with key that was answered with
+// status after duration.
+func (a *Analytics) Record(endpoint, key string, status int, duration time.Duration, now time.Time) {
+	cell := now.UTC().Format(usageDayLayout) + " " + endpoint + " " + key
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	c := a.cell(cell)
+	c.Requests++
+	if status >= 500 {
+		c.ServerErrors++
+	} else if status >= 400 {
+		c.ClientErrors++
+	}
+	c.DurationMS += float64(duration.Microseconds()) / 1000
+	a.counts[cell] = c
+	a.dirty[cell] = true
+}
+
+// AnalyticsRow is one line of a breakdown: the counts of a day, endpoint,
+// or key.
+type AnalyticsRow struct {
+	Name string `json:"name"`
+	RequestCounts
+	// AverageMS is DurationMS over Requests
+	AverageMS float64 `json:"average_ms"`
+}
+
+// AnalyticsReport breaks the requests of a span of days down by day,
+// endpoint, and key. Days run oldest first; endpoints and keys most
+// requests first.
+type AnalyticsReport struct {
+	From       string         `json:"from"`
+	To         string         `json:"to"`
+	Total      AnalyticsRow   `json:"total"`
+	ByDay      []AnalyticsRow `json:"by_day"`
+	ByEndpoint []AnalyticsRow `json:"by_endpoint"`
+	ByKey      []AnalyticsRow `json:"by_key"`
+}
+
+// AnalyticsFilter narrows a report to one endpoint or key; empty fields
+// match everything.
+type AnalyticsFilter struct {
+	Endpoint string
+	Key      string
+}
+
+// Report breaks down the requests of the last days days up to now, today
+// included, that match filter.
+func (a *Analytics) Report(days int, filter AnalyticsFilter, now time.Time) (*AnalyticsReport, error) {
+	to := now.UTC()
+	from := to.AddDate(0, 0, -(days - 1)).Format(usageDayLayout)
+	byDay := map[string]*RequestCounts{}
+	for i := days - 1; i >= 0; i-- {
+		byDay[to.AddDate(0, 0, -i).Format(usageDayLayout)] = &RequestCounts{}
+	}
+	byEndpoint := map[string]*RequestCounts{}
+	byKey := map[string]*RequestCounts{}
+	var total RequestCounts
+
+	count := func(cell string, c RequestCounts) {
+		day, endpoint, key, ok := splitAnalyticsKey(cell)
+		if !ok || day < from || (filter.Endpoint != "" && endpoint != filter.Endpoint) || (filter.Key != "" && key != filter.Key) {
+			return
+		}
+		addCounts(byDay, day, c)
+		addCounts(byEndpoint, endpoint, c)
+		addCounts(byKey, key, c)
+		total.add(c)
+	}
+
+	a.mu.Lock()
+	err := a.store.ForEach(bucketAnalytics, func(cell string, value []byte) error {
+		if _, ok := a.counts[cell]; ok {
+			return nil
+		}
+		var c RequestCounts
+		if err := json.Unmarshal(value, &c); err != nil {
+			return fmt.Errorf("analytics %s: %v", cell, err)
+		}
+		count(cell, c)
+		return nil
+	})
+	for cell, c := range a.counts {
+		count(cell, c)
+	}
+	a.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &AnalyticsReport{
+		From:       from,
+		To:         to.Format(usageDayLayout),
+		Total:      analyticsRow("total", total),
+		ByDay:      analyticsRows(byDay),
+		ByEndpoint: analyticsRows(byEndpoint),
+		ByKey:      analyticsRows(byKey),
+	}
+	sort.Slice(report.ByDay, func(i, j int) bool { return report.ByDay[i].Name < report.ByDay[j].Name })
+	return report, nil
+}
+
+func addCounts(breakdown map[string]*RequestCounts, name string, c RequestCounts) {
+	if breakdown[name] == nil {
+		breakdown[name] = &RequestCounts{}
+	}
+	breakdown[name].add(c)
+}
+
+// splitAnalyticsKey splits a store key into its day, endpoint, and key.
+func splitAnalyticsKey(cell string) (day, endpoint, key string, ok bool) {
+	fields := strings.Split(cell, " ")
+	if len(fields) != 4 {
+		return "", "", "", false
+	}
+	return fields[0], fields[1] + " " + fields[2], fields[3], true
+}
+
+func analyticsRow(name string, c RequestCounts) AnalyticsRow {
+	row := AnalyticsRow{Name: name, RequestCounts: c}
+	if c.Requests > 0 {
+		row.AverageMS = c.DurationMS / float64(c.Requests)
+	}
+	return row
+}
+
+// analyticsRows returns the rows of a breakdown, most requests first.
+func analyticsRows(counts map[string]*RequestCounts) []AnalyticsRow {
+	rows := make([]AnalyticsRow, 0, len(counts))
+	for name, c := range counts {
+		rows = append(rows, analyticsRow(name, *c))
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Requests != rows[j].Requests {
+			return rows[i].Requests > rows[j].Requests
+		}
+		return rows[i].Name < rows[j].Name
+	})
+	return rows
+}
+
+// Flush saves the counts changed since the last flush. Once a day it also
+// drops past days from memory and deletes counts older than
+// analyticsRetentionDays from the store.
+func (a *Analytics) Flush(now time.Time) error {
+	today := now.UTC().Format(usageDayLayout)
+	a.mu.Lock()
+	puts := make(map[string][]byte, len(a.dirty))
+	for cell := range a.dirty {
+		data, err := json.Marshal(a.counts[cell])
+		if err != nil {
+			a.mu.Unlock()
+			return err
+		}
+		puts[cell] = data
+	}
+	a.dirty = map[string]bool{}
+	newDay := a.day != today
+	if newDay {
+		a.day = today
+		for cell := range a.counts {
+			if !strings.HasPrefix(cell, today+" ") {
+				delete(a.counts, cell)
+			}
+		}
+	}
+	a.mu.Unlock()
+
+	var deletes []string
+	if newDay {
+		oldest := now.UTC().AddDate(0, 0, -analyticsRetentionDays).Format(usageDayLayout)
+		err := a.store.ForEach(bucketAnalytics, func(cell string, value []byte) error {
+			if day, _, _ := strings.Cut(cell, " "); day < oldest {
+				deletes = append(deletes, cell)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(puts) == 0 && len(deletes) == 0 {
+		return nil
+	}
+	return a.store.Batch(bucketAnalytics, puts, deletes)
+}
+
+// Run flushes the counts every interval until ctx is cancelled.
+func (a *Analytics) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := a.Flush(now); err != nil {
+				log.Printf("analytics: %v", err)
+				recent.addError("analytics", err)
+			}
+		}
+	}
+}
+
+// analyticsMiddleware records every request the router matches under its
+// route, e.g. "GET /cars/{car_slug}", and the key it was made with: the
+// token's id, "admin" for the admin API key, or "anonymous". Requests to
+// unknown paths aren't counted, so probes don't fill the store.
+func analyticsMiddleware(adminKey string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started := time.Now()
+			lw := &accessLogWriter{ResponseWriter: w}
+			next.ServeHTTP(lw, r)
+
+			endpoint := r.URL.Path
+			if route := mux.CurrentRoute(r); route != nil {
+				if template, err := route.GetPathTemplate(); err == nil {
+					endpoint = template
+				}
+			}
+			key := analyticsNoKey
+			if _, _, id, ok, _ := meteredKey(r, adminKey); ok {
+				key = id
+				if id == "" {
+					key = analyticsAdminKey
+				}
+			}
+			status := lw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			analytics.Record(r.Method+" "+endpoint, key, status, time.Since(started), started)
+		})
+	}
+}
+
+// analyticsHandler serves GET /admin/analytics.
+func analyticsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	days := defaultAnalyticsDays
+	if v := query.Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > analyticsRetentionDays {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Error:   "days must be between 1 and " + strconv.Itoa(analyticsRetentionDays),
+			})
+			return
+		}
+		days = n
+	}
+
+	report, err := analytics.Report(days, AnalyticsFilter{Endpoint: query.Get("endpoint"), Key: query.Get("key")}, time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Data:    report,
+	})
+}
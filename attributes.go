@@ -0,0 +1,24 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var enginePattern = regexp.MustCompile(`(?i)\b(\d{3,4})\s?cc\b|\b(\d[.,]\d)\s?(l|lítra|litra)\b`)
+
+// extractEngine returns the engine size found in text, e.g. "1400cc" or "1.4l".
+func extractEngine(text string) *string {
+	match := enginePattern.FindStringSubmatch(text)
+	if match == nil {
+		return nil
+	}
+
+	var engine string
+	if match[1] != "" {
+		engine = match[1] + "cc"
+	} else {
+		engine = strings.Replace(match[2], ",", ".", 1) + "l"
+	}
+	return &engine
+}
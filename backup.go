@@ -0,0 +1,214 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const backupVersion = 1
+
+// BackupManifest is the first entry of a backup archive.
+type BackupManifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Buckets   []string  `json:"buckets"`
+}
+
+// WriteBackup writes every bucket of store to w as a gzipped tar archive: a
+// manifest.json followed by buckets/<name>.json holding each bucket's keys
+// and values.
+func WriteBackup(store Store, w io.Writer) error {
+	buckets, err := store.Buckets()
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now().UTC()
+
+	manifest, err := json.Marshal(BackupManifest{Version: backupVersion, CreatedAt: now, Buckets: buckets})
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, "manifest.json", manifest, now); err != nil {
+		return err
+	}
+
+	for _, bucket := range buckets {
+		entries := map[string]json.RawMessage{}
+		err := store.ForEach(bucket, func(key string, value []byte) error {
+			entries[key] = append(json.RawMessage{}, value...)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(entries)
+		if err != nil {
+			return err
+		}
+		if err := writeTarFile(tw, "buckets/"+bucket+".json", data, now); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// backupError is a restore refused for what is in the archive, rather
+// than for the store failing.
+type backupError struct {
+	message string
+}
+
+func (e *backupError) Error() string {
+	return e.message
+}
+
+func invalidBackup(format string, args ...interface{}) error {
+	return &backupError{message: fmt.Sprintf(format, args...)}
+}
+
+// RestoreBackup replaces the contents of store with an archive written by
+// WriteBackup, migrated to the current schema. Buckets missing from the
+// archive are emptied. The archive is read and migrated completely before
+// the store is replaced, in one transaction, so a failed restore leaves the
+// store as it was. Errors about the archive are *backupError.
+func RestoreBackup(store Store, r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return invalidBackup("backup is not gzipped: %v", err)
+	}
+	tr := tar.NewReader(gz)
+
+	var manifest *BackupManifest
+	contents := map[string]map[string]json.RawMessage{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return invalidBackup("failed to read backup: %v", err)
+		}
+
+		switch {
+		case header.Name == "manifest.json":
+			manifest = &BackupManifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return invalidBackup("invalid manifest: %v", err)
+			}
+		case strings.HasPrefix(header.Name, "buckets/") && strings.HasSuffix(header.Name, ".json"):
+			bucket := strings.TrimSuffix(strings.TrimPrefix(header.Name, "buckets/"), ".json")
+			entries := map[string]json.RawMessage{}
+			if err := json.NewDecoder(tr).Decode(&entries); err != nil {
+				return invalidBackup("invalid bucket %s: %v", bucket, err)
+			}
+			contents[bucket] = entries
+		}
+	}
+
+	if manifest == nil {
+		return invalidBackup("backup has no manifest.json")
+	}
+	if manifest.Version < 1 || manifest.Version > backupVersion {
+		return invalidBackup("unsupported backup version %d (this build reads up to %d)", manifest.Version, backupVersion)
+	}
+
+	// Backups from older builds come with an older schema, so the archive
+	// is migrated on its own before it replaces the store in one go
+	restored := NewMemoryStore()
+	for bucket, entries := range contents {
+		for key, value := range entries {
+			restored.Put(bucket, key, value)
+		}
+	}
+	if err := Migrate(restored); err != nil {
+		return invalidBackup("backup does not migrate: %v", err)
+	}
+	return store.Replace(restored.buckets)
+}
+
+func backupHandler(w http.ResponseWriter, r *http.Request) {
+	filename := fmt.Sprintf("partasala-backup-%s.tar.gz", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	// Errors after the first byte can't change the status any more; the
+	// archive is then truncated and fails to extract
+	if err := WriteBackup(dataset.store, w); err != nil {
+		log.Printf("backup: %v", err)
+	}
+}
+
+// maxBackupSize caps the archives restoreHandler reads, which are held in
+// memory whole.
+const maxBackupSize = 512 << 20
+
+func restoreHandler(w http.ResponseWriter, r *http.Request) {
+	err := RestoreBackup(dataset.store, http.MaxBytesReader(w, r.Body, maxBackupSize))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if _, ok := err.(*backupError); ok {
+			status = http.StatusBadRequest
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	forgetRestored()
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+	})
+}
+
+// forgetRestored drops what is held in memory about the store's contents
+// after a restore has replaced them, so it is read again from the store
+// rather than diffed against or flushed over the restored data. The next
+// crawl records a new baseline.
+func forgetRestored() {
+	dataset.forgetSightings()
+	dataset.forgetEnrichments()
+	crawler.forget()
+	usage.forget()
+	analytics.forget()
+	popularity.forget()
+	webhooks.forgetDeliveries()
+	searches.clear()
+	heads.clear()
+	if crawling.Load() {
+		if err := searchIndex.Rebuild(dataset); err != nil {
+			log.Printf("search index: %v", err)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// basePath is the URL prefix the API is served under, such as
+// "/api/partasala" behind a shared reverse proxy, or "" at the root.
+var basePath string
+
+// link returns the URL of path, a route like "/ui", under basePath, for
+// links and redirects the API hands out.
+func link(path string) string {
+	return basePath + path
+}
+
+// stripBasePath serves the requests under prefix with it taken off their
+// path, so routes are declared without it, and answers 404 to the rest.
+func stripBasePath(prefix string, next http.Handler) http.Handler {
+	if prefix == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = strings.TrimPrefix(path, prefix)
+		if r2.URL.Path == "" {
+			r2.URL.Path = "/"
+		}
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
+	})
+}
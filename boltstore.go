@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltStore keeps buckets in an embedded bbolt database file, for single
+// binary deployments that outgrow the JSON file store.
+type BoltStore struct {
+	path string
+
+	// mu guards db, which Compact swaps for a rewritten file
+	mu sync.RWMutex
+	db *bolt.DB
+}
+
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := openBolt(path)
+	if err != nil {
+		return nil, err
+	}
+	return &BoltStore{path: path, db: db}, nil
+}
+
+func openBolt(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt store %s: %v", path, err)
+	}
+	return db, nil
+}
+
+func (s *BoltStore) Get(bucket, key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return ErrNotFound
+		}
+		v := b.Get([]byte(key))
+		if v == nil {
+			return ErrNotFound
+		}
+		// Values are only valid inside the transaction
+		value = append([]byte{}, v...)
+		return nil
+	})
+	return value, err
+}
+
+func (s *BoltStore) Put(bucket, key string, value []byte) error {
+	return s.Batch(bucket, map[string][]byte{key: value}, nil)
+}
+
+func (s *BoltStore) Delete(bucket, key string) error {
+	return s.Batch(bucket, nil, []string{key})
+}
+
+func (s *BoltStore) Batch(bucket string, puts map[string][]byte, deletes []string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		for _, key := range deletes {
+			if err := b.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		for key, value := range puts {
+			if err := b.Put([]byte(key), value); err != nil {
+				return err
+			}
+		}
+		// Keep Buckets() limited to non-empty buckets like the other backends
+		if k, _ := b.Cursor().First(); k == nil {
+			return tx.DeleteBucket([]byte(bucket))
+		}
+		return nil
+	})
+}
+
+// ForEach copies the bucket out of the transaction before calling fn, so fn
+// may write to the store.
+func (s *BoltStore) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	s.mu.RLock()
+	var keys []string
+	var values [][]byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			values = append(values, append([]byte{}, v...))
+			return nil
+		})
+	})
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	for i, key := range keys {
+		if err := fn(key, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *BoltStore) Buckets() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	buckets := []string{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if k, _ := b.Cursor().First(); k != nil {
+				buckets = append(buckets, string(name))
+			}
+			return nil
+		})
+	})
+	return buckets, err
+}
+
+func (s *BoltStore) Replace(contents map[string]map[string][]byte) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var names [][]byte
+		err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			names = append(names, append([]byte{}, name...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+
+		for bucket, entries := range contents {
+			if len(entries) == 0 {
+				continue
+			}
+			b, err := tx.CreateBucket([]byte(bucket))
+			if err != nil {
+				return err
+			}
+			for key, value := range entries {
+				if err := b.Put([]byte(key), value); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// SizeBytes reports the size of the database file.
+func (s *BoltStore) SizeBytes() (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var size int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		return nil
+	})
+	return size, err
+}
+
+// Compact rewrites the database into a fresh file, returning the space that
+// bbolt keeps in its freelist after deletes to the filesystem. Other calls
+// wait until it is done.
+func (s *BoltStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmpPath := s.path + ".compact"
+	os.Remove(tmpPath)
+	dst, err := openBolt(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err := bolt.Compact(dst, s.db, 1<<20); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to compact bolt store: %v", err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := s.db.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	renameErr := os.Rename(tmpPath, s.path)
+	// Reopen whichever file is in place so the store stays usable
+	db, err := openBolt(s.path)
+	if err != nil {
+		return err
+	}
+	s.db = db
+	if renameErr != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace bolt store: %v", renameErr)
+	}
+	return nil
+}
+
+func (s *BoltStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Close()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"log"
+	"sort"
+)
+
+// brandAliases maps the slugs a brand is also listed under to its
+// canonical slug, e.g. "mercedes-benz" to "mercedes". Set from the
+// brand_aliases config option.
+var brandAliases map[string]string
+
+// canonicalBrand returns the slug slug is an alias of, or slug itself.
+func canonicalBrand(slug string) string {
+	if canonical, ok := brandAliases[slug]; ok {
+		return canonical
+	}
+	return slug
+}
+
+// brandGroup returns the canonical slug of slug's brand followed by its
+// aliases, sorted.
+func brandGroup(slug string) []string {
+	canonical := canonicalBrand(slug)
+	aliases := []string{}
+	for alias, target := range brandAliases {
+		if target == canonical && alias != canonical {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+	return append([]string{canonical}, aliases...)
+}
+
+// groupBrands folds aliased brands into their canonical brand, which lists
+// them in Aliases. A canonical brand the site doesn't list is stood in for
+// by its first alias, under the canonical slug.
+func groupBrands(brands []Brand) []Brand {
+	if len(brandAliases) == 0 {
+		return brands
+	}
+
+	listed := map[string]bool{}
+	for _, brand := range brands {
+		listed[brand.Slug] = true
+	}
+	grouped := []Brand{}
+	seen := map[string]bool{}
+	for _, brand := range brands {
+		canonical := canonicalBrand(brand.Slug)
+		if seen[canonical] {
+			continue
+		}
+		if brand.Slug != canonical {
+			if listed[canonical] {
+				continue
+			}
+			brand.Slug = canonical
+		}
+		seen[canonical] = true
+		grouped = append(grouped, brand)
+	}
+
+	for i := range grouped {
+		aliases := []string{}
+		for _, alias := range brandGroup(grouped[i].Slug)[1:] {
+			if listed[alias] {
+				aliases = append(aliases, alias)
+			}
+		}
+		if len(aliases) > 0 {
+			grouped[i].Aliases = aliases
+		}
+	}
+	return grouped
+}
+
+// groupedBrandCars fetches the cars of every slug in brandSlug's group with
+// fetch, merged under the canonical slug. A slug that fails is logged and
+// left out, as aliases come and go on the site; the first error is only
+// returned if every slug failed.
+func groupedBrandCars(brandSlug string, fetch func(slug string) ([]Car, error)) ([]Car, error) {
+	group := brandGroup(brandSlug)
+	if len(group) == 1 {
+		return fetch(group[0])
+	}
+
+	var firstErr error
+	failed := 0
+	cars := []Car{}
+	seen := map[string]bool{}
+	for _, slug := range group {
+		brandCars, err := fetch(slug)
+		if err != nil {
+			log.Printf("brand aliases: %s: %v", slug, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed++
+			continue
+		}
+		for _, car := range brandCars {
+			if seen[car.Slug] {
+				continue
+			}
+			seen[car.Slug] = true
+			car.Brand = group[0]
+			cars = append(cars, car)
+		}
+	}
+	if failed == len(group) {
+		return nil, firstErr
+	}
+	return cars, nil
+}
+
+// appendGroupRemoved adds the removed cars of every slug in brandSlug's
+// group to its listed cars, under the canonical slug.
+func appendGroupRemoved(cars []Car, brandSlug string) ([]Car, error) {
+	group := brandGroup(brandSlug)
+	listed := len(cars)
+	for _, slug := range group {
+		var err error
+		if cars, err = appendRemoved(cars, slug); err != nil {
+			return nil, err
+		}
+	}
+	for i := listed; i < len(cars); i++ {
+		cars[i].Brand = group[0]
+	}
+	return cars, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// BrandImage is an image of one of a brand's cars.
+type BrandImage struct {
+	Image
+	Car     string `json:"car"`
+	CarName string `json:"car_name"`
+}
+
+type BrandImagesResponse struct {
+	Success bool   `json:"success"`
+	Brand   string `json:"brand"`
+	Count   int    `json:"count"`
+	// Total is how many images the brand's cars have, of which Data holds
+	// Count from Offset
+	Total  int          `json:"total"`
+	Limit  int          `json:"limit"`
+	Offset int          `json:"offset"`
+	Data   []BrandImage `json:"data"`
+}
+
+// brandImages collects the images of the brand's stored cars, in the order
+// the cars are stored. Cars whose details haven't been stored yet are left
+// out.
+func brandImages(brandSlug string) ([]BrandImage, error) {
+	cars, err := storedBrandCars(brandSlug)
+	if err != nil {
+		return nil, err
+	}
+	images := []BrandImage{}
+	for _, car := range cars {
+		details, err := dataset.Details(car.Slug)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, image := range details.Images {
+			images = append(images, BrandImage{Image: image, Car: car.Slug, CarName: car.Name})
+		}
+	}
+	return images, nil
+}
+
+// getBrandImagesHandler serves a page of the images of a brand's cars from
+// the store, without going upstream, for brand gallery pages.
+func getBrandImagesHandler(w http.ResponseWriter, r *http.Request) {
+	brandSlug := mux.Vars(r)["brand_slug"]
+	limit, offset, err := parseSearchPage(r.URL.Query())
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	images, err := brandImages(brandSlug)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	total := len(images)
+	start := min(offset, total)
+	page := images[start : start+min(limit, total-start)]
+	respond(w, r, http.StatusOK, BrandImagesResponse{
+		Success: true,
+		Brand:   brandSlug,
+		Count:   len(page),
+		Total:   total,
+		Limit:   limit,
+		Offset:  offset,
+		Data:    page,
+	})
+}
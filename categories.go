@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+	"github.com/gorilla/mux"
+)
+
+// CategoryProvider is implemented by site scrapers that can list the terms
+// of the site's taxonomies other than brands, and their cars.
+type CategoryProvider interface {
+	GetCategories() ([]Category, error)
+	GetCategoryCars(taxonomy, slug string) ([]Car, error)
+}
+
+type CategoryResponse struct {
+	Success  bool        `json:"success"`
+	Category Category    `json:"category"`
+	Count    int         `json:"count"`
+	Data     interface{} `json:"data"`
+}
+
+// categoryProvider returns the site's CategoryProvider, answering 501 if
+// it has none.
+func categoryProvider(w http.ResponseWriter, r *http.Request) (CategoryProvider, bool) {
+	provider, ok := siteFor(r).(CategoryProvider)
+	if !ok {
+		respond(w, r, http.StatusNotImplemented, APIResponse{
+			Success: false,
+			Error:   "This site has no categories to read",
+		})
+	}
+	return provider, ok
+}
+
+// getCategories lists the categories of the taxonomy the request names,
+// or of all of them, answering the error itself if that fails.
+func getCategories(w http.ResponseWriter, r *http.Request, provider CategoryProvider) ([]Category, bool) {
+	categories, err := provider.GetCategories()
+	if err != nil && upstreamDown() {
+		writeUpstreamUnavailable(w, r)
+		return nil, false
+	}
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return nil, false
+	}
+
+	taxonomy := r.URL.Query().Get("taxonomy")
+	if taxonomy == "" {
+		return categories, true
+	}
+	filtered := []Category{}
+	for _, category := range categories {
+		if category.Taxonomy == taxonomy {
+			filtered = append(filtered, category)
+		}
+	}
+	return filtered, true
+}
+
+func getCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := categoryProvider(w, r)
+	if !ok {
+		return
+	}
+	categories, ok := getCategories(w, r, provider)
+	if !ok {
+		return
+	}
+	respond(w, r, http.StatusOK, APIResponse{
+		Success: true,
+		Count:   len(categories),
+		Data:    categories,
+	})
+}
+
+// getCategoryCarsHandler lists the cars of the category with the slug in
+// the path. Slugs can repeat across taxonomies, in which case the first
+// taxonomy's is served unless the taxonomy parameter names another.
+func getCategoryCarsHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := categoryProvider(w, r)
+	if !ok {
+		return
+	}
+	categories, ok := getCategories(w, r, provider)
+	if !ok {
+		return
+	}
+
+	slug := mux.Vars(r)["slug"]
+	var category *Category
+	for i := range categories {
+		if categories[i].Slug == slug {
+			category = &categories[i]
+			break
+		}
+	}
+	if category == nil {
+		respond(w, r, http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   "Category not found",
+		})
+		return
+	}
+
+	cars, err := provider.GetCategoryCars(category.Taxonomy, category.Slug)
+	if err != nil && upstreamDown() {
+		writeUpstreamUnavailable(w, r)
+		return
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, partasala.ErrUnknownTaxonomy) {
+			status = http.StatusNotFound
+		}
+		respond(w, r, status, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	annotateCars(cars)
+	respond(w, r, http.StatusOK, CategoryResponse{
+		Success:  true,
+		Category: *category,
+		Count:    len(cars),
+		Data:     cars,
+	})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+// runCommand runs one of the command-line subcommands against the
+// configured scraper and dataset.
+func runCommand(scraperConfig partasala.Config, args []string) error {
+	switch args[0] {
+	case "export":
+		return exportCommand(args[1:])
+	case "import":
+		return importCommand(args[1:])
+	case "crawl":
+		return crawlCommand(args[1:])
+	case "verify-parsers":
+		return verifyParsersCommand(scraperConfig, args[1:])
+	case "images":
+		return imagesCommand(args[1:])
+	case "watch":
+		return watchCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q (available: export, import, crawl, verify-parsers, images, watch)", args[0])
+	}
+}
+
+func exportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	output := fs.String("o", "-", "Output file, or - for stdout; a directory for Parquet")
+	format := fs.String("format", "json", "Snapshot format: json, or parquet for brands, cars, and images tables")
+	refresh := fs.Bool("refresh", false, "Scrape the whole site, including detail pages, before exporting")
+	fs.Parse(args)
+
+	if *format != "json" && *format != "parquet" {
+		return fmt.Errorf("unknown export format %q (available: json, parquet)", *format)
+	}
+	if *format == "parquet" && *output == "-" {
+		return fmt.Errorf("a Parquet export needs an output directory, e.g. -o export/")
+	}
+
+	if *refresh {
+		if err := dataset.Refresh(scraper); err != nil {
+			return err
+		}
+	}
+
+	snapshot, err := dataset.Export()
+	if err != nil {
+		return err
+	}
+	if *format == "parquet" {
+		return WriteParquet(snapshot, *output)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(snapshot)
+}
+
+func importCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: import <snapshot.json>")
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var snapshot DatasetSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("failed to parse snapshot: %v", err)
+	}
+	if err := dataset.Import(&snapshot); err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d brands, %d cars, %d car details\n", len(snapshot.Brands), len(snapshot.Cars), len(snapshot.Details))
+	return nil
+}
+
+func exportDatasetHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := dataset.Export()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	filename := fmt.Sprintf("partasala-%s.json", snapshot.ExportedAt.Format("20060102-150405"))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+func importDatasetHandler(w http.ResponseWriter, r *http.Request) {
+	var snapshot DatasetSnapshot
+	if err := json.NewDecoder(r.Body).Decode(&snapshot); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "Request body must be a dataset snapshot",
+		})
+		return
+	}
+
+	if err := dataset.Import(&snapshot); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	// Searches answered from the old data are out of date now
+	searches.clear()
+	if ready, _ := searchIndex.Ready(); ready {
+		if err := searchIndex.Rebuild(dataset); err != nil {
+			log.Printf("search index: %v", err)
+		}
+	}
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Count:   len(snapshot.Cars),
+	})
+}
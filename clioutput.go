@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+)
+
+// The -output formats of the commands that print rows.
+const (
+	OutputJSON  = "json"
+	OutputTable = "table"
+	OutputCSV   = "csv"
+)
+
+// outputFlags are the -output and -format flags of a command that prints
+// rows, e.g. the cars watch finds.
+type outputFlags struct {
+	output *string
+	format *string
+}
+
+func addOutputFlags(fs *flag.FlagSet, defaultOutput string) *outputFlags {
+	return &outputFlags{
+		output: fs.String("output", defaultOutput, "Output format: json, table, or csv"),
+		format: fs.String("format", "", "Go template to print each row with instead, e.g. '{{.Name}} {{.URL}}'"),
+	}
+}
+
+// rowPrinter prints rows to a command's output: each as a line of JSON, a
+// table row, a CSV record, or through a template.
+type rowPrinter struct {
+	w       io.Writer
+	output  string
+	columns []string
+	tmpl    *template.Template
+	json    *json.Encoder
+	table   *tabwriter.Writer
+	csv     *csv.Writer
+	header  bool
+}
+
+// printer returns a printer of rows under columns to w. A -format template
+// takes precedence over -output.
+func (f *outputFlags) printer(w io.Writer, columns []string) (*rowPrinter, error) {
+	p := &rowPrinter{w: w, output: *f.output, columns: columns}
+	if *f.format != "" {
+		format := *f.format
+		if !strings.HasSuffix(format, "\n") {
+			format += "\n"
+		}
+		tmpl, err := template.New("format").Parse(format)
+		if err != nil {
+			return nil, fmt.Errorf("-format: %v", err)
+		}
+		p.tmpl = tmpl
+		return p, nil
+	}
+	switch p.output {
+	case OutputJSON:
+		p.json = json.NewEncoder(w)
+	case OutputTable:
+		p.table = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	case OutputCSV:
+		p.csv = csv.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("unknown output %q (available: %s, %s, %s)", p.output, OutputJSON, OutputTable, OutputCSV)
+	}
+	return p, nil
+}
+
+// Print prints a row: v as JSON or through the template, record, which
+// has a field per column, as a table row or CSV record.
+func (p *rowPrinter) Print(v any, record []string) error {
+	switch {
+	case p.tmpl != nil:
+		return p.tmpl.Execute(p.w, v)
+	case p.json != nil:
+		return p.json.Encode(v)
+	case p.csv != nil:
+		if !p.header {
+			p.header = true
+			if err := p.csv.Write(p.columns); err != nil {
+				return err
+			}
+		}
+		return p.csv.Write(record)
+	default:
+		if !p.header {
+			p.header = true
+			fmt.Fprintln(p.table, strings.ToUpper(strings.Join(p.columns, "\t")))
+		}
+		_, err := fmt.Fprintln(p.table, strings.Join(record, "\t"))
+		return err
+	}
+}
+
+// Flush writes out the rows printed so far. A table's columns are aligned
+// over the rows of each flush.
+func (p *rowPrinter) Flush() error {
+	if p.csv != nil {
+		p.csv.Flush()
+		return p.csv.Error()
+	}
+	if p.table != nil {
+		return p.table.Flush()
+	}
+	return nil
+}
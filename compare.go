@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+const maxCompareSlugs = 10
+
+type CompareResponse struct {
+	Success    bool                     `json:"success"`
+	Count      int                      `json:"count"`
+	Slugs      []string                 `json:"slugs"`
+	Attributes map[string][]interface{} `json:"attributes"`
+	Data       []*CarDetails            `json:"data"`
+	Errors     map[string]string        `json:"errors,omitempty"`
+	Stale      bool                     `json:"stale,omitempty"`
+}
+
+func compareCarsHandler(w http.ResponseWriter, r *http.Request) {
+	slugs := []string{}
+	for _, slug := range strings.Split(r.URL.Query().Get("slugs"), ",") {
+		if slug = strings.TrimSpace(slug); slug != "" {
+			slugs = append(slugs, slug)
+		}
+	}
+
+	if len(slugs) < 2 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "Parameter \"slugs\" must list at least two car slugs",
+		})
+		return
+	}
+	if len(slugs) > maxCompareSlugs {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("At most %d cars can be compared at once", maxCompareSlugs),
+		})
+		return
+	}
+
+	// Fetch all details concurrently, keeping results aligned with slugs
+	details := make([]*CarDetails, len(slugs))
+	errs := make([]error, len(slugs))
+	site := siteFor(r)
+	var wg sync.WaitGroup
+	for i, slug := range slugs {
+		wg.Add(1)
+		go func(i int, slug string) {
+			defer wg.Done()
+			details[i], errs[i] = site.GetCarDetails(slug)
+		}(i, slug)
+	}
+	wg.Wait()
+
+	// While upstream is down, cars that couldn't be fetched come from the
+	// store
+	stale := false
+	if upstreamDown() {
+		for i, slug := range slugs {
+			if errs[i] == nil {
+				continue
+			}
+			if stored, err := dataset.Details(slug); err == nil {
+				details[i], errs[i], stale = stored, nil, true
+			}
+		}
+	}
+
+	attributes := map[string][]interface{}{
+		"year":        make([]interface{}, len(slugs)),
+		"engine":      make([]interface{}, len(slugs)),
+		"image_count": make([]interface{}, len(slugs)),
+	}
+	errors := map[string]string{}
+	for i, car := range details {
+		if errs[i] != nil {
+			errors[slugs[i]] = errs[i].Error()
+			continue
+		}
+
+		text := car.Name
+		if car.Description != nil {
+			text += " " + *car.Description
+		}
+		attributes["year"][i] = partasala.ModelYear(text)
+		attributes["engine"][i] = extractEngine(text)
+		attributes["image_count"][i] = car.ImageCount
+	}
+
+	if len(errors) == len(slugs) && upstreamDown() {
+		writeUpstreamUnavailable(w, r)
+		return
+	}
+	if len(errors) == len(slugs) {
+		respond(w, r, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Failed to fetch any of the requested cars",
+		})
+		return
+	}
+
+	respond(w, r, http.StatusOK, CompareResponse{
+		Success:    true,
+		Count:      len(slugs) - len(errors),
+		Slugs:      slugs,
+		Attributes: attributes,
+		Data:       details,
+		Errors:     errors,
+		Stale:      stale,
+	})
+}
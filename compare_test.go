@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+// downScraper is a site whose circuit breaker is open.
+type downScraper struct {
+	*crawlScraper
+}
+
+func (downScraper) CircuitState() string { return partasala.CircuitOpen }
+
+// TestCompareCarsWhileUpstreamDown checks that /compare answers from the
+// stored details, marked stale, while upstream is down.
+func TestCompareCarsWhileUpstreamDown(t *testing.T) {
+	withUsage(t, QuotaConfig{})
+	saved := scraper
+	scraper = downScraper{&crawlScraper{}}
+	t.Cleanup(func() { scraper = saved })
+
+	compare := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		compareCarsHandler(w, httptest.NewRequest("GET", "/compare?slugs=toyota-yaris,audi-a3", nil))
+		return w
+	}
+	if w := compare(); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("nothing stored: %d, want 503", w.Code)
+	}
+
+	if err := dataset.SaveDetails(&CarDetails{Name: "TOYOTA YARIS", Slug: "toyota-yaris"}); err != nil {
+		t.Fatal(err)
+	}
+	w := compare()
+	var body CompareResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || !body.Stale || body.Count != 1 {
+		t.Errorf("one car stored: %d, stale %v, count %d, want 200, true, 1", w.Code, body.Stale, body.Count)
+	}
+	if _, ok := body.Errors["audi-a3"]; !ok {
+		t.Errorf("errors = %v, want audi-a3", body.Errors)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressionEncodings are the response encodings offered, in order of
+// preference when the client weighs them equally.
+var compressionEncodings = []string{"br", "zstd", "gzip"}
+
+// negotiateEncoding picks the best encoding from an Accept-Encoding header,
+// or "" for none.
+func negotiateEncoding(header string) string {
+	weights := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if parsed, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		weights[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range compressionEncodings {
+		q, ok := weights[encoding]
+		if !ok {
+			q, ok = weights["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+func newEncoder(encoding string, w io.Writer) io.WriteCloser {
+	switch encoding {
+	case "br":
+		return brotli.NewWriterLevel(w, 5)
+	case "zstd":
+		// Only fails on invalid options
+		enc, _ := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedDefault))
+		return enc
+	default:
+		return gzip.NewWriter(w)
+	}
+}
+
+// compressMiddleware compresses responses with brotli, zstd, or gzip as the
+// client's Accept-Encoding allows. Responses that are already compressed,
+// like backup archives, workbooks, and proxied images, are passed through.
+func compressMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == "HEAD" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	decided  bool
+	compress bool
+	enc      io.WriteCloser
+}
+
+// decide settles on compressing once the handler's headers are final.
+func (cw *compressWriter) decide(status int) {
+	if cw.decided {
+		return
+	}
+	cw.decided = true
+
+	header := cw.Header()
+	contentType := header.Get("Content-Type")
+	if status == http.StatusNoContent || status == http.StatusNotModified ||
+		header.Get("Content-Encoding") != "" ||
+		strings.HasPrefix(contentType, "application/gzip") || strings.HasPrefix(contentType, "application/zip") ||
+		strings.HasPrefix(contentType, "application/vnd.openxmlformats") ||
+		(strings.HasPrefix(contentType, "image/") && !strings.HasPrefix(contentType, "image/svg+xml")) {
+		return
+	}
+	cw.compress = true
+	header.Set("Content-Encoding", cw.encoding)
+	header.Del("Content-Length")
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	cw.decide(status)
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	cw.decide(http.StatusOK)
+	if !cw.compress {
+		return cw.ResponseWriter.Write(p)
+	}
+	if cw.enc == nil {
+		cw.enc = newEncoder(cw.encoding, cw.ResponseWriter)
+	}
+	return cw.enc.Write(p)
+}
+
+// Flush sends what has been compressed so far, for streamed responses.
+func (cw *compressWriter) Flush() {
+	if flusher, ok := cw.enc.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack hands the connection over for a WebSocket, uncompressed.
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	cw.decided = true
+	return hijack(cw.ResponseWriter)
+}
+
+func (cw *compressWriter) Close() error {
+	if !cw.compress {
+		return nil
+	}
+	if cw.enc == nil {
+		// Headers promised an encoded body, so send a valid empty one
+		cw.enc = newEncoder(cw.encoding, cw.ResponseWriter)
+	}
+	return cw.enc.Close()
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+// Config holds the runtime settings of the API server. It is loaded from an
+// optional JSON file passed with -config; missing fields keep their defaults.
+type Config struct {
+	Site      string           `json:"site"`
+	Server    ServerConfig     `json:"server"`
+	CORS      CORSConfig       `json:"cors"`
+	Scraper   partasala.Config `json:"scraper"`
+	Store     StoreConfig      `json:"store"`
+	Upload    UploadConfig     `json:"upload"`
+	Crawler   CrawlerConfig    `json:"crawler"`
+	SMTP      SMTPConfig       `json:"smtp"`
+	Notifiers []NotifierConfig `json:"notifiers"`
+
+	// SearchSinks receive the dataset after every crawl.
+	SearchSinks []SearchSinkConfig `json:"search_sinks"`
+	// EventPublishers receive every crawl's added, removed, and updated
+	// cars.
+	EventPublishers []EventPublisherConfig `json:"event_publishers"`
+
+	// AdminAPIKey guards the /admin endpoints, which are disabled when it is
+	// empty.
+	AdminAPIKey string            `json:"admin_api_key"`
+	Quotas      QuotaConfig       `json:"quotas"`
+	SelfCheck   SelfCheckConfig   `json:"selfcheck"`
+	SearchCache SearchCacheConfig `json:"search_cache"`
+
+	// CarOrder is the order brand pages and /cars list cars in by default.
+	CarOrder string `json:"car_order"`
+
+	// BrandAliases maps brand slugs to the canonical slug /brands groups
+	// them under.
+	BrandAliases map[string]string `json:"brand_aliases"`
+
+	// ImageRewrite maps upstream image URLs to a mirror in responses.
+	ImageRewrite ImageRewriteConfig `json:"image_rewrite"`
+	ImageProxy   ImageProxyConfig   `json:"image_proxy"`
+
+	// Translations adds to or replaces the Icelandic words ?lang=en
+	// translates, keyed by the Icelandic word.
+	Translations       map[string]string        `json:"translations"`
+	MachineTranslation MachineTranslationConfig `json:"machine_translation"`
+
+	ErrorReporting ErrorReportingConfig `json:"error_reporting"`
+	UpstreamAlert  UpstreamAlertConfig  `json:"upstream_alert"`
+	Enrichment     EnrichmentConfig     `json:"enrichment"`
+
+	// Sites are further salvage yards served under /sites/<name>/, by
+	// name.
+	Sites map[string]SiteConfig `json:"sites"`
+}
+
+// ServerConfig sets where the API server listens, whether it serves HTTPS,
+// and its timeouts. Listen is a host:port, or "unix:" followed by a socket
+// path; SocketMode is the socket file's octal permissions. Listen is
+// ignored under systemd socket activation. BasePath is the URL prefix
+// every route is served under. AccessLog logs every request, to
+// AccessLogFile if it has a path. WriteTimeout
+// has to cover the slowest endpoint, such as /cars fetching every brand
+// page.
+type ServerConfig struct {
+	Listen        string              `json:"listen"`
+	BasePath      string              `json:"base_path"`
+	SocketMode    string              `json:"socket_mode"`
+	AccessLog     bool                `json:"access_log"`
+	AccessLogFile AccessLogFileConfig `json:"access_log_file"`
+	TLS           TLSConfig           `json:"tls"`
+	ReadTimeout   Duration            `json:"read_timeout"`
+	WriteTimeout  Duration            `json:"write_timeout"`
+	IdleTimeout   Duration            `json:"idle_timeout"`
+}
+
+// SelfCheckConfig names the known-good brand and car scraped by
+// /admin/selfcheck. Empty values fall back to the first ones found.
+type SelfCheckConfig struct {
+	Brand string `json:"brand"`
+	Car   string `json:"car"`
+}
+
+// CrawlerConfig configures the background crawler. With Schedules set, crawls
+// follow them instead of Interval.
+type CrawlerConfig struct {
+	Enabled   bool            `json:"enabled"`
+	Interval  Duration        `json:"interval"`
+	Schedules []CrawlSchedule `json:"schedules"`
+	// Auto is set when the config leaves out enabled, so the crawler
+	// starts once something needs crawls to fire, like the first alert
+	Auto bool `json:"-"`
+}
+
+// SMTPConfig configures outgoing alert emails. Email is disabled when Host
+// is empty.
+type SMTPConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+}
+
+// NotifierConfig configures a chat integration that is told about crawler
+// events. Type is "telegram" (bot_token, chat_id), "discord" (bot_token,
+// channel_id) or "slack" (webhook_url). Events defaults to car_added only,
+// and without filters every car is posted.
+type NotifierConfig struct {
+	Type       string      `json:"type"`
+	BotToken   string      `json:"bot_token"`
+	ChatID     string      `json:"chat_id"`
+	ChannelID  string      `json:"channel_id"`
+	WebhookURL string      `json:"webhook_url"`
+	Events     []string    `json:"events"`
+	Filters    []CarFilter `json:"filters"`
+}
+
+// Duration is shared with the scraper's settings, so every duration in the
+// file is written the same way.
+type Duration = partasala.Duration
+
+func DefaultConfig() Config {
+	return Config{
+		Site: "partasala",
+		Server: ServerConfig{
+			Listen:     ":1667",
+			SocketMode: "0660",
+			AccessLog:  true,
+			AccessLogFile: AccessLogFileConfig{
+				MaxSizeMB:  100,
+				MaxBackups: 5,
+			},
+			TLS:          TLSConfig{AutocertCache: "autocert-cache"},
+			ReadTimeout:  Duration{Duration: 15 * time.Second},
+			WriteTimeout: Duration{Duration: 5 * time.Minute},
+			IdleTimeout:  Duration{Duration: 2 * time.Minute},
+		},
+		CORS: CORSConfig{
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+			AllowedHeaders: []string{"Origin", "Content-Type", "Accept", "Authorization", "X-API-Key"},
+			ExposedHeaders: []string{"X-Quota-Limit", "X-Quota-Remaining", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After", "X-Request-ID"},
+		},
+		Scraper: partasala.DefaultConfig(),
+		Crawler: CrawlerConfig{
+			Interval: Duration{Duration: 30 * time.Minute},
+			Auto:     true,
+		},
+		SMTP: SMTPConfig{
+			Port: 587,
+		},
+		SearchCache: SearchCacheConfig{
+			TTL:        Duration{Duration: time.Minute},
+			MaxEntries: 500,
+		},
+		ImageProxy: ImageProxyConfig{
+			MaxAge: Duration{Duration: 7 * 24 * time.Hour},
+		},
+		UpstreamAlert: UpstreamAlertConfig{
+			Window:      Duration{Duration: 10 * time.Minute},
+			MinRequests: 10,
+		},
+		CarOrder: OrderName,
+		Enrichment: EnrichmentConfig{
+			Interval:    Duration{Duration: 24 * time.Hour},
+			Delay:       Duration{Duration: 2 * time.Second},
+			MaxAge:      Duration{Duration: 7 * 24 * time.Hour},
+			HotInterval: Duration{Duration: 15 * time.Minute},
+		},
+		Upload: UploadConfig{
+			Region:    "us-east-1",
+			Interval:  Duration{Duration: 24 * time.Hour},
+			Retention: 7,
+		},
+	}
+}
+
+// defaultCassetteDir is where -replay keeps cassettes unless
+// scraper.cassette.dir says otherwise.
+const defaultCassetteDir = "testdata/cassettes"
+
+// replayCassettes turns on cassettes for the main site and every site
+// under sites, in defaultCassetteDir where no dir is configured. Sites can
+// share a directory, as their recordings are named by URL.
+func replayCassettes(config *Config) {
+	if config.Scraper.Cassette.Dir == "" {
+		config.Scraper.Cassette.Dir = defaultCassetteDir
+	}
+	for name, site := range config.Sites {
+		if site.Scraper.Cassette.Dir == "" {
+			site.Scraper.Cassette.Dir = config.Scraper.Cassette.Dir
+			config.Sites[name] = site
+		}
+	}
+}
+
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read config %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse config %s: %v", path, err)
+	}
+	var crawlerSet struct {
+		Crawler struct {
+			Enabled *bool `json:"enabled"`
+		} `json:"crawler"`
+	}
+	json.Unmarshal(data, &crawlerSet)
+	if crawlerSet.Crawler.Enabled != nil {
+		config.Crawler.Auto = false
+	} else {
+		// Without crawls, notifiers and publishers would never hear of a car
+		config.Crawler.Enabled = len(config.Notifiers) > 0 || len(config.EventPublishers) > 0
+	}
+
+	if config.Scraper.BaseURL == "" {
+		return config, fmt.Errorf("scraper.base_url must not be empty")
+	}
+	for _, host := range config.Scraper.AllowedHosts {
+		if host == "" || strings.ContainsAny(host, "/ ") {
+			return config, fmt.Errorf("scraper.allowed_hosts must be host names, like \"cdn.example.com\" or \"*.example.com\"")
+		}
+	}
+	if !strings.HasPrefix(config.Scraper.BrandPath, "/") || !strings.HasPrefix(config.Scraper.CarPath, "/") || !strings.HasPrefix(config.Scraper.ContactPath, "/") {
+		return config, fmt.Errorf("scraper.brand_path, scraper.car_path, and scraper.contact_path must start with \"/\"")
+	}
+	if config.Scraper.MaxConcurrency < 1 {
+		return config, fmt.Errorf("scraper.max_concurrency must be at least 1")
+	}
+	if config.Scraper.MaxBrandPages < 1 || config.Scraper.MaxArchivePages < 1 {
+		return config, fmt.Errorf("scraper.max_brand_pages and scraper.max_archive_pages must be at least 1")
+	}
+	taxonomies := map[string]bool{}
+	for i, taxonomy := range config.Scraper.Taxonomies {
+		if taxonomy.Slug == "" || taxonomies[taxonomy.Slug] {
+			return config, fmt.Errorf("scraper.taxonomies[%d] needs a slug no other taxonomy uses", i)
+		}
+		taxonomies[taxonomy.Slug] = true
+		if !strings.HasPrefix(taxonomy.Path, "/") || !strings.HasSuffix(taxonomy.Path, "/") || taxonomy.Path == "/" ||
+			taxonomy.Path == config.Scraper.BrandPath || taxonomy.Path == config.Scraper.CarPath {
+			return config, fmt.Errorf("scraper.taxonomies[%d].path must start and end with \"/\" and differ from brand_path and car_path", i)
+		}
+	}
+	switch config.Scraper.AllCarsSource {
+	case partasala.AllCarsBrands, partasala.AllCarsArchive, partasala.AllCarsBoth:
+	default:
+		return config, fmt.Errorf("scraper.all_cars_source must be %q, %q, or %q", partasala.AllCarsBrands, partasala.AllCarsArchive, partasala.AllCarsBoth)
+	}
+	switch config.Scraper.ImageDedup {
+	case partasala.ImageDedupURL, partasala.ImageDedupFilename, partasala.ImageDedupContent:
+	default:
+		return config, fmt.Errorf("scraper.image_dedup must be %q, %q, or %q", partasala.ImageDedupURL, partasala.ImageDedupFilename, partasala.ImageDedupContent)
+	}
+	if config.Server.Listen == "" || config.Server.Listen == unixSocketPrefix {
+		return config, fmt.Errorf("server.listen must be a host:port or unix:<path>")
+	}
+	if config.Server.AccessLogFile.MaxSizeMB < 1 || config.Server.AccessLogFile.MaxBackups < 0 {
+		return config, fmt.Errorf("server.access_log_file needs a max_size_mb of at least 1 and max_backups of 0 or more")
+	}
+	config.Server.BasePath = strings.TrimRight(config.Server.BasePath, "/")
+	if config.Server.BasePath != "" && !strings.HasPrefix(config.Server.BasePath, "/") {
+		return config, fmt.Errorf("server.base_path must start with /, e.g. /api/partasala")
+	}
+	if tls := config.Server.TLS; (tls.CertFile == "") != (tls.KeyFile == "") {
+		return config, fmt.Errorf("server.tls needs both cert_file and key_file, or neither")
+	} else if tls.CertFile != "" && len(tls.AutocertHosts) > 0 {
+		return config, fmt.Errorf("server.tls takes cert_file and key_file or autocert_hosts, not both")
+	}
+	if config.CORS.MaxAge.Duration < 0 {
+		return config, fmt.Errorf("cors.max_age must not be negative")
+	}
+	if config.ErrorReporting.DSN != "" {
+		if _, _, err := parseSentryDSN(config.ErrorReporting.DSN); err != nil {
+			return config, fmt.Errorf("error_reporting: %v", err)
+		}
+	}
+	if a := config.UpstreamAlert; a.ErrorRate < 0 || a.ErrorRate >= 1 {
+		return config, fmt.Errorf("upstream_alert.error_rate must be at least 0 and below 1")
+	} else if a.ErrorRate > 0 && (a.Window.Duration < time.Minute || a.Window.Duration > partasala.MaxOutcomeWindow || a.MinRequests < 1) {
+		return config, fmt.Errorf("upstream_alert needs a window between 1m and %s and min_requests of at least 1", partasala.MaxOutcomeWindow)
+	}
+	if e := config.Enrichment; e.Enabled && (e.Interval.Duration <= 0 || e.MaxAge.Duration <= 0 || e.Delay.Duration < 0) {
+		return config, fmt.Errorf("enrichment needs a positive interval and max_age, and a delay that is not negative")
+	}
+	if e := config.Enrichment; e.Enabled && (e.HotCars < 0 || (e.HotCars > 0 && e.HotInterval.Duration <= 0)) {
+		return config, fmt.Errorf("enrichment.hot_cars must not be negative, and needs a positive hot_interval")
+	}
+	for name, site := range config.Sites {
+		if !siteName.MatchString(name) || name == config.Site {
+			return config, fmt.Errorf("sites: %q must be lowercase letters, digits, and hyphens, and not the main site's %q", name, config.Site)
+		}
+		if !slices.Contains(SiteSlugs(), site.Site) {
+			return config, fmt.Errorf("sites.%s.site must be one of %v", name, SiteSlugs())
+		}
+		if site.Scraper.BaseURL == "" {
+			return config, fmt.Errorf("sites.%s.scraper.base_url must not be empty", name)
+		}
+	}
+	if config.Quotas.DailyRequests < 0 {
+		return config, fmt.Errorf("quotas.daily_requests must not be negative")
+	}
+	if config.Quotas.AnonymousDailyRequests < 0 {
+		return config, fmt.Errorf("quotas.anonymous_daily_requests must not be negative")
+	}
+	if config.ImageProxy.MaxAge.Duration < 0 {
+		return config, fmt.Errorf("image_proxy.max_age must not be negative")
+	}
+	if !validCarOrder(config.CarOrder) {
+		return config, fmt.Errorf("car_order must be %s, %s, %s, or %s", OrderName, OrderFirstSeen, OrderPosted, OrderSite)
+	}
+	for alias, canonical := range config.BrandAliases {
+		if alias == "" || canonical == "" || alias == canonical {
+			return config, fmt.Errorf("brand_aliases: %q must map to another brand slug", alias)
+		}
+		if _, chained := config.BrandAliases[canonical]; chained {
+			return config, fmt.Errorf("brand_aliases: %q maps to %q, which is an alias itself", alias, canonical)
+		}
+	}
+	for word := range config.Translations {
+		if translationWord.FindString(word) != word {
+			return config, fmt.Errorf("translations: %q is not a single word", word)
+		}
+	}
+	if config.MachineTranslation.Provider != "" {
+		if _, err := NewDescriptionTranslator(config.MachineTranslation); err != nil {
+			return config, err
+		}
+	}
+	if (config.ImageRewrite.From == "") != (config.ImageRewrite.To == "") {
+		return config, fmt.Errorf("image_rewrite needs both from and to, or neither")
+	}
+	if config.SearchCache.TTL.Duration < 0 || config.SearchCache.MaxEntries < 1 {
+		return config, fmt.Errorf("search_cache.ttl must not be negative and search_cache.max_entries must be at least 1")
+	}
+	switch config.Scraper.SearchFolding {
+	case partasala.FoldASCII, partasala.FoldCase:
+	default:
+		return config, fmt.Errorf("scraper.search_folding must be %q or %q", partasala.FoldASCII, partasala.FoldCase)
+	}
+	if config.Scraper.DailyRequestBudget < 0 {
+		return config, fmt.Errorf("scraper.daily_request_budget must not be negative")
+	}
+	if config.Scraper.CircuitBreaker.Failures < 0 || config.Scraper.CircuitBreaker.Cooldown.Duration <= 0 {
+		return config, fmt.Errorf("scraper.circuit_breaker needs failures of 0 or more and a positive cooldown")
+	}
+	if config.Scraper.RetryAfter.Retries < 0 || config.Scraper.RetryAfter.MaxWait.Duration < 0 {
+		return config, fmt.Errorf("scraper.retry_after settings must not be negative")
+	}
+	if config.Scraper.Transport.MaxIdleConnsPerHost < 0 || config.Scraper.Transport.IdleConnTimeout.Duration < 0 {
+		return config, fmt.Errorf("scraper.transport settings must not be negative")
+	}
+	if t := config.Scraper.Timeouts; t.Brands.Duration <= 0 || t.BrandCars.Duration <= 0 || t.Details.Duration <= 0 || t.Contact.Duration <= 0 {
+		return config, fmt.Errorf("scraper.timeouts must all be positive")
+	}
+	if config.Server.ReadTimeout.Duration < 0 || config.Server.WriteTimeout.Duration < 0 || config.Server.IdleTimeout.Duration < 0 {
+		return config, fmt.Errorf("server timeouts must not be negative")
+	}
+	if err := config.Scraper.Selectors.Validate(); err != nil {
+		return config, fmt.Errorf("scraper.selectors: %v", err)
+	}
+	if config.Crawler.Enabled && config.Crawler.Interval.Duration <= 0 {
+		return config, fmt.Errorf("crawler.interval must be positive")
+	}
+	for i, schedule := range config.Crawler.Schedules {
+		if _, err := parseCron(schedule.Cron); err != nil {
+			return config, fmt.Errorf("crawler.schedules[%d].cron: %v", i, err)
+		}
+		if schedule.Scope.Brand != "" && schedule.Scope.BrandsOnly {
+			return config, fmt.Errorf("crawler.schedules[%d].scope: brand and brands_only are mutually exclusive", i)
+		}
+	}
+	backends := 0
+	for _, v := range []string{config.Store.Path, config.Store.Bolt, config.Store.DSN} {
+		if v != "" {
+			backends++
+		}
+	}
+	if backends > 1 {
+		return config, fmt.Errorf("only one of store.path, store.bolt and store.dsn may be set")
+	}
+	if config.Store.DSN != "" {
+		if !strings.HasPrefix(config.Store.DSN, "postgres://") && !strings.HasPrefix(config.Store.DSN, "postgresql://") {
+			return config, fmt.Errorf("store.dsn must be a postgres:// URL")
+		}
+	}
+	if config.Upload.Bucket != "" {
+		if config.Upload.Endpoint == "" || config.Upload.AccessKey == "" || config.Upload.SecretKey == "" {
+			return config, fmt.Errorf("upload requires endpoint, access_key and secret_key when bucket is set")
+		}
+		if config.Upload.Interval.Duration <= 0 || config.Upload.Retention < 1 {
+			return config, fmt.Errorf("upload.interval must be positive and upload.retention at least 1")
+		}
+	}
+	delegates := 0
+	for _, sink := range config.SearchSinks {
+		if sink.DelegateSearch {
+			delegates++
+		}
+	}
+	if delegates > 1 {
+		return config, fmt.Errorf("only one search sink may set delegate_search")
+	}
+	if config.SMTP.Host != "" && config.SMTP.From == "" {
+		return config, fmt.Errorf("smtp.from is required when smtp.host is set")
+	}
+
+	return config, nil
+}
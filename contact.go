@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+const (
+	bucketSite = "site"
+	keyContact = "contact"
+)
+
+// SaveContact stores the yard's contact details for when upstream is down.
+func (d *Dataset) SaveContact(contact *Contact) error {
+	return putJSON(d.store, bucketSite, keyContact, contact)
+}
+
+// Contact returns the stored contact details, or ErrNotFound.
+func (d *Dataset) Contact() (*Contact, error) {
+	var contact Contact
+	if err := getJSON(d.store, bucketSite, keyContact, &contact); err != nil {
+		return nil, err
+	}
+	return &contact, nil
+}
+
+func getContactHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := siteFor(r).(ContactProvider)
+	if !ok {
+		respond(w, r, http.StatusNotImplemented, APIResponse{
+			Success: false,
+			Error:   "This site has no contact page to read",
+		})
+		return
+	}
+
+	contact, err := provider.GetContact()
+	if err != nil && upstreamDown() {
+		if contact, err := dataset.Contact(); err == nil {
+			respond(w, r, http.StatusOK, contactResponse(r, contact, true))
+			return
+		}
+		writeUpstreamUnavailable(w, r)
+		return
+	}
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if err := dataset.SaveContact(contact); err != nil {
+		log.Printf("dataset: %v", err)
+	}
+
+	respond(w, r, http.StatusOK, contactResponse(r, contact, false))
+}
+
+// contactResponse is the body of the site's contact details, in the
+// envelope of the surface r was made to.
+func contactResponse(r *http.Request, contact *Contact, stale bool) interface{} {
+	if isV2(r) {
+		return newV2Response(contact, V2Meta{Stale: stale}, nil)
+	}
+	return APIResponse{
+		Success: true,
+		Data:    contact,
+		Stale:   stale,
+	}
+}
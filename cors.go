@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CORSConfig sets which other sites' pages may call the API from a
+// browser. AllowedOrigins lists origins like "https://example.com", or "*"
+// for any. The admin routes (/admin, /jobs, and /webhooks) only answer
+// AdminOrigins, which is empty by default, so a page elsewhere can't use
+// an admin's saved credentials. With AllowCredentials set, the request's
+// origin is echoed instead of "*", as browsers require.
+type CORSConfig struct {
+	AllowedOrigins   []string `json:"allowed_origins"`
+	AllowedMethods   []string `json:"allowed_methods"`
+	AllowedHeaders   []string `json:"allowed_headers"`
+	ExposedHeaders   []string `json:"exposed_headers"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	// MaxAge is how long browsers may cache a preflight answer; zero
+	// leaves it to the browser
+	MaxAge       Duration `json:"max_age"`
+	AdminOrigins []string `json:"admin_origins"`
+}
+
+// adminPathPrefixes are the routes CORSConfig.AdminOrigins applies to.
+var adminPathPrefixes = []string{"/admin", "/jobs", "/webhooks"}
+
+func isAdminPath(path string) bool {
+	for _, prefix := range adminPathPrefixes {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedOrigin returns the Access-Control-Allow-Origin value for a
+// request from origin, or "" if origin isn't allowed.
+func (c CORSConfig) allowedOrigin(origins []string, origin string) string {
+	for _, allowed := range origins {
+		if allowed == "*" && !c.AllowCredentials {
+			return "*"
+		}
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// corsMiddleware answers preflight requests and adds CORS headers to every
+// response, following config. It wraps the whole router, so preflights
+// get answered on routes that don't list OPTIONS among their methods.
+func corsMiddleware(config CORSConfig) func(http.Handler) http.Handler {
+	methods := strings.Join(config.AllowedMethods, ", ")
+	headers := strings.Join(config.AllowedHeaders, ", ")
+	exposed := strings.Join(config.ExposedHeaders, ", ")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origins := config.AllowedOrigins
+			if isAdminPath(r.URL.Path) {
+				origins = config.AdminOrigins
+			}
+			w.Header().Add("Vary", "Origin")
+			w.Header().Set("Content-Type", "application/json")
+
+			if origin := config.allowedOrigin(origins, r.Header.Get("Origin")); origin != "" {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Methods", methods)
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+				if exposed != "" {
+					w.Header().Set("Access-Control-Expose-Headers", exposed)
+				}
+				if config.AllowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+				if config.MaxAge.Duration > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(config.MaxAge.Seconds())))
+				}
+			}
+
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+// CrawlDiff describes how the site's listings changed between two crawls.
+// Updated holds the cars still listed whose listing changed, such as a new
+// price or name.
+type CrawlDiff struct {
+	Added   []Car
+	Removed []Car
+	Updated []Car
+}
+
+// Crawler periodically fetches every car on the site and reports additions
+// and removals to its subscribers. The first crawl only records a baseline,
+// so a restart does not report the whole site as new.
+type Crawler struct {
+	scraper  SiteScraper
+	dataset  *Dataset
+	interval time.Duration
+
+	// crawlMu serializes crawls so scheduled runs and jobs don't overlap
+	crawlMu sync.Mutex
+
+	mu          sync.Mutex
+	status      CrawlStatus
+	lastBrands  int
+	known       map[string]Car
+	baseline    bool
+	subscribers []func(CrawlDiff)
+	onError     []func(error)
+	onSaved     []func()
+	onStatus    []func(CrawlStatus)
+}
+
+func NewCrawler(scraper SiteScraper, dataset *Dataset, interval time.Duration) *Crawler {
+	return &Crawler{
+		scraper:  scraper,
+		dataset:  dataset,
+		interval: interval,
+		known:    make(map[string]Car),
+	}
+}
+
+// forget drops the known cars, for when the stored ones are replaced
+// behind the crawler's back, as by a restore. The next full crawl records
+// a new baseline instead of diffing against them.
+func (c *Crawler) forget() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.known = make(map[string]Car)
+	c.baseline = false
+}
+
+// Subscribe registers fn to be called with the diff of every crawl after the
+// baseline.
+func (c *Crawler) Subscribe(fn func(CrawlDiff)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subscribers = append(c.subscribers, fn)
+}
+
+// SubscribeErrors registers fn to be called whenever a crawl fails.
+func (c *Crawler) SubscribeErrors(fn func(error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onError = append(c.onError, fn)
+}
+
+// SubscribeSaved registers fn to be called whenever a crawl has stored
+// what it found, whether or not anything changed.
+func (c *Crawler) SubscribeSaved(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onSaved = append(c.onSaved, fn)
+}
+
+// SubscribeStatus registers fn to be called with the crawl status when a
+// crawl starts, after every brand it crawls, and when it ends.
+func (c *Crawler) SubscribeStatus(fn func(CrawlStatus)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onStatus = append(c.onStatus, fn)
+}
+
+func (c *Crawler) statusChanged() {
+	c.mu.Lock()
+	onStatus := append([]func(CrawlStatus){}, c.onStatus...)
+	c.mu.Unlock()
+	if len(onStatus) == 0 {
+		return
+	}
+	status := c.Status()
+	for _, fn := range onStatus {
+		fn(status)
+	}
+}
+
+func (c *Crawler) saved() {
+	c.mu.Lock()
+	onSaved := append([]func(){}, c.onSaved...)
+	c.mu.Unlock()
+	for _, fn := range onSaved {
+		fn()
+	}
+}
+
+// CrawlScope limits a crawl to a single brand, or to the brand list alone.
+// The zero value crawls the whole site.
+type CrawlScope struct {
+	Brand      string `json:"brand,omitempty"`
+	BrandsOnly bool   `json:"brands_only,omitempty"`
+}
+
+// CrawlProgress is passed to a crawl's progress callback after the brand list
+// is fetched and after every brand.
+type CrawlProgress struct {
+	BrandsDone  int
+	BrandsTotal int
+	// Brand is the brand being crawled next, empty once all are done
+	Brand  string
+	Errors int
+}
+
+// CrawlStatus describes the crawl in progress, if any, and how the last one
+// ended.
+type CrawlStatus struct {
+	Running        bool        `json:"running"`
+	Scope          *CrawlScope `json:"scope,omitempty"`
+	StartedAt      *time.Time  `json:"started_at,omitempty"`
+	PagesFetched   int         `json:"pages_fetched"`
+	PagesEstimated int         `json:"pages_estimated"`
+	CurrentBrand   string      `json:"current_brand,omitempty"`
+	Errors         int         `json:"errors"`
+	// ETA extrapolates the time per page fetched so far
+	ETA            *time.Time `json:"eta,omitempty"`
+	LastFinishedAt *time.Time `json:"last_finished_at,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+}
+
+// Status returns the current crawl status.
+func (c *Crawler) Status() CrawlStatus {
+	c.mu.Lock()
+	status := c.status
+	c.mu.Unlock()
+
+	if status.Running && status.PagesFetched > 0 && status.PagesEstimated > status.PagesFetched {
+		elapsed := time.Since(*status.StartedAt)
+		perPage := elapsed / time.Duration(status.PagesFetched)
+		eta := time.Now().Add(perPage * time.Duration(status.PagesEstimated-status.PagesFetched))
+		status.ETA = &eta
+	}
+	return status
+}
+
+// Run crawls immediately and then on every interval until ctx is cancelled.
+func (c *Crawler) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.Crawl()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Crawl performs a single full crawl, saves the brands and cars to the
+// dataset, and notifies subscribers of changes.
+func (c *Crawler) Crawl() error {
+	return c.CrawlContext(context.Background(), CrawlScope{}, nil)
+}
+
+// CrawlContext crawls scope, calling progress (if not nil) as it goes, and
+// keeps Status up to date. It stops early when ctx is cancelled. Only one
+// crawl runs at a time; others wait. Brands that fail to load are counted as
+// errors and keep their previously known cars, so a flaky page doesn't report
+// a brand's cars as removed.
+func (c *Crawler) CrawlContext(ctx context.Context, scope CrawlScope, progress func(CrawlProgress)) error {
+	c.crawlMu.Lock()
+	defer c.crawlMu.Unlock()
+
+	started := time.Now()
+	c.mu.Lock()
+	estimate := c.lastBrands + 1
+	switch {
+	case scope.BrandsOnly:
+		estimate = 1
+	case scope.Brand != "":
+		estimate = 2
+	}
+	c.status = CrawlStatus{
+		Running:        true,
+		Scope:          &scope,
+		StartedAt:      &started,
+		PagesEstimated: estimate,
+		LastFinishedAt: c.status.LastFinishedAt,
+		LastError:      c.status.LastError,
+	}
+	c.mu.Unlock()
+	c.statusChanged()
+
+	report := &CrawlReport{StartedAt: started, Scope: scope, Brands: []BrandTiming{}, ParseFailures: []string{}}
+	counter, _ := c.scraper.(TrafficCounter)
+	var bytesBefore int64
+	if counter != nil {
+		bytesBefore = counter.BytesDownloaded()
+	}
+
+	err := c.crawl(ctx, scope, func(p CrawlProgress) {
+		c.mu.Lock()
+		// One page for the brand list plus one per brand
+		c.status.PagesFetched = 1 + p.BrandsDone
+		c.status.PagesEstimated = 1 + p.BrandsTotal
+		c.status.CurrentBrand = p.Brand
+		c.status.Errors = p.Errors
+		if scope.Brand == "" && !scope.BrandsOnly {
+			c.lastBrands = p.BrandsTotal
+		}
+		c.mu.Unlock()
+		c.statusChanged()
+		if progress != nil {
+			progress(p)
+		}
+	}, report)
+
+	finished := time.Now()
+	c.mu.Lock()
+	report.PagesFetched = c.status.PagesFetched
+	c.status = CrawlStatus{LastFinishedAt: &finished}
+	if err != nil {
+		c.status.LastError = err.Error()
+	}
+	c.mu.Unlock()
+	c.statusChanged()
+
+	report.FinishedAt = finished
+	report.DurationMS = finished.Sub(started).Milliseconds()
+	if counter != nil {
+		report.BytesDownloaded = counter.BytesDownloaded() - bytesBefore
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	if err := saveCrawlReport(c.dataset.store, report); err != nil {
+		log.Printf("crawler: failed to save report: %v", err)
+	}
+
+	if err != nil && ctx.Err() == nil {
+		log.Printf("crawler: crawl failed: %v", err)
+		c.mu.Lock()
+		onError := append([]func(error){}, c.onError...)
+		c.mu.Unlock()
+		for _, fn := range onError {
+			fn(err)
+		}
+	}
+	return err
+}
+
+// crawlResult is what a crawl fetched, before anything is saved.
+type crawlResult struct {
+	brands []Brand
+	// cars of the crawled brands whose page loaded
+	cars    []Car
+	failed  map[string]error
+	timings []BrandTiming
+}
+
+// fetch loads the brand list and, unless scope is brands only, the cars of
+// every brand in scope.
+func (c *Crawler) fetch(ctx context.Context, scope CrawlScope, progress func(CrawlProgress)) (*crawlResult, error) {
+	brands, err := c.scraper.GetBrands()
+	if err != nil {
+		return nil, err
+	}
+	result := &crawlResult{brands: brands, cars: []Car{}, failed: map[string]error{}}
+	if scope.BrandsOnly {
+		progress(CrawlProgress{})
+		return result, nil
+	}
+
+	crawlBrands := brands
+	if scope.Brand != "" {
+		crawlBrands = nil
+		for _, brand := range brands {
+			if brand.Slug == scope.Brand {
+				crawlBrands = append(crawlBrands, brand)
+			}
+		}
+		if len(crawlBrands) == 0 {
+			return nil, fmt.Errorf("brand %q not found", scope.Brand)
+		}
+	}
+
+	report := CrawlProgress{BrandsTotal: len(crawlBrands)}
+	for _, brand := range crawlBrands {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		report.Brand = brand.Slug
+		progress(report)
+
+		start := time.Now()
+		cars, err := c.brandCars(ctx, brand.Slug)
+		timing := BrandTiming{Brand: brand.Slug, DurationMS: time.Since(start).Milliseconds(), Cars: len(cars)}
+		if err != nil {
+			log.Printf("crawler: brand %s: %v", brand.Slug, err)
+			result.failed[brand.Slug] = err
+			timing.Error = err.Error()
+			report.Errors++
+		} else {
+			result.cars = append(result.cars, cars...)
+		}
+		result.timings = append(result.timings, timing)
+		report.BrandsDone++
+	}
+	report.Brand = ""
+	progress(report)
+	return result, nil
+}
+
+// crawlRetryAfterAttempts is how many times a crawl reads a brand that
+// upstream keeps asking it to come back later for.
+const crawlRetryAfterAttempts = 3
+
+// brandCars reads brand's cars. When upstream asks to be left alone for
+// longer than a request can wait, with a 429 or 503 and Retry-After, the
+// crawl waits it out and reads the brand again rather than counting it as
+// failed.
+func (c *Crawler) brandCars(ctx context.Context, brand string) ([]Car, error) {
+	for attempt := 1; ; attempt++ {
+		cars, err := c.scraper.GetBrandCars(brand)
+		var retryAfter *partasala.RetryAfterError
+		if !errors.As(err, &retryAfter) || attempt == crawlRetryAfterAttempts {
+			return cars, err
+		}
+		log.Printf("crawler: brand %s: waiting until %s as upstream asked", brand, retryAfter.Until.Format(time.RFC3339))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Until(retryAfter.Until)):
+		}
+	}
+}
+
+// merge returns the cars known after result: the crawled cars, plus the
+// previously known cars outside the crawled brands or of failed brands.
+// Before the baseline nothing is known yet, so the stored cars, previous,
+// stand in for those of failed brands.
+func (r *crawlResult) merge(scope CrawlScope, known, previous map[string]Car) map[string]Car {
+	current := make(map[string]Car, len(known))
+	for _, cars := range []map[string]Car{previous, known} {
+		for slug, car := range cars {
+			if (scope.Brand != "" && car.Brand != scope.Brand) || r.failed[car.Brand] != nil {
+				current[slug] = car
+			}
+		}
+	}
+	for _, car := range r.cars {
+		current[car.Slug] = car
+	}
+	return current
+}
+
+func (c *Crawler) crawl(ctx context.Context, scope CrawlScope, progress func(CrawlProgress), report *CrawlReport) error {
+	result, err := c.fetch(ctx, scope, progress)
+	if err != nil {
+		return err
+	}
+	report.Brands = result.timings
+	report.ParseFailures = parseWarnings(result)
+	if scope.BrandsOnly {
+		if err := c.dataset.SaveBrands(result.brands); err != nil {
+			return err
+		}
+		c.saved()
+		log.Printf("crawler: %d brands", len(result.brands))
+		return nil
+	}
+
+	c.mu.Lock()
+	baseline := c.baseline
+	c.mu.Unlock()
+
+	// Cars taken down while the server was stopped, and those of brands
+	// that fail on the baseline, are only described by the stored car
+	// list, which is about to be replaced
+	previous := map[string]Car{}
+	if !baseline && scope.Brand == "" {
+		stored, err := c.dataset.Cars()
+		if err != nil {
+			return err
+		}
+		for _, car := range stored {
+			previous[car.Slug] = car
+		}
+	}
+
+	c.mu.Lock()
+	current := result.merge(scope, c.known, previous)
+	c.mu.Unlock()
+
+	if scope.Brand == "" {
+		if err := c.dataset.SaveBrands(result.brands); err != nil {
+			return err
+		}
+		cars := make([]Car, 0, len(current))
+		for _, car := range current {
+			cars = append(cars, car)
+		}
+		if err := c.dataset.SaveCars(cars); err != nil {
+			return err
+		}
+	} else if result.failed[scope.Brand] == nil {
+		if err := c.dataset.SaveBrandCars(scope.Brand, result.cars); err != nil {
+			return err
+		}
+	}
+	c.saved()
+
+	c.mu.Lock()
+	diff := CrawlDiff{}
+	for slug, car := range current {
+		if known, ok := c.known[slug]; !ok {
+			diff.Added = append(diff.Added, car)
+		} else if !sameListing(known, car) {
+			diff.Updated = append(diff.Updated, car)
+		}
+	}
+	for slug, car := range c.known {
+		if _, ok := current[slug]; !ok {
+			diff.Removed = append(diff.Removed, car)
+		}
+	}
+	c.known = current
+	// Only a full crawl knows the whole site well enough to diff against
+	firstCrawl := !c.baseline
+	if scope.Brand == "" {
+		c.baseline = true
+	}
+	subscribers := append([]func(CrawlDiff){}, c.subscribers...)
+	c.mu.Unlock()
+
+	// The baseline has nothing in memory to diff against, so cars taken
+	// down while the server was stopped are found in the stored history
+	removed := diff.Removed
+	if firstCrawl && scope.Brand == "" && len(result.failed) == 0 {
+		listed, err := c.dataset.ListedInHistory()
+		if err != nil {
+			return err
+		}
+		for _, slug := range listed {
+			if _, ok := current[slug]; !ok {
+				car, ok := previous[slug]
+				if !ok {
+					car = Car{Slug: slug}
+				}
+				removed = append(removed, car)
+			}
+		}
+	}
+	if err := c.dataset.RecordHistory(time.Now().UTC(), result.cars, removed); err != nil {
+		return err
+	}
+
+	report.Cars = len(current)
+	if firstCrawl {
+		log.Printf("crawler: baseline recorded with %d cars", len(current))
+		return nil
+	}
+	report.Added = len(diff.Added)
+	report.Removed = len(diff.Removed)
+	report.Updated = len(diff.Updated)
+
+	log.Printf("crawler: %d cars, %d added, %d removed, %d updated", len(current), len(diff.Added), len(diff.Removed), len(diff.Updated))
+	if len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Updated) == 0 {
+		return nil
+	}
+	for _, fn := range subscribers {
+		fn(diff)
+	}
+	return nil
+}
+
+// sameListing reports whether two crawls listed a car the same way.
+func sameListing(a, b Car) bool {
+	return a.Name == b.Name && a.URL == b.URL && a.Brand == b.Brand &&
+		sameString(a.Thumbnail, b.Thumbnail) && sameString(a.Make, b.Make) && sameString(a.Model, b.Model) &&
+		sameInt(a.Year, b.Year) && samePrice(a.Price, b.Price)
+}
+
+func sameString(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func sameInt(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func crawlStatusHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Data:    crawler.Status(),
+	})
+}
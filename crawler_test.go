@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// crawlScraper lists fixed brands and cars, failing the brands in failing.
+type crawlScraper struct {
+	cars    map[string][]Car
+	failing map[string]bool
+}
+
+func (s *crawlScraper) GetBrands() ([]Brand, error) {
+	brands := []Brand{}
+	for slug := range s.cars {
+		brands = append(brands, Brand{Name: slug, Slug: slug})
+	}
+	return brands, nil
+}
+
+func (s *crawlScraper) GetBrandCars(brandSlug string) ([]Car, error) {
+	if s.failing[brandSlug] {
+		return nil, errors.New("brand page failed")
+	}
+	return s.cars[brandSlug], nil
+}
+
+func (s *crawlScraper) GetCarDetails(string) (*CarDetails, error) { return nil, ErrNotFound }
+func (s *crawlScraper) GetAllCars() ([]Car, error)                { return nil, ErrNotFound }
+func (s *crawlScraper) SearchCars(string) ([]Car, error)          { return nil, ErrNotFound }
+
+// TestCrawlFailedBrandAfterRestart checks that a brand failing on the
+// baseline crawl after a restart keeps its stored cars, so they aren't
+// reported as added once it loads again.
+func TestCrawlFailedBrandAfterRestart(t *testing.T) {
+	scraper := &crawlScraper{
+		cars: map[string][]Car{
+			"audi":   {{Name: "AUDI A3", Slug: "audi-a3", Brand: "audi"}},
+			"toyota": {{Name: "TOYOTA YARIS", Slug: "toyota-yaris", Brand: "toyota"}, {Name: "TOYOTA AURIS", Slug: "toyota-auris", Brand: "toyota"}},
+		},
+		failing: map[string]bool{},
+	}
+	dataset := NewDataset(NewMemoryStore())
+	if err := NewCrawler(scraper, dataset, 0).Crawl(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server restarts, and the baseline crawl fails on one brand
+	restarted := NewCrawler(scraper, dataset, 0)
+	var diffs []CrawlDiff
+	restarted.Subscribe(func(diff CrawlDiff) { diffs = append(diffs, diff) })
+	scraper.failing["toyota"] = true
+	if err := restarted.Crawl(); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := dataset.Cars()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 3 {
+		t.Errorf("stored %d cars after the failed brand, want 3", len(stored))
+	}
+
+	scraper.failing["toyota"] = false
+	if err := restarted.Crawl(); err != nil {
+		t.Fatal(err)
+	}
+	for _, diff := range diffs {
+		for _, car := range diff.Added {
+			t.Errorf("%s reported as added", car.Slug)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+// maxRecentEvents bounds how many errors and notifier deliveries are kept
+// for the admin dashboard.
+const maxRecentEvents = 50
+
+// RecentError is a failure shown on the admin dashboard.
+type RecentError struct {
+	Time    time.Time
+	Source  string
+	Message string
+}
+
+// Delivery is one notifier's attempt to post an event. Error is empty if
+// it was delivered.
+type Delivery struct {
+	Time     time.Time
+	Notifier string
+	Event    string
+	Error    string
+}
+
+// recentEvents keeps the latest errors and notifier deliveries, newest
+// first.
+type recentEvents struct {
+	mu         sync.Mutex
+	errors     []RecentError
+	deliveries []Delivery
+}
+
+var recent = &recentEvents{}
+
+func (r *recentEvents) addError(source string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := RecentError{Time: time.Now().UTC(), Source: source, Message: err.Error()}
+	r.errors = append([]RecentError{e}, r.errors[:min(len(r.errors), maxRecentEvents-1)]...)
+}
+
+func (r *recentEvents) addDelivery(d Delivery) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d.Time = time.Now().UTC()
+	r.deliveries = append([]Delivery{d}, r.deliveries[:min(len(r.deliveries), maxRecentEvents-1)]...)
+}
+
+func (r *recentEvents) snapshot() ([]RecentError, []Delivery) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RecentError{}, r.errors...), append([]Delivery{}, r.deliveries...)
+}
+
+// adminDashboard serves the HTML dashboard at /admin. Its buttons post
+// forms carrying a token derived from the admin API key, so another site
+// can't submit them with the browser's saved credentials.
+type adminDashboard struct {
+	token string
+}
+
+func newAdminDashboard(key string) *adminDashboard {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte("admin dashboard"))
+	return &adminDashboard{token: hex.EncodeToString(mac.Sum(nil))}
+}
+
+// dashboardData is what the dashboard template is rendered with.
+type dashboardData struct {
+	Token       string
+	Notice      string
+	Crawl       CrawlStatus
+	Jobs        []Job
+	SearchCache SearchCacheStats
+	PageCache   *partasala.PageCacheStats
+	IndexReady  bool
+	IndexedAt   time.Time
+	Errors      []RecentError
+	Deliveries  []Delivery
+}
+
+var dashboardNotices = map[string]string{
+	"crawl":   "A crawl has been queued.",
+	"flushed": "The caches have been flushed.",
+}
+
+func (d *adminDashboard) show(w http.ResponseWriter, r *http.Request) {
+	data := dashboardData{
+		Token:       d.token,
+		Notice:      dashboardNotices[r.URL.Query().Get("done")],
+		Crawl:       crawler.Status(),
+		Jobs:        jobs.List(),
+		SearchCache: searches.stats(),
+	}
+	if cache, ok := scraper.(PageCache); ok {
+		stats := cache.PageCacheStats()
+		data.PageCache = &stats
+	}
+	data.IndexReady, data.IndexedAt = searchIndex.Ready()
+	data.Errors, data.Deliveries = recent.snapshot()
+	renderUI(w, http.StatusOK, "admin.html", uiPage{Title: "Admin", Data: data})
+}
+
+// checkToken rejects form posts without the dashboard's token.
+func (d *adminDashboard) checkToken(w http.ResponseWriter, r *http.Request) bool {
+	if subtle.ConstantTimeCompare([]byte(r.PostFormValue("token")), []byte(d.token)) != 1 {
+		renderUIError(w, http.StatusForbidden, "The form has expired; reload the dashboard")
+		return false
+	}
+	return true
+}
+
+func (d *adminDashboard) crawl(w http.ResponseWriter, r *http.Request) {
+	if !d.checkToken(w, r) {
+		return
+	}
+	if _, err := jobs.Enqueue(CrawlScope{}, false); err != nil {
+		renderUIError(w, http.StatusServiceUnavailable, "The crawl could not be queued: "+err.Error())
+		return
+	}
+	http.Redirect(w, r, link("/admin?done=crawl"), http.StatusSeeOther)
+}
+
+// flush empties the search cache and the scraper's page caches. The search
+// index is left alone, as it only changes when a crawl saves.
+func (d *adminDashboard) flush(w http.ResponseWriter, r *http.Request) {
+	if !d.checkToken(w, r) {
+		return
+	}
+	searches.clear()
+	if cache, ok := scraper.(PageCache); ok {
+		if err := cache.FlushPageCaches(); err != nil {
+			log.Printf("admin: flush: %v", err)
+			renderUIError(w, http.StatusInternalServerError, "The page caches could not be flushed")
+			return
+		}
+	}
+	http.Redirect(w, r, link("/admin?done=flushed"), http.StatusSeeOther)
+}
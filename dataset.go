@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	bucketBrands  = "brands"
+	bucketCars    = "cars"
+	bucketDetails = "details"
+)
+
+// snapshotVersion is bumped whenever DatasetSnapshot changes incompatibly.
+const snapshotVersion = 1
+
+// Dataset is the scraped data kept in a Store: brands and cars as last seen
+// by the crawler, and details of every car fetched so far.
+type Dataset struct {
+	store Store
+
+	// sightings caches every car's first and last sighting from the
+	// history, loaded on first use
+	sightingsMu sync.Mutex
+	sightings   map[string]sighting
+
+	// enrichments caches every car's enrichment, loaded on first use
+	enrichmentMu sync.Mutex
+	enrichments  map[string]CarEnrichment
+
+	// eventsAppended is closed, and forgotten, when events are added to
+	// the log, to wake long polls
+	eventsMu       sync.Mutex
+	eventsAppended chan struct{}
+}
+
+func NewDataset(store Store) *Dataset {
+	return &Dataset{store: store}
+}
+
+// SaveBrands replaces the stored brand list.
+func (d *Dataset) SaveBrands(brands []Brand) error {
+	puts := make(map[string][]byte, len(brands))
+	for _, brand := range brands {
+		data, err := json.Marshal(brand)
+		if err != nil {
+			return err
+		}
+		puts[brand.Slug] = data
+	}
+	return d.replace(bucketBrands, puts)
+}
+
+func (d *Dataset) Brands() ([]Brand, error) {
+	brands := []Brand{}
+	err := d.store.ForEach(bucketBrands, func(key string, value []byte) error {
+		var brand Brand
+		if err := json.Unmarshal(value, &brand); err != nil {
+			return fmt.Errorf("brand %s: %v", key, err)
+		}
+		brands = append(brands, brand)
+		return nil
+	})
+	return brands, err
+}
+
+// SaveCars replaces the stored car list.
+func (d *Dataset) SaveCars(cars []Car) error {
+	puts := make(map[string][]byte, len(cars))
+	for _, car := range cars {
+		data, err := json.Marshal(car)
+		if err != nil {
+			return err
+		}
+		puts[car.Slug] = data
+	}
+	return d.replace(bucketCars, puts)
+}
+
+// SaveBrandCars replaces the stored cars of one brand, leaving other brands'
+// cars alone.
+func (d *Dataset) SaveBrandCars(brand string, cars []Car) error {
+	puts := make(map[string][]byte, len(cars))
+	for _, car := range cars {
+		data, err := json.Marshal(car)
+		if err != nil {
+			return err
+		}
+		puts[car.Slug] = data
+	}
+
+	deletes := []string{}
+	err := d.store.ForEach(bucketCars, func(key string, value []byte) error {
+		var car Car
+		if err := json.Unmarshal(value, &car); err != nil {
+			return fmt.Errorf("car %s: %v", key, err)
+		}
+		if _, ok := puts[key]; !ok && car.Brand == brand {
+			deletes = append(deletes, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return d.store.Batch(bucketCars, puts, deletes)
+}
+
+func (d *Dataset) Cars() ([]Car, error) {
+	cars := []Car{}
+	err := d.store.ForEach(bucketCars, func(key string, value []byte) error {
+		var car Car
+		if err := json.Unmarshal(value, &car); err != nil {
+			return fmt.Errorf("car %s: %v", key, err)
+		}
+		cars = append(cars, car)
+		return nil
+	})
+	return cars, err
+}
+
+// SaveDetails stores a car's details, logging an event if its number of
+// images changed since they were last stored.
+func (d *Dataset) SaveDetails(details *CarDetails) error {
+	previous, err := d.Details(details.Slug)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	if err := putJSON(d.store, bucketDetails, details.Slug, details); err != nil {
+		return err
+	}
+	if previous == nil || previous.ImageCount == details.ImageCount {
+		return nil
+	}
+
+	event := ChangeEvent{
+		At:                 time.Now().UTC(),
+		Type:               EventImageCountChanged,
+		Car:                details.Slug,
+		Name:               details.Name,
+		ImageCount:         &details.ImageCount,
+		PreviousImageCount: &previous.ImageCount,
+	}
+	var car Car
+	if getJSON(d.store, bucketCars, details.Slug, &car) == nil {
+		event.Brand = car.Brand
+	}
+	return d.appendEvents([]ChangeEvent{event})
+}
+
+// Details returns the stored details of a car, or ErrNotFound.
+func (d *Dataset) Details(slug string) (*CarDetails, error) {
+	var details CarDetails
+	if err := getJSON(d.store, bucketDetails, slug, &details); err != nil {
+		return nil, err
+	}
+	return &details, nil
+}
+
+func (d *Dataset) AllDetails() ([]CarDetails, error) {
+	all := []CarDetails{}
+	err := d.store.ForEach(bucketDetails, func(key string, value []byte) error {
+		var details CarDetails
+		if err := json.Unmarshal(value, &details); err != nil {
+			return fmt.Errorf("details %s: %v", key, err)
+		}
+		all = append(all, details)
+		return nil
+	})
+	return all, err
+}
+
+// replace writes puts to bucket and removes every other key in it.
+func (d *Dataset) replace(bucket string, puts map[string][]byte) error {
+	deletes := []string{}
+	err := d.store.ForEach(bucket, func(key string, value []byte) error {
+		if _, ok := puts[key]; !ok {
+			deletes = append(deletes, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return d.store.Batch(bucket, puts, deletes)
+}
+
+// DatasetSnapshot is the versioned export format of a Dataset.
+type DatasetSnapshot struct {
+	Version    int          `json:"version"`
+	ExportedAt time.Time    `json:"exported_at"`
+	Brands     []Brand      `json:"brands"`
+	Cars       []Car        `json:"cars"`
+	Details    []CarDetails `json:"details"`
+}
+
+func (d *Dataset) Export() (*DatasetSnapshot, error) {
+	brands, err := d.Brands()
+	if err != nil {
+		return nil, err
+	}
+	cars, err := d.Cars()
+	if err != nil {
+		return nil, err
+	}
+	details, err := d.AllDetails()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DatasetSnapshot{
+		Version:    snapshotVersion,
+		ExportedAt: time.Now().UTC(),
+		Brands:     brands,
+		Cars:       cars,
+		Details:    details,
+	}, nil
+}
+
+// Import replaces the dataset with the contents of snapshot.
+func (d *Dataset) Import(snapshot *DatasetSnapshot) error {
+	if snapshot.Version < 1 || snapshot.Version > snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d (this build reads up to %d)", snapshot.Version, snapshotVersion)
+	}
+
+	if err := d.SaveBrands(snapshot.Brands); err != nil {
+		return err
+	}
+	if err := d.SaveCars(snapshot.Cars); err != nil {
+		return err
+	}
+
+	puts := make(map[string][]byte, len(snapshot.Details))
+	for _, details := range snapshot.Details {
+		data, err := json.Marshal(details)
+		if err != nil {
+			return err
+		}
+		puts[details.Slug] = data
+	}
+	return d.replace(bucketDetails, puts)
+}
+
+// Refresh scrapes the whole site, including every car's detail page, into
+// the dataset. Cars whose details fail to load keep their previous details.
+func (d *Dataset) Refresh(scraper SiteScraper) error {
+	brands, err := scraper.GetBrands()
+	if err != nil {
+		return err
+	}
+	cars, err := scraper.GetAllCars()
+	if err != nil {
+		return err
+	}
+
+	if err := d.SaveBrands(brands); err != nil {
+		return err
+	}
+	if err := d.SaveCars(cars); err != nil {
+		return err
+	}
+
+	for _, car := range cars {
+		details, err := scraper.GetCarDetails(car.Slug)
+		if err != nil {
+			log.Printf("dataset: %s: %v", car.Slug, err)
+			continue
+		}
+		if err := d.SaveDetails(details); err != nil {
+			return err
+		}
+	}
+	return nil
+}
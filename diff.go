@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// CarChange is one car in a SnapshotDiff. Price is the car's price in the
+// later snapshot, or the earlier one for removed cars; PreviousPrice is
+// only set on changed cars.
+type CarChange struct {
+	Slug          string `json:"slug"`
+	Name          string `json:"name,omitempty"`
+	Brand         string `json:"brand,omitempty"`
+	Price         *Price `json:"price"`
+	PreviousPrice *Price `json:"previous_price,omitempty"`
+}
+
+// SnapshotDiff is how the listed cars differ between two points in time.
+type SnapshotDiff struct {
+	From    time.Time   `json:"from"`
+	To      time.Time   `json:"to"`
+	Added   []CarChange `json:"added"`
+	Removed []CarChange `json:"removed"`
+	Changed []CarChange `json:"changed"`
+}
+
+// listedAt replays a car's history up to at and reports whether the car
+// was listed then, and at what price.
+func (h *CarHistory) listedAt(at time.Time) (bool, *Price) {
+	listed, price := false, (*Price)(nil)
+	for _, event := range h.Events {
+		if event.At.After(at) {
+			break
+		}
+		switch event.Type {
+		case HistoryAppeared:
+			listed, price = true, event.Price
+		case HistoryPriceChanged:
+			price = event.Price
+		case HistoryRemoved:
+			listed = false
+		}
+	}
+	return listed, price
+}
+
+// Diff compares the cars listed at from with those listed at to, as
+// recorded in the history. Changed cars are the ones whose price changed.
+func (d *Dataset) Diff(from, to time.Time) (*SnapshotDiff, error) {
+	diff := &SnapshotDiff{From: from, To: to, Added: []CarChange{}, Removed: []CarChange{}, Changed: []CarChange{}}
+	err := d.store.ForEach(bucketHistory, func(key string, value []byte) error {
+		var h CarHistory
+		if err := json.Unmarshal(value, &h); err != nil {
+			return fmt.Errorf("history %s: %v", key, err)
+		}
+		wasListed, oldPrice := h.listedAt(from)
+		isListed, newPrice := h.listedAt(to)
+		switch {
+		case !wasListed && isListed:
+			diff.Added = append(diff.Added, CarChange{Slug: key, Price: newPrice})
+		case wasListed && !isListed:
+			diff.Removed = append(diff.Removed, CarChange{Slug: key, Price: oldPrice})
+		case wasListed && isListed && !samePrice(oldPrice, newPrice):
+			diff.Changed = append(diff.Changed, CarChange{Slug: key, Price: newPrice, PreviousPrice: oldPrice})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The history only keeps prices, so names and brands come from the
+	// stored cars and the removed-car archive
+	cars, err := d.Cars()
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]Car{}
+	for _, car := range cars {
+		names[car.Slug] = car
+	}
+	removed, err := d.RemovedCars(time.Time{}, "", math.MaxInt)
+	if err != nil {
+		return nil, err
+	}
+	for _, car := range removed {
+		names[car.Slug] = car.Car
+	}
+	for _, list := range [][]CarChange{diff.Added, diff.Removed, diff.Changed} {
+		for i := range list {
+			car := names[list[i].Slug]
+			list[i].Name, list[i].Brand = car.Name, car.Brand
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Slug < list[j].Slug })
+	}
+	return diff, nil
+}
+
+// snapshotTime resolves a /diff parameter: the id of a crawl report,
+// standing for the listing as that crawl left it, or an RFC 3339 time.
+func snapshotTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	var report CrawlReport
+	if err := getJSON(dataset.store, bucketCrawlReports, v, &report); err != nil {
+		if err == ErrNotFound {
+			return time.Time{}, fmt.Errorf("%q is neither a crawl report id nor an RFC 3339 time", v)
+		}
+		return time.Time{}, err
+	}
+	return report.FinishedAt, nil
+}
+
+func diffHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("from") == "" || query.Get("to") == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "Missing \"from\" or \"to\" parameter",
+		})
+		return
+	}
+
+	var from, to time.Time
+	for _, param := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		t, err := snapshotTime(query.Get(param.name))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Error:   param.name + ": " + err.Error(),
+			})
+			return
+		}
+		*param.t = t
+	}
+
+	diff, err := dataset.Diff(from, to)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Data:    diff,
+	})
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// DryRunReport lists what a crawl would change in the stored dataset.
+type DryRunReport struct {
+	Scope         CrawlScope `json:"scope"`
+	Brands        int        `json:"brands"`
+	Cars          int        `json:"cars"`
+	BrandsAdded   []Brand    `json:"brands_added"`
+	BrandsRemoved []Brand    `json:"brands_removed"`
+	Added         []Car      `json:"added"`
+	Removed       []Car      `json:"removed"`
+	Warnings      []string   `json:"warnings"`
+}
+
+// DryRun fetches and parses scope like a crawl but saves nothing, notifies
+// nobody, and leaves the crawler's own state alone. Changes are reported
+// against the stored dataset, along with anything that looks like a parser
+// that no longer matches the site.
+func (c *Crawler) DryRun(ctx context.Context, scope CrawlScope, progress func(CrawlProgress)) (*DryRunReport, error) {
+	c.crawlMu.Lock()
+	defer c.crawlMu.Unlock()
+
+	if progress == nil {
+		progress = func(CrawlProgress) {}
+	}
+	result, err := c.fetch(ctx, scope, progress)
+	if err != nil {
+		return nil, err
+	}
+
+	storedBrands, err := c.dataset.Brands()
+	if err != nil {
+		return nil, err
+	}
+	storedCars, err := c.dataset.Cars()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DryRunReport{
+		Scope:         scope,
+		Brands:        len(result.brands),
+		BrandsAdded:   []Brand{},
+		BrandsRemoved: []Brand{},
+		Added:         []Car{},
+		Removed:       []Car{},
+		Warnings:      []string{},
+	}
+
+	brands := map[string]Brand{}
+	for _, brand := range result.brands {
+		brands[brand.Slug] = brand
+	}
+	stored := map[string]bool{}
+	for _, brand := range storedBrands {
+		stored[brand.Slug] = true
+		if _, ok := brands[brand.Slug]; !ok {
+			report.BrandsRemoved = append(report.BrandsRemoved, brand)
+		}
+	}
+	for _, brand := range result.brands {
+		if !stored[brand.Slug] {
+			report.BrandsAdded = append(report.BrandsAdded, brand)
+		}
+	}
+	if len(result.brands) == 0 {
+		report.Warnings = append(report.Warnings, "no brands found")
+	}
+	if scope.BrandsOnly {
+		return report, nil
+	}
+
+	known := make(map[string]Car, len(storedCars))
+	for _, car := range storedCars {
+		known[car.Slug] = car
+	}
+	current := result.merge(scope, known, nil)
+	report.Cars = len(current)
+	for slug, car := range current {
+		if _, ok := known[slug]; !ok {
+			report.Added = append(report.Added, car)
+		}
+	}
+	for slug, car := range known {
+		if _, ok := current[slug]; !ok {
+			report.Removed = append(report.Removed, car)
+		}
+	}
+	sort.Slice(report.Added, func(i, j int) bool { return report.Added[i].Slug < report.Added[j].Slug })
+	sort.Slice(report.Removed, func(i, j int) bool { return report.Removed[i].Slug < report.Removed[j].Slug })
+
+	report.Warnings = append(report.Warnings, parseWarnings(result)...)
+	return report, nil
+}
+
+// parseWarnings flags brands that failed or came back empty, and cars missing
+// fields every listing should have.
+func parseWarnings(result *crawlResult) []string {
+	warnings := []string{}
+	counts := map[string]int{}
+	thumbnails := map[string]int{}
+	for _, car := range result.cars {
+		counts[car.Brand]++
+		if car.Thumbnail != nil {
+			thumbnails[car.Brand]++
+		}
+		if car.Name == "" {
+			warnings = append(warnings, fmt.Sprintf("car %s: no name", car.Slug))
+		}
+	}
+
+	for _, brand := range result.brands {
+		if err, ok := result.failed[brand.Slug]; ok {
+			warnings = append(warnings, fmt.Sprintf("brand %s: %v", brand.Slug, err))
+			continue
+		}
+		if _, crawled := counts[brand.Slug]; !crawled {
+			continue
+		}
+		if thumbnails[brand.Slug] == 0 {
+			warnings = append(warnings, fmt.Sprintf("brand %s: no car has a thumbnail", brand.Slug))
+		}
+	}
+	return warnings
+}
+
+func crawlCommand(args []string) error {
+	fs := flag.NewFlagSet("crawl", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Report what would change without saving anything")
+	brand := fs.String("brand", "", "Only crawl this brand")
+	brandsOnly := fs.Bool("brands-only", false, "Only crawl the brand list")
+	output := addOutputFlags(fs, OutputJSON)
+	fs.Parse(args)
+
+	if *brand != "" && *brandsOnly {
+		return fmt.Errorf("-brand and -brands-only are mutually exclusive")
+	}
+	printer, err := output.printer(os.Stdout, []string{"change", "slug", "name", "brand", "url"})
+	if err != nil {
+		return err
+	}
+	scope := CrawlScope{Brand: *brand, BrandsOnly: *brandsOnly}
+	c := NewCrawler(scraper, dataset, 0)
+	if !*dryRun {
+		return c.CrawlContext(context.Background(), scope, nil)
+	}
+
+	report, err := c.DryRun(context.Background(), scope, nil)
+	if err != nil {
+		return err
+	}
+	return printDryRun(report, printer)
+}
+
+// printDryRun prints a dry run's report: as JSON, through a -format
+// template, or as a table or CSV of the cars it would add and remove.
+func printDryRun(report *DryRunReport, printer *rowPrinter) error {
+	if printer.json != nil {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	if printer.tmpl != nil {
+		if err := printer.Print(report, nil); err != nil {
+			return err
+		}
+		return printer.Flush()
+	}
+	for _, change := range []struct {
+		name string
+		cars []Car
+	}{{"added", report.Added}, {"removed", report.Removed}} {
+		for _, car := range change.cars {
+			if err := printer.Print(car, []string{change.name, car.Slug, car.Name, car.Brand, car.URL}); err != nil {
+				return err
+			}
+		}
+	}
+	return printer.Flush()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	neturl "net/url"
+	"sort"
+	"time"
+)
+
+// elasticsearchBulkSize is how many documents go into one _bulk request.
+const elasticsearchBulkSize = 500
+
+// elasticsearchMapping keeps identifiers exact and the image list out of
+// the index; everything else is mapped dynamically.
+var elasticsearchMapping = map[string]interface{}{
+	"mappings": map[string]interface{}{
+		"properties": map[string]interface{}{
+			"slug":        map[string]string{"type": "keyword"},
+			"url":         map[string]string{"type": "keyword"},
+			"brand":       map[string]string{"type": "keyword"},
+			"plate":       map[string]string{"type": "keyword"},
+			"vin":         map[string]string{"type": "keyword"},
+			"name":        map[string]string{"type": "text"},
+			"description": map[string]string{"type": "text"},
+			"year":        map[string]string{"type": "integer"},
+			"images":      map[string]interface{}{"type": "object", "enabled": false},
+		},
+	},
+}
+
+// elasticsearchSink writes every sync to a new timestamped index and then
+// moves the configured alias onto it in one _aliases call, so searches
+// never see a half-filled index. The indices the alias left are deleted.
+type elasticsearchSink struct {
+	*engineClient
+	index string
+}
+
+func (s *elasticsearchSink) Sync(docs []SearchDocument) error {
+	index := fmt.Sprintf("%s-%d", s.index, time.Now().UnixMilli())
+	if err := s.do("PUT", "/"+index, "application/json", elasticsearchMapping, nil); err != nil {
+		return fmt.Errorf("elasticsearch: create %s: %v", index, err)
+	}
+	if err := s.fill(index, docs); err != nil {
+		s.do("DELETE", "/"+index, "", nil, nil)
+		return fmt.Errorf("elasticsearch: %v", err)
+	}
+
+	var current map[string]interface{}
+	if err := s.do("GET", "/_alias/"+neturl.PathEscape(s.index), "", nil, &current); err != nil && !isEngineNotFound(err) {
+		s.do("DELETE", "/"+index, "", nil, nil)
+		return fmt.Errorf("elasticsearch: alias %s: %v", s.index, err)
+	}
+	old := make([]string, 0, len(current))
+	for name := range current {
+		old = append(old, name)
+	}
+	sort.Strings(old)
+
+	actions := []interface{}{}
+	for _, name := range old {
+		actions = append(actions, map[string]interface{}{"remove": map[string]string{"index": name, "alias": s.index}})
+	}
+	actions = append(actions, map[string]interface{}{"add": map[string]string{"index": index, "alias": s.index}})
+	if err := s.do("POST", "/_aliases", "application/json", map[string]interface{}{"actions": actions}, nil); err != nil {
+		s.do("DELETE", "/"+index, "", nil, nil)
+		return fmt.Errorf("elasticsearch: alias %s: %v", s.index, err)
+	}
+
+	for _, name := range old {
+		if err := s.do("DELETE", "/"+name, "", nil, nil); err != nil {
+			return fmt.Errorf("elasticsearch: delete %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// fill bulk-indexes docs into index, keyed by car slug, and refreshes it
+// so it is searchable once the alias points at it.
+func (s *elasticsearchSink) fill(index string, docs []SearchDocument) error {
+	for start := 0; start < len(docs); start += elasticsearchBulkSize {
+		end := min(start+elasticsearchBulkSize, len(docs))
+
+		var body bytes.Buffer
+		encoder := json.NewEncoder(&body)
+		for _, doc := range docs[start:end] {
+			encoder.Encode(map[string]interface{}{"index": map[string]string{"_index": index, "_id": doc.Slug}})
+			if err := encoder.Encode(rewriteImageURLs(doc)); err != nil {
+				return err
+			}
+		}
+
+		var result struct {
+			Errors bool `json:"errors"`
+			Items  []map[string]struct {
+				ID    string `json:"_id"`
+				Error *struct {
+					Type   string `json:"type"`
+					Reason string `json:"reason"`
+				} `json:"error"`
+			} `json:"items"`
+		}
+		if err := s.do("POST", "/_bulk", "application/x-ndjson", body.Bytes(), &result); err != nil {
+			return fmt.Errorf("bulk: %v", err)
+		}
+		if result.Errors {
+			for _, item := range result.Items {
+				for _, action := range item {
+					if action.Error != nil {
+						return fmt.Errorf("bulk: %s: %s: %s", action.ID, action.Error.Type, action.Error.Reason)
+					}
+				}
+			}
+			return fmt.Errorf("bulk: some documents failed")
+		}
+	}
+	return s.do("POST", "/"+index+"/_refresh", "", nil, nil)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const contentTypeMsgpack = "application/msgpack"
+
+// acceptQuality returns the highest quality the Accept header gives any of
+// mediaTypes, or -1 if it names none of them.
+func acceptQuality(r *http.Request, mediaTypes ...string) float64 {
+	best := -1.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		for _, want := range mediaTypes {
+			if mediaType == want && q > best {
+				best = q
+			}
+		}
+	}
+	return best
+}
+
+// wantsMsgpack reports whether the Accept header asks for MessagePack
+// (application/msgpack or application/x-msgpack) ahead of JSON.
+func wantsMsgpack(r *http.Request) bool {
+	msgpackQ := acceptQuality(r, "application/msgpack", "application/x-msgpack")
+	return msgpackQ > 0 && msgpackQ >= acceptQuality(r, "application/json", "application/*", "*/*")
+}
+
+// wantsHTML reports whether the Accept header names text/html ahead of
+// JSON, as browsers' do. Wildcards alone get JSON, so API clients that
+// accept anything keep getting it.
+func wantsHTML(r *http.Request) bool {
+	htmlQ := acceptQuality(r, "text/html")
+	return htmlQ > 0 && htmlQ > acceptQuality(r, "application/json", "application/*")
+}
+
+// respond writes v with status in the encoding the client asked for: JSON by
+// default, MessagePack with the same field names, or a JSON:API document.
+// Image URLs are rewritten as configured, and descriptions translated for
+// ?lang=en. A successful response is projected with the JMESPath ?query,
+// except as a JSON:API document, whose shape is fixed. JSON objects that
+// aren't projections get the request's ResponseMeta as "meta", which the
+// ETag leaves out, and every encoding gets it as X-Cache and Age headers.
+// Under /v2, v is a V2Response, or a failed APIResponse sent as one, and
+// gets the ResponseMeta in its meta, MessagePack included.
+func respond(w http.ResponseWriter, r *http.Request, status int, v interface{}) error {
+	w.Header().Add("Vary", "Accept")
+	stale := staleResponse(v)
+	if stale {
+		noteStoredScraped(r.Context())
+	}
+	meta := responseMeta(r)
+	setCacheHeaders(w, meta, stale)
+	v = rewriteImageURLs(v)
+	if wantsEnglish(r) {
+		v = translator.Response(v)
+	}
+	if wantsJSONAPI(r) {
+		doc := jsonAPIDocument(status, v)
+		var body bytes.Buffer
+		if err := json.NewEncoder(&body).Encode(doc); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return err
+		}
+		setETagSource(r, body.Bytes())
+		if meta != nil {
+			addJSONAPIMeta(doc, meta)
+		}
+		w.Header().Set("Content-Type", contentTypeJSONAPI)
+		w.WriteHeader(status)
+		return json.NewEncoder(w).Encode(doc)
+	}
+	v2 := isV2(r)
+	if v2 {
+		v = v2Response(status, v)
+	}
+	projected := false
+	if wantsProjection(r) && status < 300 {
+		result, err := projectResponse(r.URL.Query().Get("query"), v)
+		if err != nil {
+			status = http.StatusBadRequest
+			result = APIResponse{Success: false, Error: err.Error()}
+			if v2 {
+				result = v2Response(status, result)
+			}
+		}
+		v, projected = result, err == nil
+	}
+	envelope, _ := v.(v2Envelope)
+	if wantsMsgpack(r) {
+		if envelope != nil {
+			v = envelope.withRequest(meta, requestID(r))
+		}
+		w.Header().Set("Content-Type", contentTypeMsgpack)
+		w.WriteHeader(status)
+		encoder := msgpack.NewEncoder(w)
+		encoder.SetCustomStructTag("json")
+		return encoder.Encode(v)
+	}
+
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(v); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+	data := body.Bytes()
+	switch {
+	case envelope != nil:
+		setETagSource(r, data)
+		var withMeta bytes.Buffer
+		if err := json.NewEncoder(&withMeta).Encode(envelope.withRequest(meta, requestID(r))); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return err
+		}
+		data = withMeta.Bytes()
+	case !projected:
+		setETagSource(r, data)
+		data = appendMeta(data, meta)
+		if validateResponses {
+			checkResponse(r, v, data)
+		}
+	}
+	w.WriteHeader(status)
+	_, err := w.Write(data)
+	return err
+}
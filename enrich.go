@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+const bucketEnrichment = "enrichment"
+
+// EnrichmentConfig runs a background worker that reads the detail page of
+// every stored car, so list endpoints can show its year, image count, and
+// description without fetching details per request. Each pass reads the
+// cars not enriched within MaxAge, most viewed first, waiting Delay between
+// them, and passes start every Interval. The HotCars most viewed cars are
+// also read again every HotInterval, keeping their pages cached. With
+// ImageHashes, each car's images are downloaded and their perceptual hashes
+// stored for /images/similar.
+type EnrichmentConfig struct {
+	Enabled     bool     `json:"enabled"`
+	Interval    Duration `json:"interval"`
+	Delay       Duration `json:"delay"`
+	MaxAge      Duration `json:"max_age"`
+	HotCars     int      `json:"hot_cars"`
+	HotInterval Duration `json:"hot_interval"`
+	ImageHashes bool     `json:"image_hashes"`
+}
+
+// CarEnrichment is what a car's detail page added to its listing.
+type CarEnrichment struct {
+	Year        *int    `json:"year"`
+	ImageCount  int     `json:"image_count"`
+	Description *string `json:"description"`
+	// Thumbnail is the first image on the detail page, for cars listed
+	// without one
+	Thumbnail  *string    `json:"thumbnail,omitempty"`
+	PostedAt   *time.Time `json:"posted_at,omitempty"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+	EnrichedAt time.Time  `json:"enriched_at"`
+}
+
+// newCarEnrichment takes the enrichment of a car from its details. The
+// year is read from the title, or failing that the description, and the
+// thumbnail is the first image's.
+func newCarEnrichment(details *CarDetails, now time.Time) CarEnrichment {
+	year := partasala.ModelYear(details.Name)
+	if year == nil && details.Description != nil {
+		year = partasala.ModelYear(*details.Description)
+	}
+	enrichment := CarEnrichment{
+		Year:        year,
+		ImageCount:  details.ImageCount,
+		Description: details.Description,
+		PostedAt:    details.PostedAt,
+		UpdatedAt:   details.UpdatedAt,
+		EnrichedAt:  now.UTC(),
+	}
+	if len(details.Images) > 0 {
+		thumbnail := details.Images[0].Thumbnail
+		if thumbnail == "" {
+			thumbnail = details.Images[0].URL
+		}
+		enrichment.Thumbnail = &thumbnail
+	}
+	return enrichment
+}
+
+// loadEnrichments fills the cached enrichments from the store. The caller
+// holds d.enrichmentMu.
+func (d *Dataset) loadEnrichments() error {
+	if d.enrichments != nil {
+		return nil
+	}
+	enrichments := map[string]CarEnrichment{}
+	err := d.store.ForEach(bucketEnrichment, func(key string, value []byte) error {
+		var enrichment CarEnrichment
+		if err := json.Unmarshal(value, &enrichment); err != nil {
+			return fmt.Errorf("enrichment %s: %v", key, err)
+		}
+		enrichments[key] = enrichment
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	d.enrichments = enrichments
+	return nil
+}
+
+// forgetEnrichments drops the cached enrichments, for when they are
+// replaced behind SaveEnrichment's back, as by a restore.
+func (d *Dataset) forgetEnrichments() {
+	d.enrichmentMu.Lock()
+	d.enrichments = nil
+	d.enrichmentMu.Unlock()
+}
+
+// SaveEnrichment stores what slug's detail page added to its listing.
+func (d *Dataset) SaveEnrichment(slug string, enrichment CarEnrichment) error {
+	if err := putJSON(d.store, bucketEnrichment, slug, enrichment); err != nil {
+		return err
+	}
+	d.enrichmentMu.Lock()
+	defer d.enrichmentMu.Unlock()
+	if d.enrichments != nil {
+		d.enrichments[slug] = enrichment
+	}
+	return nil
+}
+
+// Enrichment returns slug's stored enrichment, if it has one.
+func (d *Dataset) Enrichment(slug string) (CarEnrichment, bool, error) {
+	d.enrichmentMu.Lock()
+	defer d.enrichmentMu.Unlock()
+	if err := d.loadEnrichments(); err != nil {
+		return CarEnrichment{}, false, err
+	}
+	enrichment, ok := d.enrichments[slug]
+	return enrichment, ok, nil
+}
+
+// AddEnrichment fills in the image count, description, and post dates of
+// cars from their stored enrichment, and their year and thumbnail where
+// the listing had none.
+func (d *Dataset) AddEnrichment(cars []Car) {
+	d.enrichmentMu.Lock()
+	defer d.enrichmentMu.Unlock()
+	if err := d.loadEnrichments(); err != nil {
+		log.Printf("enrichment: %v", err)
+		return
+	}
+
+	for i := range cars {
+		enrichment, ok := d.enrichments[cars[i].Slug]
+		if !ok {
+			continue
+		}
+		if cars[i].Year == nil {
+			cars[i].Year = enrichment.Year
+		}
+		if cars[i].Thumbnail == nil {
+			cars[i].Thumbnail = enrichment.Thumbnail
+		}
+		imageCount := enrichment.ImageCount
+		cars[i].ImageCount = &imageCount
+		cars[i].Description = enrichment.Description
+		cars[i].PostedAt, cars[i].UpdatedAt = enrichment.PostedAt, enrichment.UpdatedAt
+	}
+}
+
+// annotateCars adds what the server knows about cars beyond their
+// listing: when they were seen, and what their detail pages added.
+func annotateCars(cars []Car) {
+	dataset.AddSightings(cars)
+	dataset.AddEnrichment(cars)
+}
+
+// hotCarsDays is how many days of views pick the cars warmed as hot.
+const hotCarsDays = 7
+
+// Enricher reads the detail pages of stored cars in the background.
+type Enricher struct {
+	config     EnrichmentConfig
+	scraper    SiteScraper
+	dataset    *Dataset
+	popularity *PopularityCounter
+}
+
+func NewEnricher(config EnrichmentConfig, scraper SiteScraper, dataset *Dataset, popularity *PopularityCounter) *Enricher {
+	return &Enricher{config: config, scraper: scraper, dataset: dataset, popularity: popularity}
+}
+
+// Run makes a pass right away and then every interval until ctx is
+// cancelled. With HotCars set, the hot cars are warmed every HotInterval
+// in between.
+func (e *Enricher) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.config.Interval.Duration)
+	defer ticker.Stop()
+	var warm <-chan time.Time
+	if e.config.HotCars > 0 {
+		warmTicker := time.NewTicker(e.config.HotInterval.Duration)
+		defer warmTicker.Stop()
+		warm = warmTicker.C
+	}
+
+	pass := e.Pass
+	for {
+		if err := pass(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("enrichment: %v", err)
+			recent.addError("enrichment", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pass = e.Pass
+		case <-warm:
+			pass = e.Warm
+		}
+	}
+}
+
+// Warm reads the details of the HotCars cars viewed most over the last
+// hotCarsDays days again, whatever their age, so requests for them are
+// served from the page caches instead of waiting on upstream. It stops
+// early while upstream is down.
+func (e *Enricher) Warm(ctx context.Context) error {
+	hot, err := e.popularity.Popular(hotCarsDays, e.config.HotCars, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, car := range hot {
+		if upstreamDown() {
+			return nil
+		}
+		if _, err := e.enrich(car.Slug); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(e.config.Delay.Duration):
+		}
+	}
+	return nil
+}
+
+// enrich reads slug's details and stores them with its enrichment,
+// reporting whether they loaded. A car whose details fail to load is
+// logged and left for later; only store errors are returned.
+func (e *Enricher) enrich(slug string) (bool, error) {
+	details, err := e.scraper.GetCarDetails(slug)
+	if err != nil {
+		log.Printf("enrichment: %s: %v", slug, err)
+		return false, nil
+	}
+	if err := e.dataset.SaveDetails(details); err != nil {
+		return false, err
+	}
+	if hasher, ok := e.scraper.(ImageHasher); ok && e.config.ImageHashes {
+		if err := e.dataset.SaveImageHashes(slug, hashImages(hasher, details)); err != nil {
+			return false, err
+		}
+	}
+	return true, e.dataset.SaveEnrichment(slug, newCarEnrichment(details, time.Now()))
+}
+
+// Pass enriches the stored cars whose enrichment is missing or older than
+// MaxAge, the ones viewed most over the last hotCarsDays days first. Cars
+// whose details fail to load are left for the next pass; the pass stops
+// early while upstream is down.
+func (e *Enricher) Pass(ctx context.Context) error {
+	cars, err := e.dataset.Cars()
+	if err != nil {
+		return err
+	}
+	views, err := e.popularity.Views(hotCarsDays, time.Now())
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(cars, func(i, j int) bool { return views[cars[i].Slug] > views[cars[j].Slug] })
+
+	enriched, failed := 0, 0
+	for _, car := range cars {
+		previous, ok, err := e.dataset.Enrichment(car.Slug)
+		if err != nil {
+			return err
+		}
+		if ok && time.Since(previous.EnrichedAt) < e.config.MaxAge.Duration {
+			continue
+		}
+		if upstreamDown() {
+			log.Printf("enrichment: upstream is down, stopping after %d cars", enriched)
+			return nil
+		}
+
+		ok, err = e.enrich(car.Slug)
+		if err != nil {
+			return err
+		}
+		if ok {
+			enriched++
+		} else {
+			failed++
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(e.config.Delay.Duration):
+		}
+	}
+	if enriched > 0 || failed > 0 {
+		log.Printf("enrichment: enriched %d cars, %d failed", enriched, failed)
+	}
+	return nil
+}
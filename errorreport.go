@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// ErrorReportingConfig sends handler panics, crawl errors, and pages that
+// stop parsing to Sentry, or a service that takes Sentry's protocol like
+// GlitchTip, when DSN is set. Events never carry client IP addresses.
+type ErrorReportingConfig struct {
+	DSN         string `json:"dsn"`
+	Environment string `json:"environment"`
+}
+
+// errorReportQueueSize is how many events may wait to be sent; more are
+// dropped, so a burst of errors can't pile up memory.
+const errorReportQueueSize = 100
+
+// errorReportHeaders are the request headers sent with panic reports.
+// Headers that can carry the client's address, like X-Forwarded-For, or
+// credentials are left out.
+var errorReportHeaders = []string{"Accept", "Accept-Encoding", "Content-Type", "User-Agent"}
+
+// ErrorReporter sends error events to a Sentry project in the background.
+// A nil ErrorReporter drops everything, so callers needn't check whether
+// error reporting is configured.
+type ErrorReporter struct {
+	dsn         string
+	endpoint    string
+	auth        string
+	environment string
+	serverName  string
+	client      *http.Client
+	queue       chan sentryEvent
+}
+
+var errorReporter *ErrorReporter
+
+// parseSentryDSN returns the envelope endpoint and public key of a DSN like
+// https://<key>@o123.ingest.sentry.io/<project>.
+func parseSentryDSN(dsn string) (endpoint, key string, err error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.User == nil || u.User.Username() == "" {
+		return "", "", fmt.Errorf("dsn must look like https://<key>@<host>/<project>")
+	}
+	path := strings.TrimRight(u.Path, "/")
+	slash := strings.LastIndex(path, "/")
+	project := path[slash+1:]
+	if project == "" {
+		return "", "", fmt.Errorf("dsn must end with the project id")
+	}
+	return u.Scheme + "://" + u.Host + path[:slash] + "/api/" + project + "/envelope/", u.User.Username(), nil
+}
+
+// NewErrorReporter returns a reporter for config, or nil if no DSN is set.
+func NewErrorReporter(config ErrorReportingConfig) (*ErrorReporter, error) {
+	if config.DSN == "" {
+		return nil, nil
+	}
+	endpoint, key, err := parseSentryDSN(config.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("error_reporting: %v", err)
+	}
+	serverName, _ := os.Hostname()
+	return &ErrorReporter{
+		dsn:         config.DSN,
+		endpoint:    endpoint,
+		auth:        "Sentry sentry_version=7, sentry_client=partasala-api/" + version + ", sentry_key=" + key,
+		environment: config.Environment,
+		serverName:  serverName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan sentryEvent, errorReportQueueSize),
+	}, nil
+}
+
+type sentryException struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sentryRequest struct {
+	Method      string            `json:"method"`
+	URL         string            `json:"url"`
+	QueryString string            `json:"query_string,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+// sentryEvent is the subset of Sentry's event payload the reporter sends.
+type sentryEvent struct {
+	EventID     string    `json:"event_id"`
+	Timestamp   time.Time `json:"timestamp"`
+	Platform    string    `json:"platform"`
+	Level       string    `json:"level"`
+	Logger      string    `json:"logger"`
+	ServerName  string    `json:"server_name,omitempty"`
+	Environment string    `json:"environment,omitempty"`
+	Exception   struct {
+		Values []sentryException `json:"values"`
+	} `json:"exception"`
+	Tags    map[string]string      `json:"tags,omitempty"`
+	Request *sentryRequest         `json:"request,omitempty"`
+	Extra   map[string]interface{} `json:"extra,omitempty"`
+}
+
+func (e *ErrorReporter) newEvent(kind, level, message string) sentryEvent {
+	id := make([]byte, 16)
+	rand.Read(id)
+	event := sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   time.Now().UTC(),
+		Platform:    "go",
+		Level:       level,
+		Logger:      kind,
+		ServerName:  e.serverName,
+		Environment: e.environment,
+		Tags:        map[string]string{"kind": kind},
+	}
+	event.Exception.Values = []sentryException{{Type: kind, Value: message}}
+	return event
+}
+
+// enqueue queues event for sending, dropping it if the queue is full.
+func (e *ErrorReporter) enqueue(event sentryEvent) {
+	select {
+	case e.queue <- event:
+	default:
+		log.Printf("error reporting: queue full, dropping %s event", event.Logger)
+	}
+}
+
+// Capture reports err under kind, e.g. "crawl", at level "error" or
+// "warning".
+func (e *ErrorReporter) Capture(kind, level string, err error) {
+	if e == nil {
+		return
+	}
+	e.enqueue(e.newEvent(kind, level, err.Error()))
+}
+
+// CapturePanic reports a handler panic with the request it happened in and
+// the panicking goroutine's stack.
+func (e *ErrorReporter) CapturePanic(r *http.Request, value interface{}, stack []byte) {
+	if e == nil {
+		return
+	}
+	event := e.newEvent("panic", "fatal", fmt.Sprint(value))
+	headers := map[string]string{}
+	for _, name := range errorReportHeaders {
+		if v := r.Header.Get(name); v != "" {
+			headers[name] = v
+		}
+	}
+	event.Request = &sentryRequest{Method: r.Method, URL: r.URL.Path, QueryString: r.URL.RawQuery, Headers: headers}
+	if id := requestID(r); id != "" {
+		event.Tags["request_id"] = id
+	}
+	event.Extra = map[string]interface{}{"stack": string(stack)}
+	e.enqueue(event)
+}
+
+// send posts event to Sentry as an envelope.
+func (e *ErrorReporter) send(event sentryEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	header, _ := json.Marshal(map[string]interface{}{"event_id": event.EventID, "dsn": e.dsn, "sent_at": time.Now().UTC()})
+	item, _ := json.Marshal(map[string]interface{}{"type": "event", "length": len(payload)})
+
+	var body bytes.Buffer
+	for _, line := range [][]byte{header, item, payload} {
+		body.Write(line)
+		body.WriteByte('\n')
+	}
+	req, err := http.NewRequest("POST", e.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", e.auth)
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("sentry answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Run sends queued events until ctx is cancelled.
+func (e *ErrorReporter) Run(ctx context.Context) {
+	if e == nil {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-e.queue:
+			if err := e.send(event); err != nil {
+				log.Printf("error reporting: %v", err)
+			}
+		}
+	}
+}
+
+// recoverMiddleware turns a handler panic into a 500 response, logs it with
+// its stack, and reports it. Panics with http.ErrAbortHandler are passed
+// on, as they abort the response on purpose.
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			value := recover()
+			if value == nil {
+				return
+			}
+			if value == http.ErrAbortHandler {
+				panic(value)
+			}
+			stack := debug.Stack()
+			log.Printf("panic serving %s %s: %v\n%s", r.Method, r.URL.Path, value, stack)
+			recent.addError("panic", fmt.Errorf("%s %s: %v", r.Method, r.URL.Path, value))
+			errorReporter.CapturePanic(r, value, stack)
+
+			if isV2(r) {
+				writeError(w, r, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(struct {
+				APIResponse
+				RequestID string `json:"request_id,omitempty"`
+			}{APIResponse{Success: false, Error: "Internal server error"}, requestID(r)})
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
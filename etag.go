@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// etagMaxBody is the largest response given an ETag. Bigger ones, and
+	// ones the handler flushes, are streamed as they are written instead.
+	etagMaxBody = 1 << 20
+	// etagHistorySize bounds how many ETags Last-Modified is kept for.
+	etagHistorySize = 1000
+)
+
+// etagHistory remembers when each URL was first served with each ETag, as
+// its Last-Modified: the body hasn't changed since then.
+type etagHistory struct {
+	mu    sync.Mutex
+	seen  map[string]time.Time
+	order []string
+}
+
+var etags = &etagHistory{seen: map[string]time.Time{}}
+
+// modified returns when uri was first served with etag, recording now if
+// it wasn't before. The oldest entries are forgotten once the history is
+// full.
+func (h *etagHistory) modified(uri, etag string, now time.Time) time.Time {
+	key := uri + " " + etag
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if t, ok := h.seen[key]; ok {
+		return t
+	}
+	if len(h.order) >= etagHistorySize {
+		delete(h.seen, h.order[0])
+		h.order = h.order[1:]
+	}
+	now = now.UTC().Truncate(time.Second)
+	h.seen[key] = now
+	h.order = append(h.order, key)
+	return now
+}
+
+// etagMatches reports whether an If-None-Match header names etag.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// etagSource is what a response's ETag is computed from instead of its
+// body, when the handler gives one with setETagSource.
+type etagSource struct {
+	data []byte
+}
+
+type etagSourceKey struct{}
+
+// setETagSource has r's ETag computed from data rather than the body, for
+// a body with parts that change on every request, like the meta respond
+// adds, so the ETag still changes only with the data.
+func setETagSource(r *http.Request, data []byte) {
+	if source, ok := r.Context().Value(etagSourceKey{}).(*etagSource); ok {
+		source.data = data
+	}
+}
+
+// etagWriter holds back a successful response until it is complete, so it
+// can be given an ETag, or falls back to passing it through.
+type etagWriter struct {
+	http.ResponseWriter
+	status  int
+	body    bytes.Buffer
+	through bool
+	source  etagSource
+}
+
+// passThrough sends what was held back and lets the rest of the response
+// through as it is written.
+func (w *etagWriter) passThrough() {
+	if w.through {
+		return
+	}
+	w.through = true
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if w.body.Len() > 0 {
+		w.ResponseWriter.Write(w.body.Bytes())
+		w.body.Reset()
+	}
+}
+
+func (w *etagWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	if status != http.StatusOK || w.Header().Get("ETag") != "" {
+		w.passThrough()
+	}
+}
+
+func (w *etagWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.through {
+		return w.ResponseWriter.Write(p)
+	}
+	if w.body.Len()+len(p) > etagMaxBody {
+		w.passThrough()
+		return w.ResponseWriter.Write(p)
+	}
+	return w.body.Write(p)
+}
+
+func (w *etagWriter) Flush() {
+	w.passThrough()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack hands the connection over for a WebSocket, with nothing held
+// back for finish to send.
+func (w *etagWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.through = true
+	return hijack(w.ResponseWriter)
+}
+
+// finish sends a held back response with its ETag and Last-Modified, or
+// just 304 Not Modified when the client has it already.
+func (w *etagWriter) finish(r *http.Request) {
+	if w.through || w.status == 0 {
+		return
+	}
+	header := w.Header()
+	sum := sha256.Sum256(w.body.Bytes())
+	if w.source.data != nil {
+		// The body is encoded as sent, so each coding gets its own ETag
+		sum = sha256.Sum256(append([]byte(header.Get("Content-Encoding")+"\x00"), w.source.data...))
+	}
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	header.Set("ETag", etag)
+	if header.Get("Last-Modified") == "" {
+		header.Set("Last-Modified", etags.modified(r.URL.RequestURI(), etag, time.Now()).Format(http.TimeFormat))
+	}
+
+	if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, etag) {
+		for _, name := range []string{"Content-Type", "Content-Length", "Content-Encoding"} {
+			header.Del(name)
+		}
+		w.ResponseWriter.WriteHeader(http.StatusNotModified)
+		return
+	}
+	header.Set("Content-Length", strconv.Itoa(w.body.Len()))
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(w.body.Bytes())
+}
+
+// etagMiddleware gives successful GET responses of up to etagMaxBody a
+// strong ETag, the hash of the body as sent or of its setETagSource, and a
+// Last-Modified of when the URL was first served with that body, and
+// answers 304 Not Modified when If-None-Match names the ETag. It runs
+// outside compressMiddleware, so each content coding gets an ETag of its
+// own.
+func etagMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ew := &etagWriter{ResponseWriter: w}
+		defer ew.finish(r)
+		r = r.WithContext(context.WithValue(r.Context(), etagSourceKey{}, &ew.source))
+		next.ServeHTTP(ew, r)
+	})
+}
+
+// headWriter drops the body of a response, counting its bytes, and holds
+// the status back until the handler is done so Content-Length can be set.
+type headWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *headWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.bytes += len(p)
+	return len(p), nil
+}
+
+func (w *headWriter) finish() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.Header().Get("Content-Length") == "" && w.status != http.StatusNotModified && w.status != http.StatusNoContent {
+		w.Header().Set("Content-Length", strconv.Itoa(w.bytes))
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// headCacheTTL is how long the headers of a GET answer HEAD requests for
+// the same URL.
+const headCacheTTL = 5 * time.Minute
+
+// headHeaders are the headers of a GET that describe its body, and so
+// answer a HEAD for it.
+var headHeaders = []string{"Content-Type", "Content-Length", "Content-Encoding", "ETag", "Last-Modified", "Vary"}
+
+// headCache remembers the headers of recent complete GET responses, for
+// HEAD requests to answer from without running the handler.
+type headCache struct {
+	mu      sync.Mutex
+	entries map[string]headEntry
+	order   []string
+}
+
+type headEntry struct {
+	header http.Header
+	stored time.Time
+}
+
+var heads = &headCache{entries: map[string]headEntry{}}
+
+// headCacheKey is what a response's headers depend on: the URL, how the
+// body is negotiated, and the API key, so no one is answered from the
+// response to someone else's credentials.
+func headCacheKey(r *http.Request) string {
+	key := requestKey(r)
+	if key != "" {
+		key = tokenHash(key)
+	}
+	return strings.Join([]string{r.URL.RequestURI(), r.Header.Get("Accept"), r.Header.Get("Accept-Encoding"), r.Header.Get("Accept-Language"), key}, "\x00")
+}
+
+// store keeps the headers of a GET that sent its whole body with an
+// ETag. The oldest entries are forgotten once etagHistorySize are kept.
+func (c *headCache) store(r *http.Request, header http.Header, now time.Time) {
+	if r.Header.Get("If-None-Match") != "" || header.Get("ETag") == "" || header.Get("Content-Length") == "" {
+		return
+	}
+	kept := http.Header{}
+	for _, name := range headHeaders {
+		if values := header.Values(name); len(values) > 0 {
+			kept[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+		}
+	}
+	key := headCacheKey(r)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		if len(c.order) >= etagHistorySize {
+			delete(c.entries, c.order[0])
+			c.order = c.order[1:]
+		}
+		c.order = append(c.order, key)
+	}
+	c.entries[key] = headEntry{header: kept, stored: now}
+}
+
+// lookup returns the headers kept for r, if they are recent enough.
+func (c *headCache) lookup(r *http.Request, now time.Time) (http.Header, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[headCacheKey(r)]
+	if !ok || now.Sub(entry.stored) > headCacheTTL {
+		return nil, false
+	}
+	return entry.header, true
+}
+
+// clear forgets every entry, for when the data behind the responses has
+// changed.
+func (c *headCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries, c.order = map[string]headEntry{}, nil
+}
+
+type headKey struct{}
+
+// isHead reports whether r is a HEAD request being served as a GET.
+func isHead(r *http.Request) bool {
+	head, _ := r.Context().Value(headKey{}).(bool)
+	return head
+}
+
+// headMiddleware answers HEAD for every GET route, without scraping
+// upstream: with the headers of a GET for the same URL from the last
+// headCacheTTL, or 304 Not Modified when If-None-Match names its ETag, and
+// otherwise by serving the request as a GET from the stored dataset (see
+// siteFor) and sending its headers, with the body's Content-Length, but
+// not the body.
+func headMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			next.ServeHTTP(w, r)
+			heads.store(r, w.Header(), time.Now())
+			return
+		case "HEAD":
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if header, ok := heads.lookup(r, time.Now()); ok {
+			for name, values := range header {
+				w.Header()[name] = values
+			}
+			if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, header.Get("ETag")) {
+				for _, name := range []string{"Content-Type", "Content-Length", "Content-Encoding"} {
+					w.Header().Del(name)
+				}
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		get := r.Clone(context.WithValue(r.Context(), headKey{}, true))
+		get.Method = "GET"
+		hw := &headWriter{ResponseWriter: w}
+		defer hw.finish()
+		next.ServeHTTP(hw, get)
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestHeadMiddleware checks that HEAD is answered from the headers of an
+// earlier GET without running the handler again.
+func TestHeadMiddleware(t *testing.T) {
+	heads.clear()
+	t.Cleanup(heads.clear)
+	served := 0
+	handler := headMiddleware(etagMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"success":true}`))
+	})))
+
+	// Nothing to answer from yet, so the handler runs without the body
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("HEAD", "/brands", nil))
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("Content-Length") != "16" {
+		t.Errorf("HEAD: %d, Content-Length %q, body %q", w.Code, w.Header().Get("Content-Length"), w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/brands", nil))
+	etag := w.Header().Get("ETag")
+	served = 0
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("HEAD", "/brands", nil))
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != etag || w.Header().Get("Content-Length") != "16" {
+		t.Errorf("HEAD after GET: %d, ETag %q, Content-Length %q", w.Code, w.Header().Get("ETag"), w.Header().Get("Content-Length"))
+	}
+
+	r := httptest.NewRequest("HEAD", "/brands", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("HEAD with If-None-Match: %d, want 304", w.Code)
+	}
+
+	// Another API key isn't answered from the GET
+	r = httptest.NewRequest("HEAD", "/brands", nil)
+	r.Header.Set("X-API-Key", "other")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if served != 1 {
+		t.Errorf("the handler ran %d times for HEAD after GET, want 1", served)
+	}
+}
+
+// TestHeadUsesStoredData checks that a HEAD the handler runs for reads the
+// stored dataset rather than scraping upstream.
+func TestHeadUsesStoredData(t *testing.T) {
+	heads.clear()
+	t.Cleanup(heads.clear)
+	withUsage(t, QuotaConfig{})
+	upstream := &crawlScraper{failing: map[string]bool{"toyota": true}}
+	saved := scraper
+	scraper = upstream
+	t.Cleanup(func() { scraper = saved })
+
+	handler := headMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := siteFor(r).GetBrandCars("toyota"); err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("HEAD", "/brands/toyota", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("HEAD: %d, want 200 from the stored cars", w.Code)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/brands/toyota", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("GET: %d, want the upstream failure", w.Code)
+	}
+}
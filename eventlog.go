@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const bucketEvents = "events"
+
+// Types of ChangeEvent besides EventCarAdded and EventCarRemoved.
+const (
+	EventPriceChanged      = "price_changed"
+	EventImageCountChanged = "image_count_changed"
+)
+
+const (
+	defaultEventHistoryLimit = 100
+	maxEventHistoryLimit     = 1000
+)
+
+// ChangeEvent is one change the crawler or a detail fetch noticed, kept in
+// an append-only log. Price and ImageCount are the new values and the
+// Previous fields the old ones, for the event types they apply to.
+type ChangeEvent struct {
+	At                 time.Time `json:"at"`
+	Type               string    `json:"type"`
+	Car                string    `json:"car"`
+	Name               string    `json:"name,omitempty"`
+	Brand              string    `json:"brand,omitempty"`
+	Price              *Price    `json:"price,omitempty"`
+	PreviousPrice      *Price    `json:"previous_price,omitempty"`
+	ImageCount         *int      `json:"image_count,omitempty"`
+	PreviousImageCount *int      `json:"previous_image_count,omitempty"`
+}
+
+// appendEvents adds events to the log. Keys sort by time, so the log reads
+// back in the order it was written.
+func (d *Dataset) appendEvents(events []ChangeEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	puts := make(map[string][]byte, len(events))
+	for i, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		key := fmt.Sprintf("%s-%06d-%s", event.At.UTC().Format("20060102T150405.000000000"), i, event.Car)
+		puts[key] = data
+	}
+	if err := d.store.Batch(bucketEvents, puts, nil); err != nil {
+		return err
+	}
+
+	d.eventsMu.Lock()
+	defer d.eventsMu.Unlock()
+	if d.eventsAppended != nil {
+		close(d.eventsAppended)
+		d.eventsAppended = nil
+	}
+	return nil
+}
+
+// EventsAppended returns a channel that is closed the next time events
+// are added to the log.
+func (d *Dataset) EventsAppended() <-chan struct{} {
+	d.eventsMu.Lock()
+	defer d.eventsMu.Unlock()
+	if d.eventsAppended == nil {
+		d.eventsAppended = make(chan struct{})
+	}
+	return d.eventsAppended
+}
+
+// EventFilter selects events from the log. Zero values match everything;
+// Since is inclusive and Until exclusive.
+type EventFilter struct {
+	Types []string
+	Brand string
+	Since time.Time
+	Until time.Time
+}
+
+func (f EventFilter) matches(event ChangeEvent) bool {
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			found = found || t == event.Type
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.Brand != "" && f.Brand != event.Brand {
+		return false
+	}
+	if !f.Since.IsZero() && event.At.Before(f.Since) {
+		return false
+	}
+	return f.Until.IsZero() || event.At.Before(f.Until)
+}
+
+// Events returns up to limit events matching filter, newest first.
+func (d *Dataset) Events(filter EventFilter, limit int) ([]ChangeEvent, error) {
+	all := []ChangeEvent{}
+	err := d.store.ForEach(bucketEvents, func(key string, value []byte) error {
+		var event ChangeEvent
+		if err := json.Unmarshal(value, &event); err != nil {
+			return fmt.Errorf("event %s: %v", key, err)
+		}
+		if filter.matches(event) {
+			all = append(all, event)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	events := []ChangeEvent{}
+	for i := len(all) - 1; i >= 0 && len(events) < limit; i-- {
+		events = append(events, all[i])
+	}
+	return events, nil
+}
+
+// EventsAfter returns up to limit events matching filter that were logged
+// after the event cursor names, oldest first, and the cursor to ask for
+// the next ones with. An empty cursor names the newest event, so nothing
+// is returned, only the cursor to start from.
+func (d *Dataset) EventsAfter(cursor string, filter EventFilter, limit int) ([]ChangeEvent, string, error) {
+	events := []ChangeEvent{}
+	next := cursor
+	err := d.store.ForEach(bucketEvents, func(key string, value []byte) error {
+		if (cursor != "" && key <= cursor) || len(events) >= limit {
+			return nil
+		}
+		next = key
+		if cursor == "" {
+			return nil
+		}
+		var event ChangeEvent
+		if err := json.Unmarshal(value, &event); err != nil {
+			return fmt.Errorf("event %s: %v", key, err)
+		}
+		if filter.matches(event) {
+			events = append(events, event)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return events, next, nil
+}
+
+// parseEventTypes parses a comma-separated ?type list of event types.
+func parseEventTypes(v string) ([]string, error) {
+	var types []string
+	for _, t := range strings.Split(v, ",") {
+		switch t {
+		case EventCarAdded, EventCarRemoved, EventPriceChanged, EventImageCountChanged:
+			types = append(types, t)
+		default:
+			return nil, fmt.Errorf("Unknown event type %q", t)
+		}
+	}
+	return types, nil
+}
+
+func eventHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := EventFilter{Brand: query.Get("brand")}
+	badRequest := func(message string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   message,
+		})
+	}
+
+	if v := query.Get("type"); v != "" {
+		types, err := parseEventTypes(v)
+		if err != nil {
+			badRequest(err.Error())
+			return
+		}
+		filter.Types = types
+	}
+	for _, param := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		if v := query.Get(param.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				badRequest(param.name + " must be an RFC 3339 time, e.g. 2024-05-01T00:00:00Z")
+				return
+			}
+			*param.t = t
+		}
+	}
+	limit := defaultEventHistoryLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxEventHistoryLimit {
+			badRequest("limit must be between 1 and " + strconv.Itoa(maxEventHistoryLimit))
+			return
+		}
+		limit = n
+	}
+
+	events, err := dataset.Events(filter, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Count:   len(events),
+		Data:    events,
+	})
+}
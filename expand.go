@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+)
+
+const (
+	// maxExpandedCars is the most cars a list request may ask the details
+	// of with ?details=true.
+	maxExpandedCars = 100
+	// expandWorkers is how many details are fetched at once.
+	expandWorkers = 8
+)
+
+// CarWithDetails is a listed car with its details inline, for list
+// requests made with ?details=true. Details is null, and DetailsError says
+// why, for cars whose details could neither be fetched nor read from the
+// store.
+type CarWithDetails struct {
+	Car
+	Details      *CarDetails `json:"details"`
+	DetailsError string      `json:"details_error,omitempty"`
+}
+
+// wantsDetails reports whether a list request asked for each car's details
+// inline, with ?details=true.
+func wantsDetails(r *http.Request) bool {
+	return r.URL.Query().Get("details") == "true"
+}
+
+// checkExpandable answers 400 and returns false if count cars are too many
+// to expand.
+func checkExpandable(w http.ResponseWriter, r *http.Request, count int) bool {
+	if count <= maxExpandedCars {
+		return true
+	}
+	respond(w, r, http.StatusBadRequest, APIResponse{
+		Success: false,
+		Error:   fmt.Sprintf("details=true covers at most %d cars and this listing has %d; narrow it with filters", maxExpandedCars, count),
+	})
+	return false
+}
+
+// expandDetails fetches the details of cars, expandWorkers at a time, and
+// stores them as when they are served one by one. Details that fail to load
+// are read from the store instead, which covers removed cars and upstream
+// being down.
+func expandDetails(site SiteScraper, cars []Car) []CarWithDetails {
+	expanded := make([]CarWithDetails, len(cars))
+	slugs := make(chan int)
+	var wg sync.WaitGroup
+	for n := 0; n < min(expandWorkers, len(cars)); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range slugs {
+				expanded[i] = expandCar(site, cars[i])
+			}
+		}()
+	}
+	for i := range cars {
+		slugs <- i
+	}
+	close(slugs)
+	wg.Wait()
+	return expanded
+}
+
+func expandCar(site SiteScraper, car Car) CarWithDetails {
+	details, err := site.GetCarDetails(car.Slug)
+	if err == nil {
+		if err := dataset.SaveDetails(details); err != nil {
+			log.Printf("dataset: %v", err)
+		}
+		return CarWithDetails{Car: car, Details: details}
+	}
+	if stored, storeErr := dataset.Details(car.Slug); storeErr == nil {
+		return CarWithDetails{Car: car, Details: stored}
+	}
+	return CarWithDetails{Car: car, DetailsError: err.Error()}
+}
+
+// collectCars gathers what produce sends, for listings that are expanded
+// instead of streamed.
+func collectCars(ctx context.Context, produce func(context.Context, chan<- Car) error) ([]Car, error) {
+	cars := make(chan Car, 64)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- produce(ctx, cars)
+		close(cars)
+	}()
+
+	list := []Car{}
+	for car := range cars {
+		list = append(list, car)
+	}
+	return list, <-errc
+}
+
+// writeExpandedCars writes the cars produce sends with their details
+// inline, falling back to the stored cars while upstream is down. Unlike
+// the plain listing it isn't streamed, as the cars have to be counted
+// against maxExpandedCars before any details are fetched.
+func writeExpandedCars(w http.ResponseWriter, r *http.Request, site SiteScraper, produce, stored func(context.Context, chan<- Car) error) {
+	stale := false
+	cars, err := collectCars(r.Context(), produce)
+	if err != nil && upstreamDown() {
+		if !hasStoredCars() {
+			writeUpstreamUnavailable(w, r)
+			return
+		}
+		stale = true
+		cars, err = collectCars(r.Context(), stored)
+	}
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	if !checkExpandable(w, r, len(cars)) {
+		return
+	}
+
+	annotateCars(cars)
+	expanded := expandDetails(site, cars)
+	if isV2(r) {
+		respond(w, r, http.StatusOK, v2List(expanded, V2Meta{Stale: stale}))
+		return
+	}
+	respond(w, r, http.StatusOK, APIResponse{
+		Success: true,
+		Count:   len(expanded),
+		Data:    expanded,
+		Stale:   stale,
+	})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+// ListFilter narrows the cars returned by /cars and /search using their
+// query parameters. A zero ListFilter matches every car.
+type ListFilter struct {
+	// MaxPrice drops cars priced above it, and cars without a price
+	MaxPrice *int64
+	// Year, YearMin, and YearMax keep cars of that model year or range,
+	// dropping cars whose name has no year
+	Year, YearMin, YearMax *int
+	// Plate keeps only the car registered under it, normalized with
+	// partasala.NormalizePlate
+	Plate string
+
+	// plateSlugs holds the cars whose stored details carry Plate
+	plateSlugs map[string]bool
+}
+
+// parseListFilter reads a ListFilter from query, failing on values that
+// do not parse.
+func parseListFilter(query url.Values) (ListFilter, error) {
+	var f ListFilter
+	if v := query.Get("max_price"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return f, fmt.Errorf("max_price must be a whole number of krónur")
+		}
+		f.MaxPrice = &n
+	}
+	for param, field := range map[string]**int{"year": &f.Year, "year_min": &f.YearMin, "year_max": &f.YearMax} {
+		if v := query.Get(param); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return f, fmt.Errorf("%s must be a year, e.g. 2014", param)
+			}
+			*field = &n
+		}
+	}
+	f.Plate = partasala.NormalizePlate(query.Get("plate"))
+	return f, nil
+}
+
+// listFilter reads the ListFilter of r, writing an error response and
+// returning false if it can't. Plates are only known from car details, so
+// a plate is looked up in the details stored by crawls and /cars/<car_slug>.
+func listFilter(w http.ResponseWriter, r *http.Request) (ListFilter, bool) {
+	filter, err := parseListFilter(r.URL.Query())
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return filter, false
+	}
+
+	if filter.Plate != "" {
+		all, err := dataset.AllDetails()
+		if err != nil {
+			respond(w, r, http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return filter, false
+		}
+		filter.plateSlugs = make(map[string]bool)
+		for _, details := range all {
+			if details.Plate != nil && *details.Plate == filter.Plate {
+				filter.plateSlugs[details.Slug] = true
+			}
+		}
+	}
+	return filter, true
+}
+
+// Matches reports whether car satisfies every condition of the filter.
+func (f ListFilter) Matches(car Car) bool {
+	if f.MaxPrice != nil && (car.Price == nil || car.Price.Amount > *f.MaxPrice) {
+		return false
+	}
+	if f.Year != nil || f.YearMin != nil || f.YearMax != nil {
+		if car.Year == nil ||
+			(f.Year != nil && *car.Year != *f.Year) ||
+			(f.YearMin != nil && *car.Year < *f.YearMin) ||
+			(f.YearMax != nil && *car.Year > *f.YearMax) {
+			return false
+		}
+	}
+	if f.Plate != "" && !f.plateSlugs[car.Slug] {
+		return false
+	}
+	return true
+}
+
+// Apply returns the cars that match the filter.
+func (f ListFilter) Apply(cars []Car) []Car {
+	matched := []Car{}
+	for _, car := range cars {
+		if f.Matches(car) {
+			matched = append(matched, car)
+		}
+	}
+	return matched
+}
+
+// Produce wraps a car producer so only matching cars are sent to out.
+func (f ListFilter) Produce(produce func(ctx context.Context, out chan<- Car) error) func(ctx context.Context, out chan<- Car) error {
+	return func(ctx context.Context, out chan<- Car) error {
+		filtered := make(chan Car)
+		errc := make(chan error, 1)
+		go func() {
+			defer close(filtered)
+			errc <- produce(ctx, filtered)
+		}()
+		for car := range filtered {
+			if !f.Matches(car) {
+				continue
+			}
+			select {
+			case out <- car:
+			case <-ctx.Done():
+				// Drain so the producer can finish
+				for range filtered {
+				}
+				return ctx.Err()
+			}
+		}
+		return <-errc
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+type HealthResponse struct {
+	Status  string                 `json:"status"`
+	Details map[string]interface{} `json:"details"`
+}
+
+// healthzHandler reports "degraded" while probable markup changes are open,
+// the upstream circuit breaker is not closed, or the upstream alert is
+// raised; the API keeps serving, so the status code stays 200.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	issues := structure.Issues()
+	details := map[string]interface{}{
+		"structure_issues": issues,
+	}
+
+	status := "ok"
+	if len(issues) > 0 {
+		status = "degraded"
+	}
+	if circuit, ok := scraper.(UpstreamCircuit); ok {
+		state := circuit.CircuitState()
+		details["upstream_circuit"] = state
+		if state != partasala.CircuitClosed {
+			status = "degraded"
+		}
+	}
+
+	if firing, since := upstreamAlert.Firing(); firing {
+		details["upstream_alert_since"] = since.UTC()
+		status = "degraded"
+	}
+
+	json.NewEncoder(w).Encode(HealthResponse{
+		Status:  status,
+		Details: details,
+	})
+}
+
+// readyzProbeTimeout bounds the upstream probe of a deep readiness check,
+// unless the request asks for less.
+const readyzProbeTimeout = 10 * time.Second
+
+type ReadyResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]interface{} `json:"checks"`
+}
+
+// readyzHandler answers 200 once the store can be read, or 503. With
+// deep=true it also fetches and parses the homepage within timeout
+// (default readyzProbeTimeout) and is only ready if that finds brands.
+// It reports when upstream last answered successfully either way.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]interface{}{}
+	ready := true
+
+	if _, err := dataset.store.Buckets(); err != nil {
+		checks["store"] = err.Error()
+		ready = false
+	} else {
+		checks["store"] = "ok"
+	}
+
+	if r.URL.Query().Get("deep") == "true" {
+		timeout := readyzProbeTimeout
+		if v := r.URL.Query().Get("timeout"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				respond(w, r, http.StatusBadRequest, APIResponse{Success: false, Error: "timeout must be a positive duration, e.g. 5s"})
+				return
+			}
+			timeout = min(d, readyzProbeTimeout)
+		}
+		probe := map[string]interface{}{}
+		if prober, ok := scraper.(UpstreamProber); !ok {
+			probe["error"] = "site does not support upstream probes"
+			ready = false
+		} else {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			started := time.Now()
+			brands, err := prober.Probe(ctx)
+			cancel()
+			probe["duration_ms"] = time.Since(started).Milliseconds()
+			if err != nil {
+				probe["error"] = err.Error()
+				ready = false
+			} else {
+				probe["brands"] = brands
+			}
+		}
+		checks["upstream"] = probe
+	}
+
+	if health, ok := scraper.(UpstreamHealth); ok {
+		if last := health.LastUpstreamSuccess(); !last.IsZero() {
+			checks["last_upstream_success"] = last.UTC()
+		} else {
+			checks["last_upstream_success"] = nil
+		}
+	}
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not_ready", http.StatusServiceUnavailable
+	}
+	respond(w, r, code, ReadyResponse{Status: status, Checks: checks})
+}
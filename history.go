@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const bucketHistory = "history"
+
+// Kinds of HistoryEvent.
+const (
+	HistoryAppeared     = "appeared"
+	HistoryPriceChanged = "price_changed"
+	HistoryRemoved      = "removed"
+)
+
+// CarHistory is how a car's listing changed across crawls: when it was
+// first and last seen, its price changes, and when it was taken down. A car
+// that is listed again after being removed gets another appeared event.
+type CarHistory struct {
+	Slug      string         `json:"slug"`
+	FirstSeen time.Time      `json:"first_seen"`
+	LastSeen  time.Time      `json:"last_seen"`
+	Listed    bool           `json:"listed"`
+	Price     *Price         `json:"price"`
+	Events    []HistoryEvent `json:"events"`
+}
+
+type HistoryEvent struct {
+	At    time.Time `json:"at"`
+	Type  string    `json:"type"`
+	Price *Price    `json:"price,omitempty"`
+}
+
+// RecordHistory updates the history of every car in listed, seen at at,
+// and marks the cars in removed as taken down, archiving their last-known
+// records. The changes are also added to the event log, except for the
+// cars a new store first records, which weren't added so much as found.
+func (d *Dataset) RecordHistory(at time.Time, listed, removed []Car) error {
+	buckets, err := d.store.Buckets()
+	if err != nil {
+		return err
+	}
+	firstRecord := true
+	for _, bucket := range buckets {
+		firstRecord = firstRecord && bucket != bucketHistory
+	}
+
+	puts := make(map[string][]byte, len(listed)+len(removed))
+	events := []ChangeEvent{}
+	archive, relisted := map[string][]byte{}, []string{}
+	update := func(car Car, fn func(h *CarHistory)) error {
+		h, err := d.History(car.Slug)
+		if err == ErrNotFound {
+			h = &CarHistory{Slug: car.Slug, FirstSeen: at}
+		} else if err != nil {
+			return err
+		}
+		fn(h)
+		data, err := json.Marshal(h)
+		if err != nil {
+			return err
+		}
+		puts[car.Slug] = data
+		return nil
+	}
+
+	for _, car := range listed {
+		err := update(car, func(h *CarHistory) {
+			event := ChangeEvent{At: at, Car: car.Slug, Name: car.Name, Brand: car.Brand, Price: car.Price}
+			if !h.Listed {
+				h.Events = append(h.Events, HistoryEvent{At: at, Type: HistoryAppeared, Price: car.Price})
+				relisted = append(relisted, car.Slug)
+				if !firstRecord {
+					event.Type = EventCarAdded
+					events = append(events, event)
+				}
+			} else if !samePrice(h.Price, car.Price) {
+				h.Events = append(h.Events, HistoryEvent{At: at, Type: HistoryPriceChanged, Price: car.Price})
+				event.Type, event.PreviousPrice = EventPriceChanged, h.Price
+				events = append(events, event)
+			}
+			h.Listed = true
+			h.LastSeen = at
+			h.Price = car.Price
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, car := range removed {
+		var record *RemovedCar
+		err := update(car, func(h *CarHistory) {
+			if h.Listed {
+				h.Events = append(h.Events, HistoryEvent{At: at, Type: HistoryRemoved})
+				events = append(events, ChangeEvent{At: at, Type: EventCarRemoved, Car: car.Slug, Name: car.Name, Brand: car.Brand})
+				first, last, removedAt := h.FirstSeen, h.LastSeen, at
+				record = &RemovedCar{Car: car}
+				record.FirstSeen, record.LastSeen, record.RemovedAt = &first, &last, &removedAt
+			}
+			h.Listed = false
+		})
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			continue
+		}
+		if record.Details, err = d.Details(car.Slug); err != nil && err != ErrNotFound {
+			return err
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		archive[car.Slug] = data
+	}
+	if err := d.store.Batch(bucketHistory, puts, nil); err != nil {
+		return err
+	}
+	if err := d.store.Batch(bucketRemoved, archive, relisted); err != nil {
+		return err
+	}
+
+	d.sightingsMu.Lock()
+	if d.sightings != nil {
+		for _, car := range listed {
+			seen, ok := d.sightings[car.Slug]
+			if !ok {
+				seen.first = at
+			}
+			seen.last = at
+			d.sightings[car.Slug] = seen
+		}
+	}
+	d.sightingsMu.Unlock()
+	return d.appendEvents(events)
+}
+
+// forgetSightings drops the cached sightings, for when the history is
+// replaced behind RecordHistory's back, as by a restore.
+func (d *Dataset) forgetSightings() {
+	d.sightingsMu.Lock()
+	d.sightings = nil
+	d.sightingsMu.Unlock()
+}
+
+type sighting struct {
+	first, last time.Time
+}
+
+// AddSightings sets FirstSeen and LastSeen on the cars crawls have listed,
+// from the history. The history is read once and then kept up to date by
+// RecordHistory, so list responses don't need a store read per car.
+func (d *Dataset) AddSightings(cars []Car) {
+	d.sightingsMu.Lock()
+	defer d.sightingsMu.Unlock()
+
+	if d.sightings == nil {
+		sightings := map[string]sighting{}
+		err := d.store.ForEach(bucketHistory, func(key string, value []byte) error {
+			var h CarHistory
+			if err := json.Unmarshal(value, &h); err != nil {
+				return fmt.Errorf("history %s: %v", key, err)
+			}
+			sightings[key] = sighting{first: h.FirstSeen, last: h.LastSeen}
+			return nil
+		})
+		if err != nil {
+			log.Printf("history: %v", err)
+			return
+		}
+		d.sightings = sightings
+	}
+
+	for i := range cars {
+		if seen, ok := d.sightings[cars[i].Slug]; ok {
+			first, last := seen.first, seen.last
+			cars[i].FirstSeen, cars[i].LastSeen = &first, &last
+		}
+	}
+}
+
+// History returns the recorded history of a car, or ErrNotFound if no
+// crawl has listed it.
+func (d *Dataset) History(slug string) (*CarHistory, error) {
+	var h CarHistory
+	if err := getJSON(d.store, bucketHistory, slug, &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// ListedInHistory returns the slugs of the cars whose history says they
+// are still listed.
+func (d *Dataset) ListedInHistory() ([]string, error) {
+	slugs := []string{}
+	err := d.store.ForEach(bucketHistory, func(key string, value []byte) error {
+		var h CarHistory
+		if err := json.Unmarshal(value, &h); err != nil {
+			return fmt.Errorf("history %s: %v", key, err)
+		}
+		if h.Listed {
+			slugs = append(slugs, key)
+		}
+		return nil
+	})
+	return slugs, err
+}
+
+// samePrice reports whether two listed prices are equal, treating two
+// missing prices as equal.
+func samePrice(a, b *Price) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func getCarHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	history, err := dataset.History(mux.Vars(r)["car_slug"])
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "No history recorded for this car",
+		})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Data:    history,
+	})
+}
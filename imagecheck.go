@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// imageCheckWorkers is how many images are checked at once; the scraper's
+// upstream limiter still applies.
+const imageCheckWorkers = 4
+
+// DeadImage is a stored image upstream no longer serves.
+type DeadImage struct {
+	Car    string `json:"car"`
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+}
+
+// ImageCheckReport describes a pass over the stored images.
+type ImageCheckReport struct {
+	Checked int         `json:"checked"`
+	Dead    []DeadImage `json:"dead"`
+	// Failed counts images that couldn't be checked, e.g. because upstream
+	// timed out; they are kept
+	Failed int `json:"failed"`
+	// Removed is whether the dead images were dropped from the stored cars
+	Removed bool `json:"removed"`
+}
+
+// CheckStoredImages sends a HEAD request for every stored car's images and
+// reports those upstream answers 404 or 410 for. With remove, they are also
+// dropped from the stored details.
+func CheckStoredImages(checker ImageChecker, d *Dataset, remove bool) (*ImageCheckReport, error) {
+	all, err := d.AllDetails()
+	if err != nil {
+		return nil, err
+	}
+
+	type check struct {
+		car, url string
+	}
+	statuses := make(map[check]int)
+	var mu sync.Mutex
+	next := make(chan check)
+	var wg sync.WaitGroup
+	for w := 0; w < imageCheckWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range next {
+				// Status stays 0 if the request failed
+				status, _ := checker.CheckImage(c.url)
+				mu.Lock()
+				statuses[c] = status
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, details := range all {
+		for _, image := range details.Images {
+			next <- check{details.Slug, image.URL}
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	report := &ImageCheckReport{Dead: []DeadImage{}, Removed: remove}
+	for _, details := range all {
+		kept := []Image{}
+		for _, image := range details.Images {
+			report.Checked++
+			switch status := statuses[check{details.Slug, image.URL}]; status {
+			case 0:
+				report.Failed++
+			case http.StatusNotFound, http.StatusGone:
+				report.Dead = append(report.Dead, DeadImage{Car: details.Slug, URL: image.URL, Status: status})
+				continue
+			}
+			kept = append(kept, image)
+		}
+
+		if remove && len(kept) < len(details.Images) {
+			details.Images, details.ImageCount = kept, len(kept)
+			if err := d.SaveDetails(&details); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return report, nil
+}
+
+// wantsVerifiedImages reports whether a details request asked, with
+// ?verify_images=true, to leave out the images upstream doesn't serve.
+func wantsVerifiedImages(r *http.Request) bool {
+	return r.URL.Query().Get("verify_images") == "true"
+}
+
+// verifyImages sends a HEAD request for each of details' full-size images
+// at once and leaves out those upstream answers 404 or 410 for. Full-size
+// URLs are made by stripping the size suffix off the picture on the page,
+// which sometimes names a file that was never uploaded, so an image whose
+// thumbnail is another URL falls back to it instead, with both URLs the
+// same. Images that can't be checked are kept. details is left as it is.
+func verifyImages(checker ImageChecker, details *CarDetails) *CarDetails {
+	statuses := make([]int, len(details.Images))
+	slots := make(chan struct{}, imageCheckWorkers)
+	var wg sync.WaitGroup
+	for i, image := range details.Images {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, url string) {
+			defer wg.Done()
+			// Status stays 0 if the request failed
+			statuses[i], _ = checker.CheckImage(url)
+			<-slots
+		}(i, image.URL)
+	}
+	wg.Wait()
+
+	verified := *details
+	verified.Images = []Image{}
+	for i, image := range details.Images {
+		if status := statuses[i]; status == http.StatusNotFound || status == http.StatusGone {
+			if image.Thumbnail == image.URL {
+				continue
+			}
+			dead := image.URL
+			image.URL = image.Thumbnail
+			image.Width, image.Height, image.Bytes, image.Format = nil, nil, nil, ""
+			image.Variants = slices.DeleteFunc(slices.Clone(image.Variants), func(v ImageVariant) bool {
+				return v.URL == dead
+			})
+		}
+		verified.Images = append(verified.Images, image)
+	}
+	verified.ImageCount = len(verified.Images)
+	return &verified
+}
+
+func checkImagesHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Remove bool `json:"remove"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Error:   "Request body must be empty or {\"remove\": true}",
+			})
+			return
+		}
+	}
+
+	checker, ok := siteFor(r).(ImageChecker)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "This site can't check images",
+		})
+		return
+	}
+
+	report, err := CheckStoredImages(checker, dataset, req.Remove)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Data:    report,
+	})
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ImageManifest describes a directory of downloaded car images. It is
+// written to manifest.json at the top of the directory.
+type ImageManifest struct {
+	DownloadedAt time.Time          `json:"downloaded_at"`
+	Cars         []ImageManifestCar `json:"cars"`
+}
+
+// ImageManifestCar is one car whose images were downloaded. Error is set,
+// and Images empty, if its details couldn't be fetched.
+type ImageManifestCar struct {
+	Slug   string               `json:"slug"`
+	Name   string               `json:"name"`
+	URL    string               `json:"url"`
+	Brand  *string              `json:"brand"`
+	Images []ImageManifestImage `json:"images"`
+	Error  string               `json:"error,omitempty"`
+}
+
+// ImageManifestImage is one downloaded image; File is relative to the
+// manifest and empty if the download failed.
+type ImageManifestImage struct {
+	URL    string `json:"url"`
+	File   string `json:"file,omitempty"`
+	Bytes  int64  `json:"bytes,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// imagesCommand runs the "images" subcommands.
+func imagesCommand(args []string) error {
+	if len(args) == 0 || args[0] != "download" {
+		return fmt.Errorf("usage: images download (--car <slug> | --brand <slug>) [-o dir]")
+	}
+	return imagesDownloadCommand(args[1:])
+}
+
+// imagesDownloadCommand downloads every image of one car, or of every car
+// a brand lists, into <dir>/<car_slug>/ and describes them in
+// <dir>/manifest.json.
+func imagesDownloadCommand(args []string) error {
+	fs := flag.NewFlagSet("images download", flag.ExitOnError)
+	carSlug := fs.String("car", "", "Download the images of this car")
+	brandSlug := fs.String("brand", "", "Download the images of every car this brand lists")
+	dir := fs.String("o", "images", "Output directory")
+	concurrency := fs.Int("concurrency", 4, "Images to download at once")
+	fs.Parse(args)
+
+	if (*carSlug == "") == (*brandSlug == "") {
+		return fmt.Errorf("images download needs exactly one of --car or --brand")
+	}
+	fetcher, ok := scraper.(ImageFetcher)
+	if !ok {
+		return fmt.Errorf("this site can't download images")
+	}
+
+	slugs := []string{*carSlug}
+	if *brandSlug != "" {
+		cars, err := scraper.GetBrandCars(*brandSlug)
+		if err != nil {
+			return err
+		}
+		slugs = slugs[:0]
+		for _, car := range cars {
+			slugs = append(slugs, car.Slug)
+		}
+	}
+
+	manifest := ImageManifest{DownloadedAt: time.Now().UTC(), Cars: []ImageManifestCar{}}
+	carsFailed := 0
+	for _, slug := range slugs {
+		details, err := scraper.GetCarDetails(slug)
+		if err != nil && *carSlug != "" {
+			return fmt.Errorf("car %s: %v", slug, err)
+		}
+		if err != nil {
+			// One car gone from a brand's listing shouldn't cost the rest
+			carsFailed++
+			manifest.Cars = append(manifest.Cars, ImageManifestCar{Slug: slug, Images: []ImageManifestImage{}, Error: err.Error()})
+			continue
+		}
+		car := ImageManifestCar{Slug: details.Slug, Name: details.Name, URL: details.URL, Brand: details.Brand, Images: []ImageManifestImage{}}
+		for i, image := range details.Images {
+			car.Images = append(car.Images, ImageManifestImage{
+				URL:  image.URL,
+				File: path.Join(details.Slug, fmt.Sprintf("%02d-%s", i+1, path.Base(image.URL))),
+			})
+		}
+		manifest.Cars = append(manifest.Cars, car)
+	}
+
+	next := make(chan *ImageManifestImage)
+	var wg sync.WaitGroup
+	for w := 0; w < max(*concurrency, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for image := range next {
+				image.Bytes, image.SHA256, image.Error = downloadImage(fetcher, image.URL, filepath.Join(*dir, filepath.FromSlash(image.File)))
+				if image.Error != "" {
+					image.File = ""
+				}
+			}
+		}()
+	}
+	for i := range manifest.Cars {
+		for j := range manifest.Cars[i].Images {
+			next <- &manifest.Cars[i].Images[j]
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	count, failed := 0, 0
+	for _, car := range manifest.Cars {
+		for _, image := range car.Images {
+			if image.Error != "" {
+				failed++
+			} else {
+				count++
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(*dir, "manifest.json"), append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Downloaded %d images of %d cars to %s", count, len(manifest.Cars)-carsFailed, *dir)
+	if failed > 0 {
+		fmt.Printf(", %d failed (see manifest.json)", failed)
+	}
+	if carsFailed > 0 {
+		fmt.Printf(", %d cars' details failed (see manifest.json)", carsFailed)
+	}
+	fmt.Println()
+	return nil
+}
+
+// downloadImage saves the image at url to file and returns its size and
+// SHA-256, or the reason it failed.
+func downloadImage(fetcher ImageFetcher, url, file string) (int64, string, string) {
+	resp, err := fetcher.FetchImage(context.Background(), url)
+	if err != nil {
+		return 0, "", err.Error()
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return 0, "", fmt.Sprintf("status code error: %d %s", resp.StatusCode, resp.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return 0, "", err.Error()
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return 0, "", err.Error()
+	}
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, hash), resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file)
+		return 0, "", err.Error()
+	}
+	return n, fmt.Sprintf("%x", hash.Sum(nil)), ""
+}
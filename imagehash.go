@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+const bucketImageHashes = "image_hashes"
+
+const (
+	defaultSimilarDistance = 10
+	maxSimilarResults      = 100
+)
+
+// ImageHash is the perceptual hash of one of a car's images, as 16 hex
+// digits.
+type ImageHash struct {
+	URL  string `json:"url"`
+	Hash string `json:"hash"`
+}
+
+// SimilarImage is a stored image whose perceptual hash is within reach of
+// the one asked about: the same photo, or one very like it.
+type SimilarImage struct {
+	Car      string `json:"car"`
+	URL      string `json:"url"`
+	Hash     string `json:"hash"`
+	Distance int    `json:"distance"`
+}
+
+// hashImages returns the perceptual hashes of details' images, hashing each
+// one's thumbnail where it has one. Images that can't be hashed, e.g. in a
+// format that can't be decoded, are logged and left out.
+func hashImages(hasher ImageHasher, details *CarDetails) []ImageHash {
+	hashes := []ImageHash{}
+	for _, image := range details.Images {
+		url := image.Thumbnail
+		if url == "" {
+			url = image.URL
+		}
+		hash, err := hasher.PerceptualImageHash(url)
+		if err != nil {
+			log.Printf("image hashes: %s: %v", details.Slug, err)
+			continue
+		}
+		hashes = append(hashes, ImageHash{URL: image.URL, Hash: partasala.FormatImageHash(hash)})
+	}
+	return hashes
+}
+
+// SaveImageHashes stores the perceptual hashes of slug's images, replacing
+// any from before.
+func (d *Dataset) SaveImageHashes(slug string, hashes []ImageHash) error {
+	return putJSON(d.store, bucketImageHashes, slug, hashes)
+}
+
+// ImageHashes returns the stored perceptual hashes of slug's images, or
+// ErrNotFound if they haven't been hashed.
+func (d *Dataset) ImageHashes(slug string) ([]ImageHash, error) {
+	var hashes []ImageHash
+	err := getJSON(d.store, bucketImageHashes, slug, &hashes)
+	return hashes, err
+}
+
+// SimilarImages returns the stored images within maxDistance of any of
+// hashes, closest first, leaving out those of the car exclude. Images of
+// cars since taken down are kept, so a car listed again is found too.
+func (d *Dataset) SimilarImages(hashes []uint64, maxDistance int, exclude string) ([]SimilarImage, error) {
+	similar := []SimilarImage{}
+	err := d.store.ForEach(bucketImageHashes, func(key string, value []byte) error {
+		if key == exclude {
+			return nil
+		}
+		var stored []ImageHash
+		if err := json.Unmarshal(value, &stored); err != nil {
+			return fmt.Errorf("image hashes %s: %v", key, err)
+		}
+		for _, image := range stored {
+			hash, err := partasala.ParseImageHash(image.Hash)
+			if err != nil {
+				continue
+			}
+			best := -1
+			for _, want := range hashes {
+				if distance := partasala.ImageHashDistance(hash, want); best < 0 || distance < best {
+					best = distance
+				}
+			}
+			if best >= 0 && best <= maxDistance {
+				similar = append(similar, SimilarImage{Car: key, URL: image.URL, Hash: image.Hash, Distance: best})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(similar, func(i, j int) bool { return similar[i].Distance < similar[j].Distance })
+	return similar, nil
+}
+
+// similarImagesHandler serves GET /images/similar: the stored images that
+// look like ?hash, or like any image of the car ?car, to spot a car listed
+// again or a photo used for more than one listing.
+func similarImagesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	badRequest := func(message string) {
+		respond(w, r, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   message,
+		})
+	}
+
+	maxDistance := defaultSimilarDistance
+	if v := query.Get("max_distance"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 64 {
+			badRequest("max_distance must be between 0 and 64")
+			return
+		}
+		maxDistance = n
+	}
+
+	var hashes []uint64
+	exclude := ""
+	switch hash, car := query.Get("hash"), query.Get("car"); {
+	case (hash == "") == (car == ""):
+		badRequest("Give either \"hash\" or \"car\"")
+		return
+	case hash != "":
+		parsed, err := partasala.ParseImageHash(hash)
+		if err != nil {
+			badRequest(err.Error())
+			return
+		}
+		hashes = []uint64{parsed}
+	default:
+		stored, err := dataset.ImageHashes(car)
+		if err == ErrNotFound {
+			respond(w, r, http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   "The images of this car haven't been hashed",
+			})
+			return
+		}
+		if err != nil {
+			respond(w, r, http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		for _, image := range stored {
+			if parsed, err := partasala.ParseImageHash(image.Hash); err == nil {
+				hashes = append(hashes, parsed)
+			}
+		}
+		exclude = car
+	}
+
+	similar, err := dataset.SimilarImages(hashes, maxDistance, exclude)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	if len(similar) > maxSimilarResults {
+		similar = similar[:maxSimilarResults]
+	}
+	respond(w, r, http.StatusOK, APIResponse{
+		Success: true,
+		Count:   len(similar),
+		Data:    similar,
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+// maxProxiedImageBytes bounds how much of an image /proxy/image passes on.
+const maxProxiedImageBytes = 20 << 20
+
+// ImageProxyConfig sets which images /proxy/image serves and how long
+// browsers may cache them. Empty AllowedPrefixes allow the site's uploads,
+// scraper.base_url followed by /wp-content/uploads/.
+type ImageProxyConfig struct {
+	AllowedPrefixes []string `json:"allowed_prefixes"`
+	MaxAge          Duration `json:"max_age"`
+}
+
+var imageProxy ImageProxyConfig
+
+// allowedImageURL parses src and reports whether it is an http(s) URL under
+// one of the allowed prefixes. Paths with dot segments are refused, so a
+// prefix can't be climbed out of.
+func (c ImageProxyConfig) allowedImageURL(src string) (string, bool) {
+	u, err := neturl.Parse(src)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.User != nil || u.Host == "" {
+		return "", false
+	}
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment == "." || segment == ".." {
+			return "", false
+		}
+	}
+	u.Fragment = ""
+	normalized := u.String()
+	for _, prefix := range c.AllowedPrefixes {
+		if strings.HasPrefix(normalized, prefix) {
+			return normalized, true
+		}
+	}
+	return "", false
+}
+
+// proxyImageHandler streams an upstream image through the API, so browser
+// clients on other origins or on HTTPS aren't stopped by hotlink protection
+// or mixed-content rules.
+func proxyImageHandler(w http.ResponseWriter, r *http.Request) {
+	src, ok := imageProxy.allowedImageURL(r.URL.Query().Get("src"))
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "\"src\" must be an image URL under " + strings.Join(imageProxy.AllowedPrefixes, " or "),
+		})
+		return
+	}
+	fetcher, ok := scraper.(ImageFetcher)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "This site can't proxy images",
+		})
+		return
+	}
+	if isHead(r) {
+		// Only a GET fetches the image
+		w.Header().Set("Allow", "GET")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "HEAD isn't answered for proxied images; use GET",
+		})
+		return
+	}
+
+	resp, err := fetcher.FetchImage(r.Context(), src)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		status := http.StatusBadGateway
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+			status = http.StatusNotFound
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Upstream answered %s", resp.Status),
+		})
+		return
+	}
+
+	// Trust upstream's type only if it names an image, and otherwise sniff
+	// it, so nothing but images is served from the API's origin
+	body := bufio.NewReader(io.LimitReader(resp.Body, maxProxiedImageBytes))
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || !strings.HasPrefix(mediaType, "image/") || mediaType == "image/svg+xml" {
+		head, _ := body.Peek(512)
+		contentType = http.DetectContentType(head)
+	}
+	if !strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "image/svg+xml") {
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "Upstream did not answer with an image",
+		})
+		return
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(imageProxy.MaxAge.Duration/time.Second)))
+	header.Set("X-Content-Type-Options", "nosniff")
+	if resp.ContentLength >= 0 && resp.ContentLength <= maxProxiedImageBytes {
+		header.Set("Content-Length", fmt.Sprint(resp.ContentLength))
+	}
+	for _, name := range []string{"ETag", "Last-Modified"} {
+		if value := resp.Header.Get(name); value != "" {
+			header.Set(name, value)
+		}
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		log.Printf("proxy: %s: %v", src, err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+)
+
+// ImageRewriteConfig points clients at a mirror of upstream's uploads, e.g.
+// From "https://partasala.is/wp-content/uploads/" and To
+// "https://cdn.example.com/uploads/". Rewriting is off while From is empty.
+type ImageRewriteConfig struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+var imageRewrite ImageRewriteConfig
+
+// rewriteImageURLs returns a copy of the response v in which every string
+// starting with imageRewrite.From starts with imageRewrite.To instead. The
+// stored and cached data v shares slices with is left as it is.
+func rewriteImageURLs(v interface{}) interface{} {
+	if imageRewrite.From == "" || v == nil {
+		return v
+	}
+	return imageRewrite.value(reflect.ValueOf(v)).Interface()
+}
+
+func (c ImageRewriteConfig) value(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.String:
+		if rest, ok := strings.CutPrefix(v.String(), c.From); ok {
+			return reflect.ValueOf(c.To + rest).Convert(v.Type())
+		}
+	case reflect.Pointer:
+		if !v.IsNil() {
+			out := reflect.New(v.Type().Elem())
+			out.Elem().Set(c.value(v.Elem()))
+			return out
+		}
+	case reflect.Interface:
+		if !v.IsNil() {
+			out := reflect.New(v.Type()).Elem()
+			out.Set(c.value(v.Elem()))
+			return out
+		}
+	case reflect.Struct:
+		out := reflect.New(v.Type()).Elem()
+		out.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if out.Field(i).CanSet() {
+				out.Field(i).Set(c.value(v.Field(i)))
+			}
+		}
+		return out
+	case reflect.Slice:
+		if !v.IsNil() {
+			out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+			for i := 0; i < v.Len(); i++ {
+				out.Index(i).Set(c.value(v.Index(i)))
+			}
+			return out
+		}
+	case reflect.Array:
+		out := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(c.value(v.Index(i)))
+		}
+		return out
+	case reflect.Map:
+		if !v.IsNil() {
+			out := reflect.MakeMapWithSize(v.Type(), v.Len())
+			iter := v.MapRange()
+			for iter.Next() {
+				out.SetMapIndex(iter.Key(), c.value(iter.Value()))
+			}
+			return out
+		}
+	}
+	return v
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobFinished  JobStatus = "finished"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+// maxFinishedJobs bounds how many finished jobs are kept for GET /jobs.
+const maxFinishedJobs = 100
+
+type JobProgress struct {
+	Done    int    `json:"done"`
+	Total   int    `json:"total"`
+	Current string `json:"current,omitempty"`
+}
+
+// Job is a crawl requested through the jobs API.
+type Job struct {
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	Scope      CrawlScope  `json:"scope"`
+	DryRun     bool        `json:"dry_run,omitempty"`
+	Status     JobStatus   `json:"status"`
+	Progress   JobProgress `json:"progress"`
+	Errors     int         `json:"errors"`
+	Error      string      `json:"error,omitempty"`
+	CreatedAt  time.Time   `json:"created_at"`
+	StartedAt  *time.Time  `json:"started_at,omitempty"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+	// Report is set when a dry run finishes
+	Report *DryRunReport `json:"report,omitempty"`
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// JobQueue runs crawl jobs one at a time, in the order they were created.
+type JobQueue struct {
+	crawler *Crawler
+	queue   chan *Job
+
+	mu   sync.Mutex
+	jobs []*Job
+}
+
+func NewJobQueue(crawler *Crawler) *JobQueue {
+	return &JobQueue{
+		crawler: crawler,
+		queue:   make(chan *Job, 1000),
+	}
+}
+
+// Run works through queued jobs until ctx is cancelled.
+func (q *JobQueue) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-q.queue:
+			q.run(job)
+		}
+	}
+}
+
+func (q *JobQueue) run(job *Job) {
+	q.mu.Lock()
+	if job.Status != JobQueued {
+		q.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	job.Status = JobRunning
+	job.StartedAt = &now
+	q.mu.Unlock()
+
+	progress := func(p CrawlProgress) {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		job.Progress = JobProgress{Done: p.BrandsDone, Total: p.BrandsTotal, Current: p.Brand}
+		job.Errors = p.Errors
+	}
+	var report *DryRunReport
+	var err error
+	if job.DryRun {
+		report, err = q.crawler.DryRun(job.ctx, job.Scope, progress)
+	} else {
+		err = q.crawler.CrawlContext(job.ctx, job.Scope, progress)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job.Report = report
+	finished := time.Now()
+	job.FinishedAt = &finished
+	switch {
+	case job.ctx.Err() != nil:
+		job.Status = JobCancelled
+	case err != nil:
+		job.Status = JobFailed
+		job.Error = err.Error()
+	default:
+		job.Status = JobFinished
+	}
+	job.cancel()
+	q.prune()
+}
+
+// Enqueue adds a crawl job for scope, or a dry run of it.
+func (q *JobQueue) Enqueue(scope CrawlScope, dryRun bool) (Job, error) {
+	id, err := newID()
+	if err != nil {
+		return Job{}, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:        id,
+		Type:      "crawl",
+		Scope:     scope,
+		DryRun:    dryRun,
+		Status:    JobQueued,
+		CreatedAt: time.Now(),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.queue <- job:
+	default:
+		cancel()
+		return Job{}, errJobQueueFull
+	}
+	q.jobs = append(q.jobs, job)
+	return *job, nil
+}
+
+var errJobQueueFull = errors.New("too many queued jobs")
+
+func (q *JobQueue) List() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	list := make([]Job, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		list = append(list, *job)
+	}
+	return list
+}
+
+// Cancel stops a queued or running job. It returns false for unknown jobs.
+func (q *JobQueue) Cancel(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, job := range q.jobs {
+		if job.ID != id {
+			continue
+		}
+		switch job.Status {
+		case JobQueued:
+			now := time.Now()
+			job.Status = JobCancelled
+			job.FinishedAt = &now
+			job.cancel()
+		case JobRunning:
+			// run() records the cancellation once the crawl returns
+			job.cancel()
+		}
+		return *job, true
+	}
+	return Job{}, false
+}
+
+// prune drops the oldest finished jobs beyond maxFinishedJobs.
+func (q *JobQueue) prune() {
+	finished := 0
+	for _, job := range q.jobs {
+		if job.FinishedAt != nil {
+			finished++
+		}
+	}
+
+	kept := q.jobs[:0]
+	for _, job := range q.jobs {
+		if job.FinishedAt != nil && finished > maxFinishedJobs {
+			finished--
+			continue
+		}
+		kept = append(kept, job)
+	}
+	q.jobs = kept
+}
+
+func createCrawlJobHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CrawlScope
+		DryRun bool `json:"dry_run"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Error:   "Request body must be empty or a JSON crawl scope",
+			})
+			return
+		}
+	}
+	scope := req.CrawlScope
+
+	if scope.Brand != "" && scope.BrandsOnly {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "\"brand\" and \"brands_only\" are mutually exclusive",
+		})
+		return
+	}
+	if scope.Brand != "" {
+		brands, err := scraper.GetBrands()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		found := false
+		for _, brand := range brands {
+			found = found || brand.Slug == scope.Brand
+		}
+		if !found {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Error:   "Brand not found",
+			})
+			return
+		}
+	}
+
+	job, err := jobs.Enqueue(scope, req.DryRun)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == errJobQueueFull {
+			status = http.StatusServiceUnavailable
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Data:    job,
+	})
+}
+
+func listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	list := jobs.List()
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Count:   len(list),
+		Data:    list,
+	})
+}
+
+func cancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := jobs.Cancel(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "Job not found",
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Data:    job,
+	})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const contentTypeJSONAPI = "application/vnd.api+json"
+
+// wantsJSONAPI reports whether the client asked for a JSON:API document,
+// with ?format=jsonapi or an Accept header naming application/vnd.api+json.
+func wantsJSONAPI(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "jsonapi" {
+		return true
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part)); err == nil && mediaType == contentTypeJSONAPI {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonAPIResource is a JSON:API resource object.
+type jsonAPIResource struct {
+	Type          string                 `json:"type"`
+	ID            string                 `json:"id"`
+	Attributes    map[string]interface{} `json:"attributes"`
+	Relationships map[string]interface{} `json:"relationships,omitempty"`
+	Links         map[string]string      `json:"links,omitempty"`
+}
+
+// jsonAPIDocument turns a response v, one of the APIResponse-shaped
+// structs or a V2Response, into a JSON:API document. Brands and cars in
+// its Data become resources identified by their slugs, with a car's brand
+// as a relationship; the other fields go in "meta". Data that holds
+// neither, like /stats, goes in "meta" as well. Failures become "errors".
+func jsonAPIDocument(status int, v interface{}) map[string]interface{} {
+	var fields map[string]interface{}
+	raw, err := json.Marshal(v)
+	if err != nil || json.Unmarshal(raw, &fields) != nil {
+		return map[string]interface{}{"errors": []map[string]string{{"status": "500", "detail": "response is not an object"}}}
+	}
+	if envelope, ok := v.(v2Envelope); ok {
+		if detail, failed := envelope.failure(); failed {
+			return map[string]interface{}{"errors": []map[string]string{{"status": strconv.Itoa(status), "detail": detail}}}
+		}
+		// A V2Response's meta is already the document's
+		meta, _ := fields["meta"].(map[string]interface{})
+		fields = map[string]interface{}{"data": fields["data"]}
+		for name, value := range meta {
+			fields[name] = value
+		}
+	} else if success, _ := fields["success"].(bool); !success {
+		detail, _ := fields["error"].(string)
+		return map[string]interface{}{"errors": []map[string]string{{"status": strconv.Itoa(status), "detail": detail}}}
+	}
+	delete(fields, "success")
+
+	doc := map[string]interface{}{}
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() == reflect.Struct {
+		if data := value.FieldByName("Data"); data.IsValid() {
+			if resources, ok := jsonAPIData(data.Interface()); ok {
+				doc["data"] = resources
+				delete(fields, "data")
+			}
+		}
+	}
+	if len(fields) > 0 {
+		doc["meta"] = fields
+	}
+	return doc
+}
+
+// addJSONAPIMeta adds the fields of meta to doc's "meta".
+func addJSONAPIMeta(doc map[string]interface{}, meta *ResponseMeta) {
+	fields, ok := doc["meta"].(map[string]interface{})
+	if !ok {
+		fields = map[string]interface{}{}
+		doc["meta"] = fields
+	}
+	fields["cached"] = meta.Cached
+	fields["data_age_seconds"] = meta.DataAgeSeconds
+	fields["scraped_at"] = meta.ScrapedAt
+	fields["upstream_fetches"] = meta.UpstreamFetches
+}
+
+// jsonAPIData converts brands and cars, alone or in slices, to resources.
+// Details expanded inline stay attributes of their car.
+func jsonAPIData(data interface{}) (interface{}, bool) {
+	switch d := data.(type) {
+	case []Brand:
+		resources := make([]jsonAPIResource, len(d))
+		for i, brand := range d {
+			resources[i] = brandResource(brand)
+		}
+		return resources, true
+	case []Car:
+		resources := make([]jsonAPIResource, len(d))
+		for i, car := range d {
+			resources[i] = carResource(car)
+		}
+		return resources, true
+	case []CarWithDetails:
+		resources := make([]jsonAPIResource, len(d))
+		for i, car := range d {
+			resources[i] = carResource(car.Car)
+			resources[i].Attributes["details"] = car.Details
+			if car.DetailsError != "" {
+				resources[i].Attributes["details_error"] = car.DetailsError
+			}
+		}
+		return resources, true
+	case Brand:
+		return brandResource(d), true
+	case Car:
+		return carResource(d), true
+	case *CarDetails:
+		if d == nil {
+			return nil, true
+		}
+		return resource("cars", d.Slug, d), true
+	}
+	return nil, false
+}
+
+func brandResource(brand Brand) jsonAPIResource {
+	return resource("brands", brand.Slug, brand)
+}
+
+func carResource(car Car) jsonAPIResource {
+	res := resource("cars", car.Slug, car)
+	delete(res.Attributes, "brand")
+	brand := map[string]interface{}{"data": nil}
+	if car.Brand != "" {
+		brand["data"] = map[string]string{"type": "brands", "id": car.Brand}
+		brand["links"] = map[string]string{"related": link("/brands/" + car.Brand)}
+	}
+	res.Relationships = map[string]interface{}{"brand": brand}
+	return res
+}
+
+// resource makes a resource of v, whose JSON fields other than its slug
+// are the attributes.
+func resource(typ, id string, v interface{}) jsonAPIResource {
+	var attributes map[string]interface{}
+	raw, _ := json.Marshal(v)
+	json.Unmarshal(raw, &attributes)
+	delete(attributes, "slug")
+	return jsonAPIResource{
+		Type:       typ,
+		ID:         id,
+		Attributes: attributes,
+		Links:      map[string]string{"self": link("/" + typ + "/" + id)},
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// shutdownTimeout is how long requests in flight get to finish once the
+// server is asked to stop.
+const shutdownTimeout = 30 * time.Second
+
+// systemdListenFD is the first file descriptor systemd passes to a
+// socket-activated service.
+const systemdListenFD = 3
+
+// unixSocketPrefix marks a ServerConfig.Listen value as a Unix socket path.
+const unixSocketPrefix = "unix:"
+
+// listen opens the listener the API server is served on: a TCP address
+// like ":1667" or "127.0.0.1:8080", or with "unix:" a Unix domain socket.
+// A socket file left behind by a previous run is replaced, and the new one
+// gets SocketMode so a reverse proxy running as another user can connect.
+// Under systemd socket activation the socket systemd passes is used
+// instead, whatever Listen says.
+func listen(config ServerConfig) (net.Listener, error) {
+	if listener, err := systemdListener(); listener != nil || err != nil {
+		return listener, err
+	}
+	if !strings.HasPrefix(config.Listen, unixSocketPrefix) {
+		return net.Listen("tcp", config.Listen)
+	}
+
+	path := strings.TrimPrefix(config.Listen, unixSocketPrefix)
+	mode, err := strconv.ParseUint(config.SocketMode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("server.socket_mode must be octal, e.g. 0660")
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, fs.FileMode(mode)); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// systemdListener returns the socket systemd passed if the process was
+// started by socket activation, following sd_listen_fds(3): LISTEN_PID
+// names this process and LISTEN_FDS counts the descriptors from 3 up. Only
+// the first is served. It returns nil without socket activation.
+func systemdListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if n > 1 {
+		log.Printf("systemd passed %d sockets; serving only the first", n)
+	}
+
+	file := os.NewFile(systemdListenFD, "systemd socket")
+	defer file.Close()
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("systemd socket: %v", err)
+	}
+	return listener, nil
+}
+
+// listenURL describes where the server listens, for the startup log.
+func listenURL(listener net.Listener, secure bool) string {
+	addr := listener.Addr()
+	if addr.Network() == "unix" {
+		return unixSocketPrefix + addr.String()
+	}
+	scheme := "http://"
+	if secure {
+		scheme = "https://"
+	}
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return scheme + net.JoinHostPort(host, port) + "/"
+}
+
+// TLSConfig turns on HTTPS, with certificate files or with certificates
+// from Let's Encrypt. CertFile and KeyFile name PEM files; otherwise
+// AutocertHosts lists the hostnames to get certificates for, which are
+// kept in AutocertCache. HTTPListen, e.g. ":80", also serves plain HTTP
+// there, redirecting to HTTPS and answering Let's Encrypt's HTTP
+// challenges; without it certificates are obtained over TLS-ALPN on the
+// HTTPS port, which then has to be 443.
+type TLSConfig struct {
+	CertFile      string   `json:"cert_file"`
+	KeyFile       string   `json:"key_file"`
+	AutocertHosts []string `json:"autocert_hosts"`
+	AutocertCache string   `json:"autocert_cache"`
+	AutocertEmail string   `json:"autocert_email"`
+	HTTPListen    string   `json:"http_listen"`
+}
+
+// Enabled reports whether the server should serve HTTPS.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || len(c.AutocertHosts) > 0
+}
+
+// serve serves HTTP, or HTTPS as config.TLS sets out, on listener.
+func serve(server *http.Server, listener net.Listener, config ServerConfig) error {
+	if !config.TLS.Enabled() {
+		return server.Serve(listener)
+	}
+
+	redirect := redirectToHTTPS(config.Listen)
+	if config.TLS.CertFile != "" {
+		if config.TLS.HTTPListen != "" {
+			go serveHTTPRedirect(config.TLS.HTTPListen, redirect)
+		}
+		return server.ServeTLS(listener, config.TLS.CertFile, config.TLS.KeyFile)
+	}
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(config.TLS.AutocertHosts...),
+		Cache:      autocert.DirCache(config.TLS.AutocertCache),
+		Email:      config.TLS.AutocertEmail,
+	}
+	if config.TLS.HTTPListen != "" {
+		go serveHTTPRedirect(config.TLS.HTTPListen, manager.HTTPHandler(redirect))
+	}
+	server.TLSConfig = manager.TLSConfig()
+	return server.ServeTLS(listener, "", "")
+}
+
+// shutdownOnSignal stops server gracefully on SIGTERM or an interrupt,
+// letting requests in flight finish, and saves the usage counts. With
+// socket activation systemd keeps accepting connections meanwhile and
+// hands them to the next process, so a restart drops none. The returned
+// channel is closed once the server has shut down.
+func shutdownOnSignal(server *http.Server) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+		<-stop
+		signal.Stop(stop)
+		log.Println("Shutting down...")
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+		if err := usage.Flush(time.Now()); err != nil {
+			log.Printf("usage: %v", err)
+		}
+	}()
+	return done
+}
+
+func serveHTTPRedirect(addr string, handler http.Handler) {
+	server := &http.Server{Addr: addr, Handler: handler, ReadTimeout: 15 * time.Second, WriteTimeout: 15 * time.Second}
+	log.Fatal(server.ListenAndServe())
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL over HTTPS,
+// on the port of the HTTPS listen address unless that is 443.
+func redirectToHTTPS(listen string) http.HandlerFunc {
+	_, port, err := net.SplitHostPort(listen)
+	if err != nil || strings.HasPrefix(listen, unixSocketPrefix) {
+		port = "443"
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	}
+}
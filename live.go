@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// LiveCrawlStatus is the type of the /ws messages carrying the crawl
+// status; car messages have the CarEvent types.
+const LiveCrawlStatus = "crawl.status"
+
+const (
+	// liveBuffer is how many messages a /ws client may fall behind by
+	// before it is disconnected.
+	liveBuffer       = 64
+	liveWriteTimeout = 10 * time.Second
+	livePingInterval = 30 * time.Second
+	// livePongTimeout is how long a client has to answer a ping
+	livePongTimeout = livePingInterval + 10*time.Second
+)
+
+// LiveEvent is a message pushed to /ws clients: a car the crawler found
+// listed or taken down, or the crawl status.
+type LiveEvent struct {
+	Type   string       `json:"type"`
+	At     time.Time    `json:"at"`
+	Car    *Car         `json:"car,omitempty"`
+	Status *CrawlStatus `json:"status,omitempty"`
+}
+
+// liveClient is a /ws connection. Its filter narrows down the car events
+// it gets; everyone gets the crawl status.
+type liveClient struct {
+	filter CarFilter
+	send   chan LiveEvent
+}
+
+// LiveHub fans the crawler's changes and status out to the /ws clients.
+// A client that can't keep up is dropped rather than holding up the rest.
+type LiveHub struct {
+	mu      sync.Mutex
+	clients map[*liveClient]bool
+}
+
+var live = NewLiveHub()
+
+func NewLiveHub() *LiveHub {
+	return &LiveHub{clients: map[*liveClient]bool{}}
+}
+
+func (h *LiveHub) add(client *liveClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[client] = true
+}
+
+// remove drops client and closes its channel, unless it's gone already.
+func (h *LiveHub) remove(client *liveClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients[client] {
+		delete(h.clients, client)
+		close(client.send)
+	}
+}
+
+func (h *LiveHub) publish(event LiveEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.clients {
+		if event.Car != nil && !client.filter.Matches(*event.Car) {
+			continue
+		}
+		select {
+		case client.send <- event:
+		default:
+			delete(h.clients, client)
+			close(client.send)
+		}
+	}
+}
+
+// PublishDiff sends the cars a crawl found added and removed.
+func (h *LiveHub) PublishDiff(diff CrawlDiff) {
+	for _, event := range carEvents(diff, time.Now().UTC()) {
+		if event.Type == CarEventUpdated {
+			continue
+		}
+		car := event.Car
+		h.publish(LiveEvent{Type: event.Type, At: event.At, Car: &car})
+	}
+}
+
+// PublishStatus sends the crawl status.
+func (h *LiveHub) PublishStatus(status CrawlStatus) {
+	h.publish(LiveEvent{Type: LiveCrawlStatus, At: time.Now().UTC(), Status: &status})
+}
+
+// hijack hands a response's connection over, for the ResponseWriter
+// wrappers to pass a WebSocket upgrade through.
+func hijack(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the connection can't be taken over")
+	}
+	return hijacker.Hijack()
+}
+
+// liveOriginAllowed lets browsers connect to /ws from this server's own
+// pages and from the origins CORS allows. Other clients, which send no
+// Origin, are let through.
+func liveOriginAllowed(cors CORSConfig) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+		return cors.allowedOrigin(cors.AllowedOrigins, origin) != ""
+	}
+}
+
+// liveHandler serves GET /ws: a WebSocket that gets a JSON message for
+// every car a crawl finds added or removed that matches ?brand and ?q, and
+// the crawl status, starting with the current one, as it changes.
+func liveHandler(cors CORSConfig) http.HandlerFunc {
+	upgrader := websocket.Upgrader{CheckOrigin: liveOriginAllowed(cors)}
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter := CarFilter{Query: r.URL.Query().Get("q"), Brand: r.URL.Query().Get("brand")}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// The upgrader has answered with the error
+			return
+		}
+		defer conn.Close()
+
+		client := &liveClient{filter: filter, send: make(chan LiveEvent, liveBuffer)}
+		status := crawler.Status()
+		client.send <- LiveEvent{Type: LiveCrawlStatus, At: time.Now().UTC(), Status: &status}
+		live.add(client)
+		defer live.remove(client)
+
+		// Read only to answer pings and notice the client going away
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			conn.SetReadDeadline(time.Now().Add(livePongTimeout))
+			conn.SetPongHandler(func(string) error {
+				return conn.SetReadDeadline(time.Now().Add(livePongTimeout))
+			})
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		ping := time.NewTicker(livePingInterval)
+		defer ping.Stop()
+		for {
+			select {
+			case <-closed:
+				return
+			case event, ok := <-client.send:
+				conn.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+				if !ok {
+					conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too far behind"))
+					return
+				}
+				if err := conn.WriteJSON(event); err != nil {
+					return
+				}
+			case <-ping.C:
+				conn.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+				if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const bucketTranslations = "translations"
+
+// MachineTranslationConfig has car descriptions on /cars/<car_slug>
+// translated by an online service when asked for with ?lang=<code>.
+// Provider is "deepl" or "google"; translation is off while it is empty.
+// URL overrides the service's API address, e.g. for a proxy.
+type MachineTranslationConfig struct {
+	Provider string `json:"provider"`
+	APIKey   string `json:"api_key"`
+	URL      string `json:"url"`
+}
+
+// DescriptionTranslator translates text into lang, a language code like
+// "en" or "pt-BR".
+type DescriptionTranslator interface {
+	Translate(text, lang string) (string, error)
+}
+
+var machineTranslator DescriptionTranslator
+
+var languageCode = regexp.MustCompile(`^[a-z]{2}(-[A-Za-z]{2})?$`)
+
+// NewDescriptionTranslator builds the translator described by config.
+func NewDescriptionTranslator(config MachineTranslationConfig) (DescriptionTranslator, error) {
+	if config.APIKey == "" {
+		return nil, fmt.Errorf("%s machine translation requires api_key", config.Provider)
+	}
+
+	switch config.Provider {
+	case "deepl":
+		url := config.URL
+		if url == "" {
+			// Keys of the free plan end in :fx and have an API of their own
+			url = "https://api.deepl.com"
+			if strings.HasSuffix(config.APIKey, ":fx") {
+				url = "https://api-free.deepl.com"
+			}
+		}
+		client := newEngineClient(url)
+		client.header.Set("Authorization", "DeepL-Auth-Key "+config.APIKey)
+		return &deeplTranslator{client}, nil
+	case "google":
+		url := config.URL
+		if url == "" {
+			url = "https://translation.googleapis.com"
+		}
+		client := newEngineClient(url)
+		client.header.Set("X-Goog-Api-Key", config.APIKey)
+		return &googleTranslator{client}, nil
+	}
+	return nil, fmt.Errorf("unknown machine translation provider %q", config.Provider)
+}
+
+// deeplTranslator uses the DeepL API.
+type deeplTranslator struct {
+	*engineClient
+}
+
+func (t *deeplTranslator) Translate(text, lang string) (string, error) {
+	request := map[string]interface{}{
+		"text":        []string{text},
+		"target_lang": strings.ToUpper(lang),
+	}
+	var response struct {
+		Translations []struct {
+			Text string `json:"text"`
+		} `json:"translations"`
+	}
+	if err := t.do("POST", "/v2/translate", "application/json", request, &response); err != nil {
+		return "", fmt.Errorf("deepl: %v", err)
+	}
+	if len(response.Translations) == 0 {
+		return "", errors.New("deepl: no translation in the response")
+	}
+	return response.Translations[0].Text, nil
+}
+
+// googleTranslator uses the Google Cloud Translation API (basic edition).
+type googleTranslator struct {
+	*engineClient
+}
+
+func (t *googleTranslator) Translate(text, lang string) (string, error) {
+	request := map[string]interface{}{
+		"q":      []string{text},
+		"source": "is",
+		"target": lang,
+		"format": "text",
+	}
+	var response struct {
+		Data struct {
+			Translations []struct {
+				TranslatedText string `json:"translatedText"`
+			} `json:"translations"`
+		} `json:"data"`
+	}
+	if err := t.do("POST", "/language/translate/v2", "application/json", request, &response); err != nil {
+		return "", fmt.Errorf("google translate: %v", err)
+	}
+	if len(response.Data.Translations) == 0 {
+		return "", errors.New("google translate: no translation in the response")
+	}
+	return response.Data.Translations[0].TranslatedText, nil
+}
+
+// storedTranslation is a car's description in another language. Source is
+// a hash of the description it was translated from, so a car whose
+// description changes is translated again.
+type storedTranslation struct {
+	Source       string    `json:"source"`
+	Text         string    `json:"text"`
+	TranslatedAt time.Time `json:"translated_at"`
+}
+
+// TranslateDescription returns text, slug's description, in lang, from
+// the store if it was translated before and otherwise through translator.
+func (d *Dataset) TranslateDescription(translator DescriptionTranslator, slug, lang, text string) (string, error) {
+	sum := sha256.Sum256([]byte(text))
+	source := hex.EncodeToString(sum[:16])
+	key := slug + "/" + lang
+
+	var stored storedTranslation
+	err := getJSON(d.store, bucketTranslations, key, &stored)
+	if err == nil && stored.Source == source {
+		return stored.Text, nil
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return "", err
+	}
+
+	translated, err := translator.Translate(text, lang)
+	if err != nil {
+		return "", err
+	}
+	stored = storedTranslation{Source: source, Text: translated, TranslatedAt: time.Now().UTC()}
+	if err := putJSON(d.store, bucketTranslations, key, stored); err != nil {
+		log.Printf("dataset: %v", err)
+	}
+	return translated, nil
+}
+
+// checkTranslationLang answers 400 and returns false if ?lang is set to
+// something other than a language code while machine translation is on.
+func checkTranslationLang(w http.ResponseWriter, r *http.Request) bool {
+	lang := r.URL.Query().Get("lang")
+	if machineTranslator == nil || lang == "" || languageCode.MatchString(lang) {
+		return true
+	}
+	respond(w, r, http.StatusBadRequest, APIResponse{
+		Success: false,
+		Error:   fmt.Sprintf("Invalid lang %q; use a language code like en or de", lang),
+	})
+	return false
+}
+
+// translateDetails returns a copy of details with its description
+// translated into the ?lang the request asked for, when machine
+// translation is on, and marks the response's Content-Language. The
+// description is left as it is if translation fails.
+func translateDetails(w http.ResponseWriter, r *http.Request, details *CarDetails) *CarDetails {
+	lang := r.URL.Query().Get("lang")
+	if machineTranslator == nil || lang == "" || details.Description == nil {
+		return details
+	}
+	translated, err := dataset.TranslateDescription(machineTranslator, details.Slug, lang, *details.Description)
+	if err != nil {
+		log.Printf("translation: %s: %v", details.Slug, err)
+		recent.addError("translation", err)
+		return details
+	}
+	copied := *details
+	copied.Description = &translated
+	w.Header().Set("Content-Language", lang)
+	return &copied
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var alertEmailTemplate = template.Must(template.New("alert").Parse(`<p>A car matching your saved search <strong>{{.Alert.Query}}</strong> was just listed on {{.Site}}:</p>
+<p><a href="{{.Car.URL}}">{{.Car.Name}}</a></p>
+{{if .Car.Thumbnail}}<p><a href="{{.Car.URL}}"><img src="{{.Car.Thumbnail}}" alt="{{.Car.Name}}"></a></p>{{end}}`))
+
+// Mailer sends alert notifications over SMTP.
+type Mailer struct {
+	config SMTPConfig
+	// site is the host of the scraped site, as alert emails name it.
+	site string
+}
+
+// NewMailer returns a mailer whose alert emails name the site at baseURL
+// by its host.
+func NewMailer(config SMTPConfig, baseURL string) *Mailer {
+	site := baseURL
+	if u, err := url.Parse(baseURL); err == nil && u.Hostname() != "" {
+		site = u.Hostname()
+	}
+	return &Mailer{config: config, site: site}
+}
+
+// SendAlertMatch emails the alert's recipient about a newly listed car.
+func (m *Mailer) SendAlertMatch(alert Alert, car Car) error {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	text, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(text, "A car matching your saved search \"%s\" was just listed on %s:\r\n\r\n%s\r\n%s\r\n", alert.Query, m.site, car.Name, car.URL)
+
+	html, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/html; charset=utf-8"}})
+	if err != nil {
+		return err
+	}
+	if err := alertEmailTemplate.Execute(html, map[string]interface{}{"Alert": alert, "Car": car, "Site": m.site}); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	contentType := "multipart/alternative; boundary=" + mw.Boundary()
+	if err := m.send(alert.Email, "Partasala: "+car.Name, contentType, body.Bytes()); err != nil {
+		return fmt.Errorf("failed to send alert email to %s: %v", alert.Email, err)
+	}
+	return nil
+}
+
+// SendText emails a plain text message to one recipient.
+func (m *Mailer) SendText(to, subject, text string) error {
+	if err := m.send(to, subject, "text/plain; charset=utf-8", []byte(text)); err != nil {
+		return fmt.Errorf("failed to send email to %s: %v", to, err)
+	}
+	return nil
+}
+
+// send mails body to to. The headers get the addresses as mail.Address
+// writes them, and the SMTP envelope their bare addresses, without any
+// display name.
+func (m *Mailer) send(to, subject, contentType string, body []byte) error {
+	if addr, err := mail.ParseAddress(to); err == nil {
+		to = addr.Address
+	}
+	from := m.config.From
+	fromHeader := from
+	if addr, err := mail.ParseAddress(from); err == nil {
+		from, fromHeader = addr.Address, addr.String()
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", fromHeader)
+	fmt.Fprintf(&msg, "To: %s\r\n", (&mail.Address{Address: to}).String())
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: %s\r\n\r\n", contentType)
+	msg.Write(body)
+
+	var auth smtp.Auth
+	if m.config.Username != "" {
+		auth = smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)
+	}
+
+	addr := net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port))
+	return smtp.SendMail(addr, auth, from, []string{to}, msg.Bytes())
+}
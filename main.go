@@ -1,12 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/mux"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
 )
 
 type APIResponse struct {
@@ -14,13 +23,27 @@ type APIResponse struct {
 	Count   int         `json:"count,omitempty"`
 	Data    interface{} `json:"data,omitempty"`
 	Error   string      `json:"error,omitempty"`
+	// Stale marks data served from the store while upstream is unavailable
+	Stale bool `json:"stale,omitempty"`
 }
 
 type SearchResponse struct {
-	Success bool        `json:"success"`
-	Query   string      `json:"query"`
-	Count   int         `json:"count"`
-	Data    interface{} `json:"data"`
+	Success bool   `json:"success"`
+	Query   string `json:"query"`
+	Count   int    `json:"count"`
+	// Total is how many cars matched, of which Data holds Count from Offset
+	Total  int         `json:"total"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+	Data   interface{} `json:"data"`
+	// Suggestions are close names to try when nothing matched
+	Suggestions []string `json:"suggestions,omitempty"`
+	// IndexedAt is when the search index answering the query was last
+	// rebuilt from a crawl
+	IndexedAt *time.Time `json:"indexed_at,omitempty"`
+	// Engine is the external search engine that answered the query
+	Engine string `json:"engine,omitempty"`
+	Stale  bool   `json:"stale,omitempty"`
 }
 
 type BrandResponse struct {
@@ -28,57 +51,561 @@ type BrandResponse struct {
 	Brand   string      `json:"brand"`
 	Count   int         `json:"count"`
 	Data    interface{} `json:"data"`
+	Stale   bool        `json:"stale,omitempty"`
 }
 
 type CarResponse struct {
 	Success bool        `json:"success"`
 	Data    interface{} `json:"data"`
+	Stale   bool        `json:"stale,omitempty"`
 }
 
-var scraper *PartasalaScraper
+var (
+	scraper   SiteScraper
+	dataset   *Dataset
+	alerts    *AlertStore
+	webhooks  *WebhookStore
+	crawler   *Crawler
+	jobs      *JobQueue
+	mailer    *Mailer
+	structure *partasala.StructureMonitor
+
+	selfCheckConfig SelfCheckConfig
+	searchSynonyms  map[string]string
+	searchFolding   string
+	searches        *searchCache
+	searchIndex     = &SearchIndex{}
+
+	// crawling is set once the background crawler runs
+	crawling atomic.Bool
+	// startCrawler starts the background crawler if crawler.enabled was
+	// left out of the config; alerts and webhooks call it when created,
+	// since they only fire on crawls
+	startCrawler = func() {}
+)
 
 func main() {
-	scraper = NewPartasalaScraper()
+	configPath := flag.String("config", "", "Path to a JSON config file")
+	mock := flag.Bool("mock", false, "Serve embedded sample data instead of scraping the site")
+	replay := flag.Bool("replay", false, "Replay upstream responses recorded in cassettes, recording the ones missing")
+	flag.BoolVar(&validateResponses, "validate-responses", false, "Check JSON responses against the published schemas and log mismatches, for development")
+	flag.Parse()
+
+	config, err := LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *mock {
+		config.Site = "mock"
+	}
+	if *replay {
+		replayCassettes(&config)
+	}
+
+	scraperConfig := config.Scraper
+	if scraperConfig.SelectorsFile != "" {
+		scraperConfig.Selectors, err = LoadSelectors(scraperConfig.SelectorsFile, config.Scraper.Selectors)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	scraper, err = NewSiteScraper(config.Site, scraperConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := loadHostedSites(config.Site, scraperConfig, config.Sites, *mock); err != nil {
+		log.Fatal(err)
+	}
+
+	store, err := OpenStore(config.Store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+	if err := Migrate(store); err != nil {
+		log.Fatal(err)
+	}
+	dataset = NewDataset(store)
+
+	if flag.NArg() > 0 {
+		if err := runCommand(scraperConfig, flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	features = Features{
+		Site:        config.Site,
+		Store:       config.Store.Backend(),
+		PageCache:   config.Scraper.Cache.Dir != "",
+		Browser:     config.Scraper.Browser.Enabled,
+		SearchSinks: len(config.SearchSinks),
+	}
+	selfCheckConfig = config.SelfCheck
+	basePath = config.Server.BasePath
+	searchSynonyms = config.Scraper.SearchSynonyms
+	searchFolding = config.Scraper.SearchFolding
+	searches = newSearchCache(config.SearchCache)
+	imageRewrite = config.ImageRewrite
+	brandAliases = config.BrandAliases
+	carOrder = config.CarOrder
+	translator = NewTranslator(config.Translations)
+	if config.MachineTranslation.Provider != "" {
+		if machineTranslator, err = NewDescriptionTranslator(config.MachineTranslation); err != nil {
+			log.Fatal(err)
+		}
+	}
+	imageProxy = config.ImageProxy
+	if len(imageProxy.AllowedPrefixes) == 0 {
+		imageProxy.AllowedPrefixes = []string{strings.TrimRight(config.Scraper.BaseURL, "/") + "/wp-content/uploads/"}
+	}
+	if config.Crawler.Enabled {
+		if err := searchIndex.Rebuild(dataset); err != nil {
+			log.Printf("search index: %v", err)
+		}
+	}
+	structure = partasala.NewStructureMonitor()
+	if observable, ok := scraper.(StructureObservable); ok {
+		observable.SetStructureMonitor(structure)
+	}
+
+	if scraperConfig.SelectorsFile != "" {
+		reloader, ok := scraper.(SelectorReloader)
+		if !ok {
+			log.Fatalf("site %q does not support selector files", config.Site)
+		}
+		go WatchSelectors(context.Background(), scraperConfig.SelectorsFile, config.Scraper.Selectors, reloader, 5*time.Second)
+	}
+
+	errorReporter, err = NewErrorReporter(config.ErrorReporting)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go errorReporter.Run(context.Background())
+
+	alerts = NewAlertStore(dataset.store)
+	webhooks = NewWebhookStore(dataset.store)
+	if config.SMTP.Host != "" {
+		mailer = NewMailer(config.SMTP, config.Scraper.BaseURL)
+	}
+
+	configured, err := NewNotifiers(config.Notifiers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	notifiers := &NotifierSet{}
+	notifiers.Set(configured)
+
+	sinks := &SearchSinks{}
+	for _, sc := range config.SearchSinks {
+		sink, err := NewSearchSink(sc)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sinks.sinks = append(sinks.sinks, sink)
+		if sc.DelegateSearch {
+			searchDelegate = &delegatedSearch{engine: sc.Type, sink: sink.(SearchDelegate)}
+		}
+	}
+
+	publishers := EventPublishers{}
+	for _, pc := range config.EventPublishers {
+		publisher, err := NewEventPublisher(pc)
+		if err != nil {
+			log.Fatal(err)
+		}
+		publishers = append(publishers, publisher)
+	}
+
+	if health, ok := scraper.(UpstreamHealth); ok && config.UpstreamAlert.ErrorRate > 0 {
+		upstreamAlert = NewUpstreamAlertMonitor(config.UpstreamAlert, health, notifiers, mailer)
+		go upstreamAlert.Run(context.Background())
+	}
+
+	structure.Subscribe(func(issue partasala.StructureIssue) {
+		recent.addError("structure", errors.New(issue.URL+": "+issue.Message))
+		errorReporter.Capture("structure", "warning", errors.New(issue.URL+": "+issue.Message))
+		notifiers.Notify(NotifierEvent{Type: EventStructureChanged, Error: issue.URL + ": " + issue.Message})
+	})
+
+	crawler = NewCrawler(scraper, dataset, config.Crawler.Interval.Duration)
+	crawler.Subscribe(func(diff CrawlDiff) {
+		notifications, err := alerts.Evaluate(diff.Added)
+		if err != nil {
+			log.Printf("alerts: %v", err)
+			recent.addError("alerts", err)
+		}
+		for _, n := range notifications {
+			if n.Alert.Email == "" || mailer == nil {
+				continue
+			}
+			if err := mailer.SendAlertMatch(n.Alert, n.Car); err != nil {
+				log.Printf("alerts: %v", err)
+				recent.addError("alerts", err)
+			}
+		}
+	})
+	crawler.Subscribe(func(diff CrawlDiff) {
+		for _, car := range diff.Added {
+			notifiers.Notify(NotifierEvent{Type: EventCarAdded, Car: car})
+		}
+		for _, car := range diff.Removed {
+			notifiers.Notify(NotifierEvent{Type: EventCarRemoved, Car: car})
+		}
+	})
+	crawler.Subscribe(func(diff CrawlDiff) {
+		events := carEvents(diff, time.Now().UTC())
+		publishers.Publish(events)
+		webhooks.Dispatch(events)
+	})
+	crawler.Subscribe(live.PublishDiff)
+	crawler.SubscribeStatus(live.PublishStatus)
+	crawler.SubscribeSaved(func() {
+		searches.clear()
+		heads.clear()
+		if crawling.Load() {
+			if err := searchIndex.Rebuild(dataset); err != nil {
+				log.Printf("search index: %v", err)
+			}
+		}
+		go sinks.Sync(dataset)
+	})
+	crawler.SubscribeErrors(func(err error) {
+		recent.addError("crawl", err)
+		errorReporter.Capture("crawl", "error", err)
+		notifiers.Notify(NotifierEvent{Type: EventCrawlFailed, Error: err.Error()})
+	})
+	popularity = NewPopularityCounter(store)
+	go popularity.Run(context.Background(), time.Minute)
+	if config.Enrichment.Enabled {
+		// With the crawler on, wait for it to store the cars to enrich
+		enricher := NewEnricher(config.Enrichment, scraper, dataset, popularity)
+		if config.Crawler.Enabled {
+			var once sync.Once
+			crawler.SubscribeSaved(func() {
+				once.Do(func() { go enricher.Run(context.Background()) })
+			})
+		} else {
+			go enricher.Run(context.Background())
+		}
+	}
+
+	jobs = NewJobQueue(crawler)
+	go jobs.Run(context.Background())
+	var crawlerStarted sync.Once
+	runCrawler := func() {
+		crawlerStarted.Do(func() {
+			crawling.Store(true)
+			if len(config.Crawler.Schedules) > 0 {
+				go crawler.RunSchedules(context.Background(), config.Crawler.Schedules)
+			} else {
+				go crawler.Run(context.Background())
+			}
+		})
+	}
+	if config.Crawler.Auto {
+		startCrawler = runCrawler
+		// Alerts and webhooks kept from before a restart need crawls as
+		// much as new ones
+		savedAlerts, _ := alerts.Len()
+		savedWebhooks, _ := webhooks.Len()
+		if savedAlerts > 0 || savedWebhooks > 0 {
+			runCrawler()
+		}
+	}
+	if config.Crawler.Enabled {
+		runCrawler()
+	}
+
+	if config.Upload.Bucket != "" {
+		go NewSnapshotUploader(config.Upload, dataset).Run(context.Background())
+	}
+
+	usage = NewUsageMeter(store, config.Quotas)
+	go usage.Run(context.Background(), time.Minute)
+	analytics = NewAnalytics(store)
+	go analytics.Run(context.Background(), time.Minute)
+
+	reloader := NewReloader(*configPath, notifiers)
+	go reloader.ReloadOnSignal(context.Background())
 
 	r := mux.NewRouter()
 
-	// Enable CORS middleware
-	r.Use(corsMiddleware)
+	// Count requests before anything can answer them, quota refusals included
+	r.Use(analyticsMiddleware(config.AdminAPIKey))
+	// Enable response compression middleware; CORS wraps the whole router
+	r.Use(etagMiddleware)
+	r.Use(compressMiddleware)
+	r.Use(errorRequestIDMiddleware)
+	r.Use(quotaMiddleware(config.AdminAPIKey))
 
 	// Routes
 	r.HandleFunc("/", indexHandler).Methods("GET")
+	r.HandleFunc("/healthz", healthzHandler).Methods("GET")
+	r.HandleFunc("/readyz", readyzHandler).Methods("GET")
+	r.HandleFunc("/version", versionHandler).Methods("GET")
+	r.HandleFunc("/stats", statsHandler).Methods("GET")
+	r.HandleFunc("/stats/popular", popularCarsHandler).Methods("GET")
 	r.HandleFunc("/brands", getBrandsHandler).Methods("GET")
 	r.HandleFunc("/brands/{brand_slug}", getBrandCarsHandler).Methods("GET")
+	r.HandleFunc("/brands/{brand_slug}/cars/{car_slug}", getBrandCarHandler).Methods("GET")
+	r.HandleFunc("/brands/{brand_slug}/images", getBrandImagesHandler).Methods("GET")
+	r.HandleFunc("/categories", getCategoriesHandler).Methods("GET")
+	r.HandleFunc("/categories/{slug}", getCategoryCarsHandler).Methods("GET")
 	r.HandleFunc("/cars", getAllCarsHandler).Methods("GET")
+	r.HandleFunc("/cars/removed", getRemovedCarsHandler).Methods("GET")
+	r.HandleFunc("/cars/new", getNewCarsHandler).Methods("GET")
 	r.HandleFunc("/cars/{car_slug}", getCarDetailsHandler).Methods("GET")
+	r.HandleFunc("/cars/{car_slug}/history", getCarHistoryHandler).Methods("GET")
 	r.HandleFunc("/search", searchCarsHandler).Methods("GET")
+	r.HandleFunc("/search/all", searchAllHandler).Methods("GET")
+	r.HandleFunc("/ws", liveHandler(config.CORS)).Methods("GET")
+	r.HandleFunc("/poll", pollHandler).Methods("GET")
+	r.HandleFunc("/compare", compareCarsHandler).Methods("GET")
+	r.HandleFunc("/contact", getContactHandler).Methods("GET")
+	r.HandleFunc("/proxy/image", proxyImageHandler).Methods("GET")
+	r.HandleFunc("/export/cars.xlsx", exportCarsXLSXHandler).Methods("GET")
+	r.HandleFunc("/ui", uiBrandsHandler).Methods("GET")
+	r.HandleFunc("/ui/brands/{brand_slug}", uiBrandCarsHandler).Methods("GET")
+	r.HandleFunc("/ui/cars/{car_slug}", uiCarHandler).Methods("GET")
+	r.HandleFunc("/ui/search", uiSearchHandler).Methods("GET")
+	r.HandleFunc("/events/history", eventHistoryHandler).Methods("GET")
+	r.HandleFunc("/diff", diffHandler).Methods("GET")
+	r.HandleFunc("/images/similar", similarImagesHandler).Methods("GET")
+	r.HandleFunc("/schemas", listSchemasHandler).Methods("GET")
+	r.HandleFunc("/schemas/{name}", getSchemaHandler).Methods("GET")
+	r.HandleFunc("/me/usage", usageHandler(config.AdminAPIKey)).Methods("GET")
+	r.HandleFunc("/sites", listSitesHandler).Methods("GET")
 
-	log.Println("Starting Partasala.is Scraper API...")
-	log.Println("API Documentation: http://localhost:1667/")
-	log.Fatal(http.ListenAndServe(":1667", r))
-}
+	sitesRouter := r.PathPrefix("/sites/{site}").Subrouter()
+	sitesRouter.HandleFunc("/brands", hostedSite(getBrandsHandler, siteBrandsHandler)).Methods("GET")
+	sitesRouter.HandleFunc("/brands/{brand_slug}", hostedSite(getBrandCarsHandler, siteBrandCarsHandler)).Methods("GET")
+	sitesRouter.HandleFunc("/cars", hostedSite(getAllCarsHandler, siteCarsHandler)).Methods("GET")
+	sitesRouter.HandleFunc("/cars/{car_slug}", hostedSite(getCarDetailsHandler, siteCarDetailsHandler)).Methods("GET")
+	sitesRouter.HandleFunc("/search", hostedSite(searchCarsHandler, siteSearchHandler)).Methods("GET")
+	sitesRouter.HandleFunc("/contact", hostedSite(getContactHandler, siteContactHandler)).Methods("GET")
 
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Accept")
-		w.Header().Set("Content-Type", "application/json")
+	// The same handlers under one envelope; see V2Response
+	v2 := r.PathPrefix("/v2").Subrouter()
+	v2.HandleFunc("/brands", getBrandsHandler).Methods("GET")
+	v2.HandleFunc("/brands/{brand_slug}", getBrandCarsHandler).Methods("GET")
+	v2.HandleFunc("/brands/{brand_slug}/cars/{car_slug}", getBrandCarHandler).Methods("GET")
+	v2.HandleFunc("/cars", getAllCarsHandler).Methods("GET")
+	v2.HandleFunc("/cars/{car_slug}", getCarDetailsHandler).Methods("GET")
+	v2.HandleFunc("/search", searchCarsHandler).Methods("GET")
+	v2.HandleFunc("/contact", getContactHandler).Methods("GET")
+	v2.NotFoundHandler = http.HandlerFunc(v2NotFoundHandler)
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+	watchlistRouter := r.PathPrefix("/watchlist").Subrouter()
+	watchlistRouter.Use(requireScope(config.AdminAPIKey, ScopeRead))
+	watchlistRouter.HandleFunc("", listWatchlistHandler).Methods("GET")
+	watchlistRouter.HandleFunc("", addWatchlistHandler).Methods("POST", "OPTIONS")
+	watchlistRouter.HandleFunc("/updates", watchlistUpdatesHandler).Methods("GET")
+	watchlistRouter.HandleFunc("/{car_slug}", deleteWatchlistHandler).Methods("DELETE", "OPTIONS")
 
-		next.ServeHTTP(w, r)
-	})
+	alertsRouter := r.PathPrefix("/alerts").Subrouter()
+	alertsRouter.Use(requireScope(config.AdminAPIKey, ScopeRead))
+	alertsRouter.HandleFunc("", listAlertsHandler).Methods("GET")
+	alertsRouter.HandleFunc("", createAlertHandler).Methods("POST", "OPTIONS")
+	alertsRouter.HandleFunc("/{id}", deleteAlertHandler).Methods("DELETE", "OPTIONS")
+	alertsRouter.HandleFunc("/{id}/matches", getAlertMatchesHandler).Methods("GET")
+
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(requireScope(config.AdminAPIKey, ScopeAdmin))
+	dashboard := newAdminDashboard(config.AdminAPIKey)
+	admin.HandleFunc("", dashboard.show).Methods("GET")
+	admin.HandleFunc("/dashboard/crawl", dashboard.crawl).Methods("POST")
+	admin.HandleFunc("/dashboard/flush", dashboard.flush).Methods("POST")
+	admin.HandleFunc("/selfcheck", selfCheckHandler).Methods("GET")
+	admin.HandleFunc("/export", exportDatasetHandler).Methods("GET")
+	admin.HandleFunc("/import", importDatasetHandler).Methods("POST")
+	admin.HandleFunc("/backup", backupHandler).Methods("POST")
+	admin.HandleFunc("/restore", restoreHandler).Methods("POST")
+	admin.HandleFunc("/store", storeStatsHandler).Methods("GET")
+	admin.HandleFunc("/store/compact", compactStoreHandler).Methods("POST")
+	admin.HandleFunc("/crawl/status", crawlStatusHandler).Methods("GET")
+	admin.HandleFunc("/crawl/reports", crawlReportsHandler).Methods("GET")
+	admin.HandleFunc("/analytics", analyticsHandler).Methods("GET")
+	admin.HandleFunc("/images/check", checkImagesHandler).Methods("POST")
+	admin.HandleFunc("/tokens", listTokensHandler).Methods("GET")
+	admin.HandleFunc("/tokens", createTokenHandler).Methods("POST")
+	admin.HandleFunc("/tokens/{id}", revokeTokenHandler).Methods("DELETE")
+	admin.HandleFunc("/reload", reloadHandler(reloader)).Methods("POST")
+
+	jobsRouter := r.PathPrefix("/jobs").Subrouter()
+	jobsRouter.Use(requireScope(config.AdminAPIKey, ScopeAdmin))
+	jobsRouter.HandleFunc("", listJobsHandler).Methods("GET")
+	jobsRouter.HandleFunc("/crawl", createCrawlJobHandler).Methods("POST")
+	jobsRouter.HandleFunc("/{id}", cancelJobHandler).Methods("DELETE")
+
+	webhooksRouter := r.PathPrefix("/webhooks").Subrouter()
+	webhooksRouter.Use(requireScope(config.AdminAPIKey, ScopeWebhooks))
+	webhooksRouter.HandleFunc("", listWebhooksHandler).Methods("GET")
+	webhooksRouter.HandleFunc("", createWebhookHandler).Methods("POST")
+	webhooksRouter.HandleFunc("/{id}", deleteWebhookHandler).Methods("DELETE")
+	webhooksRouter.HandleFunc("/{id}/deliveries", getWebhookDeliveriesHandler).Methods("GET")
+
+	listener, err := listen(config.Server)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Starting Partasala.is Scraper API %s...", version)
+	log.Println("API Documentation: " + listenURL(listener, config.Server.TLS.Enabled()) + strings.TrimPrefix(link("/"), "/"))
+	var handler http.Handler = recoverMiddleware(stripBasePath(basePath, corsMiddleware(config.CORS)(headMiddleware(r))))
+	if config.Server.AccessLog {
+		sink := logAccessText
+		if config.Server.AccessLogFile.Path != "" {
+			file, err := openRotatingFile(config.Server.AccessLogFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			sink = jsonAccessLog(file)
+		}
+		handler = accessLogMiddleware(sink)(handler)
+	}
+	handler = requestIDMiddleware(handler)
+	server := &http.Server{
+		Handler:      handler,
+		ReadTimeout:  config.Server.ReadTimeout.Duration,
+		WriteTimeout: config.Server.WriteTimeout.Duration,
+		IdleTimeout:  config.Server.IdleTimeout.Duration,
+	}
+	stopped := shutdownOnSignal(server)
+	if err := serve(server, listener, config.Server); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-stopped
 }
 
 func indexHandler(w http.ResponseWriter, r *http.Request) {
 	doc := map[string]interface{}{
 		"name":    "Partasala.is Scraper API",
-		"version": "1.0.0",
+		"version": version,
 		"endpoints": map[string]interface{}{
+			"/admin": map[string]interface{}{
+				"method":      "GET",
+				"description": "HTML dashboard with crawl status, cache stats, recent errors, notifier deliveries, and buttons to crawl or flush caches (requires the admin API key or a token with the admin scope, e.g. as the basic auth password)",
+			},
+			"/admin/selfcheck": map[string]interface{}{
+				"method":      "GET",
+				"description": "Scrape one brand and one car live and check every parser still extracts its fields (requires the admin API key or an admin token)",
+				"response":    "Pass/fail per parser",
+			},
+			"/admin/export": map[string]interface{}{
+				"method":      "GET",
+				"description": "Download the stored dataset as a versioned JSON snapshot (requires the admin API key or an admin token)",
+				"response":    "Snapshot with brands, cars, and car details",
+			},
+			"/admin/import": map[string]interface{}{
+				"method":      "POST",
+				"description": "Replace the stored dataset with a snapshot from /admin/export (requires the admin API key or an admin token)",
+			},
+			"/admin/backup": map[string]interface{}{
+				"method":      "POST",
+				"description": "Download a tar.gz archive of the whole store, including history (requires the admin API key or an admin token)",
+			},
+			"/admin/restore": map[string]interface{}{
+				"method":      "POST",
+				"description": "Replace the whole store with an archive from /admin/backup (requires the admin API key or an admin token)",
+			},
+			"/admin/store": map[string]interface{}{
+				"method":      "GET",
+				"description": "Key counts per store bucket and the store's size on disk (requires the admin API key or an admin token)",
+			},
+			"/admin/store/compact": map[string]interface{}{
+				"method":      "POST",
+				"description": "Compact the bolt store to reclaim unused space (requires the admin API key or an admin token)",
+			},
+			"/admin/crawl/status": map[string]interface{}{
+				"method":      "GET",
+				"description": "Whether a crawl is running, its progress, current brand, errors, and ETA (requires the admin API key or an admin token)",
+			},
+			"/admin/crawl/reports": map[string]interface{}{
+				"method":      "GET",
+				"description": "The last crawl reports with pages, bytes, duration, per-brand timings, and parse failures (requires the admin API key or an admin token)",
+				"parameters":  "limit (default 10)",
+			},
+			"/admin/analytics": map[string]interface{}{
+				"method":      "GET",
+				"description": "Requests per day, endpoint, and API key, with error counts and average durations (requires the admin API key or an admin token)",
+				"parameters":  "days (default 7, max 90), endpoint (e.g. GET /cars/{car_slug}), key (token id, admin, or anonymous)",
+			},
+			"/admin/images/check": map[string]interface{}{
+				"method":      "POST",
+				"description": "HEAD-check every stored car image and list those upstream answers 404 or 410 for; {\"remove\": true} also drops them from the stored cars (requires the admin API key or an admin token)",
+			},
+			"/admin/tokens": map[string]interface{}{
+				"method":      "GET, POST",
+				"description": "List API tokens, or issue one with scopes: read (watchlist and alerts), admin, webhooks (requires the admin API key or an admin token)",
+				"parameters": map[string]string{
+					"name":   "Optional label (JSON body field)",
+					"scopes": "Scopes the token carries (JSON body field)",
+				},
+				"response": "Array of tokens, or the issued token, shown only this once",
+			},
+			"/admin/tokens/{id}": map[string]interface{}{
+				"method":      "DELETE",
+				"description": "Revoke a token, deleting its watchlist and saved searches (requires the admin API key or an admin token)",
+			},
+			"/admin/reload": map[string]interface{}{
+				"method":      "POST",
+				"description": "Re-read the config file and apply selectors, cache TTLs, rate limits, quotas, and notifiers without a restart, as SIGHUP does (requires the admin API key or an admin token)",
+			},
+			"/jobs": map[string]interface{}{
+				"method":      "GET",
+				"description": "Queued, running, and recently finished crawl jobs with progress and error counts (requires the admin API key or an admin token)",
+			},
+			"/jobs/crawl": map[string]interface{}{
+				"method":      "POST",
+				"description": "Queue a full crawl, or one brand's with {\"brand\": \"<brand_slug>\"} (requires the admin API key or an admin token)",
+			},
+			"/jobs/{id}": map[string]interface{}{
+				"method":      "DELETE",
+				"description": "Cancel a queued or running job (requires the admin API key or an admin token)",
+			},
+			"/webhooks": map[string]interface{}{
+				"method":      "GET, POST",
+				"description": "List your webhooks, or register a public URL that gets a signed POST for car events, retried with backoff (requires the admin API key or a token with the webhooks scope)",
+				"parameters": map[string]string{
+					"url":    "http or https URL to post to (JSON body field)",
+					"events": "Optional event types: car.added (default), car.removed, car.updated (JSON body field)",
+					"secret": "Optional signing secret, generated if left out (JSON body field)",
+				},
+				"response": "Array of webhooks, or the created webhook with its id and secret",
+			},
+			"/webhooks/{id}": map[string]interface{}{
+				"method":      "DELETE",
+				"description": "Delete one of your webhooks (requires the admin API key or a token with the webhooks scope)",
+			},
+			"/webhooks/{id}/deliveries": map[string]interface{}{
+				"method":      "GET",
+				"description": "The webhook's last 100 deliveries, newest first, with every attempt's status code or error (requires the admin API key or a token with the webhooks scope)",
+			},
+			"/healthz": map[string]interface{}{
+				"method":      "GET",
+				"description": "Service health, including probable upstream markup changes",
+				"response":    "Status (ok or degraded) with details",
+			},
+			"/readyz": map[string]interface{}{
+				"method":      "GET",
+				"description": "Readiness: 503 unless the store can be read, and with deep=true unless the upstream homepage is reachable and lists brands",
+				"parameters":  "deep (true to probe upstream), timeout (probe timeout, default and most 10s)",
+				"response":    "Status (ready or not_ready) with checks, including when upstream last answered",
+			},
+			"/version": map[string]interface{}{
+				"method":      "GET",
+				"description": "Build details: version, git commit, build date, Go version, and the features this deployment runs with",
+			},
+			"/stats": map[string]interface{}{
+				"method":      "GET",
+				"description": "Upstream usage: the daily request budget, bytes downloaded, and circuit breaker state",
+			},
+			"/stats/popular": map[string]interface{}{
+				"method":      "GET",
+				"description": "The cars whose details were viewed most, most viewed first",
+				"parameters":  "days (default 7, max 90), limit (default 20, max 200)",
+			},
 			"/brands": map[string]interface{}{
 				"method":      "GET",
 				"description": "Get list of all car brands",
@@ -88,140 +615,637 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 				"method":      "GET",
 				"description": "Get list of cars for a specific brand",
 				"parameters": map[string]string{
-					"brand_slug": "Brand identifier (e.g., audi, bmw, toyota)",
+					"brand_slug":      "Brand identifier (e.g., audi, bmw, toyota)",
+					"include_removed": "Optional: true to add the brand's removed cars, marked with removed_at",
+					"details":         "Optional: true to embed each car's details (at most 100 cars)",
+					"sort":            "Optional: name (default), first_seen, posted, or site",
 				},
 				"response": "Array of car objects with name, URL, and thumbnail",
 			},
+			"/brands/<brand_slug>/cars/<car_slug>": map[string]interface{}{
+				"method":      "GET",
+				"description": "Get details and images for a car listed under a brand, or 404 if the brand doesn't list it",
+				"parameters": map[string]string{
+					"brand_slug": "Brand identifier (e.g., audi, bmw, toyota)",
+					"car_slug":   "Car identifier from the car URL",
+				},
+				"response": "Car object with name, description, and array of image URLs",
+			},
+			"/brands/{brand_slug}/images": map[string]interface{}{
+				"method":      "GET",
+				"description": "Every image of a brand's stored cars, from the stored car details, a page at a time",
+				"parameters": map[string]string{
+					"brand_slug": "Brand identifier (e.g., audi, bmw, toyota)",
+					"limit":      "Optional: images to return (default 50, at most 200)",
+					"offset":     "Optional: images to skip, for the next page",
+				},
+				"response": "Page of image objects, each with the slug and name of its car, and the total",
+			},
+			"/categories": map[string]interface{}{
+				"method":      "GET",
+				"description": "Categories from the site's other taxonomies, such as vehicle types, as configured in scraper.taxonomies",
+				"parameters":  "taxonomy (optional, only that taxonomy's categories)",
+				"response":    "Array of category objects with name, slug, taxonomy, and URL",
+			},
+			"/categories/{slug}": map[string]interface{}{
+				"method":      "GET",
+				"description": "Get all cars in a category",
+				"parameters": map[string]string{
+					"slug":     "Category identifier",
+					"taxonomy": "Optional taxonomy, for slugs used by more than one",
+				},
+				"response": "The category and an array of car objects",
+			},
 			"/cars": map[string]interface{}{
 				"method":      "GET",
 				"description": "Get all available cars across all brands",
-				"response":    "Array of all car objects with name, URL, and thumbnail",
+				"parameters": map[string]string{
+					"max_price":       "Optional: only cars priced at most this many ISK",
+					"year":            "Optional: only cars of this model year; year_min and year_max give a range",
+					"plate":           "Optional: only the car with this registration number, if its details are stored",
+					"include_removed": "Optional: true to add removed cars after the listed ones, marked with removed_at",
+					"details":         "Optional: true to embed each car's details; the filtered list may hold at most 100 cars",
+					"sort":            "Optional: name (default), first_seen (newest first), posted (newest post first), or site (the site's page order)",
+				},
+				"response": "Array of all car objects with name, URL, thumbnail, and price",
 			},
 			"/cars/<car_slug>": map[string]interface{}{
 				"method":      "GET",
 				"description": "Get details and images for a specific car",
+				"parameters": map[string]string{
+					"car_slug":      "Car identifier from the car URL",
+					"lang":          "Optional: language code to translate the description into, when machine translation is set up",
+					"verify_images": "Optional: true to HEAD each full-size image and leave out the ones upstream doesn't serve, falling back to the thumbnail where it differs",
+				},
+				"response": "Car object with name, description, and array of image URLs, or an HTML page for Accept: text/html",
+			},
+			"/cars/<car_slug>/history": map[string]interface{}{
+				"method":      "GET",
+				"description": "When a car appeared, its price changes, and when it was removed, as seen by the crawler",
 				"parameters": map[string]string{
 					"car_slug": "Car identifier from the car URL",
 				},
-				"response": "Car object with name, description, and array of image URLs",
+				"response": "History object with first_seen, last_seen, listed, price, and events",
 			},
 			"/search": map[string]interface{}{
 				"method":      "GET",
-				"description": "Search for cars by name",
+				"description": "Search for cars by name, in the crawler's index of stored cars when it has one",
+				"parameters": map[string]string{
+					"q":         "Search query; all words must match, with \"quoted phrases\" and OR for alternatives",
+					"mode":      "Optional: terms (default) or regex, to match q as a regular expression against car names",
+					"max_price": "Optional: only cars priced at most this many ISK",
+					"year":      "Optional: only cars of this model year; year_min and year_max give a range",
+					"plate":     "Optional: only the car with this registration number, if its details are stored",
+					"limit":     "Optional: matches to return (default 50, at most 200)",
+					"offset":    "Optional: matches to skip, for the next page",
+				},
+				"response": "Page of matching cars with the matched parts of their names and the total count, plus suggestions of close names when there are none",
+			},
+			"/search/all": map[string]interface{}{
+				"method":      "GET",
+				"description": "Search the main site and every site under sites at once, with the hits ranked together and tagged with their site",
+				"parameters":  "q, max_price, year, year_min, year_max, limit (default 50, at most 200), offset",
+				"response":    "Page of matching cars, each with its site, and the sites that failed in errors",
+			},
+			"/compare": map[string]interface{}{
+				"method":      "GET",
+				"description": "Compare several cars side by side",
+				"parameters": map[string]string{
+					"slugs": "Comma-separated car slugs (2-10)",
+				},
+				"response": "Car details plus attributes (year, engine, image_count) aligned by slug",
+			},
+			"/contact": map[string]interface{}{
+				"method":      "GET",
+				"description": "The yard's phone numbers, email, address, and opening hours from its contact page",
+				"response":    "Contact object with url, phones, email, address, and opening_hours",
+			},
+			"/sites": map[string]interface{}{
+				"method":      "GET",
+				"description": "The sites this instance serves; each one's brands, cars, search, and contact are under /sites/<name>/ with the top-level paths",
+				"response":    "Array of site objects with name, adapter, base_url, and primary",
+			},
+			"/v2/<path>": map[string]interface{}{
+				"method":      "GET",
+				"description": "brands, brands/<brand_slug>, brands/<brand_slug>/cars/<car_slug>, cars, cars/<car_slug>, search, and contact with the same parameters, answered in one envelope",
+				"response":    "Object with data, meta (counts, paging, stale, and where the data came from), and on failure error with a code and message",
+			},
+			"/export/cars.xlsx": map[string]interface{}{
+				"method":      "GET",
+				"description": "Download every car as an Excel workbook with one sheet per brand: name, slug, URL, year, and thumbnail link",
+			},
+			"/proxy/image": map[string]interface{}{
+				"method":      "GET",
+				"description": "Stream an image from the site's uploads through the API, for browsers blocked by hotlink protection or mixed content",
+				"parameters":  "src (image URL under the site's uploads)",
+			},
+			"/cars/new": map[string]interface{}{
+				"method":      "GET",
+				"description": "Cars posted, or first listed by a crawl, within the last days, newest first, from the store",
+				"parameters":  "days (default 7, max 90), max_price, year, year_min, year_max, plate",
+			},
+			"/cars/removed": map[string]interface{}{
+				"method":      "GET",
+				"description": "Cars taken down from the site, most recently removed first, with their last-known listing and details",
+				"parameters":  "since (RFC 3339), brand, limit (default 100, max 1000)",
+			},
+			"/diff": map[string]interface{}{
+				"method":      "GET",
+				"description": "Cars added, removed, and repriced between two crawls or points in time, from the stored history",
+				"parameters":  "from, to (crawl report ids from /admin/crawl/reports, or RFC 3339 times)",
+			},
+			"/images/similar": map[string]interface{}{
+				"method":      "GET",
+				"description": "Stored car images that look like the given one, by perceptual hash, closest first, to spot cars listed again and photos used for more than one car (needs enrichment.image_hashes)",
+				"parameters":  "hash (16 hex digits) or car (slug; finds other cars' images like its own), max_distance (bits, default 10, max 64)",
+			},
+			"/schemas": map[string]interface{}{
+				"method":      "GET",
+				"description": "The JSON Schemas of Brand, Car, CarDetails, and the response envelopes; each is served at /schemas/<name>",
+			},
+			"/events/history": map[string]interface{}{
+				"method":      "GET",
+				"description": "The stored log of changes crawls and detail fetches found, newest first",
+				"parameters":  "type (car_added, car_removed, price_changed, image_count_changed; comma-separated), brand, since, until (RFC 3339), limit (default 100, max 1000)",
+			},
+			"/poll": map[string]interface{}{
+				"method":      "GET",
+				"description": "Long poll for change events: waits until events are logged after the cursor, or the timeout passes, and answers with them and a new cursor",
+				"parameters":  "cursor (from the previous answer; without one, answers at once with the cursor to start from), timeout (seconds, default 30, max 120), type, brand, limit (as for /events/history)",
+			},
+			"/ws": map[string]interface{}{
+				"method":      "GET",
+				"description": "WebSocket pushing a JSON message for every car a crawl finds added or removed, and the crawl status as it changes",
+				"parameters":  "brand, q (only cars of that brand or matching the query)",
+				"response":    "Messages of type car.added, car.removed, or crawl.status",
+			},
+			"/me/usage": map[string]interface{}{
+				"method":      "GET",
+				"description": "Requests made today and on each of the last 30 days with the API key the request is sent with, its daily quota, and when it resets",
+			},
+			"/watchlist": map[string]interface{}{
+				"method":      "GET, POST",
+				"description": "List the cars on the watchlist of the API token the request is sent with, or add one (requires a token with the read scope when admin_api_key is set)",
 				"parameters": map[string]string{
-					"q": "Search query",
+					"slug": "Car slug to watch (JSON body field)",
 				},
-				"response": "Array of matching cars",
+				"response": "Array of entries with the car's stored listing, or the added entry",
+			},
+			"/watchlist/<car_slug>": map[string]interface{}{
+				"method":      "DELETE",
+				"description": "Stop watching a car (requires a read token when admin_api_key is set)",
+			},
+			"/watchlist/updates": map[string]interface{}{
+				"method":      "GET",
+				"description": "Removals, price changes, and image count changes of watched cars since they were added, newest first (requires a read token when admin_api_key is set)",
+				"parameters":  "since (RFC 3339), limit (default 100, max 1000)",
+			},
+			"/alerts": map[string]interface{}{
+				"method":      "GET, POST",
+				"description": "List the saved searches of the API token the request is sent with, or register one that is checked against newly listed cars (requires a token with the read scope when admin_api_key is set)",
+				"parameters": map[string]string{
+					"q":     "Search query (JSON body field)",
+					"brand": "Optional brand slug to restrict matches (JSON body field)",
+					"email": "Optional address notified of each match when SMTP is configured (JSON body field)",
+				},
+				"response": "Array of alerts, or the created alert with its id",
+			},
+			"/alerts/<id>": map[string]interface{}{
+				"method":      "DELETE",
+				"description": "Delete a saved search (requires a read token when admin_api_key is set)",
+			},
+			"/alerts/<id>/matches": map[string]interface{}{
+				"method":      "GET",
+				"description": "Get newly listed cars that matched a saved search (requires a read token when admin_api_key is set)",
+				"response":    "Array of matches with the car and when it was matched",
 			},
 		},
 	}
 
+	if basePath != "" {
+		endpoints := map[string]interface{}{}
+		for path, endpoint := range doc["endpoints"].(map[string]interface{}) {
+			endpoints[link(path)] = endpoint
+		}
+		doc["endpoints"] = endpoints
+	}
 	json.NewEncoder(w).Encode(doc)
 }
 
 func getBrandsHandler(w http.ResponseWriter, r *http.Request) {
-	brands, err := scraper.GetBrands()
+	brands, err := siteFor(r).GetBrands()
+	if err != nil && upstreamDown() {
+		if brands, err := dataset.Brands(); err == nil && len(brands) > 0 {
+			respond(w, r, http.StatusOK, brandsResponse(r, groupBrands(brands), true))
+			return
+		}
+		writeUpstreamUnavailable(w, r)
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(APIResponse{
+		respond(w, r, http.StatusInternalServerError, APIResponse{
 			Success: false,
 			Error:   err.Error(),
 		})
 		return
 	}
 
-	json.NewEncoder(w).Encode(APIResponse{
+	respond(w, r, http.StatusOK, brandsResponse(r, groupBrands(brands), false))
+}
+
+// brandsResponse is the body of a list of brands, in the envelope of the
+// surface r was made to.
+func brandsResponse(r *http.Request, brands []Brand, stale bool) interface{} {
+	if isV2(r) {
+		return v2List(brands, V2Meta{Stale: stale})
+	}
+	return APIResponse{
 		Success: true,
 		Count:   len(brands),
 		Data:    brands,
-	})
+		Stale:   stale,
+	}
 }
 
 func getBrandCarsHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	brandSlug := vars["brand_slug"]
+	brandSlug := canonicalBrand(vars["brand_slug"])
+	order, ok := listOrder(w, r)
+	if !ok {
+		return
+	}
 
-	cars, err := scraper.GetBrandCars(brandSlug)
+	cars, err := groupedBrandCars(brandSlug, siteFor(r).GetBrandCars)
+	if err != nil && upstreamDown() {
+		if cars, err := groupedBrandCars(brandSlug, storedBrandCars); err == nil && len(cars) > 0 {
+			sortCars(cars, order)
+			if wantsRemoved(r) {
+				if cars, err = appendGroupRemoved(cars, brandSlug); err != nil {
+					respond(w, r, http.StatusInternalServerError, APIResponse{
+						Success: false,
+						Error:   err.Error(),
+					})
+					return
+				}
+			}
+			writeBrandCars(w, r, brandSlug, cars, true)
+			return
+		}
+		writeUpstreamUnavailable(w, r)
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(APIResponse{
+		respond(w, r, http.StatusInternalServerError, APIResponse{
 			Success: false,
 			Error:   err.Error(),
 		})
 		return
 	}
+	sortCars(cars, order)
+	if wantsRemoved(r) {
+		if cars, err = appendGroupRemoved(cars, brandSlug); err != nil {
+			respond(w, r, http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+
+	writeBrandCars(w, r, brandSlug, cars, false)
+}
+
+// writeBrandCars writes a brand's cars, with their details inline when the
+// request asks for them.
+func writeBrandCars(w http.ResponseWriter, r *http.Request, brandSlug string, cars []Car, stale bool) {
+	annotateCars(cars)
+	if !wantsDetails(r) {
+		respond(w, r, http.StatusOK, brandCarsResponse(r, brandSlug, cars, stale))
+		return
+	}
+	if !checkExpandable(w, r, len(cars)) {
+		return
+	}
+	respond(w, r, http.StatusOK, brandCarsResponse(r, brandSlug, expandDetails(siteFor(r), cars), stale))
+}
 
-	json.NewEncoder(w).Encode(BrandResponse{
+// brandCarsResponse is the body of a brand's cars, with or without their
+// details, in the envelope of the surface r was made to.
+func brandCarsResponse[T Car | CarWithDetails](r *http.Request, brandSlug string, cars []T, stale bool) interface{} {
+	if isV2(r) {
+		return v2List(cars, V2Meta{Brand: brandSlug, Stale: stale})
+	}
+	return BrandResponse{
 		Success: true,
 		Brand:   brandSlug,
 		Count:   len(cars),
 		Data:    cars,
-	})
+		Stale:   stale,
+	}
 }
 
+// getAllCarsHandler streams the car list, since it can run to thousands of
+// cars; see streamCars for the response layout.
 func getAllCarsHandler(w http.ResponseWriter, r *http.Request) {
-	cars, err := scraper.GetAllCars()
+	filter, ok := listFilter(w, r)
+	if !ok {
+		return
+	}
+	order, ok := listOrder(w, r)
+	if !ok {
+		return
+	}
+
+	site := siteFor(r)
+	produce := func(ctx context.Context, out chan<- Car) error {
+		cars, err := site.GetAllCars()
+		if err != nil {
+			return err
+		}
+		for _, car := range cars {
+			select {
+			case out <- car:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+	if streamer, ok := site.(CarStreamer); ok {
+		produce = streamer.StreamAllCars
+	}
+	stored := dataset.StreamCars
+	produce, stored = sortedProduce(order, produce), sortedProduce(order, stored)
+	if wantsRemoved(r) {
+		produce, stored = withRemoved(produce), withRemoved(stored)
+	}
+	if wantsDetails(r) {
+		writeExpandedCars(w, r, site, filter.Produce(produce), filter.Produce(stored))
+		return
+	}
+
+	started, err := streamCars(w, r, false, filter.Produce(produce))
+	if started || err == nil {
+		return
+	}
+
+	if upstreamDown() {
+		if hasStoredCars() {
+			streamCars(w, r, true, filter.Produce(stored))
+			return
+		}
+		writeUpstreamUnavailable(w, r)
+		return
+	}
+
+	respond(w, r, http.StatusInternalServerError, APIResponse{
+		Success: false,
+		Error:   err.Error(),
+	})
+}
+
+func getCarDetailsHandler(w http.ResponseWriter, r *http.Request) {
+	writeCarDetails(w, r, mux.Vars(r)["car_slug"])
+}
+
+// getBrandCarHandler serves a car's details under the brand it is listed
+// in, answering 404 if the brand doesn't list it.
+func getBrandCarHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	brandSlug, carSlug := vars["brand_slug"], vars["car_slug"]
+
+	listed, err := brandListsCar(siteFor(r), brandSlug, carSlug)
+	if err != nil && upstreamDown() {
+		writeUpstreamUnavailable(w, r)
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(APIResponse{
+		respond(w, r, http.StatusInternalServerError, APIResponse{
 			Success: false,
 			Error:   err.Error(),
 		})
 		return
 	}
+	if !listed {
+		respond(w, r, http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Car %q is not listed under brand %q", carSlug, brandSlug),
+		})
+		return
+	}
+	writeCarDetails(w, r, carSlug)
+}
 
-	json.NewEncoder(w).Encode(APIResponse{
-		Success: true,
-		Count:   len(cars),
-		Data:    cars,
-	})
+// brandListsCar reports whether the brand's listing includes the car. The
+// search index is checked first, and then the live listing, for cars
+// listed since the last crawl. While upstream is down the stored listing is
+// used instead.
+func brandListsCar(site SiteScraper, brandSlug, carSlug string) (bool, error) {
+	indexed, _ := searchIndex.Cars()
+	for _, car := range indexed {
+		if car.Brand == brandSlug && car.Slug == carSlug {
+			return true, nil
+		}
+	}
+
+	cars, err := site.GetBrandCars(brandSlug)
+	if err != nil && upstreamDown() {
+		if cars, err = storedBrandCars(brandSlug); err == nil && len(cars) == 0 {
+			err = errors.New("no stored listing for this brand")
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, car := range cars {
+		if car.Slug == carSlug {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
-func getCarDetailsHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	carSlug := vars["car_slug"]
+// writeCarDetails writes the details of the car, falling back to the stored
+// details while upstream is down, with its images checked for
+// ?verify_images=true. Browsers asking for HTML get the car's page instead
+// of JSON.
+func writeCarDetails(w http.ResponseWriter, r *http.Request, carSlug string) {
+	html := wantsHTML(r) && !wantsJSONAPI(r) && !isV2(r)
+	if html {
+		w.Header().Add("Vary", "Accept")
+	}
+	if !checkTranslationLang(w, r) {
+		return
+	}
 
-	carDetails, err := scraper.GetCarDetails(carSlug)
+	carDetails, err := siteFor(r).GetCarDetails(carSlug)
+	if err != nil && upstreamDown() {
+		if carDetails, err := dataset.Details(carSlug); err == nil {
+			popularity.View(carSlug, time.Now())
+			carDetails = translateDetails(w, r, carDetails)
+			if html {
+				renderUI(w, http.StatusOK, "car.html", uiPage{Title: carDetails.Name, Data: carDetails, Stale: true})
+				return
+			}
+			respond(w, r, http.StatusOK, carResponse(r, carDetails, true))
+			return
+		}
+		if html {
+			renderUIError(w, http.StatusServiceUnavailable, "The site is unavailable and this car has not been stored")
+			return
+		}
+		writeUpstreamUnavailable(w, r)
+		return
+	}
+	if err != nil && html {
+		renderUIError(w, http.StatusInternalServerError, "The car could not be loaded")
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(APIResponse{
+		respond(w, r, http.StatusInternalServerError, APIResponse{
 			Success: false,
 			Error:   err.Error(),
 		})
 		return
 	}
 
-	json.NewEncoder(w).Encode(CarResponse{
+	if err := dataset.SaveDetails(carDetails); err != nil {
+		log.Printf("dataset: %v", err)
+	}
+	popularity.View(carSlug, time.Now())
+	if wantsVerifiedImages(r) {
+		if checker, ok := siteFor(r).(ImageChecker); ok {
+			carDetails = verifyImages(checker, carDetails)
+		}
+	}
+	carDetails = translateDetails(w, r, carDetails)
+
+	if html {
+		renderUI(w, http.StatusOK, "car.html", uiPage{Title: carDetails.Name, Data: carDetails})
+		return
+	}
+	respond(w, r, http.StatusOK, carResponse(r, carDetails, false))
+}
+
+// carResponse is the body of a car's details, in the envelope of the
+// surface r was made to.
+func carResponse(r *http.Request, details *CarDetails, stale bool) interface{} {
+	if isV2(r) {
+		return newV2Response(details, V2Meta{Stale: stale}, nil)
+	}
+	return CarResponse{
 		Success: true,
-		Data:    carDetails,
-	})
+		Data:    details,
+		Stale:   stale,
+	}
 }
 
 func searchCarsHandler(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query().Get("q")
 	if query == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(APIResponse{
+		respond(w, r, http.StatusBadRequest, APIResponse{
 			Success: false,
 			Error:   "Missing search query parameter \"q\"",
 		})
 		return
 	}
-
-	results, err := scraper.SearchCars(strings.ToLower(query))
+	filter, ok := listFilter(w, r)
+	if !ok {
+		return
+	}
+	limit, offset, err := parseSearchPage(r.URL.Query())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(APIResponse{
+		respond(w, r, http.StatusBadRequest, APIResponse{
 			Success: false,
 			Error:   err.Error(),
 		})
 		return
 	}
 
-	json.NewEncoder(w).Encode(SearchResponse{
-		Success: true,
-		Query:   query,
-		Count:   len(results),
-		Data:    results,
-	})
+	site := siteFor(r)
+	search, searchStored, searchIndexed := site.SearchCars, searchStoredCars, searchIndex.Search
+	highlight := partasala.ParseQuery(query, searchSynonyms, searchFolding).Highlight
+	mode := r.URL.Query().Get("mode")
+	switch mode {
+	case "", "terms":
+	case "regex":
+		pattern, err := compileSearchPattern(query)
+		if err != nil {
+			respond(w, r, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		search = func(string) ([]Car, error) { return regexSearch(site.GetAllCars, pattern) }
+		searchStored = func(string) ([]Car, error) { return regexSearch(dataset.Cars, pattern) }
+		searchIndexed = func(string) ([]Car, error) { return searchIndex.SearchRegex(pattern) }
+		highlight = func(name string) []partasala.Highlight { return regexHighlights(pattern, name) }
+	default:
+		respond(w, r, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "mode must be \"terms\" or \"regex\"",
+		})
+		return
+	}
+
+	// A search engine the dataset is pushed to ranks and forgives typos
+	// better than the local search, which still answers when it fails
+	if searchDelegate != nil && mode != "regex" {
+		results, err := searchDelegate.sink.Search(query)
+		if err == nil {
+			result := searchPage(site, query, mode, filter.Apply(results), highlight, limit, offset, false)
+			result.engine = searchDelegate.engine
+			respond(w, r, http.StatusOK, result.response(r))
+			return
+		}
+		log.Printf("search delegate: %v", err)
+		recent.addError("search delegate", err)
+	}
+
+	// With the crawler keeping the index up to date, searches don't touch
+	// upstream at all
+	if ready, builtAt := searchIndex.Ready(); ready {
+		results, err := searchIndexed(query)
+		if err != nil {
+			respond(w, r, http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		noteScraped(r.Context(), builtAt)
+		result := searchPage(site, query, mode, filter.Apply(results), highlight, limit, offset, false)
+		result.indexedAt = &builtAt
+		respond(w, r, http.StatusOK, result.response(r))
+		return
+	}
+
+	key := searchCacheKey(mode, query)
+	results, stored, cached := searches.get(key)
+	if cached {
+		noteScraped(r.Context(), stored)
+	} else if results, err = search(strings.ToLower(query)); err == nil {
+		searches.put(key, results)
+	}
+	if err != nil && upstreamDown() {
+		// An empty result is a valid answer here, as long as there is a
+		// stored dataset to search
+		if brands, _ := dataset.Brands(); len(brands) > 0 {
+			if results, err := searchStored(query); err == nil {
+				respond(w, r, http.StatusOK, searchPage(site, query, mode, filter.Apply(results), highlight, limit, offset, true).response(r))
+				return
+			}
+		}
+		writeUpstreamUnavailable(w, r)
+		return
+	}
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	respond(w, r, http.StatusOK, searchPage(site, query, mode, filter.Apply(results), highlight, limit, offset, false).response(r))
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	// meilisearchBatchSize is how many documents go into one request.
+	meilisearchBatchSize = 1000
+	// meilisearchSearchLimit is the most hits a delegated search returns,
+	// Meilisearch's default maxTotalHits.
+	meilisearchSearchLimit = 1000
+)
+
+// meilisearchSettings searches names and identifiers and lets brand and
+// year be filtered on; Meilisearch brings typo tolerance and ranking.
+var meilisearchSettings = map[string]interface{}{
+	"searchableAttributes": []string{"name", "make", "model", "plate", "vin", "description"},
+	"filterableAttributes": []string{"brand", "year"},
+	"sortableAttributes":   []string{"year", "price.amount"},
+}
+
+// meilisearchSink fills a scratch index on every sync and swaps it with
+// the configured one, so searches never see a half-filled index. The
+// scratch index, holding the old documents after the swap, is deleted.
+type meilisearchSink struct {
+	*engineClient
+	index string
+}
+
+func (s *meilisearchSink) Sync(docs []SearchDocument) error {
+	scratch := s.index + "_sync"
+
+	// Swapping needs both indexes to exist, and a scratch index left over
+	// from a failed sync is stale
+	if err := s.do("GET", "/indexes/"+url.PathEscape(s.index), "", nil, nil); isEngineNotFound(err) {
+		if err := s.task("POST", "/indexes", map[string]string{"uid": s.index, "primaryKey": "slug"}); err != nil {
+			return fmt.Errorf("meilisearch: create %s: %v", s.index, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("meilisearch: %s: %v", s.index, err)
+	}
+	s.task("DELETE", "/indexes/"+url.PathEscape(scratch), nil)
+
+	if err := s.task("POST", "/indexes", map[string]string{"uid": scratch, "primaryKey": "slug"}); err != nil {
+		return fmt.Errorf("meilisearch: create %s: %v", scratch, err)
+	}
+	if err := s.task("PATCH", "/indexes/"+url.PathEscape(scratch)+"/settings", meilisearchSettings); err != nil {
+		return fmt.Errorf("meilisearch: settings: %v", err)
+	}
+	for start := 0; start < len(docs); start += meilisearchBatchSize {
+		batch := docs[start:min(start+meilisearchBatchSize, len(docs))]
+		if err := s.task("POST", "/indexes/"+url.PathEscape(scratch)+"/documents", rewriteImageURLs(batch)); err != nil {
+			return fmt.Errorf("meilisearch: documents: %v", err)
+		}
+	}
+
+	swap := []interface{}{map[string][]string{"indexes": {s.index, scratch}}}
+	if err := s.task("POST", "/swap-indexes", swap); err != nil {
+		return fmt.Errorf("meilisearch: swap %s: %v", s.index, err)
+	}
+	if err := s.task("DELETE", "/indexes/"+url.PathEscape(scratch), nil); err != nil {
+		return fmt.Errorf("meilisearch: delete %s: %v", scratch, err)
+	}
+	return nil
+}
+
+func (s *meilisearchSink) Search(query string) ([]Car, error) {
+	var result struct {
+		Hits []Car `json:"hits"`
+	}
+	err := s.do("POST", "/indexes/"+url.PathEscape(s.index)+"/search", "application/json",
+		map[string]interface{}{"q": query, "limit": meilisearchSearchLimit}, &result)
+	if err != nil {
+		return nil, fmt.Errorf("meilisearch: %v", err)
+	}
+	return result.Hits, nil
+}
+
+// task sends a request Meilisearch answers with an enqueued task and waits
+// for the task to finish.
+func (s *meilisearchSink) task(method, path string, payload interface{}) error {
+	var enqueued struct {
+		TaskUID int64 `json:"taskUid"`
+	}
+	if err := s.do(method, path, "application/json", payload, &enqueued); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(5 * time.Minute)
+	for {
+		var task struct {
+			Status string `json:"status"`
+			Error  *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := s.do("GET", fmt.Sprintf("/tasks/%d", enqueued.TaskUID), "", nil, &task); err != nil {
+			return err
+		}
+		switch task.Status {
+		case "succeeded":
+			return nil
+		case "failed", "canceled":
+			if task.Error != nil {
+				return fmt.Errorf("task %d %s: %s", enqueued.TaskUID, task.Status, task.Error.Message)
+			}
+			return fmt.Errorf("task %d %s", enqueued.TaskUID, task.Status)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("task %d still %s", enqueued.TaskUID, task.Status)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+// ResponseMeta tells a client where a response's data came from: whether
+// any of it was fetched from upstream for the request, and when the
+// oldest of it was scraped. DataAgeSeconds and ScrapedAt are null when
+// that isn't known, e.g. for stored data from before a restart.
+type ResponseMeta struct {
+	Cached          bool       `json:"cached"`
+	DataAgeSeconds  *int64     `json:"data_age_seconds"`
+	ScrapedAt       *time.Time `json:"scraped_at"`
+	UpstreamFetches int64      `json:"upstream_fetches"`
+}
+
+// responseMeta describes the data used for r so far, or returns nil for a
+// request without a FetchTrace.
+func responseMeta(r *http.Request) *ResponseMeta {
+	trace := partasala.FetchTraceFrom(r.Context())
+	if trace == nil {
+		return nil
+	}
+	meta := &ResponseMeta{UpstreamFetches: trace.Fetches()}
+	meta.Cached = meta.UpstreamFetches == 0
+	if at, ok := trace.ScrapedAt(); ok {
+		at = at.UTC().Truncate(time.Second)
+		age := int64(time.Since(at).Seconds())
+		if age < 0 {
+			age = 0
+		}
+		meta.ScrapedAt = &at
+		meta.DataAgeSeconds = &age
+	}
+	return meta
+}
+
+// The X-Cache header's values: whether a response was answered from the
+// caches alone, needed upstream, or came from the stored dataset because
+// upstream is down.
+const (
+	cacheHit   = "HIT"
+	cacheMiss  = "MISS"
+	cacheStale = "STALE"
+)
+
+// setCacheHeaders sets X-Cache from meta, and Age to its data's age when
+// that is known, so cache behaviour shows with curl -I.
+func setCacheHeaders(w http.ResponseWriter, meta *ResponseMeta, stale bool) {
+	if meta == nil {
+		return
+	}
+	switch {
+	case stale:
+		w.Header().Set("X-Cache", cacheStale)
+	case meta.Cached:
+		w.Header().Set("X-Cache", cacheHit)
+	default:
+		w.Header().Set("X-Cache", cacheMiss)
+	}
+	if meta.DataAgeSeconds != nil {
+		w.Header().Set("Age", strconv.FormatInt(*meta.DataAgeSeconds, 10))
+	}
+}
+
+// noteScraped records that data scraped at at went into the response to
+// the request ctx belongs to, for data the Scraper didn't fetch itself,
+// like cached searches and the search index.
+func noteScraped(ctx context.Context, at time.Time) {
+	if trace := partasala.FetchTraceFrom(ctx); trace != nil && !at.IsZero() {
+		trace.NoteScraped(at)
+	}
+}
+
+// noteStoredScraped records that stored data went into the response. It
+// was scraped by the last crawl at the latest; before one finishes since
+// startup, its age is unknown.
+func noteStoredScraped(ctx context.Context) {
+	if crawler == nil {
+		return
+	}
+	if finished := crawler.Status().LastFinishedAt; finished != nil {
+		noteScraped(ctx, *finished)
+	}
+}
+
+// staleResponse reports whether v, one of the APIResponse-shaped structs
+// or a V2Response, is marked stale: answered from the stored dataset while
+// upstream is down.
+func staleResponse(v interface{}) bool {
+	if envelope, ok := v.(v2Envelope); ok {
+		return envelope.stale()
+	}
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		return false
+	}
+	stale := value.FieldByName("Stale")
+	return stale.IsValid() && stale.Kind() == reflect.Bool && stale.Bool()
+}
+
+// appendMeta adds meta as the last field of body, a JSON object as
+// Encode writes it, or returns body as it is if it's anything else.
+func appendMeta(body []byte, meta *ResponseMeta) []byte {
+	trimmed := bytes.TrimRight(body, " \r\n\t")
+	if meta == nil || !bytes.HasPrefix(trimmed, []byte("{")) || !bytes.HasSuffix(trimmed, []byte("}")) {
+		return body
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return body
+	}
+	out := bytes.TrimRight(trimmed[:len(trimmed)-1], " \r\n\t")
+	out = append([]byte(nil), out...)
+	if !bytes.HasSuffix(out, []byte("{")) {
+		out = append(out, ',')
+	}
+	out = append(out, `"meta":`...)
+	return append(append(append(out, data...), '}'), '\n')
+}
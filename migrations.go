@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+)
+
+const (
+	bucketMeta       = "meta"
+	keySchemaVersion = "schema_version"
+)
+
+// Migration upgrades stored records from Version-1 to Version. Up must be
+// safe to run again if it was interrupted, since the new version is only
+// recorded after it returns.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(store Store) error
+}
+
+// migrations lists every schema change in order. To change how a stored
+// record is shaped, append a migration with the next version that rewrites
+// the existing keys; never edit or remove one that has been released.
+var migrations = []Migration{}
+
+func latestSchemaVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the version recorded in store, 0 for stores created
+// before migrations existed.
+func SchemaVersion(store Store) (int, error) {
+	data, err := store.Get(bucketMeta, keySchemaVersion)
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	version, err := strconv.Atoi(string(data))
+	if err != nil {
+		return 0, fmt.Errorf("invalid schema version %q: %v", data, err)
+	}
+	return version, nil
+}
+
+// Migrate runs every migration newer than the store's schema version. It
+// refuses stores written by a newer build, which older code could corrupt.
+func Migrate(store Store) error {
+	current, err := SchemaVersion(store)
+	if err != nil {
+		return err
+	}
+	if latest := latestSchemaVersion(); current > latest {
+		return fmt.Errorf("store schema version %d is newer than this build supports (%d)", current, latest)
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		log.Printf("Migrating store to schema version %d: %s", m.Version, m.Description)
+		if err := m.Up(store); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %v", m.Version, m.Description, err)
+		}
+		if err := store.Put(bucketMeta, keySchemaVersion, []byte(strconv.Itoa(m.Version))); err != nil {
+			return err
+		}
+		current = m.Version
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+//go:embed fixtures/mock.json
+var mockFixtures []byte
+
+func init() {
+	RegisterSite("mock", func(config partasala.Config) SiteScraper {
+		m := NewMockScraper()
+		m.synonyms = config.SearchSynonyms
+		m.folding = config.SearchFolding
+		return m
+	})
+}
+
+// MockScraper answers from the embedded fixtures, a dataset snapshot of a
+// few brands and their cars plus the yard's contact details, without
+// touching the network. It is the "mock"
+// site, selected by -mock, for working against the API offline and for
+// deterministic integration tests.
+type MockScraper struct {
+	snapshot DatasetSnapshot
+	details  map[string]CarDetails
+	contact  Contact
+	synonyms map[string]string
+	folding  string
+}
+
+func NewMockScraper() *MockScraper {
+	m := &MockScraper{details: make(map[string]CarDetails)}
+	var fixtures struct {
+		DatasetSnapshot
+		Contact Contact `json:"contact"`
+	}
+	err := json.Unmarshal(mockFixtures, &fixtures)
+	m.snapshot, m.contact = fixtures.DatasetSnapshot, fixtures.Contact
+	if err != nil {
+		// The fixtures are compiled in, so this is a programming error
+		panic(fmt.Sprintf("mock: invalid fixtures: %v", err))
+	}
+	for _, details := range m.snapshot.Details {
+		m.details[details.Slug] = details
+	}
+	return m
+}
+
+func (m *MockScraper) GetBrands() ([]Brand, error) {
+	return append([]Brand{}, m.snapshot.Brands...), nil
+}
+
+func (m *MockScraper) GetBrandCars(brandSlug string) ([]Car, error) {
+	found := false
+	for _, brand := range m.snapshot.Brands {
+		if brand.Slug == brandSlug {
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("status code error: 404 brand %q not in mock fixtures", brandSlug)
+	}
+
+	cars := []Car{}
+	for _, car := range m.snapshot.Cars {
+		if car.Brand == brandSlug {
+			cars = append(cars, car)
+		}
+	}
+	return cars, nil
+}
+
+func (m *MockScraper) GetCarDetails(carSlug string) (*CarDetails, error) {
+	details, ok := m.details[carSlug]
+	if !ok {
+		return nil, fmt.Errorf("status code error: 404 car %q not in mock fixtures", carSlug)
+	}
+	details.Images = append([]Image{}, details.Images...)
+	return &details, nil
+}
+
+func (m *MockScraper) GetAllCars() ([]Car, error) {
+	return append([]Car{}, m.snapshot.Cars...), nil
+}
+
+func (m *MockScraper) GetContact() (*Contact, error) {
+	contact := m.contact
+	contact.Phones = append([]string{}, contact.Phones...)
+	contact.OpeningHours = append([]string{}, contact.OpeningHours...)
+	return &contact, nil
+}
+
+// SearchCars matches brands and car names like the partasala scraper does.
+func (m *MockScraper) SearchCars(query string) ([]Car, error) {
+	q := partasala.ParseQuery(query, m.synonyms, m.folding)
+	brandNames := map[string]string{}
+	for _, brand := range m.snapshot.Brands {
+		brandNames[brand.Slug] = brand.Name
+	}
+
+	results := []Car{}
+	for _, car := range m.snapshot.Cars {
+		if car.MatchType = q.MatchCar(brandNames[car.Brand], car); car.MatchType != "" {
+			results = append(results, car)
+		}
+	}
+	return results, nil
+}
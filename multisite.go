@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+	"github.com/gorilla/mux"
+)
+
+// SiteConfig is a further salvage yard served under /sites/<name>/, next to
+// the main site. Site is its adapter, "partasala" unless set, and Scraper
+// its settings, starting from the scraper defaults.
+type SiteConfig struct {
+	Site    string           `json:"site"`
+	Scraper partasala.Config `json:"scraper"`
+}
+
+func (c *SiteConfig) UnmarshalJSON(data []byte) error {
+	type plain SiteConfig
+	config := plain{Site: "partasala", Scraper: partasala.DefaultConfig()}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+	*c = SiteConfig(config)
+	return nil
+}
+
+var siteName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// errPlateMainSiteOnly refuses plate filters outside the main site, as
+// plates are read from stored details.
+var errPlateMainSiteOnly = errors.New("plate is only known for the main site's stored cars")
+
+// hostedSites are the scrapers of the sites configured under sites, by
+// name. The main site isn't among them: primarySite names it, and its
+// routes under /sites are served like the top-level ones.
+var (
+	hostedSites map[string]SiteScraper
+	hostedInfo  map[string]SiteInfo
+	primarySite string
+)
+
+// SiteInfo describes a site served by this instance.
+type SiteInfo struct {
+	Name    string `json:"name"`
+	Adapter string `json:"adapter"`
+	BaseURL string `json:"base_url"`
+	// Primary marks the main site, the one the top-level routes serve and
+	// the crawler, store, and search index cover
+	Primary bool `json:"primary"`
+}
+
+// loadHostedSites creates the scrapers of sites. With mock set, every site
+// serves the sample data.
+func loadHostedSites(primary string, primaryConfig partasala.Config, sites map[string]SiteConfig, mock bool) error {
+	primarySite = primary
+	hostedSites = map[string]SiteScraper{}
+	hostedInfo = map[string]SiteInfo{
+		primary: {Name: primary, Adapter: primary, BaseURL: primaryConfig.BaseURL, Primary: true},
+	}
+	for name, config := range sites {
+		if name == primary {
+			return fmt.Errorf("sites.%s: the main site is served under that name", name)
+		}
+		adapter := config.Site
+		if mock {
+			adapter = "mock"
+		}
+		site, err := NewSiteScraper(adapter, config.Scraper)
+		if err != nil {
+			return fmt.Errorf("sites.%s: %v", name, err)
+		}
+		hostedSites[name] = site
+		hostedInfo[name] = SiteInfo{Name: name, Adapter: adapter, BaseURL: config.Scraper.BaseURL}
+	}
+	return nil
+}
+
+// listSitesHandler serves GET /sites: the main site and every configured
+// one, by name.
+func listSitesHandler(w http.ResponseWriter, r *http.Request) {
+	sites := make([]SiteInfo, 0, len(hostedInfo))
+	for _, info := range hostedInfo {
+		sites = append(sites, info)
+	}
+	sort.Slice(sites, func(i, j int) bool { return sites[i].Name < sites[j].Name })
+	respond(w, r, http.StatusOK, APIResponse{
+		Success: true,
+		Count:   len(sites),
+		Data:    sites,
+	})
+}
+
+// hostedSite serves a /sites/{site} route: with primary for the main site,
+// and with live, which scrapes the site on every request, for the others.
+// Unknown sites answer 404.
+func hostedSite(primary http.HandlerFunc, live func(w http.ResponseWriter, r *http.Request, site SiteScraper)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["site"]
+		if name == primarySite {
+			primary(w, r)
+			return
+		}
+		site, ok := hostedSites[name]
+		if !ok {
+			respond(w, r, http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   fmt.Sprintf("Unknown site %q", name),
+			})
+			return
+		}
+		if isHead(r) {
+			// Nothing is stored for the site to answer from without
+			// scraping it
+			w.Header().Set("Allow", "GET")
+			respond(w, r, http.StatusMethodNotAllowed, APIResponse{
+				Success: false,
+				Error:   errNotScraped.Error(),
+			})
+			return
+		}
+		live(w, r, bindSite(site, r))
+	}
+}
+
+// writeSiteError answers 500 for a hosted site that couldn't be scraped.
+// Unlike the main site's, its pages aren't stored to fall back on.
+func writeSiteError(w http.ResponseWriter, r *http.Request, err error) {
+	respond(w, r, http.StatusInternalServerError, APIResponse{
+		Success: false,
+		Error:   err.Error(),
+	})
+}
+
+func siteBrandsHandler(w http.ResponseWriter, r *http.Request, site SiteScraper) {
+	brands, err := site.GetBrands()
+	if err != nil {
+		writeSiteError(w, r, err)
+		return
+	}
+	respond(w, r, http.StatusOK, APIResponse{
+		Success: true,
+		Count:   len(brands),
+		Data:    brands,
+	})
+}
+
+func siteBrandCarsHandler(w http.ResponseWriter, r *http.Request, site SiteScraper) {
+	brandSlug := mux.Vars(r)["brand_slug"]
+	cars, err := site.GetBrandCars(brandSlug)
+	if err != nil {
+		writeSiteError(w, r, err)
+		return
+	}
+	respond(w, r, http.StatusOK, BrandResponse{
+		Success: true,
+		Brand:   brandSlug,
+		Count:   len(cars),
+		Data:    cars,
+	})
+}
+
+func siteCarsHandler(w http.ResponseWriter, r *http.Request, site SiteScraper) {
+	filter, err := parseListFilter(r.URL.Query())
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	if filter.Plate != "" {
+		respond(w, r, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   errPlateMainSiteOnly.Error(),
+		})
+		return
+	}
+
+	all, err := site.GetAllCars()
+	if err != nil {
+		writeSiteError(w, r, err)
+		return
+	}
+	cars := []Car{}
+	for _, car := range all {
+		if filter.Matches(car) {
+			cars = append(cars, car)
+		}
+	}
+	respond(w, r, http.StatusOK, APIResponse{
+		Success: true,
+		Count:   len(cars),
+		Data:    cars,
+	})
+}
+
+func siteCarDetailsHandler(w http.ResponseWriter, r *http.Request, site SiteScraper) {
+	details, err := site.GetCarDetails(mux.Vars(r)["car_slug"])
+	if err != nil {
+		writeSiteError(w, r, err)
+		return
+	}
+	respond(w, r, http.StatusOK, CarResponse{
+		Success: true,
+		Data:    details,
+	})
+}
+
+func siteSearchHandler(w http.ResponseWriter, r *http.Request, site SiteScraper) {
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		respond(w, r, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Missing search query parameter \"q\"",
+		})
+		return
+	}
+	cars, err := site.SearchCars(query)
+	if err != nil {
+		writeSiteError(w, r, err)
+		return
+	}
+	respond(w, r, http.StatusOK, SearchResponse{
+		Success: true,
+		Query:   query,
+		Count:   len(cars),
+		Total:   len(cars),
+		Limit:   len(cars),
+		Data:    cars,
+	})
+}
+
+func siteContactHandler(w http.ResponseWriter, r *http.Request, site SiteScraper) {
+	provider, ok := site.(ContactProvider)
+	if !ok {
+		respond(w, r, http.StatusNotImplemented, APIResponse{
+			Success: false,
+			Error:   "This site has no contact page to read",
+		})
+		return
+	}
+	contact, err := provider.GetContact()
+	if err != nil {
+		writeSiteError(w, r, err)
+		return
+	}
+	respond(w, r, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    contact,
+	})
+}
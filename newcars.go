@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Bounds of the days parameter of /cars/new.
+const (
+	defaultNewCarsDays = 7
+	maxNewCarsDays     = 90
+)
+
+// arrivedAt returns when car came into the yard, as far as is known: when
+// the yard posted it, or failing that when a crawl first listed it. The
+// posting date comes first so the cars a first crawl lists all at once
+// don't all count as new.
+func arrivedAt(car Car) *time.Time {
+	if car.PostedAt != nil {
+		return car.PostedAt
+	}
+	return car.FirstSeen
+}
+
+// newCars returns the stored cars that arrived since since and match
+// filter, newest first.
+func newCars(since time.Time, filter ListFilter) ([]Car, error) {
+	stored, err := dataset.Cars()
+	if err != nil {
+		return nil, err
+	}
+	annotateCars(stored)
+
+	cars := []Car{}
+	for _, car := range stored {
+		if arrived := arrivedAt(car); arrived != nil && !arrived.Before(since) && filter.Matches(car) {
+			cars = append(cars, car)
+		}
+	}
+	sortNewestFirst(cars, arrivedAt)
+	return cars, nil
+}
+
+// getNewCarsHandler lists the cars that arrived in the last ?days days,
+// from the store, so it costs no upstream requests.
+func getNewCarsHandler(w http.ResponseWriter, r *http.Request) {
+	days := defaultNewCarsDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxNewCarsDays {
+			respond(w, r, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   fmt.Sprintf("days must be between 1 and %d", maxNewCarsDays),
+			})
+			return
+		}
+		days = n
+	}
+	filter, ok := listFilter(w, r)
+	if !ok {
+		return
+	}
+
+	cars, err := newCars(time.Now().AddDate(0, 0, -days), filter)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	respond(w, r, http.StatusOK, APIResponse{
+		Success: true,
+		Count:   len(cars),
+		Data:    cars,
+	})
+}
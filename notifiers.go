@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"sync"
+	"time"
+)
+
+const (
+	EventCarAdded    = "car_added"
+	EventCarRemoved  = "car_removed"
+	EventCrawlFailed = "crawl_failed"
+
+	EventStructureChanged = "structure_changed"
+
+	EventUpstreamDown      = "upstream_down"
+	EventUpstreamRecovered = "upstream_recovered"
+)
+
+// NotifierEvent is a crawler change delivered to notifiers. Car is set for
+// car events and Error for crawl failures, structure changes, and upstream
+// alerts.
+type NotifierEvent struct {
+	Type  string
+	Car   Car
+	Error string
+}
+
+// Notifier posts crawler events to an external chat service.
+type Notifier interface {
+	Notify(event NotifierEvent) error
+}
+
+// Notifiers fans an event out to several notifiers, logging failures.
+type Notifiers []Notifier
+
+func (ns Notifiers) Notify(event NotifierEvent) {
+	for _, notifier := range ns {
+		if err := notifier.Notify(event); err != nil {
+			log.Printf("notifier: %v", err)
+			recent.addError("notifier", err)
+		}
+	}
+}
+
+// NewNotifiers builds the notifiers described by configs.
+func NewNotifiers(configs []NotifierConfig) (Notifiers, error) {
+	notifiers := Notifiers{}
+	for _, config := range configs {
+		notifier, err := NewNotifier(config)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, notifier)
+	}
+	return notifiers, nil
+}
+
+// NotifierSet holds the configured notifiers, which a config reload can
+// replace while events are being sent.
+type NotifierSet struct {
+	mu        sync.RWMutex
+	notifiers Notifiers
+}
+
+func (s *NotifierSet) Set(notifiers Notifiers) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notifiers = notifiers
+}
+
+func (s *NotifierSet) Notify(event NotifierEvent) {
+	s.mu.RLock()
+	notifiers := s.notifiers
+	s.mu.RUnlock()
+	notifiers.Notify(event)
+}
+
+// NewNotifier builds the notifier described by config.
+func NewNotifier(config NotifierConfig) (Notifier, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	var notifier Notifier
+	switch config.Type {
+	case "telegram":
+		if config.BotToken == "" || config.ChatID == "" {
+			return nil, fmt.Errorf("telegram notifier requires bot_token and chat_id")
+		}
+		notifier = &telegramNotifier{client: client, token: config.BotToken, chatID: config.ChatID}
+	case "discord":
+		if config.BotToken == "" || config.ChannelID == "" {
+			return nil, fmt.Errorf("discord notifier requires bot_token and channel_id")
+		}
+		notifier = &discordNotifier{client: client, token: config.BotToken, channelID: config.ChannelID}
+	case "slack":
+		if config.WebhookURL == "" {
+			return nil, fmt.Errorf("slack notifier requires webhook_url")
+		}
+		notifier = &slackNotifier{client: client, webhookURL: config.WebhookURL}
+	default:
+		return nil, fmt.Errorf("unknown notifier type %q", config.Type)
+	}
+
+	events := map[string]bool{}
+	for _, event := range config.Events {
+		switch event {
+		case EventCarAdded, EventCarRemoved, EventCrawlFailed, EventStructureChanged, EventUpstreamDown, EventUpstreamRecovered:
+			events[event] = true
+		default:
+			return nil, fmt.Errorf("unknown notifier event %q", event)
+		}
+	}
+	if len(events) == 0 {
+		events[EventCarAdded] = true
+	}
+
+	return &filteredNotifier{name: config.Type, next: notifier, events: events, filters: config.Filters}, nil
+}
+
+// filteredNotifier drops events of types it is not subscribed to, and car
+// events for cars that match none of its filters. With no filters every car
+// is passed on. Events it passes on are recorded for the admin dashboard.
+type filteredNotifier struct {
+	name    string
+	next    Notifier
+	events  map[string]bool
+	filters []CarFilter
+}
+
+func (n *filteredNotifier) Notify(event NotifierEvent) error {
+	if !n.events[event.Type] {
+		return nil
+	}
+
+	if (event.Type == EventCarAdded || event.Type == EventCarRemoved) && len(n.filters) > 0 {
+		matched := false
+		for _, filter := range n.filters {
+			if filter.Matches(event.Car) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil
+		}
+	}
+
+	err := n.next.Notify(event)
+	delivery := Delivery{Notifier: n.name, Event: event.Type}
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	recent.addDelivery(delivery)
+	return err
+}
+
+type telegramNotifier struct {
+	client *http.Client
+	token  string
+	chatID string
+}
+
+func (n *telegramNotifier) Notify(event NotifierEvent) error {
+	if event.Type != EventCarAdded {
+		return nil
+	}
+
+	text := fmt.Sprintf("Nýr bíll á partasala.is:\n<a href=\"%s\">%s</a>",
+		html.EscapeString(event.Car.URL), html.EscapeString(event.Car.Name))
+
+	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", n.token)
+	err := postJSON(n.client, url, nil, map[string]interface{}{
+		"chat_id":    n.chatID,
+		"text":       text,
+		"parse_mode": "HTML",
+	})
+	if err != nil {
+		return fmt.Errorf("telegram: %v", err)
+	}
+	return nil
+}
+
+type discordNotifier struct {
+	client    *http.Client
+	token     string
+	channelID string
+}
+
+func (n *discordNotifier) Notify(event NotifierEvent) error {
+	if event.Type != EventCarAdded {
+		return nil
+	}
+
+	embed := map[string]interface{}{
+		"title":       event.Car.Name,
+		"url":         event.Car.URL,
+		"description": "Nýr bíll á partasala.is",
+	}
+	if event.Car.Thumbnail != nil {
+		embed["thumbnail"] = map[string]string{"url": *event.Car.Thumbnail}
+	}
+
+	url := fmt.Sprintf("https://discord.com/api/v10/channels/%s/messages", n.channelID)
+	headers := map[string]string{"Authorization": "Bot " + n.token}
+	err := postJSON(n.client, url, headers, map[string]interface{}{
+		"embeds": []interface{}{embed},
+	})
+	if err != nil {
+		return fmt.Errorf("discord: %v", err)
+	}
+	return nil
+}
+
+type slackNotifier struct {
+	client     *http.Client
+	webhookURL string
+}
+
+func (n *slackNotifier) Notify(event NotifierEvent) error {
+	var attachment map[string]interface{}
+	switch event.Type {
+	case EventCarAdded:
+		attachment = map[string]interface{}{
+			"color":      "good",
+			"pretext":    "Nýr bíll á partasala.is",
+			"title":      event.Car.Name,
+			"title_link": event.Car.URL,
+			"fields": []map[string]interface{}{
+				{"title": "Brand", "value": event.Car.Brand, "short": true},
+			},
+		}
+		if event.Car.Thumbnail != nil {
+			attachment["thumb_url"] = *event.Car.Thumbnail
+		}
+	case EventCarRemoved:
+		attachment = map[string]interface{}{
+			"color":      "warning",
+			"pretext":    "Bíll fjarlægður af partasala.is",
+			"title":      event.Car.Name,
+			"title_link": event.Car.URL,
+		}
+	case EventCrawlFailed:
+		attachment = map[string]interface{}{
+			"color": "danger",
+			"title": "Crawl failed",
+			"text":  event.Error,
+		}
+	case EventStructureChanged:
+		attachment = map[string]interface{}{
+			"color": "danger",
+			"title": "Probable markup change on the site",
+			"text":  event.Error,
+		}
+	case EventUpstreamDown:
+		attachment = map[string]interface{}{
+			"color": "danger",
+			"title": "partasala.is is failing",
+			"text":  event.Error,
+		}
+	case EventUpstreamRecovered:
+		attachment = map[string]interface{}{
+			"color": "good",
+			"title": "partasala.is has recovered",
+			"text":  event.Error,
+		}
+	default:
+		return nil
+	}
+	attachment["fallback"] = fmt.Sprintf("%s: %s%s", event.Type, event.Car.Name, event.Error)
+
+	err := postJSON(n.client, n.webhookURL, nil, map[string]interface{}{
+		"attachments": []interface{}{attachment},
+	})
+	if err != nil {
+		return fmt.Errorf("slack: %v", err)
+	}
+	return nil
+}
+
+func postJSON(client *http.Client, url string, headers map[string]string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// Drop the *url.Error wrapper; the URL may embed a secret
+		if urlErr, ok := err.(*neturl.Error); ok {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status code error: %d %s", resp.StatusCode, resp.Status)
+	}
+	return nil
+}
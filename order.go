@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The orders /brands/<brand_slug> and /cars can list cars in.
+const (
+	// OrderName sorts by name, then slug, so pages stay put between crawls
+	OrderName = "name"
+	// OrderFirstSeen puts the cars the crawler listed most recently first
+	OrderFirstSeen = "first_seen"
+	// OrderPosted puts the cars the yard posted most recently first, as
+	// far as enrichment has read their posting dates
+	OrderPosted = "posted"
+	// OrderSite keeps the order of the site's pages
+	OrderSite = "site"
+)
+
+// carOrder is the order of car lists that don't ask for one, set from the
+// car_order config option.
+var carOrder = OrderName
+
+func validCarOrder(order string) bool {
+	return order == OrderName || order == OrderFirstSeen || order == OrderPosted || order == OrderSite
+}
+
+// listOrder returns the order a list request asked for with ?sort, or
+// carOrder. An unknown order answers 400 and returns false.
+func listOrder(w http.ResponseWriter, r *http.Request) (string, bool) {
+	order := r.URL.Query().Get("sort")
+	if order == "" {
+		return carOrder, true
+	}
+	if !validCarOrder(order) {
+		respond(w, r, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Invalid sort %q; use %s, %s, %s, or %s", order, OrderName, OrderFirstSeen, OrderPosted, OrderSite),
+		})
+		return "", false
+	}
+	return order, true
+}
+
+// sortCars puts cars in order. Under OrderFirstSeen and OrderPosted, cars
+// without the time sorted by come last.
+func sortCars(cars []Car, order string) {
+	switch order {
+	case OrderSite:
+		return
+	case OrderFirstSeen:
+		dataset.AddSightings(cars)
+		sortNewestFirst(cars, func(car Car) *time.Time { return car.FirstSeen })
+	case OrderPosted:
+		dataset.AddEnrichment(cars)
+		sortNewestFirst(cars, func(car Car) *time.Time { return car.PostedAt })
+	default:
+		sortNewestFirst(cars, nil)
+	}
+}
+
+// sortNewestFirst sorts cars by the time key returns, newest first, with
+// cars it returns nil for last, and ties broken by name and slug. With a
+// nil key cars are sorted by name and slug alone.
+func sortNewestFirst(cars []Car, key func(car Car) *time.Time) {
+	sort.SliceStable(cars, func(i, j int) bool {
+		a, b := cars[i], cars[j]
+		if key != nil {
+			timeA, timeB := key(a), key(b)
+			if (timeA == nil) != (timeB == nil) {
+				return timeA != nil
+			}
+			if timeA != nil && !timeA.Equal(*timeB) {
+				return timeA.After(*timeB)
+			}
+		}
+		if nameA, nameB := strings.ToLower(a.Name), strings.ToLower(b.Name); nameA != nameB {
+			return nameA < nameB
+		}
+		return a.Slug < b.Slug
+	})
+}
+
+// sortedProduce sends what produce sends in order. Unless the order is
+// OrderSite, that means waiting for produce to finish: cars sent before
+// it fails are still sent, sorted, before its error is returned.
+func sortedProduce(order string, produce func(context.Context, chan<- Car) error) func(context.Context, chan<- Car) error {
+	if order == OrderSite {
+		return produce
+	}
+	return func(ctx context.Context, out chan<- Car) error {
+		cars, err := collectCars(ctx, produce)
+		sortCars(cars, order)
+		for _, car := range cars {
+			select {
+			case out <- car:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return err
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// The Parquet export flattens a snapshot into three tables, joined on the
+// car and brand slugs. Nullable columns are pointers.
+type parquetBrand struct {
+	Slug string `parquet:"slug"`
+	Name string `parquet:"name"`
+	URL  string `parquet:"url"`
+}
+
+// parquetCar is a listed car together with its stored details, whose
+// columns are null when the details haven't been stored.
+type parquetCar struct {
+	Slug          string  `parquet:"slug"`
+	Name          string  `parquet:"name"`
+	URL           string  `parquet:"url"`
+	Thumbnail     *string `parquet:"thumbnail,optional"`
+	Brand         *string `parquet:"brand,optional"`
+	Make          *string `parquet:"make,optional"`
+	Model         *string `parquet:"model,optional"`
+	Year          *int32  `parquet:"year,optional"`
+	PriceAmount   *int64  `parquet:"price_amount,optional"`
+	PriceCurrency *string `parquet:"price_currency,optional"`
+	Description   *string `parquet:"description,optional"`
+	Plate         *string `parquet:"plate,optional"`
+	VIN           *string `parquet:"vin,optional"`
+	ImageCount    *int32  `parquet:"image_count,optional"`
+}
+
+// parquetImage is one image of a car's stored details; Position counts from
+// 1 in gallery order.
+type parquetImage struct {
+	CarSlug   string  `parquet:"car_slug"`
+	Position  int32   `parquet:"position"`
+	URL       string  `parquet:"url"`
+	Thumbnail string  `parquet:"thumbnail"`
+	Width     *int32  `parquet:"width,optional"`
+	Height    *int32  `parquet:"height,optional"`
+	Bytes     *int64  `parquet:"bytes,optional"`
+	Format    *string `parquet:"format,optional"`
+}
+
+// WriteParquet writes snapshot to brands.parquet, cars.parquet, and
+// images.parquet in dir, creating it if needed.
+func WriteParquet(snapshot *DatasetSnapshot, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	brands := make([]parquetBrand, 0, len(snapshot.Brands))
+	for _, brand := range snapshot.Brands {
+		brands = append(brands, parquetBrand{Slug: brand.Slug, Name: brand.Name, URL: brand.URL})
+	}
+
+	details := make(map[string]CarDetails, len(snapshot.Details))
+	images := []parquetImage{}
+	for _, d := range snapshot.Details {
+		details[d.Slug] = d
+		for i, image := range d.Images {
+			images = append(images, parquetImage{
+				CarSlug:   d.Slug,
+				Position:  int32(i + 1),
+				URL:       image.URL,
+				Thumbnail: image.Thumbnail,
+				Width:     int32Ptr(image.Width),
+				Height:    int32Ptr(image.Height),
+				Bytes:     image.Bytes,
+				Format:    stringPtr(image.Format),
+			})
+		}
+	}
+
+	cars := make([]parquetCar, 0, len(snapshot.Cars))
+	for _, car := range snapshot.Cars {
+		row := parquetCar{
+			Slug:      car.Slug,
+			Name:      car.Name,
+			URL:       car.URL,
+			Thumbnail: car.Thumbnail,
+			Brand:     stringPtr(car.Brand),
+			Make:      car.Make,
+			Model:     car.Model,
+			Year:      int32Ptr(car.Year),
+		}
+		if car.Price != nil {
+			row.PriceAmount, row.PriceCurrency = &car.Price.Amount, &car.Price.Currency
+		}
+		if d, ok := details[car.Slug]; ok {
+			count := int32(d.ImageCount)
+			row.Description, row.Plate, row.VIN, row.ImageCount = d.Description, d.Plate, d.VIN, &count
+		}
+		cars = append(cars, row)
+	}
+
+	options := []parquet.WriterOption{parquet.Compression(&parquet.Zstd)}
+	if err := parquet.WriteFile(filepath.Join(dir, "brands.parquet"), brands, options...); err != nil {
+		return err
+	}
+	if err := parquet.WriteFile(filepath.Join(dir, "cars.parquet"), cars, options...); err != nil {
+		return err
+	}
+	return parquet.WriteFile(filepath.Join(dir, "images.parquet"), images, options...)
+}
+
+// stringPtr returns nil for an empty string.
+func stringPtr(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func int32Ptr(n *int) *int32 {
+	if n == nil {
+		return nil
+	}
+	v := int32(*n)
+	return &v
+}
@@ -0,0 +1,219 @@
+// Package client is a Go client for the partasala scraper API. Its methods
+// mirror the REST endpoints and return the same types the scraper produces.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+// APIError is returned when the API answers with an error status.
+// RetryAfter is how long the response's Retry-After header asked to wait,
+// as the server's 429 does once a key's daily quota is used up.
+type APIError struct {
+	StatusCode int
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("api: status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("api: status %d: %s", e.StatusCode, e.Message)
+}
+
+// Client calls the API at BaseURL. Requests that fail with a network error,
+// 429, 502, 503, or 504 are retried up to Retries times, waiting Backoff
+// and then twice as long each time, or as long as the response's
+// Retry-After says. A wait that would outlast the context's deadline isn't
+// started; the error is returned at once instead. The fields may be changed
+// before the first request.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	Retries    int
+	Backoff    time.Duration
+}
+
+// New creates a Client for the API at baseURL, e.g.
+// "http://kristofer.is:1667", that retries three times.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 5 * time.Minute},
+		Retries:    3,
+		Backoff:    500 * time.Millisecond,
+	}
+}
+
+// Brands returns every brand on the site.
+func (c *Client) Brands(ctx context.Context) ([]partasala.Brand, error) {
+	var brands []partasala.Brand
+	err := c.get(ctx, "/brands", &brands)
+	return brands, err
+}
+
+// BrandCars returns the cars listed under brandSlug.
+func (c *Client) BrandCars(ctx context.Context, brandSlug string) ([]partasala.Car, error) {
+	var cars []partasala.Car
+	err := c.get(ctx, "/brands/"+url.PathEscape(brandSlug), &cars)
+	return cars, err
+}
+
+// Categories returns the categories of the site's other taxonomies, such
+// as vehicle types, that the server is configured to read.
+func (c *Client) Categories(ctx context.Context) ([]partasala.Category, error) {
+	var categories []partasala.Category
+	err := c.get(ctx, "/categories", &categories)
+	return categories, err
+}
+
+// CategoryCars returns the cars listed under the category slug.
+func (c *Client) CategoryCars(ctx context.Context, slug string) ([]partasala.Car, error) {
+	var cars []partasala.Car
+	err := c.get(ctx, "/categories/"+url.PathEscape(slug), &cars)
+	return cars, err
+}
+
+// AllCars returns every car on the site.
+func (c *Client) AllCars(ctx context.Context) ([]partasala.Car, error) {
+	var cars []partasala.Car
+	err := c.get(ctx, "/cars", &cars)
+	return cars, err
+}
+
+// CarDetails returns the details and images of the car carSlug.
+func (c *Client) CarDetails(ctx context.Context, carSlug string) (*partasala.CarDetails, error) {
+	var details partasala.CarDetails
+	if err := c.get(ctx, "/cars/"+url.PathEscape(carSlug), &details); err != nil {
+		return nil, err
+	}
+	return &details, nil
+}
+
+// Search returns the cars whose name or brand contains query. The server
+// returns at most its default page of matches, the first 50.
+func (c *Client) Search(ctx context.Context, query string) ([]partasala.Car, error) {
+	var cars []partasala.Car
+	err := c.get(ctx, "/search?q="+url.QueryEscape(query), &cars)
+	return cars, err
+}
+
+// Contact returns the yard's contact details.
+func (c *Client) Contact(ctx context.Context) (*partasala.Contact, error) {
+	var contact partasala.Contact
+	if err := c.get(ctx, "/contact", &contact); err != nil {
+		return nil, err
+	}
+	return &contact, nil
+}
+
+// envelope is the part of every API response the client needs.
+type envelope struct {
+	Success bool            `json:"success"`
+	Data    json.RawMessage `json:"data"`
+	Error   string          `json:"error"`
+}
+
+// get requests path and decodes the response's data into v, retrying as
+// described on Client.
+func (c *Client) get(ctx context.Context, path string, v interface{}) error {
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		err := c.do(ctx, path, v)
+		if err == nil || attempt >= c.Retries || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		wait := backoff
+		if apiErr, ok := err.(*APIError); ok && apiErr.RetryAfter > wait {
+			wait = apiErr.RetryAfter
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) do(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var body envelope
+	decodeErr := json.NewDecoder(resp.Body).Decode(&body)
+	// Let the connection be reused after a short or broken body
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode != http.StatusOK {
+		return &APIError{StatusCode: resp.StatusCode, Message: body.Error, RetryAfter: retryAfter(resp.Header)}
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("failed to decode %s: %v", path, decodeErr)
+	}
+	if !body.Success {
+		return &APIError{StatusCode: resp.StatusCode, Message: body.Error}
+	}
+	if err := json.Unmarshal(body.Data, v); err != nil {
+		return fmt.Errorf("failed to decode %s: %v", path, err)
+	}
+	return nil
+}
+
+// retryable reports whether err may go away by trying again.
+func retryable(err error) bool {
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		// Network errors; decode errors of a truncated body land here too
+		return true
+	}
+	switch apiErr.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses a Retry-After header, in seconds or as an HTTP date.
+func retryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
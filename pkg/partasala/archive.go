@@ -0,0 +1,156 @@
+package partasala
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ArchiveConfig enables archiving every page fetched from upstream. Format
+// is "warc" (one gzipped WARC file per day) or "html" (one timestamped file
+// per fetch, laid out by URL path).
+type ArchiveConfig struct {
+	Dir    string `json:"dir"`
+	Format string `json:"format"`
+}
+
+// archiveTransport records successful upstream responses before handing
+// them back to the caller.
+type archiveTransport struct {
+	next   http.RoundTripper
+	dir    string
+	format string
+
+	mu sync.Mutex
+}
+
+func newArchiveTransport(next http.RoundTripper, config ArchiveConfig) (*archiveTransport, error) {
+	switch config.Format {
+	case "warc", "html":
+	default:
+		return nil, fmt.Errorf("unknown archive format %q", config.Format)
+	}
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &archiveTransport{next: next, dir: config.Dir, format: config.Format}, nil
+}
+
+func (t *archiveTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || req.Method != "GET" || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	now := time.Now().UTC()
+	if t.format == "warc" {
+		dump, err := httputil.DumpResponse(resp, true)
+		if err != nil {
+			return nil, err
+		}
+		err = t.writeWARC(req.URL.String(), now, dump)
+		if err != nil {
+			log.Printf("archive: %v", err)
+		}
+		return resp, nil
+	}
+
+	var body bytes.Buffer
+	if _, err := body.ReadFrom(resp.Body); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	resp.Body = readCloser{bytes.NewReader(body.Bytes())}
+	if err := t.writeHTML(req, now, body.Bytes()); err != nil {
+		log.Printf("archive: %v", err)
+	}
+	return resp, nil
+}
+
+// writeHTML stores body as <dir>/<host>/<path>/<timestamp>.html.
+func (t *archiveTransport) writeHTML(req *http.Request, now time.Time, body []byte) error {
+	// Cleaning against "/" keeps ".." segments from escaping the archive
+	path := strings.Trim(filepath.ToSlash(filepath.Clean("/"+req.URL.Path)), "/")
+	if path == "" {
+		path = "index"
+	}
+	host := strings.ReplaceAll(req.URL.Host, ":", "_")
+	dir := filepath.Join(t.dir, host, filepath.FromSlash(path))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, now.Format("20060102T150405Z")+".html"), body, 0o644)
+}
+
+// writeWARC appends a response record to the day's WARC file. Every record
+// is its own gzip member, as is conventional for .warc.gz.
+func (t *archiveTransport) writeWARC(url string, now time.Time, dump []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	path := filepath.Join(t.dir, "partasala-"+now.Format("20060102")+".warc.gz")
+	_, statErr := os.Stat(path)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if os.IsNotExist(statErr) {
+		info := "software: partasalaScraper\r\nformat: WARC File Format 1.1\r\n"
+		if err := writeWARCRecord(f, "warcinfo", "", now, "application/warc-fields", []byte(info)); err != nil {
+			return err
+		}
+	}
+	return writeWARCRecord(f, "response", url, now, "application/http;msgtype=response", dump)
+}
+
+func writeWARCRecord(f *os.File, recordType, url string, now time.Time, contentType string, block []byte) error {
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+
+	var header bytes.Buffer
+	header.WriteString("WARC/1.1\r\n")
+	fmt.Fprintf(&header, "WARC-Type: %s\r\n", recordType)
+	fmt.Fprintf(&header, "WARC-Record-ID: <urn:uuid:%s>\r\n", id)
+	fmt.Fprintf(&header, "WARC-Date: %s\r\n", now.Format(time.RFC3339))
+	if url != "" {
+		fmt.Fprintf(&header, "WARC-Target-URI: %s\r\n", url)
+	}
+	fmt.Fprintf(&header, "Content-Type: %s\r\n", contentType)
+	fmt.Fprintf(&header, "Content-Length: %d\r\n\r\n", len(block))
+
+	gz := gzip.NewWriter(f)
+	gz.Write(header.Bytes())
+	gz.Write(block)
+	gz.Write([]byte("\r\n\r\n"))
+	return gz.Close()
+}
+
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+type readCloser struct {
+	*bytes.Reader
+}
+
+func (readCloser) Close() error { return nil }
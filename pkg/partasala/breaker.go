@@ -0,0 +1,127 @@
+package partasala
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned for upstream requests while the circuit breaker
+// is open.
+var ErrCircuitOpen = errors.New("upstream circuit breaker is open")
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// CircuitBreakerConfig opens the breaker after Failures consecutive
+// timeouts, network errors, or 5xx responses. After Cooldown one probe
+// request is let through; if it succeeds the breaker closes again. Failures
+// of 0 disables the breaker.
+type CircuitBreakerConfig struct {
+	Failures int      `json:"failures"`
+	Cooldown Duration `json:"cooldown"`
+}
+
+type circuitBreaker struct {
+	config CircuitBreakerConfig
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(config CircuitBreakerConfig) *circuitBreaker {
+	return &circuitBreaker{config: config}
+}
+
+func (b *circuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state()
+}
+
+func (b *circuitBreaker) state() string {
+	switch {
+	case b.config.Failures <= 0 || b.failures < b.config.Failures:
+		return CircuitClosed
+	case time.Since(b.openedAt) < b.config.Cooldown.Duration:
+		return CircuitOpen
+	default:
+		return CircuitHalfOpen
+	}
+}
+
+// allow reports whether a request may go upstream. In the half-open state
+// only one probe is in flight at a time.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state() {
+	case CircuitClosed:
+		return true
+	case CircuitHalfOpen:
+		if !b.probing {
+			b.probing = true
+			return true
+		}
+	}
+	return false
+}
+
+// release ends a request without judging upstream by it.
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *circuitBreaker) record(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if ok {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.config.Failures > 0 && b.failures >= b.config.Failures {
+		// Also restarts the cooldown after a failed probe
+		b.openedAt = time.Now()
+	}
+}
+
+// breakerTransport fails fast while the breaker is open and reports the
+// outcome of every request let through.
+type breakerTransport struct {
+	next    http.RoundTripper
+	breaker *circuitBreaker
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.breaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	switch {
+	case err != nil && errors.Is(req.Context().Err(), context.Canceled):
+		// The caller gave up; that says nothing about upstream
+		t.breaker.release()
+	case errors.As(err, new(*RetryAfterError)):
+		// Upstream is up, only asking to be left alone for a while
+		t.breaker.release()
+	case err != nil:
+		t.breaker.record(false)
+	default:
+		t.breaker.record(resp.StatusCode < 500)
+	}
+	return resp, err
+}
@@ -0,0 +1,67 @@
+package partasala
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/chromedp/chromedp"
+)
+
+// BrowserConfig enables rendering detail pages in headless Chrome when the
+// plain HTML yields no images. ExecPath is optional; by default the first
+// Chrome/Chromium found on the system is used.
+type BrowserConfig struct {
+	Enabled  bool     `json:"enabled"`
+	ExecPath string   `json:"exec_path"`
+	Timeout  Duration `json:"timeout"`
+	// Wait is how long to let gallery scripts run after the page is ready.
+	Wait Duration `json:"wait"`
+}
+
+// BrowserFetcher renders pages with chromedp. Each render starts its own
+// browser, which is slow but leaves nothing running between the rare
+// fallbacks.
+type BrowserFetcher struct {
+	config  BrowserConfig
+	limiter *upstreamLimiter
+}
+
+func NewBrowserFetcher(config BrowserConfig, limiter *upstreamLimiter) *BrowserFetcher {
+	return &BrowserFetcher{config: config, limiter: limiter}
+}
+
+// Render loads url in headless Chrome, runs its scripts, and parses the
+// resulting DOM.
+func (b *BrowserFetcher) Render(url string) (*goquery.Document, error) {
+	opts := append([]chromedp.ExecAllocatorOption{}, chromedp.DefaultExecAllocatorOptions[:]...)
+	if b.config.ExecPath != "" {
+		opts = append(opts, chromedp.ExecPath(b.config.ExecPath))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.config.Timeout.Duration)
+	defer cancel()
+	release, err := b.limiter.acquire(ctx, browserWeight)
+	if err != nil {
+		return nil, fmt.Errorf("browser render of %s: %v", url, err)
+	}
+	defer release()
+	allocCtx, cancelAlloc := chromedp.NewExecAllocator(ctx, opts...)
+	defer cancelAlloc()
+	browserCtx, cancelBrowser := chromedp.NewContext(allocCtx)
+	defer cancelBrowser()
+
+	var html string
+	err = chromedp.Run(browserCtx,
+		chromedp.Navigate(url),
+		chromedp.WaitReady("body", chromedp.ByQuery),
+		chromedp.Sleep(b.config.Wait.Duration),
+		chromedp.OuterHTML("html", &html, chromedp.ByQuery),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render %s: %v", url, err)
+	}
+
+	return goquery.NewDocumentFromReader(strings.NewReader(html))
+}
@@ -0,0 +1,112 @@
+package partasala
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrBudgetExhausted is returned for upstream requests once the day's
+// request budget is used up.
+var ErrBudgetExhausted = errors.New("daily upstream request budget is exhausted")
+
+// BudgetStatus describes the day's upstream request budget. Limit 0 means
+// unlimited.
+type BudgetStatus struct {
+	Limit     int       `json:"limit"`
+	Used      int       `json:"used"`
+	Remaining *int      `json:"remaining,omitempty"`
+	ResetsAt  time.Time `json:"resets_at"`
+	Exhausted bool      `json:"exhausted"`
+}
+
+// requestBudget counts upstream fetches per local calendar day.
+type requestBudget struct {
+	limit int
+
+	mu   sync.Mutex
+	day  time.Time
+	used int
+}
+
+func newRequestBudget(limit int) *requestBudget {
+	return &requestBudget{limit: limit}
+}
+
+// setLimit changes the daily limit, keeping the count of requests made
+// today.
+func (b *requestBudget) setLimit(limit int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.limit = limit
+}
+
+// roll starts a new day's count once midnight has passed.
+func (b *requestBudget) roll(now time.Time) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if !today.Equal(b.day) {
+		b.day = today
+		b.used = 0
+	}
+}
+
+// take uses one request of the budget, or reports false if none is left.
+func (b *requestBudget) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll(time.Now())
+	if b.limit > 0 && b.used >= b.limit {
+		return false
+	}
+	b.used++
+	return true
+}
+
+// refund gives back a request taken for a fetch that never left the process.
+func (b *requestBudget) refund() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used > 0 {
+		b.used--
+	}
+}
+
+func (b *requestBudget) Status() BudgetStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll(time.Now())
+	status := BudgetStatus{
+		Limit:    b.limit,
+		Used:     b.used,
+		ResetsAt: b.day.AddDate(0, 0, 1),
+	}
+	if b.limit > 0 {
+		remaining := b.limit - b.used
+		if remaining < 0 {
+			remaining = 0
+		}
+		status.Remaining = &remaining
+		status.Exhausted = remaining == 0
+	}
+	return status
+}
+
+// budgetTransport charges every upstream request to the budget and refuses
+// requests once it is spent. Requests the circuit breaker rejects are not
+// charged.
+type budgetTransport struct {
+	next   http.RoundTripper
+	budget *requestBudget
+}
+
+func (t *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.budget.take() {
+		return nil, ErrBudgetExhausted
+	}
+	resp, err := t.next.RoundTrip(req)
+	if errors.Is(err, ErrCircuitOpen) {
+		t.budget.refund()
+	}
+	return resp, err
+}
@@ -0,0 +1,119 @@
+package partasala
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CassetteConfig enables recording upstream responses to files under Dir
+// and replaying them on later runs, so development and integration tests
+// run fast, repeatably, and offline. Responses missing from the cassette
+// are fetched and recorded, or with Offline set, fail.
+type CassetteConfig struct {
+	Dir     string `json:"dir"`
+	Offline bool   `json:"offline"`
+}
+
+// ErrNotRecorded is returned for a request that isn't in an offline
+// cassette.
+var ErrNotRecorded = errors.New("not recorded in the cassette")
+
+// cassetteTransport replays responses recorded under dir and records the
+// ones it hasn't seen. Unlike the disk cache it sits above everything, so
+// replayed requests don't count against limits or budgets, and it keeps
+// recordings forever, whatever their status, except server errors.
+type cassetteTransport struct {
+	next    http.RoundTripper
+	dir     string
+	offline bool
+}
+
+func newCassetteTransport(next http.RoundTripper, config CassetteConfig) (*cassetteTransport, error) {
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &cassetteTransport{next: next, dir: config.Dir, offline: config.Offline}, nil
+}
+
+func (t *cassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	interaction := req.Method + " " + req.URL.String()
+	path := t.path(interaction)
+	if resp, err := t.load(path, req); err == nil {
+		return resp, nil
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cassette %s: %v", path, err)
+	}
+	if t.offline {
+		return nil, fmt.Errorf("%s: %w", interaction, ErrNotRecorded)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode >= 500 {
+		return resp, err
+	}
+	// DumpResponse buffers the body and puts a fresh reader back on resp
+	dump, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.save(path, interaction, dump); err != nil {
+		return nil, fmt.Errorf("cassette: %v", err)
+	}
+	return resp, nil
+}
+
+// path names an interaction's file after a hash of its method and URL.
+func (t *cassetteTransport) path(interaction string) string {
+	sum := sha256.Sum256([]byte(interaction))
+	return filepath.Join(t.dir, hex.EncodeToString(sum[:16])+".http")
+}
+
+// load reads a recording: the request's method and URL on the first line,
+// so cassettes can be searched, then the response as received.
+func (t *cassetteTransport) load(path string, req *http.Request) (*http.Response, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	_, dump, ok := strings.Cut(string(data), "\n")
+	if !ok {
+		return nil, errors.New("no response recorded")
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader([]byte(dump))), req)
+	if err != nil {
+		return nil, err
+	}
+	if trace := FetchTraceFrom(req.Context()); trace != nil {
+		if info, err := os.Stat(path); err == nil {
+			trace.NoteScraped(info.ModTime())
+		}
+	}
+	return resp, nil
+}
+
+func (t *cassetteTransport) save(path, interaction string, dump []byte) error {
+	tmp, err := os.CreateTemp(t.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	data := append([]byte(interaction+"\n"), dump...)
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,129 @@
+package partasala
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/text/unicode/norm"
+)
+
+// TaxonomyConfig describes one of the site's ways of grouping cars other
+// than by brand, such as vehicle types or year groups. Its terms are the
+// homepage links under Path, like "/gerd/jeppar/", and each term's page
+// lists its cars the way a brand page does.
+type TaxonomyConfig struct {
+	Slug string `json:"slug"`
+	Path string `json:"path"`
+}
+
+// Category is a term of one of the site's taxonomies.
+type Category struct {
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	Taxonomy string `json:"taxonomy"`
+	URL      string `json:"url"`
+}
+
+// ErrUnknownTaxonomy is returned for taxonomies the Config doesn't list.
+var ErrUnknownTaxonomy = errors.New("unknown taxonomy")
+
+func taxonomyPatterns(taxonomies []TaxonomyConfig) []*regexp.Regexp {
+	patterns := make([]*regexp.Regexp, len(taxonomies))
+	for i, taxonomy := range taxonomies {
+		patterns[i] = regexp.MustCompile(regexp.QuoteMeta(taxonomy.Path) + `[^/]+/?$`)
+	}
+	return patterns
+}
+
+// categories finds the terms of every configured taxonomy that doc links
+// to, sorted by taxonomy in config order and then by name.
+func (p *Parser) categories(doc *goquery.Document) []Category {
+	categories := []Category{}
+	seen := make(map[string]bool)
+
+	doc.Find(p.selectors.CategoryLinks).Each(func(i int, sel *goquery.Selection) {
+		href, exists := sel.Attr("href")
+		if !exists {
+			return
+		}
+		for t, pattern := range p.taxonomyPatterns {
+			if !pattern.MatchString(href) {
+				continue
+			}
+			categoryURL, ok := p.absoluteURL(href)
+			if !ok {
+				return
+			}
+			parts := strings.Split(strings.TrimRight(href, "/"), "/")
+			slug := parts[len(parts)-1]
+			taxonomy := p.config.Taxonomies[t].Slug
+			if seen[taxonomy+"/"+slug] {
+				return
+			}
+			seen[taxonomy+"/"+slug] = true
+
+			categories = append(categories, Category{
+				Name:     norm.NFC.String(strings.TrimSpace(sel.Text())),
+				Slug:     slug,
+				Taxonomy: taxonomy,
+				URL:      categoryURL,
+			})
+			return
+		}
+	})
+
+	order := make(map[string]int, len(p.config.Taxonomies))
+	for i, taxonomy := range p.config.Taxonomies {
+		order[taxonomy.Slug] = i
+	}
+	sort.SliceStable(categories, func(i, j int) bool {
+		if categories[i].Taxonomy != categories[j].Taxonomy {
+			return order[categories[i].Taxonomy] < order[categories[j].Taxonomy]
+		}
+		return categories[i].Name < categories[j].Name
+	})
+	return categories
+}
+
+// taxonomy returns the configured taxonomy with slug.
+func (p *Parser) taxonomy(slug string) (TaxonomyConfig, bool) {
+	for _, taxonomy := range p.config.Taxonomies {
+		if taxonomy.Slug == slug {
+			return taxonomy, true
+		}
+	}
+	return TaxonomyConfig{}, false
+}
+
+// GetCategories returns the terms of every taxonomy the homepage links to.
+func (s *Scraper) GetCategories() ([]Category, error) {
+	page, err := s.getHomePage()
+	if err != nil {
+		return nil, err
+	}
+	return append([]Category{}, page.categories...), nil
+}
+
+// GetCategoryCars reads every page of the listing of the term slug of
+// taxonomy, up to Config.MaxBrandPages. Term pages list cars without their
+// brand, so Brand is empty and Make is looked up from the brand list by
+// name, as for the archive.
+func (s *Scraper) GetCategoryCars(taxonomy, slug string) ([]Car, error) {
+	parser := s.parser.Load()
+	config, ok := parser.taxonomy(taxonomy)
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownTaxonomy, taxonomy)
+	}
+	listingPath := config.Path + slug + "/"
+	url := parser.baseURL + listingPath
+	cars, err := s.getListing(parser, url, listingPath, "", s.config.MaxBrandPages)
+	if err != nil {
+		return nil, err
+	}
+	s.splitMakes(cars)
+	return cars, nil
+}
@@ -0,0 +1,103 @@
+package partasala
+
+import (
+	"net/http"
+	"sync"
+)
+
+// maxConditionalEntries bounds how many pages keep validators and parsed
+// results; detail pages are requested on demand, so the set is open-ended.
+const maxConditionalEntries = 2000
+
+// conditionalEntry holds the validators upstream sent for a page together
+// with what the page parsed to, so a 304 can skip fetching and parsing.
+type conditionalEntry struct {
+	etag         string
+	lastModified string
+	parsed       interface{}
+}
+
+// conditionalCache remembers validators and parse results per URL.
+type conditionalCache struct {
+	mu      sync.Mutex
+	entries map[string]*conditionalEntry
+}
+
+func newConditionalCache() *conditionalCache {
+	return &conditionalCache{entries: make(map[string]*conditionalEntry)}
+}
+
+// apply adds If-None-Match/If-Modified-Since to req when a parsed result for
+// its URL is available.
+func (c *conditionalCache) apply(req *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[req.URL.String()]
+	if !ok || entry.parsed == nil {
+		return
+	}
+	if entry.etag != "" {
+		req.Header.Set("If-None-Match", entry.etag)
+	}
+	if entry.lastModified != "" {
+		req.Header.Set("If-Modified-Since", entry.lastModified)
+	}
+}
+
+// parsed returns the result stored for url, if any.
+func (c *conditionalCache) parsed(url string) interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[url]; ok {
+		return entry.parsed
+	}
+	return nil
+}
+
+// record stores the validators of a fresh 200 response. The parse result is
+// attached later with store, once the caller has parsed the page.
+func (c *conditionalCache) record(url string, resp *http.Response) {
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if etag == "" && lastModified == "" {
+		delete(c.entries, url)
+		return
+	}
+	if _, ok := c.entries[url]; !ok && len(c.entries) >= maxConditionalEntries {
+		for evict := range c.entries {
+			delete(c.entries, evict)
+			break
+		}
+	}
+	c.entries[url] = &conditionalEntry{etag: etag, lastModified: lastModified}
+}
+
+// store attaches the parse result to the validators recorded for url.
+func (c *conditionalCache) store(url string, parsed interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[url]; ok {
+		entry.parsed = parsed
+	}
+}
+
+// clear forgets every parse result, e.g. after selectors change.
+func (c *conditionalCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*conditionalEntry)
+}
+
+// len returns how many pages have validators recorded.
+func (c *conditionalCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
@@ -0,0 +1,117 @@
+package partasala
+
+import (
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Contact is how to reach the yard, as its contact page lists it. Fields
+// the page doesn't show are empty.
+type Contact struct {
+	URL          string   `json:"url"`
+	Phones       []string `json:"phones"`
+	Email        *string  `json:"email"`
+	Address      *string  `json:"address"`
+	OpeningHours []string `json:"opening_hours"`
+}
+
+var (
+	// Icelandic numbers have seven digits, written "555 1234" or
+	// "555-1234", optionally after +354
+	phonePattern = regexp.MustCompile(`(?:\+354[\s\x{00a0}-]?)?\b(\d{3})[\s\x{00a0}-]?(\d{4})\b`)
+	emailPattern = regexp.MustCompile(`[\w.+-]+@[\w-]+(?:\.[\w-]+)+`)
+	// A street and number followed by a postal code and town, e.g.
+	// "Bæjarhrauni 10, 220 Hafnarfirði"
+	addressPattern = regexp.MustCompile(`[\p{L}.]+(?:\s[\p{L}.]+)*\s\d+[a-zA-Z]?,\s*\d{3}\s+\p{L}+`)
+	// A line with a time range like "8:00-17:00" or "kl. 8-17", or one
+	// that says the yard is closed
+	hoursPattern = regexp.MustCompile(`(?i)\b\d{1,2}(?:[:.]\d{2})?\s*[-–]\s*\d{1,2}(?:[:.]\d{2})?\b|\blokað`)
+)
+
+// ParseContact parses the partasala.is contact page.
+func ParseContact(r io.Reader) (*Contact, error) {
+	return defaultParser().ParseContact(r)
+}
+
+// ParseContact parses the site's contact page.
+func (p *Parser) ParseContact(r io.Reader) (*Contact, error) {
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return nil, err
+	}
+	contact := p.contact(doc)
+	return &contact, nil
+}
+
+func (p *Parser) contactURL() string {
+	return p.baseURL + p.config.ContactPath
+}
+
+func (p *Parser) contact(doc *goquery.Document) Contact {
+	contact := Contact{
+		URL:          p.contactURL(),
+		Phones:       []string{},
+		OpeningHours: []string{},
+	}
+	seenPhones := make(map[string]bool)
+	addPhone := func(text string) {
+		match := phonePattern.FindStringSubmatch(text)
+		if match == nil {
+			return
+		}
+		phone := match[1] + " " + match[2]
+		if !seenPhones[phone] {
+			seenPhones[phone] = true
+			contact.Phones = append(contact.Phones, phone)
+		}
+	}
+
+	// Links say exactly what they mean, so they go first
+	doc.Find("a[href^='tel:']").Each(func(i int, sel *goquery.Selection) {
+		href, _ := sel.Attr("href")
+		addPhone(strings.TrimPrefix(href, "tel:"))
+	})
+	if href, ok := doc.Find("a[href^='mailto:']").First().Attr("href"); ok {
+		email := strings.SplitN(strings.TrimPrefix(href, "mailto:"), "?", 2)[0]
+		contact.Email = &email
+	}
+	if address := strings.Join(strings.Fields(doc.Find("address").First().Text()), " "); address != "" {
+		contact.Address = &address
+	}
+
+	// Then the page's text, a line per block element, table row, or <br>
+	doc.Find("br").ReplaceWithHtml("\n")
+	doc.Find("p, li, tr, div, dd, dt, h1, h2, h3, h4, address").AppendHtml("\n")
+	doc.Find("td, th").AppendHtml(" ")
+	seenHours := make(map[string]bool)
+	for _, line := range strings.Split(doc.Find("body").Text(), "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" {
+			continue
+		}
+		if contact.Email == nil {
+			if email := emailPattern.FindString(line); email != "" {
+				contact.Email = &email
+			}
+		}
+		if contact.Address == nil {
+			if address := addressPattern.FindString(line); address != "" {
+				contact.Address = &address
+			}
+		}
+		if hoursPattern.MatchString(line) && !phonePattern.MatchString(line) {
+			if !seenHours[line] {
+				seenHours[line] = true
+				contact.OpeningHours = append(contact.OpeningHours, line)
+			}
+			continue
+		}
+		for _, match := range phonePattern.FindAllString(line, -1) {
+			addPhone(match)
+		}
+	}
+	return contact
+}
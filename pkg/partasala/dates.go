@@ -0,0 +1,76 @@
+package partasala
+
+import (
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// postedSelectors and updatedSelectors find a post's publication and last
+// modification dates, in the order they are tried: the Open Graph tags
+// WordPress SEO plugins add, then the time tags themes put in the post.
+var (
+	postedSelectors = []string{
+		`meta[property="article:published_time"]`,
+		`time.published[datetime]`,
+		`time.entry-date[datetime]`,
+		`article time[datetime]`,
+	}
+	updatedSelectors = []string{
+		`meta[property="article:modified_time"]`,
+		`meta[property="og:updated_time"]`,
+		`time.updated[datetime]`,
+	}
+)
+
+// dateLayouts are the layouts dates are read in. Times without a zone are
+// taken as UTC, Iceland's time all year.
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// postDates reads when a car's post was published and last updated, either
+// being nil if the page doesn't say. An update no later than publication
+// is left out.
+func postDates(doc *goquery.Document) (posted, updated *time.Time) {
+	posted = findDate(doc, postedSelectors)
+	updated = findDate(doc, updatedSelectors)
+	if posted != nil && updated != nil && !updated.After(*posted) {
+		updated = nil
+	}
+	return posted, updated
+}
+
+// findDate returns the first date one of selectors matches that parses.
+func findDate(doc *goquery.Document, selectors []string) *time.Time {
+	for _, selector := range selectors {
+		var found *time.Time
+		doc.Find(selector).EachWithBreak(func(i int, sel *goquery.Selection) bool {
+			value, ok := sel.Attr("content")
+			if !ok {
+				value, _ = sel.Attr("datetime")
+			}
+			found = parseDate(value)
+			return found == nil
+		})
+		if found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func parseDate(value string) *time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			t = t.UTC()
+			return &t
+		}
+	}
+	return nil
+}
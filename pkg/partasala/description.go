@@ -0,0 +1,337 @@
+package partasala
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/text/unicode/norm"
+)
+
+// descriptionTags are the elements kept in DescriptionHTML, with the
+// attributes each may keep. Other elements are dropped but their text is
+// kept, except for droppedTags, which go with everything in them.
+var descriptionTags = map[string][]string{
+	"p": nil, "br": nil, "strong": nil, "b": nil, "em": nil, "i": nil, "u": nil,
+	"ul": nil, "ol": nil, "li": nil, "blockquote": nil,
+	"h2": nil, "h3": nil, "h4": nil, "h5": nil, "h6": nil,
+	"table": nil, "thead": nil, "tbody": nil, "tr": nil, "th": nil, "td": nil,
+	"dl": nil, "dt": nil, "dd": nil,
+	"a": {"href"},
+}
+
+var droppedTags = map[string]bool{
+	"script": true, "style": true, "noscript": true, "iframe": true, "object": true,
+	"embed": true, "form": true, "button": true, "select": true, "textarea": true,
+	"svg": true, "template": true,
+}
+
+// blockTags start a new line in the plaintext description.
+var blockTags = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "ul": true, "ol": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"blockquote": true, "table": true, "tr": true, "dl": true, "dt": true, "dd": true,
+	"section": true, "article": true, "figure": true, "figcaption": true, "pre": true, "hr": true,
+}
+
+// descriptionElement finds the element holding a detail page's
+// description: the first match of the PostContent selector, WordPress's
+// post body, or failing that the last Description element with one of the
+// DescriptionClasses, which on themes that nest them is the innermost.
+func (p *Parser) descriptionElement(doc *goquery.Document) *goquery.Selection {
+	if p.selectors.PostContent != "" {
+		if content := doc.Find(p.selectors.PostContent).First(); content.Length() > 0 {
+			return content
+		}
+	}
+
+	var found *goquery.Selection
+	doc.Find(p.selectors.Description).Each(func(i int, sel *goquery.Selection) {
+		class, _ := sel.Attr("class")
+		for _, keyword := range p.selectors.DescriptionClasses {
+			if strings.Contains(strings.ToLower(class), keyword) {
+				found = sel
+				return
+			}
+		}
+	})
+	return found
+}
+
+// descriptionText flattens the description to plain text: a line per
+// paragraph, list item, or table row, with runs of spaces collapsed and
+// blank lines left out.
+func descriptionText(sel *goquery.Selection) string {
+	var b strings.Builder
+	var walk func(n *nethtml.Node)
+	walk = func(n *nethtml.Node) {
+		switch n.Type {
+		case nethtml.TextNode:
+			b.WriteString(n.Data)
+			return
+		case nethtml.ElementNode:
+			if droppedTags[n.Data] {
+				return
+			}
+			if n.Data == "td" || n.Data == "th" {
+				b.WriteString(" ")
+			}
+		}
+		if blockTags[n.Data] {
+			b.WriteString("\n")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if blockTags[n.Data] {
+			b.WriteString("\n")
+		}
+	}
+	for _, n := range sel.Nodes {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+
+	lines := []string{}
+	for _, line := range strings.Split(b.String(), "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return norm.NFC.String(strings.Join(lines, "\n"))
+}
+
+// descriptionHTML renders the description with only descriptionTags and
+// their allowed attributes, so it can be shown as is. Links are made
+// absolute, and ones that aren't http or https, or only point within the
+// page, are dropped for their text.
+func (p *Parser) descriptionHTML(sel *goquery.Selection) string {
+	var b strings.Builder
+	var walk func(n *nethtml.Node)
+	walk = func(n *nethtml.Node) {
+		switch n.Type {
+		case nethtml.TextNode:
+			b.WriteString(html.EscapeString(n.Data))
+			return
+		case nethtml.ElementNode:
+		default:
+			return
+		}
+		if droppedTags[n.Data] {
+			return
+		}
+
+		allowed, keep := descriptionTags[n.Data]
+		attrs := ""
+		if n.Data == "a" {
+			href := strings.TrimSpace(attr(n, "href"))
+			absolute, ok := p.absoluteURL(href)
+			if href == "" || strings.HasPrefix(href, "#") || !ok {
+				keep = false
+			}
+			attrs = ` href="` + html.EscapeString(absolute) + `" rel="nofollow noopener"`
+		} else {
+			for _, name := range allowed {
+				if value := attr(n, name); value != "" {
+					attrs += " " + name + `="` + html.EscapeString(value) + `"`
+				}
+			}
+		}
+
+		if keep {
+			b.WriteString("<" + n.Data + attrs + ">")
+		}
+		if n.Data == "br" {
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if keep {
+			b.WriteString("</" + n.Data + ">")
+		}
+	}
+	for _, n := range sel.Nodes {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	return norm.NFC.String(strings.TrimSpace(b.String()))
+}
+
+func attr(n *nethtml.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// markdownBreak marks a line break until the trailing spaces of lines
+// are trimmed.
+const markdownBreak = "\x00"
+
+// whitespaceRun matches the whitespace HTML shows as one space.
+var whitespaceRun = regexp.MustCompile(`\s+`)
+
+// markdownEscaper escapes the characters Markdown would read as formatting
+// in the description's text.
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`)
+
+// descriptionMarkdown renders the description as Markdown, for chat bots
+// and terminals: paragraphs and headings become blocks, lists keep their
+// markers, tables become pipe tables, and line breaks, emphasis, and links
+// are kept. Everything descriptionHTML drops is dropped here too.
+func (p *Parser) descriptionMarkdown(sel *goquery.Selection) string {
+	var b strings.Builder
+	for _, n := range sel.Nodes {
+		b.WriteString(p.markdownChildren(n))
+	}
+
+	lines := []string{}
+	blank := true
+	for _, line := range strings.Split(b.String(), "\n") {
+		line = strings.TrimRight(line, " \t")
+		if strings.TrimSpace(strings.ReplaceAll(line, markdownBreak, "")) == "" {
+			if !blank {
+				lines = append(lines, "")
+			}
+			blank = true
+			continue
+		}
+		if !strings.HasPrefix(line, "  ") {
+			line = strings.TrimLeft(line, " ")
+		}
+		// A line break is two spaces at the end of the line
+		lines = append(lines, strings.ReplaceAll(line, markdownBreak, "  "))
+		blank = false
+	}
+	return norm.NFC.String(strings.TrimSpace(strings.Join(lines, "\n")))
+}
+
+func (p *Parser) markdownChildren(n *nethtml.Node) string {
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(p.markdownNode(c))
+	}
+	return b.String()
+}
+
+func (p *Parser) markdownNode(n *nethtml.Node) string {
+	switch n.Type {
+	case nethtml.TextNode:
+		return markdownEscaper.Replace(whitespaceRun.ReplaceAllString(n.Data, " "))
+	case nethtml.ElementNode:
+	default:
+		return ""
+	}
+	if droppedTags[n.Data] {
+		return ""
+	}
+
+	inner := func() string { return strings.TrimSpace(p.markdownChildren(n)) }
+	switch n.Data {
+	case "br":
+		return markdownBreak + "\n"
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		return "\n\n" + strings.Repeat("#", int(n.Data[1]-'0')) + " " + inner() + "\n\n"
+	case "strong", "b":
+		if text := inner(); text != "" {
+			return "**" + text + "**"
+		}
+		return ""
+	case "em", "i":
+		if text := inner(); text != "" {
+			return "*" + text + "*"
+		}
+		return ""
+	case "a":
+		href := strings.TrimSpace(attr(n, "href"))
+		absolute, ok := p.absoluteURL(href)
+		if href == "" || strings.HasPrefix(href, "#") || !ok {
+			return p.markdownChildren(n)
+		}
+		return "[" + inner() + "](" + absolute + ")"
+	case "ul", "ol":
+		var items []string
+		number := 1
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != nethtml.ElementNode || c.Data != "li" {
+				continue
+			}
+			marker := "- "
+			if n.Data == "ol" {
+				marker = strconv.Itoa(number) + ". "
+				number++
+			}
+			item := strings.TrimSpace(collapseBlankLines(p.markdownChildren(c)))
+			items = append(items, marker+strings.ReplaceAll(item, "\n", "\n"+strings.Repeat(" ", len(marker))))
+		}
+		return "\n\n" + strings.Join(items, "\n") + "\n\n"
+	case "blockquote":
+		return "\n\n> " + strings.ReplaceAll(collapseBlankLines(inner()), "\n", "\n> ") + "\n\n"
+	case "table":
+		return "\n\n" + p.markdownTable(n) + "\n\n"
+	case "dt":
+		return "\n\n**" + inner() + "**\n"
+	case "dd":
+		return "\n" + inner() + "\n"
+	}
+	if blockTags[n.Data] {
+		return "\n\n" + inner() + "\n\n"
+	}
+	return p.markdownChildren(n)
+}
+
+// markdownTable renders a table's rows as a pipe table, the first row
+// being its header.
+func (p *Parser) markdownTable(table *nethtml.Node) string {
+	var rows [][]string
+	var collect func(n *nethtml.Node)
+	collect = func(n *nethtml.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != nethtml.ElementNode {
+				continue
+			}
+			if c.Data != "tr" {
+				collect(c)
+				continue
+			}
+			var row []string
+			for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+				if cell.Type == nethtml.ElementNode && (cell.Data == "td" || cell.Data == "th") {
+					text := strings.Join(strings.Fields(p.markdownChildren(cell)), " ")
+					row = append(row, strings.ReplaceAll(text, "|", `\|`))
+				}
+			}
+			rows = append(rows, row)
+		}
+	}
+	collect(table)
+	if len(rows) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	for i, row := range rows {
+		b.WriteString("| " + strings.Join(row, " | ") + " |\n")
+		if i == 0 {
+			b.WriteString("|" + strings.Repeat(" --- |", len(row)) + "\n")
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// collapseBlankLines joins the blocks of a list item or quote with single
+// line breaks.
+func collapseBlankLines(text string) string {
+	for strings.Contains(text, "\n\n") {
+		text = strings.ReplaceAll(text, "\n\n", "\n")
+	}
+	return text
+}
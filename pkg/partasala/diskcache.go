@@ -0,0 +1,162 @@
+package partasala
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// DiskCacheConfig enables an on-disk cache of fetched pages, so restarts and
+// development runs don't re-download everything from the live site.
+type DiskCacheConfig struct {
+	Dir string   `json:"dir"`
+	TTL Duration `json:"ttl"`
+}
+
+// diskCacheTransport serves GET requests from files under dir while they are
+// younger than ttl, and stores successful responses on the way through.
+type diskCacheTransport struct {
+	next http.RoundTripper
+	dir  string
+	// ttl is a time.Duration, swapped by setTTL
+	ttl atomic.Int64
+}
+
+func newDiskCacheTransport(next http.RoundTripper, config DiskCacheConfig) (*diskCacheTransport, error) {
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	t := &diskCacheTransport{next: next, dir: config.Dir}
+	t.setTTL(config.TTL.Duration)
+	t.prune()
+	return t, nil
+}
+
+// setTTL changes how long cached pages are served. Pages already cached
+// are judged by the new TTL too.
+func (t *diskCacheTransport) setTTL(ttl time.Duration) {
+	t.ttl.Store(int64(ttl))
+}
+
+func (t *diskCacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" {
+		return t.next.RoundTrip(req)
+	}
+
+	path := t.path(req.URL.String())
+	if resp, ok := t.load(path, req); ok {
+		return resp, nil
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	// DumpResponse buffers the body and puts a fresh reader back on resp
+	dump, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.save(path, dump); err != nil {
+		log.Printf("diskcache: %v", err)
+	}
+	return resp, nil
+}
+
+func (t *diskCacheTransport) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(t.dir, hex.EncodeToString(sum[:])+".http")
+}
+
+func (t *diskCacheTransport) load(path string, req *http.Request) (*http.Response, bool) {
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > time.Duration(t.ttl.Load()) {
+		return nil, false
+	}
+
+	dump, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(dump)), req)
+	if err != nil {
+		return nil, false
+	}
+	if trace := FetchTraceFrom(req.Context()); trace != nil {
+		trace.NoteScraped(info.ModTime())
+	}
+	return resp, true
+}
+
+func (t *diskCacheTransport) save(path string, dump []byte) error {
+	tmp, err := os.CreateTemp(t.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(dump); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// prune removes entries that have outlived the TTL.
+func (t *diskCacheTransport) prune() {
+	entries, err := os.ReadDir(t.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err == nil && time.Since(info.ModTime()) > time.Duration(t.ttl.Load()) {
+			os.Remove(filepath.Join(t.dir, entry.Name()))
+		}
+	}
+}
+
+// count returns how many pages are stored, expired or not.
+func (t *diskCacheTransport) count() int {
+	entries, err := os.ReadDir(t.dir)
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".http" {
+			n++
+		}
+	}
+	return n
+}
+
+// clear removes every stored page.
+func (t *diskCacheTransport) clear() error {
+	entries, err := os.ReadDir(t.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".http" {
+			if err := os.Remove(filepath.Join(t.dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
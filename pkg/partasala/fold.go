@@ -0,0 +1,64 @@
+package partasala
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// FoldASCII matches text without regard to case or Icelandic letters,
+	// so "thorshofn" finds "Þórshöfn": accents are dropped, þ is th, ð is
+	// d, and æ is ae.
+	FoldASCII = "ascii"
+	// FoldCase matches text without regard to case only.
+	FoldCase = "case"
+)
+
+// asciiLetters are the letters FoldASCII writes out, having no accent to
+// drop.
+var asciiLetters = map[rune]string{
+	'þ': "th",
+	'ð': "d",
+	'æ': "ae",
+	'ø': "o",
+	'ß': "ss",
+}
+
+// Fold normalizes s for matching under folding, FoldASCII or FoldCase:
+// composed to NFC, so "ö" typed as "o" and a combining mark matches the
+// single letter, and lower-cased, with FoldASCII's Icelandic letters
+// written out. Empty folding means FoldASCII.
+func Fold(s, folding string) string {
+	folded, _ := foldRunes(s, folding)
+	return string(folded)
+}
+
+// foldRunes folds s like Fold, returning as well the index in s's NFC runes
+// that each folded rune came from.
+func foldRunes(s, folding string) ([]rune, []int) {
+	var folded []rune
+	var from []int
+	for i, r := range []rune(norm.NFC.String(s)) {
+		r = unicode.ToLower(r)
+		out := string(r)
+		if folding != FoldCase {
+			if letters, ok := asciiLetters[r]; ok {
+				out = letters
+			} else {
+				out = strings.Map(func(r rune) rune {
+					if unicode.Is(unicode.Mn, r) {
+						return -1
+					}
+					return r
+				}, norm.NFD.String(out))
+			}
+		}
+		for _, o := range out {
+			folded = append(folded, o)
+			from = append(from, i)
+		}
+	}
+	return folded, from
+}
@@ -0,0 +1,133 @@
+package partasala
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	// ImageDedupURL drops images whose full-size URL repeats.
+	ImageDedupURL = "url"
+	// ImageDedupFilename also drops images whose upload filename repeats
+	// once WordPress's size, scaling, and edit suffixes are removed, such as
+	// a photo uploaded again in another month.
+	ImageDedupFilename = "filename"
+	// ImageDedupContent also downloads the remaining images and drops those
+	// whose bytes repeat.
+	ImageDedupContent = "content"
+)
+
+// maxImageBytes bounds how much of an image is read to hash it.
+const maxImageBytes = 20 << 20
+
+// maxImageHashes bounds how many image hashes, and how many probed image
+// sizes, are remembered.
+const maxImageHashes = 10000
+
+// uploadSuffixes are what WordPress appends to an upload's name for the
+// copies it makes: "-scaled" and "-rotated" for the full-size copy, and
+// "-e<timestamp>" for one edited in the media library.
+var uploadSuffixes = regexp.MustCompile(`(-scaled|-rotated|-e\d{10,})+$`)
+
+// uploadFilename is the name an image was uploaded under, in lower case and
+// without its size and copy suffixes.
+func (p *Parser) uploadFilename(url string) string {
+	name := strings.ToLower(path.Base(p.selectors.sizeSuffix.ReplaceAllString(url, ".$1")))
+	ext := path.Ext(name)
+	if ext == ".jpeg" {
+		ext = ".jpg"
+	}
+	return uploadSuffixes.ReplaceAllString(strings.TrimSuffix(name, path.Ext(name)), "") + ext
+}
+
+// dedupImages drops repeated images as Config.ImageDedup asks, keeping the
+// first of each.
+func (s *Scraper) dedupImages(parser *Parser, images []Image) []Image {
+	mode := s.config.ImageDedup
+	if mode != ImageDedupFilename && mode != ImageDedupContent {
+		return images
+	}
+
+	kept := []Image{}
+	seen := make(map[string]bool)
+	for _, image := range images {
+		key := "name:" + parser.uploadFilename(image.URL)
+		if !seen[key] && mode == ImageDedupContent {
+			// Images that can't be fetched are kept, and only
+			// de-duplicated by name
+			if sum, err := s.imageHash(image.URL); err == nil {
+				seen[key] = true
+				key = "hash:" + sum
+			}
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		kept = append(kept, image)
+	}
+	return kept
+}
+
+// imageHashes remembers the content and perceptual hashes of each image
+// URL, since uploads don't change once made.
+type imageHashes struct {
+	mu         sync.Mutex
+	hashes     map[string]string
+	perceptual map[string]uint64
+}
+
+func newImageHashes() *imageHashes {
+	return &imageHashes{hashes: make(map[string]string), perceptual: make(map[string]uint64)}
+}
+
+// imageHash returns the SHA-256 of the image at url, downloading it unless
+// it was hashed before.
+func (s *Scraper) imageHash(url string) (string, error) {
+	s.images.mu.Lock()
+	sum, ok := s.images.hashes[url]
+	s.images.mu.Unlock()
+	if ok {
+		return sum, nil
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.config.Timeouts.Details.Duration)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", s.userAgent())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status code error: %d %s", resp.StatusCode, resp.Status)
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.LimitReader(resp.Body, maxImageBytes)); err != nil {
+		return "", err
+	}
+	sum = fmt.Sprintf("%x", hash.Sum(nil))
+
+	s.images.mu.Lock()
+	defer s.images.mu.Unlock()
+	if len(s.images.hashes) >= maxImageHashes {
+		for evict := range s.images.hashes {
+			delete(s.images.hashes, evict)
+			break
+		}
+	}
+	s.images.hashes[url] = sum
+	return sum, nil
+}
@@ -0,0 +1,173 @@
+package partasala
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// imageProbeBytes is how much of an image is requested to read its
+// dimensions. JPEGs whose EXIF data runs past it are reported without
+// them.
+const imageProbeBytes = 64 << 10
+
+// imageInfo is what probing an image found out.
+type imageInfo struct {
+	width, height int
+	bytes         int64
+	format        string
+}
+
+// imageInfos remembers what each image URL was probed to, since uploads
+// don't change once made.
+type imageInfos struct {
+	mu    sync.Mutex
+	infos map[string]imageInfo
+}
+
+func newImageInfos() *imageInfos {
+	return &imageInfos{infos: make(map[string]imageInfo)}
+}
+
+// addImageMetadata fills in the size and format of each image it can
+// probe, leaving the others as they are.
+func (s *Scraper) addImageMetadata(images []Image) {
+	var wg sync.WaitGroup
+	for i := range images {
+		wg.Add(1)
+		go func(image *Image) {
+			defer wg.Done()
+			info, err := s.probeImage(image.URL)
+			if err != nil {
+				return
+			}
+			if info.width > 0 && info.height > 0 {
+				image.Width, image.Height = &info.width, &info.height
+			}
+			if info.bytes > 0 {
+				image.Bytes = &info.bytes
+			}
+			image.Format = info.format
+		}(&images[i])
+	}
+	wg.Wait()
+}
+
+// probeImage requests the start of the image at url and reads its
+// dimensions and format from it, and its size from the response headers.
+func (s *Scraper) probeImage(url string) (imageInfo, error) {
+	s.imageInfos.mu.Lock()
+	info, ok := s.imageInfos.infos[url]
+	s.imageInfos.mu.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.config.Timeouts.Details.Duration)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return imageInfo{}, err
+	}
+	req.Header.Set("User-Agent", s.userAgent())
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", imageProbeBytes-1))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return imageInfo{}, fmt.Errorf("failed to fetch %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		// Content-Range: bytes 0-65535/123456
+		if _, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/"); ok {
+			info.bytes, _ = strconv.ParseInt(total, 10, 64)
+		}
+	case http.StatusOK:
+		// Upstream ignored the range and is sending the whole image
+		info.bytes = resp.ContentLength
+	default:
+		return imageInfo{}, fmt.Errorf("status code error: %d %s", resp.StatusCode, resp.Status)
+	}
+
+	head, err := io.ReadAll(io.LimitReader(resp.Body, imageProbeBytes))
+	if err != nil {
+		return imageInfo{}, err
+	}
+	if config, format, err := image.DecodeConfig(bytes.NewReader(head)); err == nil {
+		info.width, info.height, info.format = config.Width, config.Height, format
+	} else if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil && strings.HasPrefix(mediaType, "image/") {
+		info.format = strings.TrimPrefix(mediaType, "image/")
+	}
+
+	s.imageInfos.mu.Lock()
+	defer s.imageInfos.mu.Unlock()
+	if len(s.imageInfos.infos) >= maxImageHashes {
+		for evict := range s.imageInfos.infos {
+			delete(s.imageInfos.infos, evict)
+			break
+		}
+	}
+	s.imageInfos.infos[url] = info
+	return info, nil
+}
+
+// CheckImage sends a HEAD request for the image at url and returns the
+// status upstream answered with.
+func (s *Scraper) CheckImage(url string) (int, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.config.Timeouts.Details.Duration)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", s.userAgent())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check %s: %v", url, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// FetchImage requests the image at url through the scraper's transport, so
+// the disk cache, limiter, and request budget apply. The caller closes the
+// response body.
+func (s *Scraper) FetchImage(ctx context.Context, url string) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeouts.Details.Duration)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("User-Agent", s.userAgent())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to fetch %s: %v", url, err)
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases a request's context once its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
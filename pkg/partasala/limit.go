@@ -0,0 +1,86 @@
+package partasala
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// upstreamLimiter caps concurrent work against the upstream site. Plain
+// requests weigh 1; a headless browser render weighs browserWeight, since it
+// loads the page's scripts and images too.
+type upstreamLimiter struct {
+	mu   sync.Mutex
+	sem  *semaphore.Weighted
+	size int64
+}
+
+const browserWeight = 4
+
+func newUpstreamLimiter(size int) *upstreamLimiter {
+	l := &upstreamLimiter{}
+	l.resize(size)
+	return l
+}
+
+// resize changes how much work may run at once. Work already running
+// finishes against the old limit.
+func (l *upstreamLimiter) resize(size int) {
+	if size < 1 {
+		size = 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sem, l.size = semaphore.NewWeighted(int64(size)), int64(size)
+}
+
+// acquire waits for weight slots, capped at the limiter's size so heavy work
+// can still run alone.
+func (l *upstreamLimiter) acquire(ctx context.Context, weight int64) (release func(), err error) {
+	l.mu.Lock()
+	sem, size := l.sem, l.size
+	l.mu.Unlock()
+	if weight > size {
+		weight = size
+	}
+	if err := sem.Acquire(ctx, weight); err != nil {
+		return nil, err
+	}
+	var once sync.Once
+	return func() { once.Do(func() { sem.Release(weight) }) }, nil
+}
+
+// limitTransport holds one slot of the limiter from sending a request until
+// its response body is closed.
+type limitTransport struct {
+	next    http.RoundTripper
+	limiter *upstreamLimiter
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.limiter.acquire(req.Context(), 1)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
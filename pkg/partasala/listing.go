@@ -0,0 +1,357 @@
+package partasala
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// simpleSelector matches elements by tag name, classes, or both, as in
+// "li", ".price", or "span.price". It's the only kind of selector the
+// listing tokenizer understands.
+type simpleSelector struct {
+	tag     string
+	classes []string
+}
+
+// simpleSelectors is a group of simple selectors, as in "article, li",
+// matching elements any of them match.
+type simpleSelectors []simpleSelector
+
+// compileSimpleSelectors parses group, or returns false if any of its
+// selectors is more than a tag name and classes.
+func compileSimpleSelectors(group string) (simpleSelectors, bool) {
+	var selectors simpleSelectors
+	for _, part := range strings.Split(group, ",") {
+		names := strings.Split(strings.TrimSpace(part), ".")
+		selector := simpleSelector{tag: strings.ToLower(names[0]), classes: names[1:]}
+		if selector.tag == "" && len(selector.classes) == 0 {
+			return nil, false
+		}
+		for _, name := range names {
+			if !simpleName(name) {
+				return nil, false
+			}
+		}
+		selectors = append(selectors, selector)
+	}
+	return selectors, true
+}
+
+// simpleName reports whether name is a tag or class name that needs no
+// escaping in a selector. An empty tag name stands for any element.
+func simpleName(name string) bool {
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c > 0x7f) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s simpleSelectors) match(tag, class string) bool {
+	for _, selector := range s {
+		if selector.tag != "" && selector.tag != tag {
+			continue
+		}
+		if hasClasses(class, selector.classes) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasClasses reports whether the class attribute class lists every one of
+// want, without splitting it up.
+func hasClasses(class string, want []string) bool {
+	for _, name := range want {
+		found := false
+		for rest := class; rest != "" && !found; {
+			rest = strings.TrimLeft(rest, " \t\n\f\r")
+			end := strings.IndexAny(rest, " \t\n\f\r")
+			if end < 0 {
+				end = len(rest)
+			}
+			found = rest[:end] == name
+			rest = rest[end:]
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// listingSelectors are the selectors brandCars uses, compiled for the
+// listing tokenizer.
+type listingSelectors struct {
+	links, thumbnail, item, price simpleSelectors
+}
+
+// compileListingSelectors compiles the listing selectors of config for the
+// tokenizer, or returns nil if any of them is beyond it.
+func compileListingSelectors(config SelectorConfig) *listingSelectors {
+	var s listingSelectors
+	for _, c := range []struct {
+		group string
+		into  *simpleSelectors
+	}{
+		{config.CarLinks, &s.links},
+		{config.CarThumbnail, &s.thumbnail},
+		{config.CarItem, &s.item},
+		{config.Price, &s.price},
+	} {
+		compiled, ok := compileSimpleSelectors(c.group)
+		if !ok {
+			return nil
+		}
+		*c.into = compiled
+	}
+	return &s
+}
+
+// listingText collects the text of an element as it is read.
+type listingText struct {
+	text []byte
+}
+
+// listingLink is a car link being read.
+type listingLink struct {
+	car       int
+	url       string
+	slug      string
+	text      []byte
+	prices    []*listingText
+	thumbnail *string
+	// thumbnailSeen is set by the first thumbnail inside, with a src or not
+	thumbnailSeen bool
+	item          *listingItem
+}
+
+// listingItem is a car item being read. The cars of its links that had no
+// price inside them get its first price once it closes.
+type listingItem struct {
+	price *listingText
+	cars  []int
+}
+
+// listingElement is an element open while a listing is read.
+type listingElement struct {
+	tag   string
+	link  *listingLink
+	item  *listingItem
+	price *listingText
+}
+
+// impliedEnd lists the elements whose end tag may be left out before
+// another of the same, within the same list, link, paragraph, or table.
+var impliedEnd = map[string]bool{"a": true, "li": true, "p": true, "dt": true, "dd": true, "option": true, "tr": true, "td": true, "th": true}
+
+// impliedEndScope lists the elements that bound the search for an open
+// element a start tag implicitly ends.
+var impliedEndScope = map[string]bool{"ul": true, "ol": true, "dl": true, "table": true, "select": true, "div": true, "article": true}
+
+// listingReader reads a listing page's cars and pagination in one pass of
+// the HTML tokenizer, without building a document.
+type listingReader struct {
+	p           *Parser
+	brandSlug   string
+	listingPath string
+
+	stack []listingElement
+	seen  map[string]bool
+	page  listingPage
+}
+
+// parseListing reads a listing page into its cars and the highest page
+// number it links to, as brandCars and pageCount read a document. Listings
+// are most of what a crawl reads, so when the listing selectors are simple
+// enough this is done with the HTML tokenizer, which skips building a
+// document and cuts a crawl's allocations and CPU; otherwise it falls back
+// to goquery.
+func (p *Parser) parseListing(r io.Reader, brandSlug, listingPath string) (listingPage, error) {
+	if p.listing == nil {
+		doc, err := goquery.NewDocumentFromReader(r)
+		if err != nil {
+			return listingPage{}, err
+		}
+		return listingPage{cars: p.brandCars(doc, brandSlug), pages: p.pageCount(doc, listingPath)}, nil
+	}
+
+	l := &listingReader{
+		p:           p,
+		brandSlug:   brandSlug,
+		listingPath: listingPath,
+		seen:        make(map[string]bool),
+		page:        listingPage{cars: []Car{}, pages: 1},
+	}
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if err := z.Err(); err != io.EOF {
+				return listingPage{}, err
+			}
+			l.pop(0)
+			return l.page, nil
+		case html.TextToken:
+			l.text(z.Text())
+		case html.StartTagToken, html.SelfClosingTagToken:
+			l.start(z)
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			l.end(tagName(name))
+		}
+	}
+}
+
+// tagName interns the names of known elements, which are most of them.
+func tagName(name []byte) string {
+	if a := atom.Lookup(name); a != 0 {
+		return a.String()
+	}
+	return string(name)
+}
+
+// voidElements have no content and no end tag.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+func (l *listingReader) start(z *html.Tokenizer) {
+	name, hasAttr := z.TagName()
+	tag := tagName(name)
+	var href, class, src string
+	var hasHref, hasClass, hasSrc bool
+	for hasAttr {
+		var key, val []byte
+		key, val, hasAttr = z.TagAttr()
+		switch string(key) {
+		case "href":
+			if !hasHref {
+				href, hasHref = string(val), true
+			}
+		case "class":
+			if !hasClass {
+				class, hasClass = string(val), true
+			}
+		case "src":
+			if !hasSrc {
+				src, hasSrc = string(val), true
+			}
+		}
+	}
+
+	if tag == "a" {
+		if n, ok := listingPageNumber(href, l.listingPath); ok && n > l.page.pages {
+			l.page.pages = n
+		}
+	}
+
+	if impliedEnd[tag] {
+		for i := len(l.stack) - 1; i >= 0 && !impliedEndScope[l.stack[i].tag]; i-- {
+			if l.stack[i].tag == tag {
+				l.pop(i)
+				break
+			}
+		}
+	}
+
+	s := l.p.listing
+	if s.thumbnail.match(tag, class) {
+		for _, e := range l.stack {
+			if e.link != nil && !e.link.thumbnailSeen {
+				e.link.thumbnailSeen = true
+				if thumbnail, ok := l.p.absoluteURL(src); hasSrc && ok {
+					e.link.thumbnail = &thumbnail
+				}
+			}
+		}
+	}
+	element := listingElement{tag: tag}
+	if s.price.match(tag, class) {
+		element.price = &listingText{}
+		for _, e := range l.stack {
+			if e.link != nil {
+				e.link.prices = append(e.link.prices, element.price)
+			}
+			if e.item != nil && e.item.price == nil {
+				e.item.price = element.price
+			}
+		}
+	}
+	if voidElements[tag] {
+		return
+	}
+	if s.item.match(tag, class) {
+		element.item = &listingItem{}
+	}
+	if s.links.match(tag, class) {
+		slug, ok := linkSlug(href, l.p.config.CarPath)
+		carURL, allowed := l.p.absoluteURL(href)
+		if ok && allowed && !l.seen[slug] {
+			l.seen[slug] = true
+			element.link = &listingLink{car: len(l.page.cars), url: carURL, slug: slug}
+			l.page.cars = append(l.page.cars, Car{})
+			// The closest item, which may be the link itself
+			if element.item != nil {
+				element.link.item = element.item
+			}
+			for i := len(l.stack) - 1; i >= 0 && element.link.item == nil; i-- {
+				element.link.item = l.stack[i].item
+			}
+		}
+	}
+	l.stack = append(l.stack, element)
+}
+
+func (l *listingReader) text(text []byte) {
+	for _, e := range l.stack {
+		if e.link != nil {
+			e.link.text = append(e.link.text, text...)
+		}
+		if e.price != nil {
+			e.price.text = append(e.price.text, text...)
+		}
+	}
+}
+
+// end closes the innermost open tag element, if there is one.
+func (l *listingReader) end(tag string) {
+	for i := len(l.stack) - 1; i >= 0; i-- {
+		if l.stack[i].tag == tag {
+			l.pop(i)
+			return
+		}
+	}
+}
+
+// pop closes the elements open from depth i on, innermost first.
+func (l *listingReader) pop(i int) {
+	for j := len(l.stack) - 1; j >= i; j-- {
+		e := l.stack[j]
+		if link := e.link; link != nil {
+			var linkPrices, price bytes.Buffer
+			for _, text := range link.prices {
+				linkPrices.Write(text.text)
+			}
+			if len(link.prices) > 0 {
+				price.Write(link.prices[0].text)
+			} else if link.item != nil {
+				link.item.cars = append(link.item.cars, link.car)
+			}
+			l.page.cars[link.car] = l.p.listedCar(l.brandSlug, link.url, link.slug, string(link.text), linkPrices.String(), price.String(), link.thumbnail)
+		}
+		if item := e.item; item != nil && item.price != nil {
+			for _, car := range item.cars {
+				l.page.cars[car].Price = parsePrice(string(item.price.text))
+			}
+		}
+	}
+	l.stack = l.stack[:i]
+}
@@ -0,0 +1,98 @@
+package partasala
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParseListingImpliedEnd checks that the listing tokenizer closes
+// elements whose end tags are left out as an HTML parser would, by
+// comparing it with goquery on the same pages.
+func TestParseListingImpliedEnd(t *testing.T) {
+	tests := []struct {
+		name  string
+		html  string
+		want  []string
+		pages int
+	}{
+		{
+			name: "li ends the li before it",
+			html: `<ul><li><a href="/bilaskra/a/">Car A</a> <span class="price">100.000 kr.</span>
+				<li><a href="/bilaskra/b/">Car B</a> <span class="price">200.000 kr.</span></ul>`,
+			want: []string{"a Car A 100000", "b Car B 200000"},
+		},
+		{
+			name: "li without a price doesn't take the next one's",
+			html: `<ul><li><a href="/bilaskra/a/">Car A</a>
+				<li><a href="/bilaskra/b/">Car B</a> <span class="price">200.000 kr.</span></ul>`,
+			want: []string{"a Car A 0", "b Car B 200000"},
+		},
+		{
+			name: "a ends the a before it",
+			html: `<ul><li><a href="/bilaskra/a/">Car A<a href="/bilaskra/b/">Car B</a></li></ul>`,
+			want: []string{"a Car A 0", "b Car B 0"},
+		},
+		{
+			name: "p ends the p before it",
+			html: `<article><p><a href="/bilaskra/a/">Car A</a><p><span class="price">kr. 5.000</span></article>`,
+			want: []string{"a Car A 5000"},
+		},
+		{
+			name: "nested list bounds the search",
+			html: `<ul><li><a href="/bilaskra/a/">Car A</a><ul><li>Spare parts</ul>
+				<span class="price">300.000 kr.</span></li></ul>`,
+			want: []string{"a Car A 300000"},
+		},
+		{
+			name: "table cells and rows",
+			html: `<table><tr><td><a href="/bilaskra/a/">Car A</a><td><span class="price">1.000 kr.</span>
+				<tr><td><a href="/bilaskra/b/">Car B</a></table>`,
+			want: []string{"a Car A 0", "b Car B 0"},
+		},
+		{
+			name:  "pagination",
+			html:  `<a href="/bilaflokkur/toyota/page/2/">2</a><a href="/bilaflokkur/toyota/page/12/">12</a><a href="/bilaflokkur/kia/page/40/">40</a>`,
+			pages: 12,
+		},
+	}
+
+	p := defaultParser()
+	if p.listing == nil {
+		t.Fatal("the default selectors should be simple enough for the tokenizer")
+	}
+	withGoquery := *p
+	withGoquery.listing = nil
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.pages == 0 {
+				tt.pages = 1
+			}
+			for _, parser := range []struct {
+				name string
+				p    *Parser
+			}{{"tokenizer", p}, {"goquery", &withGoquery}} {
+				page, err := parser.p.parseListing(strings.NewReader(tt.html), "toyota", "/bilaflokkur/toyota/")
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				for _, car := range page.cars {
+					var price int64
+					if car.Price != nil {
+						price = car.Price.Amount
+					}
+					got = append(got, fmt.Sprintf("%s %s %d", car.Slug, car.Name, price))
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("%s: got %q, want %q", parser.name, got, tt.want)
+				}
+				if page.pages != tt.pages {
+					t.Errorf("%s: %d pages, want %d", parser.name, page.pages, tt.pages)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package partasala
+
+import (
+	"regexp"
+	"strings"
+)
+
+// makeAliases are other ways listings write a make, keyed like
+// makeDictionary's keys, with the brand slug they stand for.
+var makeAliases = map[string]string{
+	"vw":        "volkswagen",
+	"benz":      "mercedes-benz",
+	"mercedes":  "mercedes-benz",
+	"merc":      "mercedes-benz",
+	"chevy":     "chevrolet",
+	"landrover": "land-rover",
+}
+
+// modelEnd matches the first token of a name that is no longer part of the
+// model: a year, an engine size, a drivetrain, a fuel, or a gearbox.
+var modelEnd = regexp.MustCompile(`(?i)^(?:(?:19|20)\d\d|\d[.,]\d\w*|\d{3,4}cc|4wd|4x4|awd|fwd|tdi|tsi|tdci|cdi|crdi|hdi|dci|dísil|disel|diesel|bensín|bensin|hybrid|ssk|bsk|sjálfsk\w*|beinsk\w*)$`)
+
+// modelPunctuation is dropped from model tokens, as in "CEE'D".
+var modelPunctuation = strings.NewReplacer("'", "", "’", "")
+
+// makeDictionary maps the ways a name can start with a make to the brand
+// slug. Keys are one to three lower-case name tokens run together without
+// hyphens, so "Mercedes-Benz", "MERCEDES BENZ", and "mercedes-benz" all
+// find "mercedesbenz".
+type makeDictionary struct {
+	keys map[string]string
+}
+
+// newMakeDictionary seeds a dictionary from brands' names and slugs, plus
+// the aliases of those brands.
+func newMakeDictionary(brands []Brand) *makeDictionary {
+	d := &makeDictionary{keys: make(map[string]string)}
+	for _, brand := range brands {
+		d.add(brand.Slug, brand.Name)
+	}
+	return d
+}
+
+func (d *makeDictionary) add(slug, name string) {
+	d.keys[makeKey(strings.Fields(name))] = slug
+	d.keys[makeKey([]string{slug})] = slug
+	for alias, target := range makeAliases {
+		if target == slug {
+			d.keys[alias] = slug
+		}
+	}
+}
+
+func makeKey(tokens []string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.Join(tokens, "")), "-", "")
+}
+
+// split divides a car's name into its make and model, e.g. "toyota" and
+// "land-cruiser-150" for "TOYOTA LAND CRUISER 150 2008 4X4". When brandSlug
+// is known it is the make, whether or not the name starts with it;
+// otherwise the make is looked up in d, which may be nil. Either is nil when
+// not found.
+func (d *makeDictionary) split(name, brandSlug string) (carMake, model *string) {
+	if brandSlug != "" {
+		known := &makeDictionary{keys: make(map[string]string)}
+		if d != nil {
+			for key, slug := range d.keys {
+				if slug == brandSlug {
+					known.keys[key] = slug
+				}
+			}
+		}
+		known.add(brandSlug, strings.ReplaceAll(brandSlug, "-", " "))
+		d = known
+	}
+
+	tokens := strings.Fields(name)
+	made := 0
+	if d != nil {
+		for n := 3; n >= 1 && made == 0; n-- {
+			if n > len(tokens) {
+				continue
+			}
+			if slug, ok := d.keys[makeKey(tokens[:n])]; ok {
+				carMake, made = &slug, n
+			}
+		}
+	}
+	if carMake == nil && brandSlug == "" {
+		return nil, nil
+	}
+	if carMake == nil {
+		carMake = &brandSlug
+	}
+
+	var parts []string
+	for _, token := range tokens[made:] {
+		if token == "-" || token == "–" || modelEnd.MatchString(token) {
+			break
+		}
+		parts = append(parts, strings.ToLower(modelPunctuation.Replace(strings.Trim(token, ",.()"))))
+	}
+	if len(parts) > 0 {
+		slug := strings.Join(parts, "-")
+		model = &slug
+	}
+	return carMake, model
+}
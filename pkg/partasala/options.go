@@ -0,0 +1,76 @@
+package partasala
+
+import (
+	"net/http"
+	"time"
+)
+
+// defaultUserAgent is sent upstream when Config.UserAgent is empty. Some
+// yards' hosting turns away requests that don't look like a browser.
+const defaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36"
+
+// An Option changes how New sets up a Scraper, on top of its Config, for
+// programs that embed the scraper and would rather not fill in a Config
+// field by field.
+type Option func(*options)
+
+type options struct {
+	config Config
+	client *http.Client
+}
+
+// NewPartasalaScraper creates a Scraper for partasala.is with DefaultConfig,
+// as changed by opts. Programs that need settings the options don't cover
+// pass a Config to New instead.
+func NewPartasalaScraper(opts ...Option) *Scraper {
+	return New(DefaultConfig(), opts...)
+}
+
+// WithBaseURL points the scraper at another site running the same theme,
+// or at a test server.
+func WithBaseURL(url string) Option {
+	return func(o *options) { o.config.BaseURL = url }
+}
+
+// WithHTTPClient sends upstream requests with client's Transport, and keeps
+// cookies in its Jar if it has one, instead of the scraper's own
+// connection pool. The scraper's caches, limits, and circuit breaker still
+// sit in front of it, and its timeouts still apply, so client.Timeout is
+// best left unset. A Transport that answers from memory makes the scraper
+// testable without a network.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) { o.client = client }
+}
+
+// WithTimeout sets the deadline of every kind of upstream request, where
+// Config.Timeouts sets them one by one.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.config.Timeouts = TimeoutConfig{
+			Brands:    Duration{timeout},
+			BrandCars: Duration{timeout},
+			Details:   Duration{timeout},
+			Contact:   Duration{timeout},
+		}
+	}
+}
+
+// WithUserAgent sets the User-Agent of upstream requests.
+func WithUserAgent(userAgent string) Option {
+	return func(o *options) { o.config.UserAgent = userAgent }
+}
+
+// WithCache keeps fetched pages under dir and serves them from there until
+// they are older than ttl.
+func WithCache(dir string, ttl time.Duration) Option {
+	return func(o *options) { o.config.Cache = DiskCacheConfig{Dir: dir, TTL: Duration{ttl}} }
+}
+
+// WithRateLimit caps the upstream requests in flight at once, and those
+// made per day, 0 meaning no daily cap.
+func WithRateLimit(maxConcurrency, dailyRequestBudget int) Option {
+	return func(o *options) {
+		o.config.MaxConcurrency = maxConcurrency
+		o.config.DailyRequestBudget = dailyRequestBudget
+	}
+}
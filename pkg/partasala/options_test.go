@@ -0,0 +1,129 @@
+package partasala
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const optionsHomePage = `<ul><li><a href="/bilaflokkur/toyota/">Toyota</a></li>
+	<li><a href="/bilaflokkur/audi/">Audi</a></li></ul>`
+
+// optionsServer serves optionsHomePage, counting the requests it gets and
+// keeping the User-Agent of the last one.
+func optionsServer(t *testing.T) (server *httptest.Server, requests *atomic.Int64, userAgent *atomic.Value) {
+	requests, userAgent = &atomic.Int64{}, &atomic.Value{}
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		userAgent.Store(r.Header.Get("User-Agent"))
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(optionsHomePage))
+	}))
+	t.Cleanup(server.Close)
+	return server, requests, userAgent
+}
+
+// TestNewPartasalaScraperDefaults checks that the options go on top of
+// DefaultConfig rather than a zero Config.
+func TestNewPartasalaScraperDefaults(t *testing.T) {
+	s := NewPartasalaScraper(WithUserAgent("test"))
+	want := DefaultConfig()
+	if s.config.BaseURL != want.BaseURL || s.config.Timeouts != want.Timeouts || s.config.MaxConcurrency != want.MaxConcurrency {
+		t.Errorf("config = %+v, want DefaultConfig's base URL, timeouts, and concurrency", s.config)
+	}
+	if s.config.UserAgent != "test" {
+		t.Errorf("UserAgent = %q, want %q", s.config.UserAgent, "test")
+	}
+}
+
+func TestWithBaseURLAndHTTPClient(t *testing.T) {
+	server, requests, _ := optionsServer(t)
+	s := NewPartasalaScraper(WithBaseURL(server.URL), WithHTTPClient(server.Client()))
+	brands, err := s.GetBrands()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(brands) != 2 {
+		t.Errorf("got %d brands, want 2: %+v", len(brands), brands)
+	}
+	if requests.Load() == 0 {
+		t.Error("no request reached the test server")
+	}
+}
+
+func TestWithUserAgent(t *testing.T) {
+	server, _, userAgent := optionsServer(t)
+	s := NewPartasalaScraper(WithBaseURL(server.URL), WithHTTPClient(server.Client()), WithUserAgent("inventory-sync/1.0"))
+	if _, err := s.GetBrands(); err != nil {
+		t.Fatal(err)
+	}
+	if got := userAgent.Load(); got != "inventory-sync/1.0" {
+		t.Errorf("User-Agent = %q, want %q", got, "inventory-sync/1.0")
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	s := NewPartasalaScraper(WithBaseURL(server.URL), WithHTTPClient(server.Client()), WithTimeout(50*time.Millisecond))
+	if s.config.Timeouts.Details.Duration != 50*time.Millisecond {
+		t.Errorf("Timeouts = %+v, want 50ms each", s.config.Timeouts)
+	}
+	started := time.Now()
+	if _, err := s.GetBrands(); err == nil {
+		t.Fatal("GetBrands succeeded against a server that never answers")
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("GetBrands took %s to time out", elapsed)
+	}
+}
+
+func TestWithCache(t *testing.T) {
+	server, requests, _ := optionsServer(t)
+	dir := t.TempDir()
+	s := NewPartasalaScraper(WithBaseURL(server.URL), WithHTTPClient(server.Client()), WithCache(dir, time.Hour))
+	if _, err := s.GetBrands(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another scraper on the same directory is answered from it
+	fetched := requests.Load()
+	cached := NewPartasalaScraper(WithBaseURL(server.URL), WithHTTPClient(server.Client()), WithCache(dir, time.Hour))
+	brands, err := cached.GetBrands()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(brands) != 2 {
+		t.Errorf("got %d brands from the cache, want 2", len(brands))
+	}
+	if requests.Load() != fetched {
+		t.Errorf("the cached page was fetched again")
+	}
+}
+
+func TestWithRateLimit(t *testing.T) {
+	server, requests, _ := optionsServer(t)
+	s := NewPartasalaScraper(WithBaseURL(server.URL), WithHTTPClient(server.Client()), WithRateLimit(1, 1))
+	if s.config.MaxConcurrency != 1 || s.config.DailyRequestBudget != 1 {
+		t.Errorf("MaxConcurrency, DailyRequestBudget = %d, %d, want 1, 1", s.config.MaxConcurrency, s.config.DailyRequestBudget)
+	}
+	if _, err := s.GetBrandCars("toyota"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetBrandCars("audi"); err == nil {
+		t.Error("a second request went through a daily budget of 1")
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("the server got %d requests, want 1", got)
+	}
+}
@@ -0,0 +1,102 @@
+package partasala
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// MaxOutcomeWindow is the longest window UpstreamOutcomes can look back.
+const MaxOutcomeWindow = time.Hour
+
+// UpstreamOutcomes counts upstream requests and how many of them failed.
+type UpstreamOutcomes struct {
+	Requests int `json:"requests"`
+	Failures int `json:"failures"`
+}
+
+// ErrorRate is Failures over Requests, or 0 without requests.
+func (o UpstreamOutcomes) ErrorRate() float64 {
+	if o.Requests == 0 {
+		return 0
+	}
+	return float64(o.Failures) / float64(o.Requests)
+}
+
+// outcomeWindow keeps per-minute counts of upstream outcomes for the last
+// MaxOutcomeWindow, and when a request last succeeded.
+type outcomeWindow struct {
+	mu          sync.Mutex
+	minutes     map[int64]UpstreamOutcomes
+	lastSuccess time.Time
+}
+
+func newOutcomeWindow() *outcomeWindow {
+	return &outcomeWindow{minutes: make(map[int64]UpstreamOutcomes)}
+}
+
+func (w *outcomeWindow) record(now time.Time, failed bool) {
+	minute := now.Unix() / 60
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	counts := w.minutes[minute]
+	counts.Requests++
+	if failed {
+		counts.Failures++
+	} else {
+		w.lastSuccess = now
+	}
+	w.minutes[minute] = counts
+	for m := range w.minutes {
+		if m <= minute-int64(MaxOutcomeWindow/time.Minute) {
+			delete(w.minutes, m)
+		}
+	}
+}
+
+// since sums the outcomes of the whole minutes covering the last window.
+func (w *outcomeWindow) since(now time.Time, window time.Duration) UpstreamOutcomes {
+	first := (now.Add(-window).Unix() + 59) / 60
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var total UpstreamOutcomes
+	for m, counts := range w.minutes {
+		if m >= first {
+			total.Requests += counts.Requests
+			total.Failures += counts.Failures
+		}
+	}
+	return total
+}
+
+func (w *outcomeWindow) lastSucceeded() time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastSuccess
+}
+
+// outcomeTransport records whether each upstream request succeeded. Network
+// errors, 5xx responses, and 403 and 429, which are how a site blocks a
+// scraper, count as failures, as do requests refused by an open circuit
+// breaker. Requests the caller gave up on aren't counted.
+type outcomeTransport struct {
+	next   http.RoundTripper
+	window *outcomeWindow
+}
+
+func (t *outcomeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	switch {
+	case err != nil && errors.Is(req.Context().Err(), context.Canceled):
+	case err != nil:
+		t.window.record(time.Now(), true)
+	default:
+		failed := resp.StatusCode >= 500 || resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests
+		t.window.record(time.Now(), failed)
+	}
+	return resp, err
+}
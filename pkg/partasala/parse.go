@@ -0,0 +1,595 @@
+package partasala
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Parser extracts brands, cars, and car details from pages of a site laid
+// out as its Config describes. It does no I/O of its own, so it works just
+// as well on archived or saved pages, and is safe for concurrent use.
+type Parser struct {
+	baseURL string
+	// base is baseURL parsed, which hrefs are resolved against
+	base      *url.URL
+	config    Config
+	selectors *compiledSelectors
+	// listing is what parseListing's tokenizer matches listings with, or
+	// nil when the selectors are beyond it
+	listing *listingSelectors
+	// taxonomyPatterns match the term links of config.Taxonomies, in order
+	taxonomyPatterns []*regexp.Regexp
+}
+
+// NewParser creates a Parser for the site described by config.
+func NewParser(config Config) (*Parser, error) {
+	selectors, err := compileSelectors(config.Selectors)
+	if err != nil {
+		return nil, err
+	}
+	return newParser(config, selectors), nil
+}
+
+func newParser(config Config, selectors *compiledSelectors) *Parser {
+	baseURL := strings.TrimRight(config.BaseURL, "/")
+	base, err := url.Parse(baseURL + "/")
+	if err != nil {
+		base = &url.URL{}
+	}
+	return &Parser{
+		baseURL:   baseURL,
+		base:      base,
+		config:    config,
+		selectors: selectors,
+		listing:   compileListingSelectors(selectors.SelectorConfig),
+
+		taxonomyPatterns: taxonomyPatterns(config.Taxonomies),
+	}
+}
+
+// ParseBrands parses a partasala.is homepage, as DefaultConfig describes it.
+func ParseBrands(r io.Reader) ([]Brand, error) {
+	return defaultParser().ParseBrands(r)
+}
+
+// ParseBrandCars parses the partasala.is listing page of brandSlug.
+func ParseBrandCars(r io.Reader, brandSlug string) ([]Car, error) {
+	return defaultParser().ParseBrandCars(r, brandSlug)
+}
+
+// ParseCarDetails parses the partasala.is detail page of carSlug.
+func ParseCarDetails(r io.Reader, carSlug string) (*CarDetails, error) {
+	return defaultParser().ParseCarDetails(r, carSlug)
+}
+
+func defaultParser() *Parser {
+	// The default selectors always compile
+	p, _ := NewParser(DefaultConfig())
+	return p
+}
+
+// ParseBrands parses the homepage into its brands, sorted by name.
+func (p *Parser) ParseBrands(r io.Reader) ([]Brand, error) {
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return p.brands(doc), nil
+}
+
+// ParseBrandCars parses the listing page of brandSlug into its cars.
+func (p *Parser) ParseBrandCars(r io.Reader, brandSlug string) ([]Car, error) {
+	page, err := p.parseListing(r, brandSlug, p.config.BrandPath+brandSlug+"/")
+	if err != nil {
+		return nil, err
+	}
+	return page.cars, nil
+}
+
+// ParseCarDetails parses the detail page of carSlug.
+func (p *Parser) ParseCarDetails(r io.Reader, carSlug string) (*CarDetails, error) {
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return nil, err
+	}
+	details := p.carDetails(doc, carSlug)
+	return &details, nil
+}
+
+func (p *Parser) brandURL(brandSlug string) string {
+	return fmt.Sprintf("%s%s%s/", p.baseURL, p.config.BrandPath, brandSlug)
+}
+
+// listingPageURL is the URL of page n of the listing at listingURL, as
+// WordPress paginates archives.
+func (p *Parser) listingPageURL(listingURL string, n int) string {
+	if n <= 1 {
+		return listingURL
+	}
+	return fmt.Sprintf("%spage/%d/", listingURL, n)
+}
+
+// archiveURL is the URL of the site-wide car archive.
+func (p *Parser) archiveURL() string {
+	return p.baseURL + p.config.CarPath
+}
+
+func (p *Parser) carURL(carSlug string) string {
+	return fmt.Sprintf("%s%s%s/", p.baseURL, p.config.CarPath, carSlug)
+}
+
+func (p *Parser) brands(doc *goquery.Document) []Brand {
+	brands := []Brand{}
+	seenBrands := make(map[string]bool)
+
+	doc.Find(p.selectors.BrandLinks).Each(func(i int, sel *goquery.Selection) {
+		href, _ := sel.Attr("href")
+		brandSlug, ok := linkSlug(href, p.config.BrandPath)
+		if !ok {
+			return
+		}
+		brandURL, ok := p.absoluteURL(href)
+		if !ok {
+			return
+		}
+
+		// Avoid duplicates
+		if seenBrands[brandSlug] {
+			return
+		}
+		seenBrands[brandSlug] = true
+
+		brandName := norm.NFC.String(strings.TrimSpace(sel.Text()))
+
+		brands = append(brands, Brand{
+			Name: brandName,
+			Slug: brandSlug,
+			URL:  brandURL,
+		})
+	})
+
+	// Sort by name
+	sort.Slice(brands, func(i, j int) bool {
+		return brands[i].Name < brands[j].Name
+	})
+
+	return brands
+}
+
+func (p *Parser) brandCars(doc *goquery.Document, brandSlug string) []Car {
+	cars := []Car{}
+	seenCars := make(map[string]bool)
+
+	doc.Find(p.selectors.CarLinks).Each(func(i int, sel *goquery.Selection) {
+		href, _ := sel.Attr("href")
+		carSlug, ok := linkSlug(href, p.config.CarPath)
+		if !ok {
+			return
+		}
+		carURL, ok := p.absoluteURL(href)
+		if !ok {
+			return
+		}
+
+		// Avoid duplicates
+		if seenCars[carSlug] {
+			return
+		}
+		seenCars[carSlug] = true
+
+		// Prices sit inside the link, where they are not part of the name,
+		// or next to it in the car's item
+		linkPrices := ""
+		price := sel.Find(p.selectors.Price)
+		if price.Length() > 0 {
+			linkPrices = price.Text()
+		} else {
+			price = sel.Closest(p.selectors.CarItem).Find(p.selectors.Price)
+		}
+
+		// Try to find thumbnail image
+		var thumbnail *string
+		img := sel.Find(p.selectors.CarThumbnail)
+		if imgSrc, exists := img.Attr("src"); exists {
+			if absoluteURL, ok := p.absoluteURL(imgSrc); ok {
+				thumbnail = &absoluteURL
+			}
+		}
+
+		cars = append(cars, p.listedCar(brandSlug, carURL, carSlug, sel.Text(), linkPrices, price.First().Text(), thumbnail))
+	})
+
+	return cars
+}
+
+// listedCar is the car a listing's link to carSlug, at carURL, stands for,
+// given the link's text, the text of the prices inside it, the text of the car's
+// price, and its thumbnail. Names are kept in NFC, so letters typed with
+// combining accents compare equal to precomposed ones.
+func (p *Parser) listedCar(brandSlug, carURL, carSlug, text, linkPrices, price string, thumbnail *string) Car {
+	carName := norm.NFC.String(strings.TrimSpace(text))
+	if linkPrices != "" {
+		carName = strings.TrimSpace(strings.Replace(carName, linkPrices, "", 1))
+	}
+	carMake, model := (*makeDictionary)(nil).split(carName, brandSlug)
+	return Car{
+		Name:      carName,
+		Slug:      carSlug,
+		URL:       carURL,
+		Thumbnail: thumbnail,
+		Brand:     brandSlug,
+		Make:      carMake,
+		Model:     model,
+		Year:      ModelYear(carName),
+		Price:     parsePrice(price),
+	}
+}
+
+// linkSlug returns the slug of a link to a page under path, such as
+// "/bilaskra/", or false if href doesn't link to one.
+func linkSlug(href, path string) (string, bool) {
+	href = strings.TrimSuffix(href, "/")
+	i := strings.LastIndex(href, path)
+	if i < 0 {
+		return "", false
+	}
+	slug := href[i+len(path):]
+	if slug == "" || strings.Contains(slug, "/") {
+		return "", false
+	}
+	return slug, true
+}
+
+// pageCount returns the highest page of the listing at listingPath, such
+// as "/bilaflokkur/toyota/", that doc links to, or 1 if it has no
+// pagination links. Listings with many pages link only a few around the
+// current one, so later pages can raise it.
+func (p *Parser) pageCount(doc *goquery.Document, listingPath string) int {
+	count := 1
+	doc.Find("a").Each(func(i int, sel *goquery.Selection) {
+		href, _ := sel.Attr("href")
+		if n, ok := listingPageNumber(href, listingPath); ok && n > count {
+			count = n
+		}
+	})
+	return count
+}
+
+// listingPageNumber returns the page number of a link to a page of the
+// listing at listingPath, or false if href isn't one.
+func listingPageNumber(href, listingPath string) (int, bool) {
+	href = strings.TrimSuffix(href, "/")
+	prefix := listingPath + "page/"
+	i := strings.LastIndex(href, prefix)
+	if i < 0 {
+		return 0, false
+	}
+	digits := href[i+len(prefix):]
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(digits)
+	return n, err == nil
+}
+
+func (p *Parser) carDetails(doc *goquery.Document, carSlug string) CarDetails {
+	// Extract car name
+	var carName string
+	doc.Find(p.selectors.CarTitle).Each(func(i int, sel *goquery.Selection) {
+		if i == 0 {
+			carName = norm.NFC.String(strings.TrimSpace(sel.Text()))
+		}
+	})
+
+	// Extract description, as plain text, sanitized HTML, and Markdown
+	var description, descriptionHTML, descriptionMarkdown *string
+	if sel := p.descriptionElement(doc); sel != nil {
+		if text := descriptionText(sel); text != "" {
+			markup, markdown := p.descriptionHTML(sel), p.descriptionMarkdown(sel)
+			description, descriptionHTML, descriptionMarkdown = &text, &markup, &markdown
+		}
+	}
+
+	// Extract brand/category
+	var brand *string
+	doc.Find(fmt.Sprintf("a[href*='%s']", p.config.BrandPath)).Each(func(i int, sel *goquery.Selection) {
+		if i == 0 {
+			brandName := strings.TrimSpace(sel.Text())
+			brand = &brandName
+		}
+	})
+
+	// Extract price, from its own element or from a "Verð" line in the
+	// description
+	price := parsePrice(doc.Find(p.selectors.Price).First().Text())
+	if price == nil && description != nil {
+		if match := priceLinePattern.FindStringSubmatch(*description); match != nil {
+			price = parsePrice(match[1])
+		}
+	}
+
+	// Extract the registration number, from anywhere on the page as tables
+	// of specifications often hold it
+	var plate *string
+	if match := platePattern.FindStringSubmatch(doc.Find("body").Text()); match != nil {
+		number := match[1] + match[2]
+		plate = &number
+	}
+
+	vin := findVIN(doc.Find("body").Text())
+
+	posted, updated := postDates(doc)
+
+	images := p.images(doc)
+
+	var warnings []string
+	if carName == "" {
+		warnings = append(warnings, WarningNameNotFound)
+	}
+	if description == nil {
+		warnings = append(warnings, WarningDescriptionNotFound)
+	}
+	if len(images) == 0 {
+		warnings = append(warnings, WarningImagesNotFound)
+	}
+
+	return CarDetails{
+		Name:                carName,
+		Slug:                carSlug,
+		URL:                 p.carURL(carSlug),
+		Brand:               brand,
+		Description:         description,
+		DescriptionHTML:     descriptionHTML,
+		DescriptionMarkdown: descriptionMarkdown,
+		Price:               price,
+		Plate:               plate,
+		VIN:                 vin,
+		ImageCount:          len(images),
+		Images:              images,
+		PostedAt:            posted,
+		UpdatedAt:           updated,
+		Warnings:            warnings,
+	}
+}
+
+// images collects upload images from img tags and from links that point
+// directly at images, de-duplicated by full-size URL.
+func (p *Parser) images(doc *goquery.Document) []Image {
+	images := []Image{}
+	seenImages := make(map[string]bool)
+
+	// Look for img tags
+	doc.Find(p.selectors.Images).Each(func(i int, sel *goquery.Selection) {
+		src, exists := sel.Attr("src")
+		if !exists || !strings.Contains(src, p.selectors.UploadsMarker) || strings.Contains(strings.ToLower(src), "logo") {
+			return
+		}
+
+		// Get full-size image URL (remove size suffixes like -300x300)
+		fullSrc := p.selectors.sizeSuffix.ReplaceAllString(src, ".$1")
+		fullURL, ok := p.absoluteURL(fullSrc)
+		thumbnail, thumbnailOK := p.absoluteURL(src)
+		if !ok || !thumbnailOK || seenImages[fullURL] {
+			return
+		}
+		seenImages[fullURL] = true
+
+		srcset, _ := sel.Attr("srcset")
+		images = append(images, Image{
+			URL:       fullURL,
+			Thumbnail: thumbnail,
+			Variants:  p.imageVariants(src, srcset),
+		})
+	})
+
+	// Also look for links to images
+	doc.Find(p.selectors.ImageLinks).Each(func(i int, sel *goquery.Selection) {
+		href, exists := sel.Attr("href")
+		if !exists || !p.selectors.imageLink.MatchString(strings.ToLower(href)) {
+			return
+		}
+
+		if !strings.Contains(href, p.selectors.UploadsMarker) {
+			return
+		}
+
+		fullURL, ok := p.absoluteURL(href)
+		if !ok || seenImages[fullURL] {
+			return
+		}
+		seenImages[fullURL] = true
+
+		images = append(images, Image{
+			URL:       fullURL,
+			Thumbnail: fullURL,
+		})
+	})
+
+	return images
+}
+
+// imageVariants lists the sizes a picture is offered in, smallest first:
+// the candidates of its srcset that give a width, and src when its size
+// suffix does, as WordPress names resized uploads.
+func (p *Parser) imageVariants(src, srcset string) []ImageVariant {
+	var variants []ImageVariant
+	seen := make(map[string]bool)
+	add := func(href string, width int) {
+		url, ok := p.absoluteURL(href)
+		if ok && !seen[url] {
+			seen[url] = true
+			variants = append(variants, ImageVariant{Width: width, URL: url})
+		}
+	}
+
+	for _, candidate := range strings.Split(srcset, ",") {
+		// Density descriptors like 2x say nothing of the width
+		fields := strings.Fields(candidate)
+		if len(fields) != 2 || !strings.HasSuffix(fields[1], "w") {
+			continue
+		}
+		if width, err := strconv.Atoi(strings.TrimSuffix(fields[1], "w")); err == nil && width > 0 {
+			add(fields[0], width)
+		}
+	}
+	var width, height int
+	if suffix := p.selectors.sizeSuffix.FindString(src); suffix != "" {
+		if _, err := fmt.Sscanf(suffix, "-%dx%d", &width, &height); err == nil && width > 0 {
+			add(src, width)
+		}
+	}
+
+	sort.SliceStable(variants, func(i, j int) bool {
+		return variants[i].Width < variants[j].Width
+	})
+	return variants
+}
+
+// absoluteURL resolves href, as found on a page, against the site's base
+// URL. It reports false for hrefs that don't parse, aren't http or https,
+// or point at a host other than the site's and Config.AllowedHosts, so a
+// malformed or protocol-relative href can't send clients off the site.
+func (p *Parser) absoluteURL(href string) (string, bool) {
+	ref, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return "", false
+	}
+	u := p.base.ResolveReference(ref)
+	if (u.Scheme != "http" && u.Scheme != "https") || !p.allowedHost(u) {
+		return "", false
+	}
+	return u.String(), true
+}
+
+// allowedHost reports whether u is on the site's host or one of
+// Config.AllowedHosts, where "*.example.com" stands for any subdomain of
+// example.com.
+func (p *Parser) allowedHost(u *url.URL) bool {
+	if strings.EqualFold(u.Host, p.base.Host) {
+		return true
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range p.config.AllowedHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || strings.EqualFold(u.Host, allowed) ||
+			(strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+var yearPattern = regexp.MustCompile(`\b(19[5-9]\d|20[0-4]\d)\b`)
+
+// ModelYear returns the first plausible model year found in text, such as
+// 2014 in "TOYOTA YARIS 2014", or nil.
+func ModelYear(text string) *int {
+	match := yearPattern.FindString(text)
+	if match == "" {
+		return nil
+	}
+
+	year, err := strconv.Atoi(match)
+	if err != nil {
+		return nil
+	}
+	return &year
+}
+
+// pricePattern matches an ISK amount the ways Icelandic sites write them:
+// "45.000 kr.", "kr. 45.000", "45 000 ISK", or "45.000,-". Dots and spaces
+// separate thousands.
+var pricePattern = regexp.MustCompile(`(?i)(?:\bkr\.?|\bisk)[\s\x{00a0}]*(\d{1,3}(?:[.\s\x{00a0}]\d{3})+|\d+)|(\d{1,3}(?:[.\s\x{00a0}]\d{3})+|\d+)(?:,\d{1,2})?[\s\x{00a0}]*(?:kr\b|kr\.|krónur|isk\b|,-)`)
+
+// priceLinePattern finds the rest of a "Verð: ..." line in a description.
+var priceLinePattern = regexp.MustCompile(`(?i)verð\s*:?\s*([^\n]+)`)
+
+// parsePrice reads the first ISK amount in text, or returns nil if there
+// is none.
+func parsePrice(text string) *Price {
+	match := pricePattern.FindStringSubmatch(text)
+	if match == nil {
+		return nil
+	}
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, match[1]+match[2])
+	amount, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &Price{Amount: amount, Currency: "ISK"}
+}
+
+// platePattern matches an Icelandic registration number after a label such
+// as "Skráningarnúmer:" or "Bílnúmer", e.g. "AB123", "AB-123", or "AB C12".
+// Bare numbers are not matched, since model names like "ML 320" look the
+// same.
+var platePattern = regexp.MustCompile(`(?i:skráningarnúmer|skráningarnr\.?|bílnúmer|fastanúmer|númeraplata)\s*[:.]?\s*([A-ZÞÆÖ]{2})[\s\x{00a0}-]?([A-ZÞÆÖ0-9]\d{2})\b`)
+
+// NormalizePlate writes a registration number the way CarDetails.Plate
+// holds it: upper case, without spaces or hyphens.
+func NormalizePlate(plate string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", "-", "", "\u00a0", "").Replace(plate))
+}
+
+var (
+	// vinPattern matches 17 characters a VIN can hold, which leaves out I,
+	// O, and Q, optionally labelled
+	vinPattern = regexp.MustCompile(`(?:(?i:\b(vin|verksmiðjunúmer|verksm\.\s*nr\.?))\s*[:.]?\s*|\b)([A-HJ-NPR-Z0-9]{17})\b`)
+
+	vinWeights = [17]int{8, 7, 6, 5, 4, 3, 2, 10, 0, 9, 8, 7, 6, 5, 4, 3, 2}
+)
+
+// findVIN returns the first vehicle identification number in text. A VIN
+// that the text labels as one is taken as it is; European makers don't use
+// the check digit. Any other run of 17 characters has to pass the check
+// digit, so part numbers are not mistaken for VINs.
+func findVIN(text string) *string {
+	for _, match := range vinPattern.FindAllStringSubmatch(strings.ToUpper(text), -1) {
+		vin := match[2]
+		if !strings.ContainsAny(vin, "0123456789") || !strings.ContainsAny(vin, "ABCDEFGHJKLMNPRSTUVWXYZ") {
+			continue
+		}
+		if match[1] != "" || validVINCheckDigit(vin) {
+			return &vin
+		}
+	}
+	return nil
+}
+
+// validVINCheckDigit reports whether the ninth character of vin is the
+// check digit of the others, as ISO 3779 has it for North America.
+func validVINCheckDigit(vin string) bool {
+	sum := 0
+	for i, c := range vin {
+		var value int
+		switch {
+		case c >= '0' && c <= '9':
+			value = int(c - '0')
+		case c >= 'A' && c <= 'I':
+			value = int(c-'A') + 1
+		case c >= 'J' && c <= 'R':
+			value = int(c-'J') + 1
+		case c >= 'S' && c <= 'Z':
+			value = int(c-'S') + 2
+		}
+		sum += value * vinWeights[i]
+	}
+	check := byte('0' + sum%11)
+	if sum%11 == 10 {
+		check = 'X'
+	}
+	return vin[8] == check
+}
@@ -0,0 +1,326 @@
+package partasala
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// corpusDir is the saved pages and golden files verify-parsers checks.
+const corpusDir = "../../testdata/parsers"
+
+// TestGoldenCorpus parses every page of the corpus, as verify-parsers
+// does, and compares the result with its golden file.
+func TestGoldenCorpus(t *testing.T) {
+	config := DefaultConfig()
+	parser, err := NewParser(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pagesDir := filepath.Join(corpusDir, "pages")
+	checked := 0
+	err = filepath.Walk(pagesDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || info.Name() != "index.html" {
+			return err
+		}
+		rel, _ := filepath.Rel(pagesDir, filepath.Dir(path))
+		page := "/" + filepath.ToSlash(rel) + "/"
+		if rel == "." {
+			page = "/"
+		}
+
+		t.Run(page, func(t *testing.T) {
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			var golden string
+			var parsed interface{}
+			switch {
+			case page == "/":
+				golden = "brands.json"
+				parsed, err = parser.ParseBrands(f)
+			case page == config.ContactPath:
+				golden = "contact.json"
+				parsed, err = parser.ParseContact(f)
+			case strings.HasPrefix(page, config.BrandPath):
+				slug := strings.Trim(strings.TrimPrefix(page, config.BrandPath), "/")
+				golden = filepath.Join("brand_cars", slug+".json")
+				parsed, err = parser.ParseBrandCars(f, slug)
+			case strings.HasPrefix(page, config.CarPath):
+				slug := strings.Trim(strings.TrimPrefix(page, config.CarPath), "/")
+				golden = filepath.Join("details", slug+".json")
+				parsed, err = parser.ParseCarDetails(f, slug)
+			default:
+				t.Fatalf("not the homepage, a brand page, a car page, or the contact page")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want, err := os.ReadFile(filepath.Join(corpusDir, "golden", golden))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.Marshal(parsed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var wantValue, gotValue interface{}
+			if err := json.Unmarshal(want, &wantValue); err != nil {
+				t.Fatalf("%s: %v", golden, err)
+			}
+			json.Unmarshal(got, &gotValue)
+			if !reflect.DeepEqual(wantValue, gotValue) {
+				t.Errorf("output differs from %s (run go run . verify-parsers for the first difference)\ngot: %s", golden, got)
+			}
+		})
+		checked++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked == 0 {
+		t.Fatalf("no pages in %s", pagesDir)
+	}
+}
+
+func TestParseBrands(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want []Brand
+	}{
+		{
+			name: "sorted by name",
+			html: `<ul><li><a href="https://partasala.is/bilaflokkur/toyota/">Toyota</a></li>
+				<li><a href="/bilaflokkur/audi/">Audi</a></li></ul>`,
+			want: []Brand{
+				{Name: "Audi", Slug: "audi", URL: "https://partasala.is/bilaflokkur/audi/"},
+				{Name: "Toyota", Slug: "toyota", URL: "https://partasala.is/bilaflokkur/toyota/"},
+			},
+		},
+		{
+			name: "duplicates and other links left out",
+			html: `<a href="/bilaflokkur/kia/">Kia</a><a href="/bilaflokkur/kia/">Kia again</a>
+				<a href="/hafa-samband/">Hafa samband</a><a href="/bilaflokkur/">All</a>
+				<a href="/bilaskra/kia-rio-2009/">Kia Rio</a>`,
+			want: []Brand{{Name: "Kia", Slug: "kia", URL: "https://partasala.is/bilaflokkur/kia/"}},
+		},
+		{
+			name: "names composed",
+			html: `<a href="/bilaflokkur/skoda/">Sko` + "ď" + `a </a>`,
+			want: []Brand{{Name: "Skoďa", Slug: "skoda", URL: "https://partasala.is/bilaflokkur/skoda/"}},
+		},
+		{
+			name: "no brands",
+			html: `<p>Nothing here</p>`,
+			want: []Brand{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseBrands(strings.NewReader(tt.html))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBrandCars(t *testing.T) {
+	type car struct {
+		name, slug string
+		price      int64
+		thumbnail  string
+	}
+	tests := []struct {
+		name string
+		html string
+		want []car
+	}{
+		{
+			name: "price inside the link",
+			html: `<a href="/bilaskra/toyota-yaris-2014/"><img src="/img/yaris.jpg">Toyota Yaris 2014 <span class="price">350.000 kr.</span></a>`,
+			want: []car{{"Toyota Yaris 2014", "toyota-yaris-2014", 350000, "https://partasala.is/img/yaris.jpg"}},
+		},
+		{
+			name: "price next to the link in its item",
+			html: `<article><a href="/bilaskra/toyota-corolla/">Toyota Corolla</a><span class="price">kr. 120.000</span></article>
+				<article><a href="/bilaskra/toyota-avensis/">Toyota Avensis</a></article>`,
+			want: []car{{"Toyota Corolla", "toyota-corolla", 120000, ""}, {"Toyota Avensis", "toyota-avensis", 0, ""}},
+		},
+		{
+			name: "duplicates and other links left out",
+			html: `<a href="/bilaskra/toyota-rav4/"><img src="/rav4.jpg"></a><a href="/bilaskra/toyota-rav4/">Toyota RAV4</a>
+				<a href="/bilaflokkur/toyota/page/2/">2</a>`,
+			want: []car{{"", "toyota-rav4", 0, "https://partasala.is/rav4.jpg"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cars, err := ParseBrandCars(strings.NewReader(tt.html), "toyota")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []car
+			for _, c := range cars {
+				if c.Brand != "toyota" || c.URL != "https://partasala.is/bilaskra/"+c.Slug+"/" {
+					t.Errorf("%s: brand %q, url %q", c.Slug, c.Brand, c.URL)
+				}
+				g := car{name: c.Name, slug: c.Slug}
+				if c.Price != nil {
+					g.price = c.Price.Amount
+				}
+				if c.Thumbnail != nil {
+					g.thumbnail = *c.Thumbnail
+				}
+				got = append(got, g)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCarDetails(t *testing.T) {
+	tests := []struct {
+		name       string
+		html       string
+		wantName   string
+		wantBrand  string
+		wantPrice  int64
+		wantPlate  string
+		wantVIN    string
+		wantImages int
+	}{
+		{
+			name: "full page",
+			html: `<h1>Toyota Yaris 2014</h1><a href="/bilaflokkur/toyota/">Toyota</a>
+				<div class="entry-content"><p>Verð: 350.000 kr.</p>
+				<p>Skráningarnúmer: AB-123</p>
+				<p>VIN: JTDKW923X05012345</p>
+				<img src="/wp-content/uploads/yaris-1.jpg"><img src="/wp-content/uploads/yaris-2.jpg"></div>`,
+			wantName:   "Toyota Yaris 2014",
+			wantBrand:  "Toyota",
+			wantPrice:  350000,
+			wantPlate:  "AB123",
+			wantVIN:    "JTDKW923X05012345",
+			wantImages: 2,
+		},
+		{
+			name: "unlabelled VIN needs its check digit",
+			html: `<h1>Ford F-150</h1>
+				<div class="entry-content"><p>1M8GDM9AXKP042788 and part 1M8GDM9A1KP042788</p></div>`,
+			wantName: "Ford F-150",
+			wantVIN:  "1M8GDM9AXKP042788",
+		},
+		{
+			name:     "nothing but a title",
+			html:     `<h1>Kia Rio</h1>`,
+			wantName: "Kia Rio",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details, err := ParseCarDetails(strings.NewReader(tt.html), "car")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if details.Name != tt.wantName {
+				t.Errorf("name %q, want %q", details.Name, tt.wantName)
+			}
+			if got := deref(details.Brand); got != tt.wantBrand {
+				t.Errorf("brand %q, want %q", got, tt.wantBrand)
+			}
+			var price int64
+			if details.Price != nil {
+				price = details.Price.Amount
+			}
+			if price != tt.wantPrice {
+				t.Errorf("price %d, want %d", price, tt.wantPrice)
+			}
+			if got := deref(details.Plate); got != tt.wantPlate {
+				t.Errorf("plate %q, want %q", got, tt.wantPlate)
+			}
+			if got := deref(details.VIN); got != tt.wantVIN {
+				t.Errorf("vin %q, want %q", got, tt.wantVIN)
+			}
+			if len(details.Images) != tt.wantImages {
+				t.Errorf("%d images, want %d", len(details.Images), tt.wantImages)
+			}
+		})
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func TestValidVINCheckDigit(t *testing.T) {
+	tests := []struct {
+		vin  string
+		want bool
+	}{
+		{"1M8GDM9AXKP042788", true},
+		{"11111111111111111", true},
+		{"1HGCM82633A004352", true},
+		{"1M8GDM9A1KP042788", false},
+		{"1HGCM82613A004352", false},
+		// European makers don't use the check digit
+		{"WVWZZZ1JZXW000001", false},
+	}
+	for _, tt := range tests {
+		if got := validVINCheckDigit(tt.vin); got != tt.want {
+			t.Errorf("validVINCheckDigit(%q) = %v, want %v", tt.vin, got, tt.want)
+		}
+	}
+}
+
+func TestPricePattern(t *testing.T) {
+	tests := []struct {
+		text string
+		want int64
+	}{
+		{"45.000 kr.", 45000},
+		{"45.000 kr", 45000},
+		{"kr. 45.000", 45000},
+		{"Kr 1.250.000", 1250000},
+		{"45 000 ISK", 45000},
+		{"45 000 kr.", 45000},
+		{"45.000,-", 45000},
+		{"45.000,00 kr.", 45000},
+		{"Verð 900 krónur", 900},
+		{"Tilboð: 15000kr", 15000},
+		// Not prices
+		{"Toyota Yaris 2014", 0},
+		{"árgerð 2009, ekinn 150.000 km", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		var got int64
+		if price := parsePrice(tt.text); price != nil {
+			got = price.Amount
+			if price.Currency != "ISK" {
+				t.Errorf("parsePrice(%q) currency %q", tt.text, price.Currency)
+			}
+		}
+		if got != tt.want {
+			t.Errorf("parsePrice(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
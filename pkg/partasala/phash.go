@@ -0,0 +1,124 @@
+package partasala
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"math/bits"
+	"net/http"
+	"strconv"
+)
+
+// PerceptualHash returns the difference hash of img: the image shrunk to
+// 9×8 in grey, with a bit set for each pixel brighter than the one to its
+// right. Unlike a hash of the bytes it stays close for the same photo
+// resized, recompressed, or slightly retouched, so ImageHashDistance can
+// tell photos apart from copies of each other.
+func PerceptualHash(img image.Image) uint64 {
+	const width, height = 9, 8
+	var sums [height][width]uint64
+	var counts [height][width]uint64
+	bounds := img.Bounds()
+	dx, dy := bounds.Dx(), bounds.Dy()
+	if dx == 0 || dy == 0 {
+		return 0
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := (y - bounds.Min.Y) * height / dy
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			col := (x - bounds.Min.X) * width / dx
+			r, g, b, _ := img.At(x, y).RGBA()
+			// ITU-R 601 luma, in the 16-bit range RGBA returns
+			sums[row][col] += (299*uint64(r) + 587*uint64(g) + 114*uint64(b)) / 1000
+			counts[row][col]++
+		}
+	}
+
+	var hash uint64
+	for row := 0; row < height; row++ {
+		for col := 0; col < width-1; col++ {
+			hash <<= 1
+			if cellMean(sums[row][col], counts[row][col]) > cellMean(sums[row][col+1], counts[row][col+1]) {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func cellMean(sum, count uint64) uint64 {
+	if count == 0 {
+		return 0
+	}
+	return sum / count
+}
+
+// ImageHashDistance counts the bits two perceptual hashes differ in, from
+// 0 for the same photo to 64. Copies of a photo are usually within 10.
+func ImageHashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// FormatImageHash writes a perceptual hash as 16 hex digits.
+func FormatImageHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// ParseImageHash reads a perceptual hash written by FormatImageHash.
+func ParseImageHash(s string) (uint64, error) {
+	if len(s) != 16 {
+		return 0, fmt.Errorf("image hash %q is not 16 hex digits", s)
+	}
+	hash, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("image hash %q is not 16 hex digits", s)
+	}
+	return hash, nil
+}
+
+// PerceptualImageHash downloads the image at url and returns its
+// PerceptualHash, unless it was hashed before. Hashing an image's
+// thumbnail rather than its full size saves most of the download and
+// gives nearly the same hash.
+func (s *Scraper) PerceptualImageHash(url string) (uint64, error) {
+	s.images.mu.Lock()
+	hash, ok := s.images.perceptual[url]
+	s.images.mu.Unlock()
+	if ok {
+		return hash, nil
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.config.Timeouts.Details.Duration)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", s.userAgent())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("status code error: %d %s", resp.StatusCode, resp.Status)
+	}
+
+	img, _, err := image.Decode(io.LimitReader(resp.Body, maxImageBytes))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", url, err)
+	}
+	hash = PerceptualHash(img)
+
+	s.images.mu.Lock()
+	defer s.images.mu.Unlock()
+	if len(s.images.perceptual) >= maxImageHashes {
+		for evict := range s.images.perceptual {
+			delete(s.images.perceptual, evict)
+			break
+		}
+	}
+	s.images.perceptual[url] = hash
+	return hash, nil
+}
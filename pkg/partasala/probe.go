@@ -0,0 +1,44 @@
+package partasala
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Probe fetches the homepage straight from upstream, skipping the page
+// caches, and returns how many brands it parses to. Zero brands is an
+// error, as it means the markup changed or the site served something else.
+// The request counts against the daily budget like any other.
+func (s *Scraper) Probe(ctx context.Context) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.baseURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", s.userAgent())
+
+	client := &http.Client{Transport: &gzipTransport{next: s.traffic}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch %s: %v", s.baseURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return 0, fmt.Errorf("status code error: %d %s", resp.StatusCode, resp.Status)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	brands := s.parser.Load().brands(doc)
+	if len(brands) == 0 {
+		return 0, errors.New("homepage parsed to zero brands")
+	}
+	return len(brands), nil
+}
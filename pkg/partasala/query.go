@@ -0,0 +1,151 @@
+package partasala
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Query is a parsed search. Its words must all be found, in any order, in
+// a car's brand, name, or year; "quoted phrases" must be found as written;
+// and OR (or |) separates alternatives, any one of which may match. So
+// `toyota yaris 2015` finds the 2015 Yaris, and `yaris OR "land cruiser"`
+// finds both models. Matching ignores case, and Icelandic letters as
+// folding says, and a word that is a synonym also matches the term it
+// stands for.
+type Query struct {
+	alternatives [][]string
+	synonyms     map[string]string
+	folding      string
+}
+
+// ParseQuery parses query, looking its words up in synonyms, which maps
+// lower-case words to the term they stand for and may be nil. Query and
+// text are compared after Fold with folding.
+func ParseQuery(query string, synonyms map[string]string, folding string) Query {
+	q := Query{synonyms: make(map[string]string, len(synonyms)), folding: folding}
+	for word, term := range synonyms {
+		q.synonyms[Fold(word, folding)] = Fold(term, folding)
+	}
+	var terms []string
+	endAlternative := func() {
+		if len(terms) > 0 {
+			q.alternatives = append(q.alternatives, terms)
+		}
+		terms = nil
+	}
+
+	rest := strings.TrimSpace(query)
+	for rest != "" {
+		var token string
+		if rest[0] == '"' {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				// An unclosed quote runs to the end of the query
+				token, rest = rest[1:], ""
+			} else {
+				token, rest = rest[1:end+1], rest[end+2:]
+			}
+			if phrase := strings.Join(strings.Fields(Fold(token, folding)), " "); phrase != "" {
+				terms = append(terms, phrase)
+			}
+		} else {
+			end := strings.IndexAny(rest, " \t\"")
+			if end < 0 {
+				end = len(rest)
+			}
+			token, rest = rest[:end], rest[end:]
+			if token == "|" || strings.EqualFold(token, "or") {
+				endAlternative()
+			} else {
+				terms = append(terms, Fold(token, folding))
+			}
+		}
+		rest = strings.TrimSpace(rest)
+	}
+	endAlternative()
+	return q
+}
+
+// Matches reports whether text satisfies the query. An empty query matches
+// nothing.
+func (q Query) Matches(text string) bool {
+	text = Fold(text, q.folding)
+	for _, terms := range q.alternatives {
+		matched := true
+		for _, term := range terms {
+			if !strings.Contains(text, term) && (q.synonyms[term] == "" || !strings.Contains(text, q.synonyms[term])) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchCar returns how car, listed under the brand called brandName,
+// matches the query: "brand" when the brand name alone satisfies it,
+// "car_name" when the brand, name, and year together do, and "" otherwise.
+func (q Query) MatchCar(brandName string, car Car) string {
+	if q.Matches(brandName) {
+		return "brand"
+	}
+	text := brandName + " " + car.Name
+	if car.Year != nil {
+		text += " " + strconv.Itoa(*car.Year)
+	}
+	if q.Matches(text) {
+		return "car_name"
+	}
+	return ""
+}
+
+// Highlight is a matched part of a car's name, from character Start up to
+// but not including End. Offsets count characters of the NFC form rather
+// than bytes, so "Þ" is one.
+type Highlight struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Highlight returns where the query's terms, or the terms their synonyms
+// stand for, appear in name, in order and with overlapping parts merged.
+// Terms only matched by a car's brand or year don't appear.
+func (q Query) Highlight(name string) []Highlight {
+	text, from := foldRunes(name, q.folding)
+	var found []Highlight
+	find := func(term string) {
+		t := []rune(term)
+		for i := 0; i+len(t) <= len(text) && len(t) > 0; i++ {
+			if string(text[i:i+len(t)]) == term {
+				found = append(found, Highlight{Start: from[i], End: from[i+len(t)-1] + 1})
+			}
+		}
+	}
+	for _, terms := range q.alternatives {
+		for _, term := range terms {
+			find(term)
+			if synonym := q.synonyms[term]; synonym != "" {
+				find(synonym)
+			}
+		}
+	}
+	return MergeHighlights(found)
+}
+
+// MergeHighlights sorts highlights and joins those that overlap or touch.
+func MergeHighlights(highlights []Highlight) []Highlight {
+	sort.Slice(highlights, func(i, j int) bool { return highlights[i].Start < highlights[j].Start })
+	var merged []Highlight
+	for _, h := range highlights {
+		if n := len(merged); n > 0 && h.Start <= merged[n-1].End {
+			merged[n-1].End = max(merged[n-1].End, h.End)
+			continue
+		}
+		merged = append(merged, h)
+	}
+	return merged
+}
@@ -0,0 +1,55 @@
+package partasala
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{"toyota yaris 2015", [][]string{{"toyota", "yaris", "2015"}}},
+		{"  Toyota   YARIS ", [][]string{{"toyota", "yaris"}}},
+		{`yaris OR "land cruiser"`, [][]string{{"yaris"}, {"land cruiser"}}},
+		{"yaris or corolla | auris", [][]string{{"yaris"}, {"corolla"}, {"auris"}}},
+		{"OR yaris OR OR", [][]string{{"yaris"}}},
+		{`"land   cruiser" 1998`, [][]string{{"land cruiser", "1998"}}},
+		{`toyota"land cruiser"`, [][]string{{"toyota", "land cruiser"}}},
+		{`"land cruiser`, [][]string{{"land cruiser"}}},
+		{`yaris "`, [][]string{{"yaris"}}},
+		{`"" yaris`, [][]string{{"yaris"}}},
+		{"Þór ökutæki", [][]string{{"thor", "okutaeki"}}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		q := ParseQuery(tt.query, nil, FoldASCII)
+		if !reflect.DeepEqual(q.alternatives, tt.want) {
+			t.Errorf("ParseQuery(%q) = %q, want %q", tt.query, q.alternatives, tt.want)
+		}
+	}
+}
+
+func TestQueryMatches(t *testing.T) {
+	synonyms := map[string]string{"vw": "volkswagen"}
+	tests := []struct {
+		query, text string
+		want        bool
+	}{
+		{"toyota yaris 2015", "Toyota Yaris 2015", true},
+		{"yaris toyota", "Toyota Yaris 2015", true},
+		{"toyota yaris 2014", "Toyota Yaris 2015", false},
+		{`yaris OR "land cruiser"`, "Toyota Land Cruiser 1998", true},
+		{`"cruiser land"`, "Toyota Land Cruiser 1998", false},
+		{`"land cruiser`, "Toyota Land Cruiser", true},
+		{"vw golf", "Volkswagen Golf", true},
+		{"skoda", "Škoda Octavia", true},
+		{"", "Toyota Yaris", false},
+	}
+	for _, tt := range tests {
+		if got := ParseQuery(tt.query, synonyms, FoldASCII).Matches(tt.text); got != tt.want {
+			t.Errorf("ParseQuery(%q).Matches(%q) = %v, want %v", tt.query, tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package partasala
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetryAfterConfig sets how the scraper honours a Retry-After header on a
+// 429 or 503 from upstream. All upstream requests wait out the time asked
+// for, capped at MaxWait, and the request that got it is sent again up to
+// Retries times, as long as its timeout leaves room for the wait.
+type RetryAfterConfig struct {
+	Retries int      `json:"retries"`
+	MaxWait Duration `json:"max_wait"`
+}
+
+// RetryAfterError is returned for a request upstream asked to retry later
+// when it couldn't be retried in time, so callers can wait until Until and
+// try again instead of counting a failure.
+type RetryAfterError struct {
+	URL    string
+	Status int
+	Until  time.Time
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("%s: upstream answered %d, retry after %s", e.URL, e.Status, e.Until.Format(time.RFC3339))
+}
+
+// upstreamPause is when upstream last asked to be left alone until, and
+// the status it asked with.
+type upstreamPause struct {
+	mu     sync.Mutex
+	until  time.Time
+	status int
+}
+
+// extend pauses upstream requests until until, unless they already are
+// for longer.
+func (p *upstreamPause) extend(until time.Time, status int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if until.After(p.until) {
+		p.until, p.status = until, status
+	}
+}
+
+func (p *upstreamPause) end() (time.Time, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.until, p.status
+}
+
+// parseRetryAfter reads a Retry-After header, either seconds or an HTTP
+// date, as the time to wait from now.
+func parseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	at, err := http.ParseTime(header)
+	if err != nil {
+		return 0, false
+	}
+	if wait := at.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
+
+// retryAfterTransport holds requests back while upstream has asked to be
+// left alone, and sends a request again once the wait it was given is
+// over. A request out of retries, or whose timeout ends before the wait
+// does, fails with a RetryAfterError. It sits above the limiter so waiting
+// requests don't hold a slot, and below the breaker, which only sees how a
+// request finally went.
+type retryAfterTransport struct {
+	next   http.RoundTripper
+	pause  *upstreamPause
+	config RetryAfterConfig
+}
+
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	retryable := req.Method == "GET" || req.Method == "HEAD"
+	for attempt := 0; ; attempt++ {
+		if err := t.wait(req); err != nil {
+			return nil, err
+		}
+
+		resp, err := t.next.RoundTrip(req)
+		if err != nil || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+			return resp, err
+		}
+		wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		if !ok || t.config.MaxWait.Duration <= 0 {
+			return resp, nil
+		}
+		if wait > t.config.MaxWait.Duration {
+			wait = t.config.MaxWait.Duration
+		}
+		until := time.Now().Add(wait)
+		t.pause.extend(until, resp.StatusCode)
+		// Read a little of the error page so the connection can be reused
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		if !retryable || attempt >= t.config.Retries {
+			return nil, &RetryAfterError{URL: req.URL.String(), Status: resp.StatusCode, Until: until}
+		}
+	}
+}
+
+// wait holds req back until upstream's pause is over, or fails it with a
+// RetryAfterError if the pause outlasts its deadline.
+func (t *retryAfterTransport) wait(req *http.Request) error {
+	until, status := t.pause.end()
+	wait := time.Until(until)
+	if wait <= 0 {
+		return nil
+	}
+	ctx := req.Context()
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(until) {
+		return &RetryAfterError{URL: req.URL.String(), Status: status, Until: until}
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
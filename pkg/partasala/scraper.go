@@ -0,0 +1,921 @@
+// Package partasala scrapes partasala.is, and other salvage yards running
+// the same WordPress theme, for brands, cars, and car details. A Scraper is
+// safe to use from several goroutines; everything it keeps between requests,
+// such as cookies, the circuit breaker, and the request budget, belongs to
+// that Scraper. Parser and the Parse functions read pages that were fetched
+// some other way.
+package partasala
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	neturl "net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+type Brand struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+	URL  string `json:"url"`
+	// Aliases are the other slugs the API server groups under this brand;
+	// the scraper leaves them nil
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+type Car struct {
+	Name      string  `json:"name"`
+	Slug      string  `json:"slug"`
+	URL       string  `json:"url"`
+	Thumbnail *string `json:"thumbnail"`
+	Brand     string  `json:"brand"`
+	Make      *string `json:"make"`
+	Model     *string `json:"model"`
+	Year      *int    `json:"year"`
+	Price     *Price  `json:"price"`
+	MatchType string  `json:"match_type,omitempty"`
+	// Highlights are the parts of Name a search matched
+	Highlights []Highlight `json:"highlights,omitempty"`
+	// FirstSeen and LastSeen are when the API server's crawler first and
+	// last listed the car; the scraper leaves them nil
+	FirstSeen *time.Time `json:"first_seen,omitempty"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+	// RemovedAt is when the crawler found the car taken down, set only on
+	// the removed cars the API server keeps
+	RemovedAt *time.Time `json:"removed_at,omitempty"`
+	// ImageCount and Description are read from the car's detail page by
+	// the API server's enrichment worker; the scraper leaves them nil
+	ImageCount  *int    `json:"image_count,omitempty"`
+	Description *string `json:"description,omitempty"`
+	// PostedAt and UpdatedAt are read from the detail page the same way
+	PostedAt  *time.Time `json:"posted_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// Price is an asking price as listed, in whole units of Currency.
+type Price struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+type Image struct {
+	URL       string `json:"url"`
+	Thumbnail string `json:"thumbnail"`
+	// Width, Height, Bytes, and Format describe the full-size image when
+	// Config.ImageMetadata is set and the image could be probed
+	Width  *int   `json:"width,omitempty"`
+	Height *int   `json:"height,omitempty"`
+	Bytes  *int64 `json:"bytes,omitempty"`
+	Format string `json:"format,omitempty"`
+	// Variants are the sizes the page offers the image in whose width it
+	// gives, smallest first, so clients can pick one to fit
+	Variants []ImageVariant `json:"variants,omitempty"`
+}
+
+// ImageVariant is an image at one size: a candidate of its srcset, or a
+// resized upload whose -WxH suffix gives its width.
+type ImageVariant struct {
+	Width int    `json:"width"`
+	URL   string `json:"url"`
+}
+
+type CarDetails struct {
+	Name        string  `json:"name"`
+	Slug        string  `json:"slug"`
+	URL         string  `json:"url"`
+	Brand       *string `json:"brand"`
+	Description *string `json:"description"`
+	// DescriptionHTML is the description with its markup, limited to
+	// paragraphs, lists, tables, emphasis, and links
+	DescriptionHTML *string `json:"description_html"`
+	// DescriptionMarkdown is the same markup as Markdown, for chat bots
+	// and terminals
+	DescriptionMarkdown *string `json:"description_markdown"`
+	Price               *Price  `json:"price"`
+	Plate               *string `json:"plate"`
+	VIN                 *string `json:"vin"`
+	ImageCount          int     `json:"image_count"`
+	Images              []Image `json:"images"`
+	// PostedAt and UpdatedAt are when the yard published the car's post
+	// and last changed it, as far as the page says
+	PostedAt  *time.Time `json:"posted_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
+	// CanonicalSlug is set when the site redirected the car's page to
+	// another car slug, as it does for cars renamed since they were
+	// listed; URL is then the page redirected to
+	CanonicalSlug string `json:"canonical_slug,omitempty"`
+	// Warnings name the parts every car page should have that the parser
+	// couldn't find, e.g. WarningDescriptionNotFound, so a null description
+	// can be told apart from a selector that no longer matches
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// The warnings a parsed CarDetails can carry.
+const (
+	WarningNameNotFound        = "name_not_found"
+	WarningDescriptionNotFound = "description_not_found"
+	WarningImagesNotFound      = "images_not_found"
+)
+
+// Config describes the layout of a partasala.is-style WordPress site,
+// so the same scraper can point at other yards running the same theme.
+type Config struct {
+	BaseURL   string         `json:"base_url"`
+	BrandPath string         `json:"brand_path"`
+	CarPath   string         `json:"car_path"`
+	Selectors SelectorConfig `json:"selectors"`
+
+	// AllowedHosts are the hosts besides BaseURL's that links and images
+	// read from pages may point at, such as an image CDN; "*.example.com"
+	// allows every subdomain of example.com. Others are dropped.
+	AllowedHosts []string `json:"allowed_hosts"`
+
+	// UserAgent is sent with upstream requests. Empty means a desktop
+	// browser's.
+	UserAgent string `json:"user_agent"`
+
+	// ContactPath is the page with the yard's phone numbers, email,
+	// address, and opening hours.
+	ContactPath string `json:"contact_path"`
+
+	// SelectorsFile optionally points at a JSON file of selector overrides
+	// that is watched and re-applied whenever it changes.
+	SelectorsFile string `json:"selectors_file"`
+
+	Browser  BrowserConfig   `json:"browser"`
+	Cache    DiskCacheConfig `json:"cache"`
+	Archive  ArchiveConfig   `json:"archive"`
+	Cassette CassetteConfig  `json:"cassette"`
+
+	CircuitBreaker CircuitBreakerConfig `json:"circuit_breaker"`
+	RetryAfter     RetryAfterConfig     `json:"retry_after"`
+	Timeouts       TimeoutConfig        `json:"timeouts"`
+	Transport      TransportConfig      `json:"transport"`
+
+	// MaxConcurrency caps simultaneous upstream requests made by one
+	// Scraper.
+	MaxConcurrency int `json:"max_concurrency"`
+
+	// MaxBrandPages and MaxArchivePages bound how many pages of a paginated
+	// brand listing or of the car archive are read.
+	MaxBrandPages   int `json:"max_brand_pages"`
+	MaxArchivePages int `json:"max_archive_pages"`
+
+	// AllCarsSource is where GetAllCars finds cars: AllCarsBrands,
+	// AllCarsArchive, or AllCarsBoth. Empty means AllCarsBrands.
+	AllCarsSource string `json:"all_cars_source"`
+
+	// SearchSynonyms maps other words for a make, like "vw", to the term
+	// SearchCars searches for as well, like "volkswagen". Entries are
+	// merged with the defaults; an empty term drops a default.
+	SearchSynonyms map[string]string `json:"search_synonyms"`
+
+	// SearchFolding is how SearchCars compares queries with names:
+	// FoldASCII or FoldCase. Empty means FoldASCII.
+	SearchFolding string `json:"search_folding"`
+
+	// ImageDedup is how repeated images are dropped from CarDetails:
+	// ImageDedupURL, ImageDedupFilename, or ImageDedupContent. Empty means
+	// ImageDedupURL.
+	ImageDedup string `json:"image_dedup"`
+
+	// ImageMetadata makes GetCarDetails request the start of each image to
+	// report its dimensions, size in bytes, and format.
+	ImageMetadata bool `json:"image_metadata"`
+
+	// DailyRequestBudget caps upstream fetches per day; once it is spent
+	// requests fail with ErrBudgetExhausted. 0 means unlimited.
+	DailyRequestBudget int `json:"daily_request_budget"`
+
+	// Taxonomies are the site's other groupings of cars that GetCategories
+	// looks for on the homepage.
+	Taxonomies []TaxonomyConfig `json:"taxonomies"`
+}
+
+const (
+	// AllCarsBrands lists every brand's cars.
+	AllCarsBrands = "brands"
+	// AllCarsArchive lists the site-wide car archive, which is fewer pages
+	// but leaves Car.Brand empty.
+	AllCarsArchive = "archive"
+	// AllCarsBoth lists every brand's cars and adds the archived cars no
+	// brand lists, with an empty Car.Brand.
+	AllCarsBoth = "both"
+)
+
+// TimeoutConfig bounds each upstream request by page type, including reading
+// the body. Listing pages of big brands can be slow where detail pages are
+// quick.
+type TimeoutConfig struct {
+	Brands    Duration `json:"brands"`
+	BrandCars Duration `json:"brand_cars"`
+	Details   Duration `json:"details"`
+	Contact   Duration `json:"contact"`
+}
+
+// SelectorConfig holds the goquery selectors and regex patterns used to pick
+// elements out of brand, listing, and detail pages.
+type SelectorConfig struct {
+	BrandLinks    string `json:"brand_links"`
+	CategoryLinks string `json:"category_links"`
+	CarLinks      string `json:"car_links"`
+	CarThumbnail  string `json:"car_thumbnail"`
+	// CarItem matches the element around a car's link in a listing, which
+	// is searched for the car's Price.
+	CarItem  string `json:"car_item"`
+	Price    string `json:"price"`
+	CarTitle string `json:"car_title"`
+	// PostContent matches the element holding a car's description. When
+	// nothing matches, the last Description element whose class holds one
+	// of DescriptionClasses is used.
+	PostContent        string   `json:"post_content"`
+	Description        string   `json:"description"`
+	DescriptionClasses []string `json:"description_classes"`
+	Images             string   `json:"images"`
+	ImageLinks         string   `json:"image_links"`
+	UploadsMarker      string   `json:"uploads_marker"`
+
+	// ImageSizeSuffix matches the size suffix of resized uploads; its first
+	// group must capture the file extension.
+	ImageSizeSuffix string `json:"image_size_suffix"`
+	// ImageLinkPattern matches links that point directly at an image.
+	ImageLinkPattern string `json:"image_link_pattern"`
+}
+
+// compiledSelectors is a SelectorConfig with its patterns compiled.
+type compiledSelectors struct {
+	SelectorConfig
+	sizeSuffix *regexp.Regexp
+	imageLink  *regexp.Regexp
+}
+
+func compileSelectors(config SelectorConfig) (*compiledSelectors, error) {
+	sizeSuffix, err := regexp.Compile(config.ImageSizeSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image_size_suffix: %v", err)
+	}
+	imageLink, err := regexp.Compile(config.ImageLinkPattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image_link_pattern: %v", err)
+	}
+
+	return &compiledSelectors{
+		SelectorConfig: config,
+		sizeSuffix:     sizeSuffix,
+		imageLink:      imageLink,
+	}, nil
+}
+
+// Validate reports whether the selector patterns compile.
+func (c SelectorConfig) Validate() error {
+	_, err := compileSelectors(c)
+	return err
+}
+
+func DefaultConfig() Config {
+	return Config{
+		BaseURL:   "https://partasala.is",
+		BrandPath: "/bilaflokkur/",
+		CarPath:   "/bilaskra/",
+		// "Contact us"
+		ContactPath: "/hafa-samband/",
+		Browser: BrowserConfig{
+			Timeout: Duration{30 * time.Second},
+			Wait:    Duration{2 * time.Second},
+		},
+		Cache: DiskCacheConfig{
+			TTL: Duration{6 * time.Hour},
+		},
+		Archive: ArchiveConfig{
+			Format: "warc",
+		},
+		MaxConcurrency:  4,
+		MaxBrandPages:   50,
+		MaxArchivePages: 200,
+		AllCarsSource:   AllCarsBrands,
+		SearchFolding:   FoldASCII,
+		ImageDedup:      ImageDedupURL,
+		SearchSynonyms: map[string]string{
+			"vw":     "volkswagen",
+			"benz":   "mercedes",
+			"merc":   "mercedes",
+			"beemer": "bmw",
+			"bimmer": "bmw",
+			"chevy":  "chevrolet",
+		},
+		Transport: TransportConfig{
+			IdleConnTimeout: Duration{90 * time.Second},
+		},
+		Timeouts: TimeoutConfig{
+			Brands:    Duration{10 * time.Second},
+			BrandCars: Duration{10 * time.Second},
+			Details:   Duration{10 * time.Second},
+			Contact:   Duration{10 * time.Second},
+		},
+		CircuitBreaker: CircuitBreakerConfig{
+			Failures: 5,
+			Cooldown: Duration{30 * time.Second},
+		},
+		RetryAfter: RetryAfterConfig{
+			Retries: 2,
+			MaxWait: Duration{5 * time.Minute},
+		},
+		Selectors: SelectorConfig{
+			BrandLinks:         "a",
+			CategoryLinks:      "a",
+			CarLinks:           "a",
+			CarThumbnail:       "img",
+			CarItem:            "article, li",
+			Price:              ".price",
+			CarTitle:           "h1",
+			PostContent:        ".entry-content, .post-content",
+			Description:        "div",
+			DescriptionClasses: []string{"description", "content", "lýsing"},
+			Images:             "img",
+			ImageLinks:         "a",
+			UploadsMarker:      "uploads",
+			ImageSizeSuffix:    `-\d+x\d+\.(jpg|jpeg|png|gif)`,
+			ImageLinkPattern:   `\.(jpg|jpeg|png|gif)$`,
+		},
+	}
+}
+
+// Scraper scrapes one site, as described by its Config.
+type Scraper struct {
+	*scraperState
+	// ctx is the parent of every upstream request's context
+	ctx context.Context
+}
+
+// scraperState is what a Scraper shares with the copies WithContext makes.
+type scraperState struct {
+	baseURL     string
+	config      Config
+	parser      atomic.Pointer[Parser]
+	makes       atomic.Pointer[makeDictionary]
+	client      *http.Client
+	browser     *BrowserFetcher
+	monitor     *StructureMonitor
+	conditional *conditionalCache
+	disk        *diskCacheTransport
+	images      *imageHashes
+	imageInfos  *imageInfos
+	traffic     *countingTransport
+	breaker     *circuitBreaker
+	pause       *upstreamPause
+	budget      *requestBudget
+	limiter     *upstreamLimiter
+	outcomes    *outcomeWindow
+}
+
+// New creates a Scraper for the site described by config, as changed by
+// opts. config is taken whole, so start from DefaultConfig rather than a
+// zero Config, or use NewPartasalaScraper. Selectors that don't compile
+// fall back to DefaultConfig's; check them with SelectorConfig.Validate
+// first to catch that.
+func New(config Config, opts ...Option) *Scraper {
+	o := options{config: config}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	config = o.config
+
+	// Keep cookies between requests so session cookies and consent banners
+	// set by the site are sent back like a browser would
+	var jar http.CookieJar
+	if o.client != nil && o.client.Jar != nil {
+		jar = o.client.Jar
+	} else {
+		jar, _ = cookiejar.New(nil)
+	}
+
+	s := &Scraper{ctx: context.Background(), scraperState: &scraperState{
+		baseURL: strings.TrimRight(config.BaseURL, "/"),
+		config:  config,
+		// Timeouts are set per request from config.Timeouts
+		client: &http.Client{
+			Jar: jar,
+		},
+		conditional: newConditionalCache(),
+		images:      newImageHashes(),
+		imageInfos:  newImageInfos(),
+	}}
+
+	s.limiter = newUpstreamLimiter(config.MaxConcurrency)
+	if config.Browser.Enabled {
+		s.browser = NewBrowserFetcher(config.Browser, s.limiter)
+	}
+
+	// The traffic counter, breaker, and archive sit below the disk cache so
+	// that they see what was actually fetched from upstream; the counter sits
+	// below gzip decoding so it counts compressed bytes. The breaker sits
+	// above the limiter so an open circuit fails without waiting for a slot,
+	// and below the budget so rejected requests aren't charged. Outcomes are
+	// recorded above the breaker so its refusals count as failures. Waits
+	// for Retry-After happen between the breaker and the limiter, so a
+	// request and its retries are charged and recorded once. The cassette
+	// sits on top, so replayed pages never reach any of them.
+	s.breaker = newCircuitBreaker(config.CircuitBreaker)
+	s.pause = &upstreamPause{}
+	s.budget = newRequestBudget(config.DailyRequestBudget)
+	s.outcomes = newOutcomeWindow()
+	var upstream http.RoundTripper = newUpstreamTransport(config.Transport, config.MaxConcurrency)
+	if o.client != nil {
+		upstream = o.client.Transport
+		if upstream == nil {
+			upstream = http.DefaultTransport
+		}
+	}
+	limited := &limitTransport{next: upstream, limiter: s.limiter}
+	paused := &retryAfterTransport{next: limited, pause: s.pause, config: config.RetryAfter}
+	breaker := &breakerTransport{next: paused, breaker: s.breaker}
+	outcomes := &outcomeTransport{next: breaker, window: s.outcomes}
+	s.traffic = &countingTransport{next: &traceTransport{next: &budgetTransport{next: outcomes, budget: s.budget}}}
+	var transport http.RoundTripper = &gzipTransport{next: s.traffic}
+	if config.Archive.Dir != "" {
+		archive, err := newArchiveTransport(transport, config.Archive)
+		if err != nil {
+			log.Printf("archive: disabled: %v", err)
+		} else {
+			transport = archive
+		}
+	}
+	if config.Cache.Dir != "" {
+		cache, err := newDiskCacheTransport(transport, config.Cache)
+		if err != nil {
+			log.Printf("diskcache: disabled: %v", err)
+		} else {
+			transport = cache
+			s.disk = cache
+		}
+	}
+	if config.Cassette.Dir != "" {
+		cassette, err := newCassetteTransport(transport, config.Cassette)
+		if err != nil {
+			log.Printf("cassette: disabled: %v", err)
+		} else {
+			transport = cassette
+		}
+	}
+	s.client.Transport = transport
+
+	if err := s.SetSelectors(config.Selectors); err != nil {
+		// Fall back to the built-in patterns, which always compile
+		s.SetSelectors(DefaultConfig().Selectors)
+	}
+	return s
+}
+
+// userAgent returns the User-Agent to send upstream.
+func (s *scraperState) userAgent() string {
+	if s.config.UserAgent != "" {
+		return s.config.UserAgent
+	}
+	return defaultUserAgent
+}
+
+// SetSelectors swaps the selectors used by subsequent scrapes. Scrapes that
+// are already running finish with the selectors they started with.
+func (s *Scraper) SetSelectors(config SelectorConfig) error {
+	compiled, err := compileSelectors(config)
+	if err != nil {
+		return err
+	}
+	s.parser.Store(newParser(s.config, compiled))
+	if s.conditional != nil {
+		s.conditional.clear()
+	}
+	return nil
+}
+
+// WithContext returns a Scraper sharing s's caches, limits, and counters
+// whose upstream requests run under ctx, so they stop when it is cancelled
+// and are tagged with its FetchTrace.
+func (s *Scraper) WithContext(ctx context.Context) *Scraper {
+	return &Scraper{scraperState: s.scraperState, ctx: ctx}
+}
+
+// Reload applies the settings of config that can change while the scraper
+// is running: the selectors, the disk cache TTL, MaxConcurrency, and
+// DailyRequestBudget. The rest of config is ignored; the other settings
+// take a new Scraper. Nothing is applied if the selectors don't compile.
+func (s *Scraper) Reload(config Config) error {
+	if err := s.SetSelectors(config.Selectors); err != nil {
+		return err
+	}
+	if s.disk != nil {
+		s.disk.setTTL(config.Cache.TTL.Duration)
+	}
+	s.limiter.resize(config.MaxConcurrency)
+	s.budget.setLimit(config.DailyRequestBudget)
+	return nil
+}
+
+// BytesDownloaded reports the response body bytes read from upstream, not
+// counting disk cache hits.
+func (s *Scraper) BytesDownloaded() int64 {
+	return s.traffic.bytes.Load()
+}
+
+// UpstreamRequests reports the requests made to upstream, including ones
+// the budget or circuit breaker refused, not counting disk cache hits.
+func (s *Scraper) UpstreamRequests() int64 {
+	return s.traffic.requests.Load()
+}
+
+// UpstreamOutcomes counts the upstream requests of the last window, up to
+// MaxOutcomeWindow, and how many of them failed.
+func (s *Scraper) UpstreamOutcomes(window time.Duration) UpstreamOutcomes {
+	return s.outcomes.since(time.Now(), window)
+}
+
+// LastUpstreamSuccess returns when an upstream request last succeeded, or
+// the zero time if none has.
+func (s *Scraper) LastUpstreamSuccess() time.Time {
+	return s.outcomes.lastSucceeded()
+}
+
+func (s *Scraper) CircuitState() string {
+	return s.breaker.State()
+}
+
+func (s *Scraper) BudgetStatus() BudgetStatus {
+	return s.budget.Status()
+}
+
+// PageCacheStats counts the pages held by the scraper's caches.
+type PageCacheStats struct {
+	// Conditional is how many pages have validators and parse results kept
+	// for conditional requests
+	Conditional int `json:"conditional"`
+	// Disk is how many pages the disk cache holds, nil if it is disabled
+	Disk *int `json:"disk,omitempty"`
+}
+
+func (s *Scraper) PageCacheStats() PageCacheStats {
+	stats := PageCacheStats{Conditional: s.conditional.len()}
+	if s.disk != nil {
+		disk := s.disk.count()
+		stats.Disk = &disk
+	}
+	return stats
+}
+
+// FlushPageCaches forgets every cached page, so the next scrapes fetch and
+// parse everything afresh.
+func (s *Scraper) FlushPageCaches() error {
+	s.conditional.clear()
+	if s.disk != nil {
+		return s.disk.clear()
+	}
+	return nil
+}
+
+// SetStructureMonitor makes the scraper report parse results to monitor.
+func (s *Scraper) SetStructureMonitor(monitor *StructureMonitor) {
+	s.monitor = monitor
+}
+
+// getPage fetches and parses url. If upstream answers a conditional request
+// with 304 Not Modified, doc is nil and cached holds what the page parsed to
+// last time, as passed to s.conditional.store.
+func (s *Scraper) getPage(url string, timeout time.Duration) (doc *goquery.Document, cached interface{}, err error) {
+	location, cached, err := s.readPage(url, timeout, func(body io.Reader) (err error) {
+		doc, err = goquery.NewDocumentFromReader(body)
+		return err
+	})
+	if doc != nil {
+		doc.Url = location
+	}
+	return doc, cached, err
+}
+
+// readPage fetches url and has read parse its body, as getPage does, for
+// pages parsed without a document. Redirects are followed, and location is
+// the URL the page was finally read from. On a 304 Not Modified read isn't
+// called, and cached holds what the page parsed to last time.
+func (s *Scraper) readPage(url string, timeout time.Duration, read func(io.Reader) error) (location *neturl.URL, cached interface{}, err error) {
+	ctx, cancel := context.WithTimeout(s.ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req.Header.Set("User-Agent", s.userAgent())
+	s.conditional.apply(req)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		if cached := s.conditional.parsed(url); cached != nil {
+			return resp.Request.URL, cached, nil
+		}
+	}
+
+	if resp.StatusCode != 200 {
+		// Read a little of the error page so the connection can be reused
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return nil, nil, fmt.Errorf("status code error: %d %s", resp.StatusCode, resp.Status)
+	}
+
+	if err := read(resp.Body); err != nil {
+		return nil, nil, err
+	}
+
+	s.conditional.record(url, resp)
+	return resp.Request.URL, nil, nil
+}
+
+// homePage is what the homepage parsed to.
+type homePage struct {
+	brands     []Brand
+	categories []Category
+}
+
+// getHomePage reads the homepage, which lists the brands and the terms of
+// the taxonomies.
+func (s *Scraper) getHomePage() (homePage, error) {
+	doc, cached, err := s.getPage(s.baseURL, s.config.Timeouts.Brands.Duration)
+	if err != nil {
+		return homePage{}, err
+	}
+	if page, ok := cached.(homePage); ok {
+		return page, nil
+	}
+
+	parser := s.parser.Load()
+	page := homePage{brands: parser.brands(doc), categories: parser.categories(doc)}
+	s.monitor.Observe(PageBrands, s.baseURL, len(page.brands))
+	s.conditional.store(s.baseURL, page)
+	return page, nil
+}
+
+func (s *Scraper) GetBrands() ([]Brand, error) {
+	page, err := s.getHomePage()
+	if err != nil {
+		return nil, err
+	}
+	s.makes.Store(newMakeDictionary(page.brands))
+	return append([]Brand{}, page.brands...), nil
+}
+
+// GetBrandCars reads every page of brandSlug's listing, up to
+// Config.MaxBrandPages, and fails if any of them fails.
+func (s *Scraper) GetBrandCars(brandSlug string) ([]Car, error) {
+	parser := s.parser.Load()
+	url := parser.brandURL(brandSlug)
+	cars, err := s.getListing(parser, url, s.config.BrandPath+brandSlug+"/", brandSlug, s.config.MaxBrandPages)
+	if err != nil {
+		return nil, err
+	}
+	s.monitor.Observe(PageBrandCars, url, len(cars))
+	return cars, nil
+}
+
+// GetArchiveCars reads the site-wide car archive at Config.CarPath, up to
+// Config.MaxArchivePages. The archive lists cars without their brand, so
+// Brand is empty and Make is looked up from the brand list by name.
+func (s *Scraper) GetArchiveCars() ([]Car, error) {
+	parser := s.parser.Load()
+	cars, err := s.getListing(parser, parser.archiveURL(), s.config.CarPath, "", s.config.MaxArchivePages)
+	if err != nil {
+		return nil, err
+	}
+	s.splitMakes(cars)
+	return cars, nil
+}
+
+// splitMakes sets the Make and Model of cars listed without their brand
+// from the brand list.
+func (s *Scraper) splitMakes(cars []Car) {
+	makes := s.makes.Load()
+	if makes == nil {
+		// Without the brand list makes can't be told apart from models,
+		// in which case they stay unknown
+		s.GetBrands()
+		makes = s.makes.Load()
+	}
+	for i := range cars {
+		cars[i].Make, cars[i].Model = makes.split(cars[i].Name, "")
+	}
+}
+
+// getListing reads the paginated listing at url, whose path is listingPath,
+// following its pagination links up to limit pages.
+func (s *Scraper) getListing(parser *Parser, url, listingPath, brandSlug string, limit int) ([]Car, error) {
+	if limit < 1 {
+		limit = 1
+	}
+
+	cars := []Car{}
+	seenCars := make(map[string]bool)
+	pages := 1
+	for n := 1; n <= pages && n <= limit; n++ {
+		page, err := s.getListingPage(parser, parser.listingPageURL(url, n), listingPath, brandSlug)
+		if err != nil {
+			return nil, err
+		}
+		// A car moving between pages mid-walk can show up twice
+		for _, car := range page.cars {
+			if !seenCars[car.Slug] {
+				seenCars[car.Slug] = true
+				cars = append(cars, car)
+			}
+		}
+		if page.pages > pages {
+			pages = page.pages
+		}
+	}
+	if pages > limit {
+		log.Printf("partasala: %s has %d pages, only the first %d were read", url, pages, limit)
+	}
+	return cars, nil
+}
+
+// listingPage is what one page of a listing parsed to.
+type listingPage struct {
+	cars []Car
+	// pages is the highest page number the page links to
+	pages int
+}
+
+func (s *Scraper) getListingPage(parser *Parser, url, listingPath, brandSlug string) (listingPage, error) {
+	var page listingPage
+	_, cached, err := s.readPage(url, s.config.Timeouts.BrandCars.Duration, func(body io.Reader) (err error) {
+		page, err = parser.parseListing(body, brandSlug, listingPath)
+		return err
+	})
+	if err != nil {
+		return listingPage{}, err
+	}
+	if page, ok := cached.(listingPage); ok {
+		return page, nil
+	}
+
+	s.conditional.store(url, page)
+	return page, nil
+}
+
+func (s *Scraper) GetCarDetails(carSlug string) (*CarDetails, error) {
+	parser := s.parser.Load()
+	url := parser.carURL(carSlug)
+	doc, cached, err := s.getPage(url, s.config.Timeouts.Details.Duration)
+	if err != nil {
+		return nil, err
+	}
+	if details, ok := cached.(CarDetails); ok {
+		return &details, nil
+	}
+
+	details := parser.carDetails(doc, carSlug)
+	if canonical, ok := linkSlug(doc.Url.Path, parser.config.CarPath); ok && canonical != carSlug {
+		details.CanonicalSlug = canonical
+		details.URL = doc.Url.String()
+	}
+
+	// Some galleries are filled in by JavaScript, so render the page in a
+	// headless browser when the plain HTML has no images
+	if len(details.Images) == 0 && s.browser != nil && s.budget.take() {
+		rendered, err := s.browser.Render(url)
+		if err != nil {
+			log.Printf("browser: %v", err)
+		} else {
+			details.Images = parser.images(rendered)
+		}
+	}
+	details.Images = s.dedupImages(parser, details.Images)
+	details.ImageCount = len(details.Images)
+	if s.config.ImageMetadata {
+		s.addImageMetadata(details.Images)
+	}
+
+	s.conditional.store(url, details)
+	return &details, nil
+}
+
+// GetContact reads the yard's contact details from Config.ContactPath.
+func (s *Scraper) GetContact() (*Contact, error) {
+	parser := s.parser.Load()
+	url := parser.contactURL()
+	doc, cached, err := s.getPage(url, s.config.Timeouts.Contact.Duration)
+	if err != nil {
+		return nil, err
+	}
+	if contact, ok := cached.(Contact); ok {
+		return &contact, nil
+	}
+
+	contact := parser.contact(doc)
+	s.conditional.store(url, contact)
+	return &contact, nil
+}
+
+// GetAllCars lists every car from the source Config.AllCarsSource names.
+// Brands that fail are skipped; with the archive as a source, a failing
+// archive fails the whole call.
+func (s *Scraper) GetAllCars() ([]Car, error) {
+	if s.config.AllCarsSource == AllCarsArchive {
+		return s.GetArchiveCars()
+	}
+
+	allCars := []Car{}
+
+	// Get all brands
+	brands, err := s.GetBrands()
+	if err != nil {
+		return nil, err
+	}
+
+	// Get cars from each brand, skipping brands that fail
+	for _, cars := range s.brandCars(brands) {
+		allCars = append(allCars, cars...)
+	}
+
+	if s.config.AllCarsSource == AllCarsBoth {
+		archived, err := s.GetArchiveCars()
+		if err != nil {
+			return nil, err
+		}
+		allCars = appendUnbranded(allCars, archived)
+	}
+	return allCars, nil
+}
+
+// appendUnbranded appends the archived cars that no brand listed.
+func appendUnbranded(cars, archived []Car) []Car {
+	listed := make(map[string]bool, len(cars))
+	for _, car := range cars {
+		listed[car.Slug] = true
+	}
+	for _, car := range archived {
+		if !listed[car.Slug] {
+			cars = append(cars, car)
+		}
+	}
+	return cars
+}
+
+// brandCars fetches every brand's cars concurrently, leaving nil for brands
+// that fail. Results keep the order of brands. A pool of MaxConcurrency
+// workers takes brands in turn, so a search doesn't start a goroutine per
+// brand; the upstream limiter still bounds fetches across all requests.
+func (s *Scraper) brandCars(brands []Brand) [][]Car {
+	results := make([][]Car, len(brands))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(max(s.config.MaxConcurrency, 1), len(brands)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if cars, err := s.GetBrandCars(brands[i].Slug); err == nil {
+					results[i] = cars
+				}
+			}
+		}()
+	}
+	for i := range brands {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+// SearchCars finds the cars matching query, parsed by ParseQuery with
+// Config.SearchSynonyms and Config.SearchFolding.
+func (s *Scraper) SearchCars(query string) ([]Car, error) {
+	q := ParseQuery(query, s.config.SearchSynonyms, s.config.SearchFolding)
+	results := []Car{}
+
+	// Get all brands first
+	brands, err := s.GetBrands()
+	if err != nil {
+		return nil, err
+	}
+
+	// Search through each brand's cars
+	for i, cars := range s.brandCars(brands) {
+		for _, car := range cars {
+			if car.MatchType = q.MatchCar(brands[i].Name, car); car.MatchType != "" {
+				results = append(results, car)
+			}
+		}
+	}
+
+	return results, nil
+}
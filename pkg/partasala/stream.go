@@ -0,0 +1,73 @@
+package partasala
+
+import "context"
+
+// StreamAllCars fetches brand pages concurrently like GetAllCars but hands
+// each brand's cars on as soon as it and every brand before it are done.
+// Cars from the archive follow once it has been read in full.
+func (s *Scraper) StreamAllCars(ctx context.Context, cars chan<- Car) error {
+	if s.config.AllCarsSource == AllCarsArchive {
+		archived, err := s.GetArchiveCars()
+		if err != nil {
+			return err
+		}
+		return sendCars(ctx, cars, archived)
+	}
+
+	brands, err := s.GetBrands()
+	if err != nil {
+		return err
+	}
+
+	results := make([]chan []Car, len(brands))
+	for i, brand := range brands {
+		results[i] = make(chan []Car, 1)
+		go func(result chan<- []Car, slug string) {
+			// Skip brands that fail, like GetAllCars
+			brandCars, _ := s.GetBrandCars(slug)
+			result <- brandCars
+		}(results[i], brand.Slug)
+	}
+
+	listed := make(map[string]bool)
+	for _, result := range results {
+		var brandCars []Car
+		select {
+		case brandCars = <-result:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if err := sendCars(ctx, cars, brandCars); err != nil {
+			return err
+		}
+		for _, car := range brandCars {
+			listed[car.Slug] = true
+		}
+	}
+
+	if s.config.AllCarsSource == AllCarsBoth {
+		archived, err := s.GetArchiveCars()
+		if err != nil {
+			return err
+		}
+		for _, car := range archived {
+			if !listed[car.Slug] {
+				if err := sendCars(ctx, cars, []Car{car}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func sendCars(ctx context.Context, cars chan<- Car, list []Car) error {
+	for _, car := range list {
+		select {
+		case cars <- car:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package partasala
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	PageBrands    = "brands"
+	PageBrandCars = "brand_cars"
+)
+
+// StructureIssue is a page that parsed to nothing although it is expected to
+// list something, which usually means the site's markup changed.
+type StructureIssue struct {
+	Page       string    `json:"page"`
+	URL        string    `json:"url"`
+	Message    string    `json:"message"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// StructureMonitor watches how many items each parsed page yields and flags
+// probable markup changes: a homepage with no brands, or a brand page that
+// listed cars before and now lists none.
+type StructureMonitor struct {
+	mu          sync.Mutex
+	lastCounts  map[string]int
+	issues      map[string]StructureIssue
+	subscribers []func(StructureIssue)
+}
+
+func NewStructureMonitor() *StructureMonitor {
+	return &StructureMonitor{
+		lastCounts: make(map[string]int),
+		issues:     make(map[string]StructureIssue),
+	}
+}
+
+// Subscribe registers fn to be called once for every newly detected issue.
+func (m *StructureMonitor) Subscribe(fn func(StructureIssue)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subscribers = append(m.subscribers, fn)
+}
+
+// Observe records that the page at url of kind page parsed to count items.
+// It is safe to call on a nil monitor.
+func (m *StructureMonitor) Observe(page, url string, count int) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	if count > 0 {
+		if _, flagged := m.issues[url]; flagged {
+			log.Printf("structure: %s parses again (%d items)", url, count)
+			delete(m.issues, url)
+		}
+		m.lastCounts[url] = count
+		m.mu.Unlock()
+		return
+	}
+
+	_, flagged := m.issues[url]
+	previous := m.lastCounts[url]
+	if flagged || (page != PageBrands && previous == 0) {
+		m.mu.Unlock()
+		return
+	}
+
+	issue := StructureIssue{
+		Page:       page,
+		URL:        url,
+		DetectedAt: time.Now().UTC(),
+	}
+	if page == PageBrands {
+		issue.Message = "homepage parsed to zero brands"
+	} else {
+		issue.Message = "brand page parsed to zero cars after previously listing some"
+	}
+	m.issues[url] = issue
+	subscribers := append([]func(StructureIssue){}, m.subscribers...)
+	m.mu.Unlock()
+
+	log.Printf("ALERT structure: probable markup change at %s: %s", url, issue.Message)
+	for _, fn := range subscribers {
+		fn(issue)
+	}
+}
+
+// Issues returns the currently open issues, oldest first.
+func (m *StructureMonitor) Issues() []StructureIssue {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	issues := make([]StructureIssue, 0, len(m.issues))
+	for _, issue := range m.issues {
+		issues = append(issues, issue)
+	}
+	sort.Slice(issues, func(i, j int) bool {
+		return issues[i].DetectedAt.Before(issues[j].DetectedAt)
+	})
+	return issues
+}
@@ -0,0 +1,92 @@
+package partasala
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// FetchTrace follows the upstream requests made on behalf of one request
+// to the API. Requests made by a Scraper from WithContext with a context
+// carrying the trace send ID as X-Request-ID, are logged with it, and are
+// counted. Headless browser renders aren't traced.
+type FetchTrace struct {
+	ID string
+
+	fetches atomic.Int64
+	// scraped is the oldest ScrapedAt noted, in Unix nanoseconds, or 0
+	scraped atomic.Int64
+}
+
+// Fetches counts the upstream requests made under the trace so far.
+func (t *FetchTrace) Fetches() int64 {
+	return t.fetches.Load()
+}
+
+// NoteScraped records that data scraped at at was used under the trace:
+// a page fetched now, or one served from a cache.
+func (t *FetchTrace) NoteScraped(at time.Time) {
+	n := at.UnixNano()
+	for {
+		old := t.scraped.Load()
+		if old != 0 && old <= n {
+			return
+		}
+		if t.scraped.CompareAndSwap(old, n) {
+			return
+		}
+	}
+}
+
+// ScrapedAt returns when the oldest data used under the trace was scraped,
+// or false if none was noted.
+func (t *FetchTrace) ScrapedAt() (time.Time, bool) {
+	n := t.scraped.Load()
+	if n == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, n), true
+}
+
+type fetchTraceKey struct{}
+
+// WithFetchTrace returns a copy of ctx carrying trace.
+func WithFetchTrace(ctx context.Context, trace *FetchTrace) context.Context {
+	return context.WithValue(ctx, fetchTraceKey{}, trace)
+}
+
+// FetchTraceFrom returns the trace ctx carries, or nil.
+func FetchTraceFrom(ctx context.Context) *FetchTrace {
+	trace, _ := ctx.Value(fetchTraceKey{}).(*FetchTrace)
+	return trace
+}
+
+// traceTransport tags, counts, and logs the requests made under a
+// FetchTrace. It sits below the disk cache, so cache hits aren't traced;
+// the caches note when the pages they serve were scraped instead.
+type traceTransport struct {
+	next http.RoundTripper
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := FetchTraceFrom(req.Context())
+	if trace == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	trace.fetches.Add(1)
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Request-ID", trace.ID)
+	started := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(started).Round(time.Millisecond)
+	if err != nil {
+		log.Printf("upstream: [%s] %s %s failed after %s: %v", trace.ID, req.Method, req.URL, elapsed, err)
+		return nil, err
+	}
+	log.Printf("upstream: [%s] %s %s %d in %s", trace.ID, req.Method, req.URL, resp.StatusCode, elapsed)
+	trace.NoteScraped(started)
+	return resp, nil
+}
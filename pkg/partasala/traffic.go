@@ -0,0 +1,36 @@
+package partasala
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// countingTransport counts requests and response body bytes as they are
+// read.
+type countingTransport struct {
+	next     http.RoundTripper
+	requests atomic.Int64
+	bytes    atomic.Int64
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests.Add(1)
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &countingBody{ReadCloser: resp.Body, bytes: &t.bytes}
+	return resp, nil
+}
+
+type countingBody struct {
+	io.ReadCloser
+	bytes *atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.bytes.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,105 @@
+package partasala
+
+import (
+	"compress/gzip"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TransportConfig tunes connection reuse towards upstream. Idle connections
+// are kept per host so parallel brand fetches reuse them instead of opening
+// a new TLS handshake per page.
+type TransportConfig struct {
+	// MaxIdleConnsPerHost defaults to scraper.max_concurrency when 0
+	MaxIdleConnsPerHost int      `json:"max_idle_conns_per_host"`
+	IdleConnTimeout     Duration `json:"idle_conn_timeout"`
+	DisableHTTP2        bool     `json:"disable_http2"`
+}
+
+func newUpstreamTransport(config TransportConfig, maxConcurrency int) *http.Transport {
+	idlePerHost := config.MaxIdleConnsPerHost
+	if idlePerHost <= 0 {
+		idlePerHost = maxConcurrency
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     !config.DisableHTTP2,
+		MaxIdleConns:          idlePerHost * 4,
+		MaxIdleConnsPerHost:   idlePerHost,
+		IdleConnTimeout:       config.IdleConnTimeout.Duration,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		// gzipTransport asks for and decodes compressed pages itself, above
+		// the traffic counter, so the counter sees bytes on the wire
+		DisableCompression: true,
+	}
+	if config.DisableHTTP2 {
+		// A non-nil, empty map is how net/http is told not to upgrade
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport
+}
+
+// gzipTransport asks upstream for gzip-compressed pages and decodes them, so
+// the layers above it see plain HTML just as with net/http's transparent
+// decompression.
+type gzipTransport struct {
+	next http.RoundTripper
+}
+
+func (t *gzipTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Requests that choose their own encoding get the body as sent
+	if req.Header.Get("Accept-Encoding") != "" || req.Method == "HEAD" {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return resp, nil
+	}
+	resp.Body = &gzipBody{body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+// gzipBody reads the gzip header on the first Read rather than in
+// RoundTrip, so an empty error body doesn't fail the request.
+type gzipBody struct {
+	body io.ReadCloser
+	zr   *gzip.Reader
+	err  error
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.zr == nil {
+		b.zr, b.err = gzip.NewReader(b.body)
+		if b.err != nil {
+			return 0, b.err
+		}
+	}
+	return b.zr.Read(p)
+}
+
+func (b *gzipBody) Close() error {
+	return b.body.Close()
+}
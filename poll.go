@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// pollStart is the cursor handed out while the event log is empty: it
+// sorts before every event's key.
+const pollStart = "0"
+
+const (
+	defaultPollTimeout = 30 * time.Second
+	maxPollTimeout     = 120 * time.Second
+)
+
+// PollResponse is a /poll answer: the events since the cursor asked with,
+// and the cursor to ask for the next ones with.
+type PollResponse struct {
+	Success bool          `json:"success"`
+	Cursor  string        `json:"cursor"`
+	Count   int           `json:"count"`
+	Data    []ChangeEvent `json:"data"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// pollHandler serves GET /poll: the change events logged after ?cursor,
+// oldest first. When there are none yet it waits for some, up to ?timeout
+// seconds, before answering with none. Without a cursor it answers at once
+// with the cursor of the newest event, to start polling from.
+func pollHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	badRequest := func(message string) {
+		respond(w, r, http.StatusBadRequest, PollResponse{
+			Success: false,
+			Data:    []ChangeEvent{},
+			Error:   message,
+		})
+	}
+
+	filter := EventFilter{Brand: query.Get("brand")}
+	if v := query.Get("type"); v != "" {
+		types, err := parseEventTypes(v)
+		if err != nil {
+			badRequest(err.Error())
+			return
+		}
+		filter.Types = types
+	}
+	timeout := defaultPollTimeout
+	if v := query.Get("timeout"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || time.Duration(n)*time.Second > maxPollTimeout {
+			badRequest(fmt.Sprintf("timeout must be between 0 and %d seconds", int(maxPollTimeout.Seconds())))
+			return
+		}
+		timeout = time.Duration(n) * time.Second
+	}
+	limit := defaultEventHistoryLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxEventHistoryLimit {
+			badRequest("limit must be between 1 and " + strconv.Itoa(maxEventHistoryLimit))
+			return
+		}
+		limit = n
+	}
+
+	cursor := query.Get("cursor")
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		// Ask to be woken before looking, so events logged in between
+		// aren't missed
+		appended := dataset.EventsAppended()
+		events, next, err := dataset.EventsAfter(cursor, filter, limit)
+		if err != nil {
+			respond(w, r, http.StatusInternalServerError, PollResponse{
+				Success: false,
+				Cursor:  cursor,
+				Data:    []ChangeEvent{},
+				Error:   err.Error(),
+			})
+			return
+		}
+		// Events of other types or brands move the cursor on too
+		cursor = next
+		if cursor == "" {
+			cursor = pollStart
+		}
+		if len(events) > 0 || query.Get("cursor") == "" {
+			respond(w, r, http.StatusOK, PollResponse{
+				Success: true,
+				Cursor:  cursor,
+				Count:   len(events),
+				Data:    events,
+			})
+			return
+		}
+
+		select {
+		case <-appended:
+		case <-deadline.C:
+			respond(w, r, http.StatusOK, PollResponse{
+				Success: true,
+				Cursor:  cursor,
+				Data:    events,
+			})
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const bucketPopularity = "popularity"
+
+const (
+	// popularityRetentionDays is how long daily view counts are kept.
+	popularityRetentionDays = 90
+	defaultPopularDays      = 7
+	defaultPopularLimit     = 20
+	maxPopularLimit         = 200
+)
+
+// PopularityCounter counts how often each car's details are viewed through
+// the API, per UTC day. Like UsageMeter it keeps today's counts in memory
+// and saves them to the store from Run, keyed by the day and the car's
+// slug, so a restart loses at most one interval of them.
+type PopularityCounter struct {
+	store Store
+
+	mu     sync.Mutex
+	day    string
+	counts map[string]int64
+	dirty  map[string]bool
+}
+
+var popularity *PopularityCounter
+
+func NewPopularityCounter(store Store) *PopularityCounter {
+	return &PopularityCounter{store: store, counts: map[string]int64{}, dirty: map[string]bool{}}
+}
+
+// count returns the count under key, reading it from the store the first
+// time. The caller holds c.mu.
+func (c *PopularityCounter) count(key string) int64 {
+	if n, ok := c.counts[key]; ok {
+		return n
+	}
+	var n int64
+	if err := getJSON(c.store, bucketPopularity, key, &n); err != nil && err != ErrNotFound {
+		log.Printf("popularity: %v", err)
+	}
+	c.counts[key] = n
+	return n
+}
+
+// forget drops the counts in memory, flushed or not, for when the stored
+// ones are replaced, as by a restore.
+func (c *PopularityCounter) forget() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts, c.dirty = map[string]int64{}, map[string]bool{}
+}
+
+// View counts a view of slug's details at now.
+func (c *PopularityCounter) View(slug string, now time.Time) {
+	key := now.UTC().Format(usageDayLayout) + "/" + slug
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[key] = c.count(key) + 1
+	c.dirty[key] = true
+}
+
+// CarViews is how often a car's details were viewed.
+type CarViews struct {
+	Slug  string `json:"slug"`
+	Name  string `json:"name,omitempty"`
+	Views int64  `json:"views"`
+}
+
+// Views returns how often each car was viewed over the last days days up
+// to now, today included.
+func (c *PopularityCounter) Views(days int, now time.Time) (map[string]int64, error) {
+	from := now.UTC().AddDate(0, 0, -(days - 1)).Format(usageDayLayout)
+	views := map[string]int64{}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.store.ForEach(bucketPopularity, func(key string, value []byte) error {
+		day, slug, _ := strings.Cut(key, "/")
+		if day < from {
+			return nil
+		}
+		if _, ok := c.counts[key]; ok {
+			return nil
+		}
+		var n int64
+		if err := json.Unmarshal(value, &n); err != nil {
+			return fmt.Errorf("popularity %s: %v", key, err)
+		}
+		views[slug] += n
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for key, n := range c.counts {
+		if day, slug, _ := strings.Cut(key, "/"); day >= from {
+			views[slug] += n
+		}
+	}
+	return views, nil
+}
+
+// Popular returns the limit most viewed cars of the last days days up to
+// now, today included, most viewed first.
+func (c *PopularityCounter) Popular(days, limit int, now time.Time) ([]CarViews, error) {
+	views, err := c.Views(days, now)
+	if err != nil {
+		return nil, err
+	}
+
+	popular := make([]CarViews, 0, len(views))
+	for slug, n := range views {
+		popular = append(popular, CarViews{Slug: slug, Views: n})
+	}
+	sort.Slice(popular, func(i, j int) bool {
+		if popular[i].Views != popular[j].Views {
+			return popular[i].Views > popular[j].Views
+		}
+		return popular[i].Slug < popular[j].Slug
+	})
+	if len(popular) > limit {
+		popular = popular[:limit]
+	}
+	return popular, nil
+}
+
+// Flush saves the counts changed since the last flush. Once a day it also
+// drops past days from memory and deletes counts older than
+// popularityRetentionDays from the store.
+func (c *PopularityCounter) Flush(now time.Time) error {
+	today := now.UTC().Format(usageDayLayout)
+	c.mu.Lock()
+	puts := make(map[string][]byte, len(c.dirty))
+	for key := range c.dirty {
+		data, err := json.Marshal(c.counts[key])
+		if err != nil {
+			c.mu.Unlock()
+			return err
+		}
+		puts[key] = data
+	}
+	c.dirty = map[string]bool{}
+	newDay := c.day != today
+	if newDay {
+		c.day = today
+		for key := range c.counts {
+			if !strings.HasPrefix(key, today+"/") {
+				delete(c.counts, key)
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	var deletes []string
+	if newDay {
+		oldest := now.UTC().AddDate(0, 0, -popularityRetentionDays).Format(usageDayLayout)
+		err := c.store.ForEach(bucketPopularity, func(key string, value []byte) error {
+			if day, _, _ := strings.Cut(key, "/"); day < oldest {
+				deletes = append(deletes, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(puts) == 0 && len(deletes) == 0 {
+		return nil
+	}
+	return c.store.Batch(bucketPopularity, puts, deletes)
+}
+
+// Run flushes the counts every interval until ctx is cancelled.
+func (c *PopularityCounter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := c.Flush(now); err != nil {
+				log.Printf("popularity: %v", err)
+				recent.addError("popularity", err)
+			}
+		}
+	}
+}
+
+// popularCarsHandler serves GET /stats/popular: the cars whose details
+// were viewed most over the last ?days days, named from their stored
+// details.
+func popularCarsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	days := defaultPopularDays
+	if v := query.Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > popularityRetentionDays {
+			respond(w, r, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   fmt.Sprintf("days must be between 1 and %d", popularityRetentionDays),
+			})
+			return
+		}
+		days = n
+	}
+	limit := defaultPopularLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPopularLimit {
+			respond(w, r, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   fmt.Sprintf("limit must be between 1 and %d", maxPopularLimit),
+			})
+			return
+		}
+		limit = n
+	}
+
+	popular, err := popularity.Popular(days, limit, time.Now())
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	for i := range popular {
+		if details, err := dataset.Details(popular[i].Slug); err == nil {
+			popular[i].Name = details.Name
+		}
+	}
+	respond(w, r, http.StatusOK, APIResponse{
+		Success: true,
+		Count:   len(popular),
+		Data:    popular,
+	})
+}
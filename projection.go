@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/jmespath/go-jmespath"
+)
+
+// wantsProjection reports whether the request asks for its response to be
+// projected with a JMESPath ?query.
+func wantsProjection(r *http.Request) bool {
+	return r.URL.Query().Get("query") != ""
+}
+
+// projectResponse applies the JMESPath expression to v as clients see it:
+// the expression runs over v's JSON form, field names and all, e.g.
+// data[?thumbnail].url for the URLs of the cars with a thumbnail.
+func projectResponse(expression string, v interface{}) (interface{}, error) {
+	compiled, err := jmespath.Compile(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query %q: %v", expression, err)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	projected, err := compiled.Search(document)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query %q: %v", expression, err)
+	}
+	return projected, nil
+}
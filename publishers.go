@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+const (
+	CarEventAdded   = "car.added"
+	CarEventRemoved = "car.removed"
+	CarEventUpdated = "car.updated"
+)
+
+// CarEvent is a change to one car's listing found by a crawl, as published
+// to event streams.
+type CarEvent struct {
+	Type string    `json:"type"`
+	At   time.Time `json:"at"`
+	Car  Car       `json:"car"`
+}
+
+// carEvents lists the changes in diff, found at at.
+func carEvents(diff CrawlDiff, at time.Time) []CarEvent {
+	events := make([]CarEvent, 0, len(diff.Added)+len(diff.Removed)+len(diff.Updated))
+	for _, group := range []struct {
+		kind string
+		cars []Car
+	}{{CarEventAdded, diff.Added}, {CarEventRemoved, diff.Removed}, {CarEventUpdated, diff.Updated}} {
+		for _, car := range group.cars {
+			events = append(events, CarEvent{Type: group.kind, At: at, Car: rewriteImageURLs(car).(Car)})
+		}
+	}
+	return events
+}
+
+// EventPublisherConfig configures an event stream that gets every crawl's
+// changes. "kafka" needs Brokers and Topic, "nats" needs URL and Subject,
+// and "mqtt" needs URL and TopicPrefix; Username and Password are
+// SASL/PLAIN credentials for Kafka and user credentials otherwise. Events
+// and Filters narrow down what is published, as for notifiers.
+type EventPublisherConfig struct {
+	Type        string      `json:"type"`
+	Brokers     []string    `json:"brokers"`
+	Topic       string      `json:"topic"`
+	TLS         bool        `json:"tls"`
+	URL         string      `json:"url"`
+	Subject     string      `json:"subject"`
+	TopicPrefix string      `json:"topic_prefix"`
+	ClientID    string      `json:"client_id"`
+	Username    string      `json:"username"`
+	Password    string      `json:"password"`
+	Events      []string    `json:"events"`
+	Filters     []CarFilter `json:"filters"`
+}
+
+// EventPublisher sends car events to a message broker.
+type EventPublisher interface {
+	Publish(events []CarEvent) error
+}
+
+// NewEventPublisher builds the publisher described by config.
+func NewEventPublisher(config EventPublisherConfig) (EventPublisher, error) {
+	events := map[string]bool{}
+	for _, event := range config.Events {
+		switch event {
+		case CarEventAdded, CarEventRemoved, CarEventUpdated:
+			events[event] = true
+		default:
+			return nil, fmt.Errorf("unknown event publisher event %q", event)
+		}
+	}
+	if len(events) == 0 {
+		events = map[string]bool{CarEventAdded: true, CarEventRemoved: true, CarEventUpdated: true}
+	}
+
+	var publisher EventPublisher
+	switch config.Type {
+	case "kafka":
+		if len(config.Brokers) == 0 || config.Topic == "" {
+			return nil, fmt.Errorf("kafka event publisher requires brokers and topic")
+		}
+		transport := &kafka.Transport{}
+		if config.TLS {
+			transport.TLS = &tls.Config{}
+		}
+		if config.Username != "" {
+			transport.SASL = plain.Mechanism{Username: config.Username, Password: config.Password}
+		}
+		publisher = &kafkaPublisher{writer: &kafka.Writer{
+			Addr:         kafka.TCP(config.Brokers...),
+			Topic:        config.Topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			Transport:    transport,
+		}}
+	case "nats":
+		if config.URL == "" || config.Subject == "" {
+			return nil, fmt.Errorf("nats event publisher requires url and subject")
+		}
+		options := []nats.Option{nats.Name("partasala-scraper"), nats.RetryOnFailedConnect(true), nats.MaxReconnects(-1)}
+		if config.Username != "" {
+			options = append(options, nats.UserInfo(config.Username, config.Password))
+		}
+		conn, err := nats.Connect(config.URL, options...)
+		if err != nil {
+			return nil, fmt.Errorf("nats: %v", err)
+		}
+		publisher = &natsPublisher{conn: conn, subject: config.Subject}
+	case "mqtt":
+		if config.URL == "" || config.TopicPrefix == "" {
+			return nil, fmt.Errorf("mqtt event publisher requires url and topic_prefix")
+		}
+		clientID := config.ClientID
+		if clientID == "" {
+			clientID = "partasala-scraper"
+		}
+		options := mqtt.NewClientOptions().
+			AddBroker(config.URL).
+			SetClientID(clientID).
+			SetUsername(config.Username).
+			SetPassword(config.Password).
+			SetAutoReconnect(true).
+			SetConnectRetry(true)
+		client := mqtt.NewClient(options)
+		// With connect retry on, the token only completes once the broker
+		// is reached, which mustn't hold up startup
+		client.Connect()
+		publisher = &mqttPublisher{client: client, prefix: strings.TrimRight(config.TopicPrefix, "/")}
+	default:
+		return nil, fmt.Errorf("unknown event publisher type %q", config.Type)
+	}
+	return &filteredPublisher{next: publisher, events: events, filters: config.Filters}, nil
+}
+
+// filteredPublisher drops events of types it is not subscribed to, and
+// events for cars that match none of its filters. With no filters every
+// car is passed on.
+type filteredPublisher struct {
+	next    EventPublisher
+	events  map[string]bool
+	filters []CarFilter
+}
+
+func (p *filteredPublisher) Publish(events []CarEvent) error {
+	kept := []CarEvent{}
+	for _, event := range events {
+		if !p.events[event.Type] {
+			continue
+		}
+		matched := len(p.filters) == 0
+		for _, filter := range p.filters {
+			if filter.Matches(event.Car) {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			kept = append(kept, event)
+		}
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	return p.next.Publish(kept)
+}
+
+// EventPublishers publishes to several brokers, logging failures.
+type EventPublishers []EventPublisher
+
+func (ps EventPublishers) Publish(events []CarEvent) {
+	if len(events) == 0 {
+		return
+	}
+	for _, publisher := range ps {
+		if err := publisher.Publish(events); err != nil {
+			log.Printf("publisher: %v", err)
+			recent.addError("publisher", err)
+		}
+	}
+}
+
+// kafkaPublisher writes each event to the topic keyed by car slug, so a
+// car's events stay in order on one partition, with its type in a "type"
+// header.
+type kafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+func (p *kafkaPublisher) Publish(events []CarEvent) error {
+	messages := make([]kafka.Message, 0, len(events))
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, kafka.Message{
+			Key:     []byte(event.Car.Slug),
+			Value:   data,
+			Headers: []kafka.Header{{Key: "type", Value: []byte(event.Type)}},
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := p.writer.WriteMessages(ctx, messages...); err != nil {
+		return fmt.Errorf("kafka: %v", err)
+	}
+	return nil
+}
+
+// natsPublisher publishes each event to the subject followed by the event
+// type, e.g. partasala.car.added, so subscribers can pick types with
+// wildcards.
+type natsPublisher struct {
+	conn    *nats.Conn
+	subject string
+}
+
+func (p *natsPublisher) Publish(events []CarEvent) error {
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if err := p.conn.Publish(p.subject+"."+event.Type, data); err != nil {
+			return fmt.Errorf("nats: %v", err)
+		}
+	}
+	if err := p.conn.FlushTimeout(10 * time.Second); err != nil {
+		return fmt.Errorf("nats: %v", err)
+	}
+	return nil
+}
+
+// mqttPublisher publishes each event with QoS 1 to the topic prefix
+// followed by the event type as topic levels, e.g. partasala/car/added.
+type mqttPublisher struct {
+	client mqtt.Client
+	prefix string
+}
+
+func (p *mqttPublisher) Publish(events []CarEvent) error {
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		topic := p.prefix + "/" + strings.ReplaceAll(event.Type, ".", "/")
+		token := p.client.Publish(topic, 1, false, data)
+		if !token.WaitTimeout(10 * time.Second) {
+			return fmt.Errorf("mqtt: publishing to %s timed out", topic)
+		}
+		if err := token.Error(); err != nil {
+			return fmt.Errorf("mqtt: %v", err)
+		}
+	}
+	return nil
+}
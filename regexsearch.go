@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"unicode/utf8"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+const (
+	// maxPatternLength and maxPatternInsts bound how much work a regex
+	// search pattern can ask for. Go's regexps run in linear time, so the
+	// size of the compiled program is what's left to limit.
+	maxPatternLength = 200
+	maxPatternInsts  = 2000
+)
+
+// compileSearchPattern compiles a ?mode=regex search pattern, matched
+// without regard to case, refusing patterns that are too long or compile
+// to too large a program.
+func compileSearchPattern(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) > maxPatternLength {
+		return nil, fmt.Errorf("pattern is longer than %d characters", maxPatternLength)
+	}
+	parsed, err := syntax.Parse(pattern, syntax.Perl|syntax.FoldCase)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %v", err)
+	}
+	prog, err := syntax.Compile(parsed.Simplify())
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %v", err)
+	}
+	if len(prog.Inst) > maxPatternInsts {
+		return nil, fmt.Errorf("pattern is too complex")
+	}
+	return regexp.Compile("(?i)" + pattern)
+}
+
+// regexSearch returns the cars from list whose name matches pattern.
+func regexSearch(list func() ([]Car, error), pattern *regexp.Regexp) ([]Car, error) {
+	cars, err := list()
+	if err != nil {
+		return nil, err
+	}
+	results := []Car{}
+	for _, car := range cars {
+		if pattern.MatchString(car.Name) {
+			car.MatchType = "regex"
+			results = append(results, car)
+		}
+	}
+	return results, nil
+}
+
+// regexHighlights returns where pattern matches name, in characters.
+func regexHighlights(pattern *regexp.Regexp, name string) []partasala.Highlight {
+	var highlights []partasala.Highlight
+	for _, match := range pattern.FindAllStringIndex(name, -1) {
+		if match[0] == match[1] {
+			continue
+		}
+		highlights = append(highlights, partasala.Highlight{
+			Start: utf8.RuneCountInString(name[:match[0]]),
+			End:   utf8.RuneCountInString(name[:match[1]]),
+		})
+	}
+	return highlights
+}
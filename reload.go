@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+// ScraperReloader is implemented by site adapters that can apply changed
+// scraper settings while running, like partasala.Scraper's Reload.
+type ScraperReloader interface {
+	Reload(config partasala.Config) error
+}
+
+// Reloader re-reads the config file and applies the settings that can
+// change without a restart, keeping the caches warm: the scraper's
+// selectors, disk cache TTL, concurrency, and daily request budget, the
+// search cache, the quotas, and the notifiers. Other settings keep their
+// values until the next restart.
+type Reloader struct {
+	path      string
+	notifiers *NotifierSet
+
+	mu sync.Mutex
+}
+
+func NewReloader(path string, notifiers *NotifierSet) *Reloader {
+	return &Reloader{path: path, notifiers: notifiers}
+}
+
+// Reload applies the config file, returning the names of the settings it
+// applied. A file that fails to load or validate changes nothing.
+func (r *Reloader) Reload() ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	config, err := LoadConfig(r.path)
+	if err != nil {
+		return nil, err
+	}
+	scraperConfig := config.Scraper
+	if scraperConfig.SelectorsFile != "" {
+		scraperConfig.Selectors, err = LoadSelectors(scraperConfig.SelectorsFile, config.Scraper.Selectors)
+		if err != nil {
+			return nil, err
+		}
+	}
+	notifiers, err := NewNotifiers(config.Notifiers)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []string{}
+	if reloadable, ok := scraper.(ScraperReloader); ok {
+		if err := reloadable.Reload(scraperConfig); err != nil {
+			return nil, err
+		}
+		applied = append(applied, "scraper.selectors", "scraper.cache.ttl", "scraper.max_concurrency", "scraper.daily_request_budget")
+	}
+	searches.setConfig(config.SearchCache)
+	usage.SetQuotas(config.Quotas)
+	r.notifiers.Set(notifiers)
+	return append(applied, "search_cache", "quotas", "notifiers"), nil
+}
+
+// ReloadOnSignal reloads the config whenever the process gets SIGHUP,
+// until ctx is cancelled. Failures are logged and the running settings
+// stay in effect.
+func (r *Reloader) ReloadOnSignal(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		if _, err := r.Reload(); err != nil {
+			log.Printf("reload: keeping running config: %v", err)
+			recent.addError("reload", err)
+			continue
+		}
+		log.Printf("reload: applied %s", r.describe())
+	}
+}
+
+// describe names the config file for the log.
+func (r *Reloader) describe() string {
+	if r.path == "" {
+		return "default config"
+	}
+	return r.path
+}
+
+// reloadHandler serves POST /admin/reload.
+func reloadHandler(reloader *Reloader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		applied, err := reloader.Reload()
+		if err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+
+		log.Printf("reload: applied %s", reloader.describe())
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: true,
+			Data:    map[string][]string{"applied": applied},
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const bucketRemoved = "removed_cars"
+
+// RemovedCar is the last-known record of a car that was taken down from
+// the site: its listing as last crawled, with when crawls first and last
+// saw it and when it was removed, and its stored details if any were
+// fetched. A car that is listed again leaves the archive, so the archive
+// holds a tombstone for every car that is gone.
+type RemovedCar struct {
+	Car
+	Details *CarDetails `json:"details"`
+}
+
+// RemovedCars returns the archived cars removed at or after since, most
+// recently removed first, up to limit.
+func (d *Dataset) RemovedCars(since time.Time, brand string, limit int) ([]RemovedCar, error) {
+	cars := []RemovedCar{}
+	err := d.store.ForEach(bucketRemoved, func(key string, value []byte) error {
+		var car RemovedCar
+		if err := json.Unmarshal(value, &car); err != nil {
+			return fmt.Errorf("removed car %s: %v", key, err)
+		}
+		if car.RemovedAt == nil || car.RemovedAt.Before(since) || (brand != "" && car.Brand != brand) {
+			return nil
+		}
+		cars = append(cars, car)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(cars, func(i, j int) bool {
+		return cars[i].RemovedAt.After(*cars[j].RemovedAt)
+	})
+	if len(cars) > limit {
+		cars = cars[:limit]
+	}
+	return cars, nil
+}
+
+// wantsRemoved reports whether a list request asked for removed cars as
+// well, with ?include_removed=true.
+func wantsRemoved(r *http.Request) bool {
+	return r.URL.Query().Get("include_removed") == "true"
+}
+
+// removedListing returns the removed cars of brand, or of every brand if
+// brand is empty, leaving out those in listed: a car can be listed again
+// before the next crawl takes it out of the archive.
+func removedListing(brand string, listed map[string]bool) ([]Car, error) {
+	removed, err := dataset.RemovedCars(time.Time{}, brand, math.MaxInt)
+	if err != nil {
+		return nil, err
+	}
+	cars := []Car{}
+	for _, car := range removed {
+		if !listed[car.Slug] {
+			cars = append(cars, car.Car)
+		}
+	}
+	return cars, nil
+}
+
+// appendRemoved adds the removed cars of brand to its listed cars.
+func appendRemoved(cars []Car, brand string) ([]Car, error) {
+	listed := make(map[string]bool, len(cars))
+	for _, car := range cars {
+		listed[car.Slug] = true
+	}
+	removed, err := removedListing(brand, listed)
+	if err != nil {
+		return nil, err
+	}
+	return append(cars, removed...), nil
+}
+
+// withRemoved follows the cars produce sends with the removed cars, most
+// recently removed first.
+func withRemoved(produce func(ctx context.Context, out chan<- Car) error) func(ctx context.Context, out chan<- Car) error {
+	return func(ctx context.Context, out chan<- Car) error {
+		cars := make(chan Car)
+		errc := make(chan error, 1)
+		go func() {
+			defer close(cars)
+			errc <- produce(ctx, cars)
+		}()
+		listed := map[string]bool{}
+		for car := range cars {
+			listed[car.Slug] = true
+			select {
+			case out <- car:
+			case <-ctx.Done():
+				// Drain so the producer can finish
+				for range cars {
+				}
+				return ctx.Err()
+			}
+		}
+		if err := <-errc; err != nil {
+			return err
+		}
+
+		removed, err := removedListing("", listed)
+		if err != nil {
+			return err
+		}
+		for _, car := range removed {
+			select {
+			case out <- car:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+}
+
+func getRemovedCarsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var since time.Time
+	if v := query.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Error:   "since must be an RFC 3339 time, e.g. 2024-05-01T00:00:00Z",
+			})
+			return
+		}
+		since = t
+	}
+	limit := defaultEventHistoryLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxEventHistoryLimit {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Error:   "limit must be between 1 and " + strconv.Itoa(maxEventHistoryLimit),
+			})
+			return
+		}
+		limit = n
+	}
+
+	cars, err := dataset.RemovedCars(since, query.Get("brand"), limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(rewriteImageURLs(APIResponse{
+		Success: true,
+		Count:   len(cars),
+		Data:    cars,
+	}))
+}
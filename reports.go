@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const bucketCrawlReports = "crawl_reports"
+
+// maxCrawlReports bounds how many crawl reports are kept in the store.
+const maxCrawlReports = 500
+
+// CrawlReport is saved after every crawl, successful or not, for capacity
+// planning. Dry runs are not reported.
+type CrawlReport struct {
+	// ID is the report's key in the store, which sorts by start time
+	ID           string     `json:"id"`
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   time.Time  `json:"finished_at"`
+	DurationMS   int64      `json:"duration_ms"`
+	Scope        CrawlScope `json:"scope"`
+	PagesFetched int        `json:"pages_fetched"`
+	// BytesDownloaded counts everything the scraper downloaded from upstream
+	// while the crawl ran, including pages fetched for API requests
+	BytesDownloaded int64         `json:"bytes_downloaded"`
+	Cars            int           `json:"cars"`
+	Added           int           `json:"added"`
+	Removed         int           `json:"removed"`
+	Updated         int           `json:"updated"`
+	Brands          []BrandTiming `json:"brands"`
+	ParseFailures   []string      `json:"parse_failures"`
+	Error           string        `json:"error,omitempty"`
+}
+
+type BrandTiming struct {
+	Brand      string `json:"brand"`
+	DurationMS int64  `json:"duration_ms"`
+	Cars       int    `json:"cars"`
+	Error      string `json:"error,omitempty"`
+}
+
+// crawlReportKey sorts reports by start time.
+func crawlReportKey(started time.Time) string {
+	return started.UTC().Format("20060102T150405.000000000")
+}
+
+// saveCrawlReport stores report and drops the oldest reports beyond
+// maxCrawlReports.
+func saveCrawlReport(store Store, report *CrawlReport) error {
+	report.ID = crawlReportKey(report.StartedAt)
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{}
+	err = store.ForEach(bucketCrawlReports, func(key string, value []byte) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	deletes := []string{}
+	if excess := len(keys) + 1 - maxCrawlReports; excess > 0 {
+		deletes = keys[:excess]
+	}
+
+	return store.Batch(bucketCrawlReports, map[string][]byte{report.ID: data}, deletes)
+}
+
+// CrawlReports returns up to limit reports, newest first.
+func CrawlReports(store Store, limit int) ([]CrawlReport, error) {
+	all := []CrawlReport{}
+	err := store.ForEach(bucketCrawlReports, func(key string, value []byte) error {
+		var report CrawlReport
+		if err := json.Unmarshal(value, &report); err != nil {
+			return err
+		}
+		report.ID = key
+		all = append(all, report)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reports := []CrawlReport{}
+	for i := len(all) - 1; i >= 0 && len(reports) < limit; i-- {
+		reports = append(reports, all[i])
+	}
+	return reports, nil
+}
+
+func crawlReportsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 10
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxCrawlReports {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Error:   "limit must be between 1 and " + strconv.Itoa(maxCrawlReports),
+			})
+			return
+		}
+		limit = n
+	}
+
+	reports, err := CrawlReports(dataset.store, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Count:   len(reports),
+		Data:    reports,
+	})
+}
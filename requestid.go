@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+// maxRequestIDLength bounds the X-Request-ID values accepted from clients.
+const maxRequestIDLength = 128
+
+// validRequestID reports whether a client's X-Request-ID is safe to log
+// and send on: printable ASCII without spaces or quotes.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the ID requestIDMiddleware gave r, or "".
+func requestID(r *http.Request) string {
+	if trace := partasala.FetchTraceFrom(r.Context()); trace != nil {
+		return trace.ID
+	}
+	return ""
+}
+
+// requestIDMiddleware gives every request an ID: the client's X-Request-ID
+// if it sent a usable one, or a new one. The ID is sent back as
+// X-Request-ID, logged with the request, and sent with the upstream
+// requests made on its behalf, which are logged with it too.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			var err error
+			if id, err = newID(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("X-Request-ID", id)
+		ctx := partasala.WithFetchTrace(r.Context(), &partasala.FetchTrace{ID: id})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// errorIDWriter holds back JSON error bodies so request_id can be added to
+// them.
+type errorIDWriter struct {
+	http.ResponseWriter
+	id string
+
+	held bool
+	body bytes.Buffer
+}
+
+func (w *errorIDWriter) WriteHeader(status int) {
+	if status >= 400 && strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		w.held = true
+		w.Header().Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *errorIDWriter) Write(p []byte) (int, error) {
+	if w.held {
+		return w.body.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *errorIDWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok && !w.held {
+		flusher.Flush()
+	}
+}
+
+func (w *errorIDWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hijack(w.ResponseWriter)
+}
+
+// finish sends the held body with request_id added as its last field,
+// or as it was if it isn't a JSON object.
+func (w *errorIDWriter) finish() {
+	if !w.held {
+		return
+	}
+	body := bytes.TrimRight(w.body.Bytes(), " \r\n\t")
+	if !json.Valid(body) || !bytes.HasPrefix(body, []byte("{")) {
+		w.ResponseWriter.Write(w.body.Bytes())
+		return
+	}
+	id, _ := json.Marshal(w.id)
+	out := bytes.TrimRight(body[:len(body)-1], " \r\n\t")
+	if !bytes.HasSuffix(out, []byte("{")) {
+		out = append(out, ',')
+	}
+	out = append(out, `"request_id":`...)
+	out = append(append(append(out, id...), '}'), '\n')
+	w.ResponseWriter.Write(out)
+}
+
+// errorRequestIDMiddleware adds the request's ID to JSON error responses
+// as request_id, so a failure reported from a client can be found in the
+// logs. It runs inside compressMiddleware, which compresses the result.
+func errorRequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestID(r)
+		// /v2 errors carry it in their error object already
+		if id == "" || isV2(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ew := &errorIDWriter{ResponseWriter: w, id: id}
+		defer ew.finish()
+		next.ServeHTTP(ew, r)
+	})
+}
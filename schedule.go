@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// CrawlSchedule runs a crawl of Scope whenever the standard five-field cron
+// expression Cron matches, in local time unless it starts with CRON_TZ=.
+type CrawlSchedule struct {
+	Cron  string     `json:"cron"`
+	Scope CrawlScope `json:"scope"`
+}
+
+func parseCron(expr string) (cron.Schedule, error) {
+	return cron.ParseStandard(expr)
+}
+
+// RunSchedules records a baseline with a full crawl, then crawls each
+// schedule's scope when it is due until ctx is cancelled. Runs missed while
+// another crawl was in progress are skipped, not queued up.
+func (c *Crawler) RunSchedules(ctx context.Context, schedules []CrawlSchedule) {
+	parsed := make([]cron.Schedule, len(schedules))
+	for i, schedule := range schedules {
+		var err error
+		if parsed[i], err = parseCron(schedule.Cron); err != nil {
+			log.Printf("crawler: schedule %q: %v", schedule.Cron, err)
+			return
+		}
+	}
+
+	c.Crawl()
+
+	now := time.Now()
+	next := make([]time.Time, len(parsed))
+	for i, schedule := range parsed {
+		next[i] = schedule.Next(now)
+	}
+
+	for {
+		due := 0
+		for i := range next {
+			if next[i].Before(next[due]) {
+				due = i
+			}
+		}
+
+		timer := time.NewTimer(time.Until(next[due]))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		c.CrawlContext(ctx, schedules[due].Scope, nil)
+		next[due] = parsed[due].Next(time.Now())
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+const contentTypeSchema = "application/schema+json"
+
+// schemas are the published JSON Schemas by file name, such as
+// "car.json", parsed for validating responses against.
+var schemas = map[string]map[string]interface{}{}
+
+func init() {
+	entries, err := schemaFiles.ReadDir("schemas")
+	if err != nil {
+		panic(err)
+	}
+	for _, entry := range entries {
+		data, err := schemaFiles.ReadFile("schemas/" + entry.Name())
+		if err != nil {
+			panic(err)
+		}
+		var schema map[string]interface{}
+		if err := json.Unmarshal(data, &schema); err != nil {
+			panic(fmt.Sprintf("schemas/%s: %v", entry.Name(), err))
+		}
+		schemas[entry.Name()] = schema
+	}
+}
+
+// validateResponses is set by -validate-responses, to check JSON responses
+// against the published schemas and log where they don't match.
+var validateResponses bool
+
+// envelopeSchemas name the schema of each response envelope type.
+var envelopeSchemas = map[reflect.Type]string{
+	reflect.TypeOf(APIResponse{}):    "api-response.json",
+	reflect.TypeOf(BrandResponse{}):  "brand-response.json",
+	reflect.TypeOf(CarResponse{}):    "car-response.json",
+	reflect.TypeOf(SearchResponse{}): "search-response.json",
+}
+
+// routeDataSchemas name the schema of each element of data for the routes
+// whose APIResponse holds a list of a published type.
+var routeDataSchemas = map[string]string{
+	"/brands":              "brand.json",
+	"/brands/{brand_slug}": "car.json",
+	"/cars":                "car.json",
+}
+
+// listSchemasHandler serves GET /schemas: the names and links of the
+// published schemas.
+func listSchemasHandler(w http.ResponseWriter, r *http.Request) {
+	type schemaLink struct {
+		Name  string `json:"name"`
+		Title string `json:"title"`
+		URL   string `json:"url"`
+	}
+	links := []schemaLink{}
+	for name, schema := range schemas {
+		title, _ := schema["title"].(string)
+		links = append(links, schemaLink{Name: name, Title: title, URL: link("/schemas/" + name)})
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Name < links[j].Name })
+	respond(w, r, http.StatusOK, APIResponse{
+		Success: true,
+		Count:   len(links),
+		Data:    links,
+	})
+}
+
+// getSchemaHandler serves GET /schemas/{name}, one published schema as
+// written. Schemas refer to each other by relative URLs, so validators
+// fetching them from here find the rest.
+func getSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !strings.HasSuffix(name, ".json") {
+		name += ".json"
+	}
+	data, err := schemaFiles.ReadFile("schemas/" + path.Base(name))
+	if err != nil {
+		respond(w, r, http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   "Schema not found",
+		})
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeSchema)
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write(data)
+}
+
+// checkResponse validates body, the JSON answer to r made from v, against
+// the schema of v's envelope, and logs what doesn't match. Responses of
+// types without a published schema are left alone.
+func checkResponse(r *http.Request, v interface{}, body []byte) {
+	file, ok := envelopeSchemas[reflect.TypeOf(v)]
+	if !ok {
+		return
+	}
+	problems := checkJSON(file, body, "")
+	if response, ok := v.(APIResponse); ok && response.Success {
+		if route := mux.CurrentRoute(r); route != nil {
+			template, _ := route.GetPathTemplate()
+			if item, ok := routeDataSchemas[template]; ok {
+				var envelope struct {
+					Data []json.RawMessage `json:"data"`
+				}
+				if err := json.Unmarshal(body, &envelope); err == nil {
+					for i, element := range envelope.Data {
+						problems = append(problems, checkJSON(item, element, fmt.Sprintf("/data/%d", i))...)
+					}
+				}
+			}
+		}
+	}
+	logSchemaProblems(r, file, problems)
+}
+
+// checkJSON validates data, found at the JSON Pointer at of a response,
+// against the schema file, returning a message for each mismatch that
+// starts with where in the response it is.
+func checkJSON(file string, data []byte, at string) []string {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return []string{"not JSON: " + err.Error()}
+	}
+	v := schemaValidator{root: file}
+	v.check(schemas[file], value, at)
+	return v.problems
+}
+
+func logSchemaProblems(r *http.Request, file string, problems []string) {
+	for _, problem := range problems {
+		log.Printf("schema: %s %s does not match %s: %s", r.Method, r.URL.Path, file, problem)
+	}
+}
+
+// schemaValidator checks values against the parts of JSON Schema the
+// published schemas use: type, enum, format date-time, properties,
+// required, additionalProperties, items, anyOf, and $ref to another file
+// or to one of the current file's $defs.
+type schemaValidator struct {
+	root     string
+	problems []string
+}
+
+func (v *schemaValidator) fail(at, format string, args ...interface{}) {
+	if at == "" {
+		at = "top level"
+	}
+	v.problems = append(v.problems, at+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *schemaValidator) check(schema interface{}, value interface{}, at string) {
+	node, ok := schema.(map[string]interface{})
+	if !ok {
+		if schema == false {
+			v.fail(at, "not allowed")
+		}
+		return
+	}
+
+	if ref, ok := node["$ref"].(string); ok {
+		file, pointer, _ := strings.Cut(ref, "#")
+		if file != "" {
+			root := v.root
+			v.root = file
+			defer func() { v.root = root }()
+		}
+		target, found := interface{}(schemas[v.root]), schemas[v.root] != nil
+		if pointer != "" {
+			defs, _ := schemas[v.root]["$defs"].(map[string]interface{})
+			target, found = defs[strings.TrimPrefix(pointer, "/$defs/")]
+		}
+		if !found {
+			v.fail(at, "can't resolve $ref %q", ref)
+			return
+		}
+		v.check(target, value, at)
+		return
+	}
+
+	if anyOf, ok := node["anyOf"].([]interface{}); ok {
+		matched := false
+		for _, option := range anyOf {
+			attempt := schemaValidator{root: v.root}
+			attempt.check(option, value, at)
+			if len(attempt.problems) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.fail(at, "matches none of the allowed schemas")
+		}
+		return
+	}
+
+	if enum, ok := node["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if reflect.DeepEqual(allowed, value) {
+				found = true
+			}
+		}
+		if !found {
+			v.fail(at, "%v is not one of %v", value, enum)
+		}
+	}
+
+	if want, ok := node["type"]; ok && !schemaTypeMatches(want, value) {
+		v.fail(at, "is %s, want %v", jsonType(value), want)
+		return
+	}
+	if s, ok := value.(string); ok && node["format"] == "date-time" {
+		if _, err := time.Parse(time.RFC3339, s); err != nil {
+			v.fail(at, "%q is not an RFC 3339 date-time", s)
+		}
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		properties, _ := node["properties"].(map[string]interface{})
+		if required, ok := node["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := value[name.(string)]; !ok {
+					v.fail(at, "missing %q", name)
+				}
+			}
+		}
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if property, ok := properties[key]; ok {
+				v.check(property, value[key], at+"/"+key)
+			} else if additional, ok := node["additionalProperties"]; ok {
+				if additional == false {
+					v.fail(at, "unexpected %q", key)
+				} else {
+					v.check(additional, value[key], at+"/"+key)
+				}
+			}
+		}
+	case []interface{}:
+		if items, ok := node["items"]; ok {
+			for i, element := range value {
+				v.check(items, element, fmt.Sprintf("%s/%d", at, i))
+			}
+		}
+	}
+}
+
+// schemaTypeMatches reports whether value is of the schema type want, a
+// type name or a list of them.
+func schemaTypeMatches(want interface{}, value interface{}) bool {
+	names, ok := want.([]interface{})
+	if !ok {
+		names = []interface{}{want}
+	}
+	for _, name := range names {
+		switch have := jsonType(value); {
+		case name == have:
+			return true
+		case name == "number" && have == "integer":
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType names the JSON Schema type of a value decoded with UseNumber.
+func jsonType(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := value.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
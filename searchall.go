@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+// SiteCar is a search hit tagged with the site it was found on.
+type SiteCar struct {
+	Site string `json:"site"`
+	Car
+}
+
+// AggregatedSearchResponse is /search/all's page of hits from every site.
+// Errors holds the sites that couldn't be searched, by name; the others'
+// hits are still returned.
+type AggregatedSearchResponse struct {
+	Success bool              `json:"success"`
+	Query   string            `json:"query"`
+	Count   int               `json:"count"`
+	Total   int               `json:"total"`
+	Limit   int               `json:"limit"`
+	Offset  int               `json:"offset"`
+	Data    []SiteCar         `json:"data"`
+	Errors  map[string]string `json:"errors,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// searchMainSite searches the main site as /search does in its default
+// mode, short of a delegated search engine: from the search index once a
+// crawl has built it, otherwise live through the search cache, falling
+// back on the stored cars while upstream is down. Where the results came
+// from is noted on ctx's FetchTrace.
+func searchMainSite(ctx context.Context, site SiteScraper, query string) ([]Car, error) {
+	if ready, builtAt := searchIndex.Ready(); ready {
+		noteScraped(ctx, builtAt)
+		return searchIndex.Search(query)
+	}
+	key := searchCacheKey("", query)
+	if results, stored, cached := searches.get(key); cached {
+		noteScraped(ctx, stored)
+		return results, nil
+	}
+	results, err := site.SearchCars(strings.ToLower(query))
+	if err == nil {
+		searches.put(key, results)
+		return results, nil
+	}
+	if upstreamDown() {
+		noteStoredScraped(ctx)
+		return searchStoredCars(query)
+	}
+	return nil, err
+}
+
+// searchRank orders hits across sites, since each site ranks its own
+// differently: cars whose name matched before those only their brand
+// matched, then by how much of the name the query highlights, then by name.
+func searchRank(q partasala.Query, hits []SiteCar) {
+	type rankedHit struct {
+		hit   SiteCar
+		score float64
+	}
+	ranked := make([]rankedHit, len(hits))
+	for i, hit := range hits {
+		hit.Highlights = q.Highlight(hit.Name)
+		matched := 0
+		for _, h := range hit.Highlights {
+			matched += h.End - h.Start
+		}
+		var score float64
+		if length := len([]rune(hit.Name)); length > 0 {
+			score = float64(matched) / float64(length)
+		}
+		if hit.MatchType == "brand" {
+			score--
+		}
+		ranked[i] = rankedHit{hit, score}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if a.hit.Name != b.hit.Name {
+			return a.hit.Name < b.hit.Name
+		}
+		return a.hit.Site < b.hit.Site
+	})
+	for i := range ranked {
+		hits[i] = ranked[i].hit
+	}
+}
+
+// searchAllHandler serves GET /search/all: the query run on the main site
+// and every site under sites at once, with the hits merged and ranked
+// together and tagged with their site.
+func searchAllHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		respond(w, r, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Missing search query parameter \"q\"",
+		})
+		return
+	}
+	filter, err := parseListFilter(r.URL.Query())
+	if err == nil && filter.Plate != "" {
+		err = errPlateMainSiteOnly
+	}
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	limit, offset, err := parseSearchPage(r.URL.Query())
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	searchers := map[string]func() ([]Car, error){}
+	mainSite := siteFor(r)
+	searchers[primarySite] = func() ([]Car, error) { return searchMainSite(r.Context(), mainSite, query) }
+	for name, site := range hostedSites {
+		site := bindSite(site, r)
+		searchers[name] = func() ([]Car, error) { return site.SearchCars(strings.ToLower(query)) }
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	hits := []SiteCar{}
+	failed := map[string]string{}
+	for name, search := range searchers {
+		wg.Add(1)
+		go func(name string, search func() ([]Car, error)) {
+			defer wg.Done()
+			cars, err := search()
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed[name] = err.Error()
+				return
+			}
+			for _, car := range filter.Apply(cars) {
+				hits = append(hits, SiteCar{Site: name, Car: car})
+			}
+		}(name, search)
+	}
+	wg.Wait()
+
+	if len(failed) == len(searchers) {
+		respond(w, r, http.StatusInternalServerError, AggregatedSearchResponse{
+			Success: false,
+			Query:   query,
+			Data:    []SiteCar{},
+			Errors:  failed,
+			Error:   "No site could be searched",
+		})
+		return
+	}
+
+	searchRank(partasala.ParseQuery(query, searchSynonyms, searchFolding), hits)
+	total := len(hits)
+	start := min(offset, total)
+	page := hits[start : start+min(limit, total-start)]
+	respond(w, r, http.StatusOK, AggregatedSearchResponse{
+		Success: true,
+		Query:   query,
+		Count:   len(page),
+		Total:   total,
+		Limit:   limit,
+		Offset:  offset,
+		Data:    page,
+		Errors:  failed,
+	})
+}
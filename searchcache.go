@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+// SearchCacheConfig sets how long /search keeps the cars a query matched,
+// so popular queries don't read every brand page each time. A zero TTL
+// disables the cache.
+type SearchCacheConfig struct {
+	TTL        Duration `json:"ttl"`
+	MaxEntries int      `json:"max_entries"`
+}
+
+type searchCacheEntry struct {
+	cars    []Car
+	stored  time.Time
+	expires time.Time
+}
+
+// searchCache holds search results by mode and normalized query until
+// they expire.
+type searchCache struct {
+	mu      sync.Mutex
+	config  SearchCacheConfig
+	entries map[string]searchCacheEntry
+	hits    int
+	misses  int
+}
+
+// SearchCacheStats describes the search cache's contents and hit rate since
+// startup.
+type SearchCacheStats struct {
+	Entries int `json:"entries"`
+	Hits    int `json:"hits"`
+	Misses  int `json:"misses"`
+}
+
+func newSearchCache(config SearchCacheConfig) *searchCache {
+	return &searchCache{config: config, entries: make(map[string]searchCacheEntry)}
+}
+
+// searchCacheKey normalizes a query so that ones that search the same way,
+// like "Toyota  Yaris" and "toyota yaris", share a cache entry. Regular
+// expressions are kept as written.
+func searchCacheKey(mode, query string) string {
+	if mode != "regex" {
+		query = strings.Join(strings.Fields(partasala.Fold(query, searchFolding)), " ")
+	}
+	return mode + "\x00" + query
+}
+
+// get returns the unexpired results cached under key and when they were
+// cached.
+func (c *searchCache) get(key string) ([]Car, time.Time, bool) {
+	if c == nil {
+		return nil, time.Time{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.config.TTL.Duration <= 0 {
+		return nil, time.Time{}, false
+	}
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		c.misses++
+		return nil, time.Time{}, false
+	}
+	c.hits++
+	return entry.cars, entry.stored, true
+}
+
+// put caches cars under key, first dropping expired entries and then, if
+// the cache is still full, the one closest to expiring.
+func (c *searchCache) put(key string, cars []Car) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.config.TTL.Duration <= 0 {
+		return
+	}
+
+	now := time.Now()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.config.MaxEntries {
+		oldest := ""
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			} else if oldest == "" || entry.expires.Before(c.entries[oldest].expires) {
+				oldest = k
+			}
+		}
+		if len(c.entries) >= c.config.MaxEntries {
+			delete(c.entries, oldest)
+		}
+	}
+	c.entries[key] = searchCacheEntry{cars: cars, stored: now, expires: now.Add(c.config.TTL.Duration)}
+}
+
+// clear forgets every cached search, e.g. after a crawl changed the cars.
+func (c *searchCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]searchCacheEntry)
+}
+
+// setConfig changes the TTL and size of the cache, emptying it.
+func (c *searchCache) setConfig(config SearchCacheConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.config = config
+	c.entries = make(map[string]searchCacheEntry)
+}
+
+func (c *searchCache) stats() SearchCacheStats {
+	if c == nil {
+		return SearchCacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return SearchCacheStats{Entries: len(c.entries), Hits: c.hits, Misses: c.misses}
+}
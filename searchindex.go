@@ -0,0 +1,75 @@
+package main
+
+import (
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+// SearchIndex holds the stored cars in memory for /search, rebuilt from the
+// dataset whenever a crawl has saved, so queries are answered without
+// fetching or decoding anything.
+type SearchIndex struct {
+	mu         sync.RWMutex
+	brandNames map[string]string
+	cars       []Car
+	builtAt    time.Time
+}
+
+// Rebuild replaces the index with the brands and cars stored in d.
+func (x *SearchIndex) Rebuild(d *Dataset) error {
+	brands, err := d.Brands()
+	if err != nil {
+		return err
+	}
+	cars, err := d.Cars()
+	if err != nil {
+		return err
+	}
+	brandNames := make(map[string]string, len(brands))
+	for _, brand := range brands {
+		brandNames[brand.Slug] = brand.Name
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.brandNames, x.cars, x.builtAt = brandNames, cars, time.Now().UTC()
+	return nil
+}
+
+// Ready reports whether the index holds any cars, and when it was built.
+func (x *SearchIndex) Ready() (bool, time.Time) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.cars) > 0, x.builtAt
+}
+
+// Cars returns the indexed cars.
+func (x *SearchIndex) Cars() ([]Car, error) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.cars, nil
+}
+
+// Search matches the indexed cars the same way SearchCars matches the live
+// site.
+func (x *SearchIndex) Search(query string) ([]Car, error) {
+	q := partasala.ParseQuery(query, searchSynonyms, searchFolding)
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	results := []Car{}
+	for _, car := range x.cars {
+		if car.MatchType = q.MatchCar(x.brandNames[car.Brand], car); car.MatchType != "" {
+			results = append(results, car)
+		}
+	}
+	return results, nil
+}
+
+// SearchRegex returns the indexed cars whose name matches pattern.
+func (x *SearchIndex) SearchRegex(pattern *regexp.Regexp) ([]Car, error) {
+	return regexSearch(x.Cars, pattern)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+const (
+	// defaultSearchLimit is how many matches /search returns without a limit
+	defaultSearchLimit = 50
+	// maxSearchLimit caps limit, so a query like "a" can't ask for every car
+	maxSearchLimit = 200
+)
+
+// parseSearchPage reads the limit and offset of a /search request.
+func parseSearchPage(query url.Values) (limit, offset int, err error) {
+	limit = defaultSearchLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxSearchLimit)
+		}
+		limit = n
+	}
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("offset must be a whole number")
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
+
+// A searchResult is one page of a search's matches, before it is put in
+// the envelope of the surface the search was made to.
+type searchResult struct {
+	query         string
+	page          []Car
+	total         int
+	limit, offset int
+	suggestions   []string
+	indexedAt     *time.Time
+	engine        string
+	stale         bool
+}
+
+// searchPage picks one page of a search's results, marking the parts of
+// each car's name that highlight finds. Suggestions are only looked for
+// when the search matched nothing at all, not when offset is past the last
+// match.
+func searchPage(site SiteScraper, query, mode string, results []Car, highlight func(name string) []partasala.Highlight, limit, offset int, stale bool) searchResult {
+	total := len(results)
+	start := min(offset, total)
+	page := results[start : start+min(limit, total-start)]
+	annotateCars(page)
+	for i := range page {
+		page[i].Highlights = highlight(page[i].Name)
+	}
+	result := searchResult{
+		query:  query,
+		page:   page,
+		total:  total,
+		limit:  limit,
+		offset: offset,
+		stale:  stale,
+	}
+	if total == 0 && mode != "regex" {
+		result.suggestions = suggestionsFor(site, query)
+	}
+	return result
+}
+
+// response is the body of the page, in the envelope of the surface r was
+// made to.
+func (s searchResult) response(r *http.Request) interface{} {
+	if isV2(r) {
+		return v2List(s.page, V2Meta{
+			Total:       &s.total,
+			Limit:       &s.limit,
+			Offset:      &s.offset,
+			Query:       s.query,
+			Suggestions: s.suggestions,
+			IndexedAt:   s.indexedAt,
+			Engine:      s.engine,
+			Stale:       s.stale,
+		})
+	}
+	return SearchResponse{
+		Success:     true,
+		Query:       s.query,
+		Count:       len(s.page),
+		Total:       s.total,
+		Limit:       s.limit,
+		Offset:      s.offset,
+		Data:        s.page,
+		Suggestions: s.suggestions,
+		IndexedAt:   s.indexedAt,
+		Engine:      s.engine,
+		Stale:       s.stale,
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SearchSinkConfig configures an external search engine that receives the
+// whole dataset after every crawl. Type is "elasticsearch" (which also
+// works with OpenSearch), "meilisearch", or "typesense". Index is the
+// alias, index, or collection searches should use; each sync builds a
+// fresh one behind it. With DelegateSearch set, a Meilisearch or
+// Typesense sink answers /search.
+type SearchSinkConfig struct {
+	Type           string `json:"type"`
+	URL            string `json:"url"`
+	Index          string `json:"index"`
+	Username       string `json:"username"`
+	Password       string `json:"password"`
+	APIKey         string `json:"api_key"`
+	DelegateSearch bool   `json:"delegate_search"`
+}
+
+// SearchDocument is a listed car together with its stored details, as
+// pushed to search sinks.
+type SearchDocument struct {
+	Car
+	Description *string `json:"description"`
+	Plate       *string `json:"plate"`
+	VIN         *string `json:"vin"`
+	ImageCount  int     `json:"image_count"`
+	Images      []Image `json:"images"`
+}
+
+// SearchSink replaces an external index's contents with docs.
+type SearchSink interface {
+	Sync(docs []SearchDocument) error
+}
+
+// SearchDelegate is a sink that can answer searches itself, best match
+// first.
+type SearchDelegate interface {
+	SearchSink
+	Search(query string) ([]Car, error)
+}
+
+// delegatedSearch is the sink answering /search, if any; engine is its
+// type, reported in search responses.
+type delegatedSearch struct {
+	engine string
+	sink   SearchDelegate
+}
+
+var searchDelegate *delegatedSearch
+
+// NewSearchSink builds the sink described by config.
+func NewSearchSink(config SearchSinkConfig) (SearchSink, error) {
+	if config.URL == "" || config.Index == "" {
+		return nil, fmt.Errorf("%s search sink requires url and index", config.Type)
+	}
+	client := newEngineClient(config.URL)
+
+	var sink SearchSink
+	switch config.Type {
+	case "elasticsearch":
+		if config.APIKey != "" {
+			client.header.Set("Authorization", "ApiKey "+config.APIKey)
+		} else if config.Username != "" {
+			credentials := base64.StdEncoding.EncodeToString([]byte(config.Username + ":" + config.Password))
+			client.header.Set("Authorization", "Basic "+credentials)
+		}
+		sink = &elasticsearchSink{engineClient: client, index: config.Index}
+	case "meilisearch":
+		if config.APIKey != "" {
+			client.header.Set("Authorization", "Bearer "+config.APIKey)
+		}
+		sink = &meilisearchSink{engineClient: client, index: config.Index}
+	case "typesense":
+		if config.APIKey == "" {
+			return nil, fmt.Errorf("typesense search sink requires api_key")
+		}
+		client.header.Set("X-Typesense-Api-Key", config.APIKey)
+		sink = &typesenseSink{engineClient: client, collection: config.Index}
+	default:
+		return nil, fmt.Errorf("unknown search sink type %q", config.Type)
+	}
+	if _, ok := sink.(SearchDelegate); config.DelegateSearch && !ok {
+		return nil, fmt.Errorf("%s search sink can't answer searches", config.Type)
+	}
+	return sink, nil
+}
+
+// SearchSinks pushes the dataset to several sinks, logging failures. Syncs
+// run one at a time, so a slow sink can't race the next crawl's sync.
+type SearchSinks struct {
+	mu    sync.Mutex
+	sinks []SearchSink
+}
+
+func (s *SearchSinks) Sync(d *Dataset) {
+	if len(s.sinks) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	docs, err := searchDocuments(d)
+	if err != nil {
+		log.Printf("search sink: %v", err)
+		recent.addError("search sink", err)
+		return
+	}
+	for _, sink := range s.sinks {
+		if err := sink.Sync(docs); err != nil {
+			log.Printf("search sink: %v", err)
+			recent.addError("search sink", err)
+		}
+	}
+}
+
+// searchDocuments joins the stored cars with their details.
+func searchDocuments(d *Dataset) ([]SearchDocument, error) {
+	cars, err := d.Cars()
+	if err != nil {
+		return nil, err
+	}
+	all, err := d.AllDetails()
+	if err != nil {
+		return nil, err
+	}
+	details := make(map[string]CarDetails, len(all))
+	for _, detail := range all {
+		details[detail.Slug] = detail
+	}
+
+	docs := make([]SearchDocument, 0, len(cars))
+	for _, car := range cars {
+		doc := SearchDocument{Car: car, Images: []Image{}}
+		if detail, ok := details[car.Slug]; ok {
+			doc.Description, doc.Plate, doc.VIN = detail.Description, detail.Plate, detail.VIN
+			doc.ImageCount, doc.Images = detail.ImageCount, detail.Images
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// engineClient talks JSON to a search engine's HTTP API.
+type engineClient struct {
+	client  *http.Client
+	baseURL string
+	header  http.Header
+}
+
+func newEngineClient(url string) *engineClient {
+	return &engineClient{
+		client:  &http.Client{Timeout: time.Minute},
+		baseURL: strings.TrimRight(url, "/"),
+		header:  http.Header{},
+	}
+}
+
+// engineError is a non-2xx answer; Body holds the start of the response,
+// which names the cause.
+type engineError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *engineError) Error() string {
+	return fmt.Sprintf("status code error: %d %s", e.StatusCode, e.Body)
+}
+
+func isEngineNotFound(err error) bool {
+	engineErr, ok := err.(*engineError)
+	return ok && engineErr.StatusCode == http.StatusNotFound
+}
+
+// do sends a request to the engine. A []byte payload is sent as is and
+// anything else as JSON. A 2xx answer is copied to out if it is an
+// io.Writer, and otherwise decoded into out if it isn't nil.
+func (c *engineClient) do(method, path, contentType string, payload interface{}, out interface{}) error {
+	var body io.Reader
+	switch p := payload.(type) {
+	case nil:
+	case []byte:
+		body = bytes.NewReader(p)
+	default:
+		data, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	for key, values := range c.header {
+		req.Header[key] = values
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &engineError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(data))}
+	}
+	switch out := out.(type) {
+	case nil:
+		_, err = io.Copy(io.Discard, resp.Body)
+	case io.Writer:
+		_, err = io.Copy(out, resp.Body)
+	default:
+		err = json.NewDecoder(resp.Body).Decode(out)
+	}
+	return err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+// SelectorReloader is implemented by site adapters whose selectors can be
+// replaced while the server is running.
+type SelectorReloader interface {
+	SetSelectors(config partasala.SelectorConfig) error
+}
+
+// LoadSelectors reads a JSON selector file and applies it on top of base, so
+// the file only needs to list the selectors it overrides.
+func LoadSelectors(path string, base partasala.SelectorConfig) (partasala.SelectorConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return base, fmt.Errorf("failed to read selectors %s: %v", path, err)
+	}
+
+	config := base
+	config.DescriptionClasses = append([]string{}, base.DescriptionClasses...)
+	if err := json.Unmarshal(data, &config); err != nil {
+		return base, fmt.Errorf("failed to parse selectors %s: %v", path, err)
+	}
+	if err := config.Validate(); err != nil {
+		return base, fmt.Errorf("invalid selectors in %s: %v", path, err)
+	}
+
+	return config, nil
+}
+
+// WatchSelectors polls path and applies the selector file to target whenever
+// its modification time changes. Invalid files are logged and ignored, so the
+// previous selectors stay in effect.
+func WatchSelectors(ctx context.Context, path string, base partasala.SelectorConfig, target SelectorReloader, interval time.Duration) {
+	var lastMod time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastMod = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Printf("selectors: %v", err)
+			continue
+		}
+		if info.ModTime().Equal(lastMod) {
+			continue
+		}
+		lastMod = info.ModTime()
+
+		selectors, err := LoadSelectors(path, base)
+		if err == nil {
+			err = target.SetSelectors(selectors)
+		}
+		if err != nil {
+			log.Printf("selectors: keeping previous selectors: %v", err)
+			continue
+		}
+		log.Printf("selectors: reloaded %s", path)
+	}
+}
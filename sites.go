@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+// The scraped records are the library's; the server uses them as they are.
+type (
+	Brand        = partasala.Brand
+	Car          = partasala.Car
+	Image        = partasala.Image
+	ImageVariant = partasala.ImageVariant
+	CarDetails   = partasala.CarDetails
+	Price        = partasala.Price
+	Contact      = partasala.Contact
+	Category     = partasala.Category
+)
+
+// SiteScraper is implemented by every supported salvage-yard site. Adapters
+// register themselves under a slug with RegisterSite.
+type SiteScraper interface {
+	GetBrands() ([]Brand, error)
+	GetBrandCars(brandSlug string) ([]Car, error)
+	GetCarDetails(carSlug string) (*CarDetails, error)
+	GetAllCars() ([]Car, error)
+	SearchCars(query string) ([]Car, error)
+}
+
+// ContactProvider is implemented by site scrapers that can read the yard's
+// contact details.
+type ContactProvider interface {
+	GetContact() (*Contact, error)
+}
+
+// TrafficCounter is implemented by site scrapers that count the requests
+// they send and the bytes they download from upstream.
+type TrafficCounter interface {
+	BytesDownloaded() int64
+	UpstreamRequests() int64
+}
+
+// UpstreamCircuit is implemented by site scrapers with a circuit breaker.
+type UpstreamCircuit interface {
+	CircuitState() string
+}
+
+// UpstreamHealth is implemented by site scrapers that track how many of
+// their recent upstream requests failed, and when one last succeeded.
+type UpstreamHealth interface {
+	UpstreamOutcomes(window time.Duration) partasala.UpstreamOutcomes
+	LastUpstreamSuccess() time.Time
+}
+
+// UpstreamProber is implemented by site scrapers that can check the site
+// is up by fetching and parsing its homepage, returning the brand count.
+type UpstreamProber interface {
+	Probe(ctx context.Context) (int, error)
+}
+
+// UpstreamBudget is implemented by site scrapers that cap their daily
+// upstream requests.
+type UpstreamBudget interface {
+	BudgetStatus() partasala.BudgetStatus
+}
+
+// PageCache is implemented by site scrapers that cache fetched pages.
+type PageCache interface {
+	PageCacheStats() partasala.PageCacheStats
+	FlushPageCaches() error
+}
+
+// ImageChecker is implemented by site scrapers that can check whether an
+// image URL still resolves.
+type ImageChecker interface {
+	CheckImage(url string) (int, error)
+}
+
+// ImageHasher is implemented by site scrapers that can download images to
+// take their perceptual hashes.
+type ImageHasher interface {
+	PerceptualImageHash(url string) (uint64, error)
+}
+
+// ImageFetcher is implemented by site scrapers that can fetch images from
+// upstream for /proxy/image.
+type ImageFetcher interface {
+	FetchImage(ctx context.Context, url string) (*http.Response, error)
+}
+
+// StructureObservable is implemented by site adapters that report parse
+// results to a StructureMonitor.
+type StructureObservable interface {
+	SetStructureMonitor(monitor *partasala.StructureMonitor)
+}
+
+var (
+	sitesMu       sync.RWMutex
+	siteFactories = map[string]func(partasala.Config) SiteScraper{}
+)
+
+func init() {
+	RegisterSite("partasala", func(config partasala.Config) SiteScraper {
+		return partasalaSite{partasala.New(config)}
+	})
+}
+
+// ContextBinder is implemented by site scrapers that can make their
+// upstream requests on behalf of an API request, under its context.
+type ContextBinder interface {
+	BindContext(ctx context.Context) SiteScraper
+}
+
+// partasalaSite adds BindContext to partasala.Scraper.
+type partasalaSite struct {
+	*partasala.Scraper
+}
+
+func (s partasalaSite) BindContext(ctx context.Context) SiteScraper {
+	return partasalaSite{s.WithContext(ctx)}
+}
+
+// siteFor returns the scraper to serve r with: where the site supports it,
+// one whose upstream requests carry r's request ID. They aren't cancelled
+// when r's client goes away, so the pages still reach the caches. HEAD
+// requests get the stored dataset instead, so probing a URL never
+// scrapes it.
+func siteFor(r *http.Request) SiteScraper {
+	if isHead(r) {
+		return storedSite{}
+	}
+	return bindSite(scraper, r)
+}
+
+// bindSite binds site to r as siteFor does the main site. Hosted sites
+// have nothing stored, so they fail HEAD requests instead.
+func bindSite(site SiteScraper, r *http.Request) SiteScraper {
+	if isHead(r) {
+		return unscrapedSite{}
+	}
+	if binder, ok := site.(ContextBinder); ok {
+		return binder.BindContext(context.WithoutCancel(r.Context()))
+	}
+	return site
+}
+
+// RegisterSite makes a site adapter available under slug. It panics if the
+// slug is already taken, as that is a programming error.
+func RegisterSite(slug string, factory func(partasala.Config) SiteScraper) {
+	sitesMu.Lock()
+	defer sitesMu.Unlock()
+
+	if _, exists := siteFactories[slug]; exists {
+		panic(fmt.Sprintf("site %q registered twice", slug))
+	}
+	siteFactories[slug] = factory
+}
+
+// NewSiteScraper creates the adapter registered under slug, configured with
+// config.
+func NewSiteScraper(slug string, config partasala.Config) (SiteScraper, error) {
+	sitesMu.RLock()
+	factory, ok := siteFactories[slug]
+	sitesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown site %q (available: %v)", slug, SiteSlugs())
+	}
+	return factory(config), nil
+}
+
+// SiteSlugs returns the slugs of all registered sites, sorted.
+func SiteSlugs() []string {
+	sitesMu.RLock()
+	defer sitesMu.RUnlock()
+
+	slugs := make([]string, 0, len(siteFactories))
+	for slug := range siteFactories {
+		slugs = append(slugs, slug)
+	}
+	sort.Strings(slugs)
+	return slugs
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// SQLStore keeps every bucket in one PostgreSQL table, so several API
+// replicas and a separately running crawler can share a dataset.
+type SQLStore struct {
+	db *sql.DB
+}
+
+const sqlStoreSchema = `CREATE TABLE IF NOT EXISTS partasala_store (
+	bucket TEXT NOT NULL,
+	key TEXT NOT NULL,
+	value BYTEA NOT NULL,
+	PRIMARY KEY (bucket, key)
+)`
+
+// OpenPostgresStore connects to dsn and creates the store table if needed.
+func OpenPostgresStore(dsn string) (*SQLStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, sqlStoreSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create store table: %v", err)
+	}
+
+	return &SQLStore{db: db}, nil
+}
+
+func (s *SQLStore) Get(bucket, key string) ([]byte, error) {
+	var value []byte
+	err := s.db.QueryRow(`SELECT value FROM partasala_store WHERE bucket = $1 AND key = $2`, bucket, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+func (s *SQLStore) Put(bucket, key string, value []byte) error {
+	_, err := s.db.Exec(sqlStoreUpsert, bucket, key, value)
+	return err
+}
+
+const sqlStoreUpsert = `INSERT INTO partasala_store (bucket, key, value) VALUES ($1, $2, $3)
+	ON CONFLICT (bucket, key) DO UPDATE SET value = EXCLUDED.value`
+
+func (s *SQLStore) Delete(bucket, key string) error {
+	_, err := s.db.Exec(`DELETE FROM partasala_store WHERE bucket = $1 AND key = $2`, bucket, key)
+	return err
+}
+
+func (s *SQLStore) Batch(bucket string, puts map[string][]byte, deletes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, key := range deletes {
+		if _, err := tx.Exec(`DELETE FROM partasala_store WHERE bucket = $1 AND key = $2`, bucket, key); err != nil {
+			return err
+		}
+	}
+	for key, value := range puts {
+		if _, err := tx.Exec(sqlStoreUpsert, bucket, key, value); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ForEach reads the whole bucket before calling fn, so fn may use the store
+// itself. Keys are ordered bytewise, like the other backends.
+func (s *SQLStore) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	rows, err := s.db.Query(`SELECT key, value FROM partasala_store WHERE bucket = $1 ORDER BY key COLLATE "C"`, bucket)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type entry struct {
+		key   string
+		value []byte
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.key, &e.value); err != nil {
+			return err
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, e := range entries {
+		if err := fn(e.key, e.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) Buckets() ([]string, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT bucket FROM partasala_store ORDER BY bucket COLLATE "C"`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := []string{}
+	for rows.Next() {
+		var bucket string
+		if err := rows.Scan(&bucket); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, rows.Err()
+}
+
+func (s *SQLStore) Replace(contents map[string]map[string][]byte) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM partasala_store`); err != nil {
+		return err
+	}
+	for bucket, entries := range contents {
+		for key, value := range entries {
+			if _, err := tx.Exec(sqlStoreUpsert, bucket, key, value); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+// upstreamDown reports whether the circuit breaker is refusing upstream
+// requests or the day's request budget is spent. Handlers then answer from
+// the stored dataset and mark the response stale instead of failing.
+func upstreamDown() bool {
+	if circuit, ok := scraper.(UpstreamCircuit); ok && circuit.CircuitState() != partasala.CircuitClosed {
+		return true
+	}
+	budget, ok := scraper.(UpstreamBudget)
+	return ok && budget.BudgetStatus().Exhausted
+}
+
+// hasStoredCars reports whether the dataset holds any cars, without reading
+// them all.
+func hasStoredCars() bool {
+	buckets, err := dataset.store.Buckets()
+	if err != nil {
+		return false
+	}
+	for _, bucket := range buckets {
+		if bucket == bucketCars {
+			return true
+		}
+	}
+	return false
+}
+
+func storedBrandCars(brandSlug string) ([]Car, error) {
+	cars, err := dataset.Cars()
+	if err != nil {
+		return nil, err
+	}
+	brandCars := []Car{}
+	for _, car := range cars {
+		if car.Brand == brandSlug {
+			brandCars = append(brandCars, car)
+		}
+	}
+	return brandCars, nil
+}
+
+// searchStoredCars matches stored cars the same way SearchCars matches the
+// live site.
+func searchStoredCars(query string) ([]Car, error) {
+	brands, err := dataset.Brands()
+	if err != nil {
+		return nil, err
+	}
+	cars, err := dataset.Cars()
+	if err != nil {
+		return nil, err
+	}
+
+	q := partasala.ParseQuery(query, searchSynonyms, searchFolding)
+	brandNames := map[string]string{}
+	for _, brand := range brands {
+		brandNames[brand.Slug] = brand.Name
+	}
+
+	results := []Car{}
+	for _, car := range cars {
+		if car.MatchType = q.MatchCar(brandNames[car.Brand], car); car.MatchType != "" {
+			results = append(results, car)
+		}
+	}
+	return results, nil
+}
+
+func writeUpstreamUnavailable(w http.ResponseWriter, r *http.Request) {
+	respond(w, r, http.StatusServiceUnavailable, APIResponse{
+		Success: false,
+		Error:   "The upstream site is unavailable and no stored data exists for this request",
+	})
+}
+
+// storedSite answers like the main site from the stored dataset alone,
+// for requests that mustn't reach upstream, like HEAD.
+type storedSite struct{}
+
+func (storedSite) GetBrands() ([]Brand, error) {
+	return dataset.Brands()
+}
+
+func (storedSite) GetBrandCars(brandSlug string) ([]Car, error) {
+	return storedBrandCars(brandSlug)
+}
+
+func (storedSite) GetCarDetails(carSlug string) (*CarDetails, error) {
+	return dataset.Details(carSlug)
+}
+
+func (storedSite) GetAllCars() ([]Car, error) {
+	return dataset.Cars()
+}
+
+func (storedSite) SearchCars(query string) ([]Car, error) {
+	return searchStoredCars(query)
+}
+
+func (storedSite) GetContact() (*Contact, error) {
+	return dataset.Contact()
+}
+
+// errNotScraped is what sites other than the main one answer requests
+// that mustn't reach upstream with, having nothing stored to answer from.
+var errNotScraped = errors.New("this site is only scraped for GET requests")
+
+// unscrapedSite stands in for a hosted site under such requests.
+type unscrapedSite struct{}
+
+func (unscrapedSite) GetBrands() ([]Brand, error)               { return nil, errNotScraped }
+func (unscrapedSite) GetBrandCars(string) ([]Car, error)        { return nil, errNotScraped }
+func (unscrapedSite) GetCarDetails(string) (*CarDetails, error) { return nil, errNotScraped }
+func (unscrapedSite) GetAllCars() ([]Car, error)                { return nil, errNotScraped }
+func (unscrapedSite) SearchCars(string) ([]Car, error)          { return nil, errNotScraped }
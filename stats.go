@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+// UpstreamStats summarizes the API's use of the upstream site.
+type UpstreamStats struct {
+	Budget          *partasala.BudgetStatus `json:"budget,omitempty"`
+	BytesDownloaded *int64                  `json:"bytes_downloaded,omitempty"`
+	Circuit         string                  `json:"upstream_circuit,omitempty"`
+}
+
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	stats := UpstreamStats{}
+	if budget, ok := scraper.(UpstreamBudget); ok {
+		status := budget.BudgetStatus()
+		stats.Budget = &status
+	}
+	if counter, ok := scraper.(TrafficCounter); ok {
+		bytes := counter.BytesDownloaded()
+		stats.BytesDownloaded = &bytes
+	}
+	if circuit, ok := scraper.(UpstreamCircuit); ok {
+		stats.Circuit = circuit.CircuitState()
+	}
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Data:    stats,
+	})
+}
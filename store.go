@@ -0,0 +1,482 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by Store.Get for keys that don't exist.
+var ErrNotFound = errors.New("not found")
+
+// Store persists JSON documents under string keys in named buckets. Higher
+// level helpers like Dataset encode their records on top of it, so storage
+// backends only need to implement this small interface.
+type Store interface {
+	Get(bucket, key string) ([]byte, error)
+	Put(bucket, key string, value []byte) error
+	Delete(bucket, key string) error
+	// Batch writes puts and removes deletes in one go, so backends can do it
+	// in a single transaction or write.
+	Batch(bucket string, puts map[string][]byte, deletes []string) error
+	// ForEach calls fn for every key in bucket, in key order.
+	ForEach(bucket string, fn func(key string, value []byte) error) error
+	// Buckets lists the names of all non-empty buckets, sorted.
+	Buckets() ([]string, error)
+	// Replace swaps everything in the store for contents in one
+	// transaction, so a failure leaves the old contents whole.
+	Replace(contents map[string]map[string][]byte) error
+	Close() error
+}
+
+// StoreSizer is implemented by stores that can report their size on disk.
+type StoreSizer interface {
+	SizeBytes() (int64, error)
+}
+
+// StoreCompactor is implemented by stores that can reclaim unused space.
+type StoreCompactor interface {
+	Compact() error
+}
+
+// StoreConfig selects the storage backend: PostgreSQL when DSN is set, a
+// bbolt database when Bolt is set, a JSON file when Path is set, otherwise
+// data is only kept in memory.
+type StoreConfig struct {
+	Path string `json:"path"`
+	Bolt string `json:"bolt"`
+	DSN  string `json:"dsn"`
+}
+
+// Backend names the backend config selects: "postgres", "bolt", "file",
+// or "memory".
+func (config StoreConfig) Backend() string {
+	switch {
+	case config.DSN != "":
+		return "postgres"
+	case config.Bolt != "":
+		return "bolt"
+	case config.Path != "":
+		return "file"
+	default:
+		return "memory"
+	}
+}
+
+// OpenStore opens the backend described by config.
+func OpenStore(config StoreConfig) (Store, error) {
+	switch {
+	case config.DSN != "":
+		return OpenPostgresStore(config.DSN)
+	case config.Bolt != "":
+		return OpenBoltStore(config.Bolt)
+	case config.Path != "":
+		return OpenFileStore(config.Path)
+	default:
+		return NewMemoryStore(), nil
+	}
+}
+
+func getJSON(store Store, bucket, key string, v interface{}) error {
+	data, err := store.Get(bucket, key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func putJSON(store Store, bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return store.Put(bucket, key, data)
+}
+
+// MemoryStore is a Store that lives only as long as the process.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]map[string][]byte)}
+}
+
+func (s *MemoryStore) Get(bucket, key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.buckets[bucket][key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (s *MemoryStore) Put(bucket, key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string][]byte)
+	}
+	s.buckets[bucket][key] = append([]byte{}, value...)
+	return nil
+}
+
+func (s *MemoryStore) Delete(bucket, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.buckets[bucket], key)
+	return nil
+}
+
+func (s *MemoryStore) Batch(bucket string, puts map[string][]byte, deletes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string][]byte)
+	}
+	for _, key := range deletes {
+		delete(s.buckets[bucket], key)
+	}
+	for key, value := range puts {
+		s.buckets[bucket][key] = append([]byte{}, value...)
+	}
+	return nil
+}
+
+func (s *MemoryStore) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	s.mu.RLock()
+	keys := make([]string, 0, len(s.buckets[bucket]))
+	for key := range s.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	values := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		values[key] = s.buckets[bucket][key]
+	}
+	s.mu.RUnlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := fn(key, values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Buckets() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	buckets := []string{}
+	for bucket, entries := range s.buckets {
+		if len(entries) > 0 {
+			buckets = append(buckets, bucket)
+		}
+	}
+	sort.Strings(buckets)
+	return buckets, nil
+}
+
+func (s *MemoryStore) Replace(contents map[string]map[string][]byte) error {
+	s.swap(contents)
+	return nil
+}
+
+// swap copies contents in as the store's buckets and returns the ones it
+// replaced.
+func (s *MemoryStore) swap(contents map[string]map[string][]byte) map[string]map[string][]byte {
+	buckets := make(map[string]map[string][]byte, len(contents))
+	for bucket, entries := range contents {
+		buckets[bucket] = make(map[string][]byte, len(entries))
+		for key, value := range entries {
+			buckets[bucket][key] = append([]byte{}, value...)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.buckets
+	s.buckets = buckets
+	return old
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// fileStoreFlushDelay is how long a FileStore waits after a change before
+// writing the file, so a burst of writes, like a crawl's or a busy
+// minute's usage counters, costs one rewrite instead of one each.
+const fileStoreFlushDelay = time.Second
+
+// FileStore is a MemoryStore that is written to a single JSON file shortly
+// after it changes. It suits the modest size of one yard's listings. Writes
+// return once memory holds them; the file catches up within
+// fileStoreFlushDelay, or on Close, so a crash loses at most that much. A
+// failed write is logged and tried again.
+type FileStore struct {
+	*MemoryStore
+	path string
+
+	// writeMu serializes writing the file
+	writeMu sync.Mutex
+	// timerMu guards timer, which is set while a flush is pending
+	timerMu sync.Mutex
+	timer   *time.Timer
+}
+
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{MemoryStore: NewMemoryStore(), path: path}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read store %s: %v", path, err)
+	}
+	if len(data) > 0 {
+		var buckets map[string]map[string]json.RawMessage
+		if err := json.Unmarshal(data, &buckets); err != nil {
+			return nil, fmt.Errorf("failed to parse store %s: %v", path, err)
+		}
+		for bucket, entries := range buckets {
+			for key, value := range entries {
+				s.MemoryStore.Put(bucket, key, value)
+			}
+		}
+	}
+
+	return s, nil
+}
+
+func (s *FileStore) Put(bucket, key string, value []byte) error {
+	if !json.Valid(value) {
+		return fmt.Errorf("file store values must be JSON")
+	}
+	s.MemoryStore.Put(bucket, key, value)
+	s.changed()
+	return nil
+}
+
+func (s *FileStore) Delete(bucket, key string) error {
+	s.MemoryStore.Delete(bucket, key)
+	s.changed()
+	return nil
+}
+
+func (s *FileStore) Batch(bucket string, puts map[string][]byte, deletes []string) error {
+	for _, value := range puts {
+		if !json.Valid(value) {
+			return fmt.Errorf("file store values must be JSON")
+		}
+	}
+	s.MemoryStore.Batch(bucket, puts, deletes)
+	s.changed()
+	return nil
+}
+
+// Replace writes the file before returning, and puts the old contents back
+// if it can't.
+func (s *FileStore) Replace(contents map[string]map[string][]byte) error {
+	for _, entries := range contents {
+		for _, value := range entries {
+			if !json.Valid(value) {
+				return fmt.Errorf("file store values must be JSON")
+			}
+		}
+	}
+	old := s.MemoryStore.swap(contents)
+	s.changed()
+	if err := s.Flush(); err != nil {
+		s.MemoryStore.swap(old)
+		s.changed()
+		return err
+	}
+	return nil
+}
+
+// Close writes any pending changes.
+func (s *FileStore) Close() error {
+	return s.Flush()
+}
+
+// Flush writes pending changes to the file now, instead of when the
+// flush delay is up.
+func (s *FileStore) Flush() error {
+	s.timerMu.Lock()
+	pending := s.timer != nil
+	if pending {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.timerMu.Unlock()
+	if !pending {
+		return nil
+	}
+	return s.flush()
+}
+
+func (s *FileStore) SizeBytes() (int64, error) {
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// changed schedules a flush, unless one is pending already.
+func (s *FileStore) changed() {
+	s.timerMu.Lock()
+	defer s.timerMu.Unlock()
+	if s.timer == nil {
+		s.timer = time.AfterFunc(fileStoreFlushDelay, s.flushPending)
+	}
+}
+
+func (s *FileStore) flushPending() {
+	s.timerMu.Lock()
+	s.timer = nil
+	s.timerMu.Unlock()
+	if err := s.flush(); err != nil {
+		log.Printf("store: %v", err)
+		s.changed()
+	}
+}
+
+// flush rewrites the store file atomically: the new contents are synced to
+// a temporary file before it replaces the old one, so a crash leaves one or
+// the other whole.
+func (s *FileStore) flush() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.mu.RLock()
+	buckets := make(map[string]map[string]json.RawMessage, len(s.buckets))
+	for bucket, entries := range s.buckets {
+		buckets[bucket] = make(map[string]json.RawMessage, len(entries))
+		for key, value := range entries {
+			buckets[bucket][key] = value
+		}
+	}
+	data, err := json.Marshal(buckets)
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(s.path)
+	tmp, err := os.CreateTemp(dir, ".store-*")
+	if err != nil {
+		return fmt.Errorf("failed to write store %s: %v", s.path, err)
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write store %s: %v", s.path, err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write store %s: %v", s.path, err)
+	}
+	// Sync the directory too, or the rename itself may not survive a crash
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+type StoreStats struct {
+	SizeBytes *int64         `json:"size_bytes,omitempty"`
+	Buckets   map[string]int `json:"buckets"`
+}
+
+// storeStats counts the keys in every bucket and adds the size on disk when
+// the backend reports it.
+func storeStats(store Store) (StoreStats, error) {
+	stats := StoreStats{Buckets: map[string]int{}}
+	buckets, err := store.Buckets()
+	if err != nil {
+		return stats, err
+	}
+	for _, bucket := range buckets {
+		count := 0
+		err := store.ForEach(bucket, func(key string, value []byte) error {
+			count++
+			return nil
+		})
+		if err != nil {
+			return stats, err
+		}
+		stats.Buckets[bucket] = count
+	}
+
+	if sizer, ok := store.(StoreSizer); ok {
+		size, err := sizer.SizeBytes()
+		if err != nil {
+			return stats, err
+		}
+		stats.SizeBytes = &size
+	}
+	return stats, nil
+}
+
+func storeStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := storeStats(dataset.store)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Data:    stats,
+	})
+}
+
+func compactStoreHandler(w http.ResponseWriter, r *http.Request) {
+	compactor, ok := dataset.store.(StoreCompactor)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "The configured store does not support compaction",
+		})
+		return
+	}
+
+	if err := compactor.Compact(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	storeStatsHandler(w, r)
+}
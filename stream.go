@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// CarStreamer is implemented by site scrapers that can send every car on
+// the site as it is scraped, instead of collecting them in one slice.
+type CarStreamer interface {
+	// StreamAllCars sends every car to cars in GetAllCars order. It
+	// returns early with ctx's error if ctx is cancelled, and does not
+	// close cars.
+	StreamAllCars(ctx context.Context, cars chan<- Car) error
+}
+
+// StreamCars sends every stored car to cars in key order.
+func (d *Dataset) StreamCars(ctx context.Context, cars chan<- Car) error {
+	return d.store.ForEach(bucketCars, func(key string, value []byte) error {
+		var car Car
+		if err := json.Unmarshal(value, &car); err != nil {
+			return err
+		}
+		select {
+		case cars <- car:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+// streamCars writes the cars produce sends as an APIResponse-shaped object,
+// one element at a time, so memory stays flat however many cars there are.
+// The fields after "data" are written last, which lets an error that comes
+// up mid-stream still turn "success" false. If produce fails before sending
+// anything, nothing is written and its error is returned with started false
+// so the caller can answer normally. The request's ResponseMeta goes last.
+// MessagePack, JSON:API, and /v2 clients get the whole list at once
+// instead.
+func streamCars(w http.ResponseWriter, r *http.Request, stale bool, produce func(context.Context, chan<- Car) error) (started bool, err error) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	if stale {
+		noteStoredScraped(ctx)
+	}
+
+	cars := make(chan Car, 64)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- produce(ctx, cars)
+		close(cars)
+	}()
+
+	if wantsMsgpack(r) || wantsJSONAPI(r) || wantsProjection(r) || isV2(r) {
+		// MessagePack arrays are prefixed with their length, JSON:API
+		// documents are built whole, a ?query projects the whole
+		// response, and /v2 has its own envelope, so the list is
+		// collected first
+		list := []Car{}
+		for car := range cars {
+			list = append(list, car)
+		}
+		annotateCars(list)
+		err := <-errc
+		if err != nil && len(list) == 0 {
+			return false, err
+		}
+		if isV2(r) {
+			response := v2List(list, V2Meta{Stale: stale})
+			if err != nil {
+				response.Error = &V2Error{Code: v2CodeIncomplete, Message: err.Error()}
+			}
+			respond(w, r, http.StatusOK, response)
+			return true, err
+		}
+		response := APIResponse{Success: err == nil, Count: len(list), Data: list, Stale: stale}
+		if err != nil {
+			response.Error = err.Error()
+		}
+		respond(w, r, http.StatusOK, response)
+		return true, err
+	}
+
+	w.Header().Add("Vary", "Accept")
+	if stale {
+		// A live stream's fetches are still to come when the headers go
+		// out, so only stored cars get X-Cache
+		setCacheHeaders(w, responseMeta(r), true)
+	}
+	// The ETag is taken from everything but the meta, hashed as it goes
+	hash := sha256.New()
+	out := io.MultiWriter(w, hash)
+	encoder := json.NewEncoder(out)
+	english := wantsEnglish(r)
+	count := 0
+	for car := range cars {
+		if count == 0 {
+			out.Write([]byte(`{"data":[`))
+		} else {
+			out.Write([]byte(","))
+		}
+		sighted := []Car{car}
+		annotateCars(sighted)
+		var out interface{} = rewriteImageURLs(sighted[0])
+		if english {
+			out = translator.Response(out)
+		}
+		if validateResponses {
+			if data, err := json.Marshal(out); err == nil {
+				logSchemaProblems(r, "car.json", checkJSON("car.json", data, fmt.Sprintf("/data/%d", count)))
+			}
+		}
+		// Encode adds a newline after each car, which is valid whitespace
+		if err := encoder.Encode(out); err != nil {
+			// The client went away; let produce stop and drain the rest
+			cancel()
+			for range cars {
+			}
+			<-errc
+			return true, err
+		}
+		count++
+	}
+	err = <-errc
+	if count == 0 {
+		if err != nil {
+			return false, err
+		}
+		out.Write([]byte(`{"data":[`))
+	}
+
+	tail := struct {
+		Count   int           `json:"count"`
+		Stale   bool          `json:"stale,omitempty"`
+		Success bool          `json:"success"`
+		Error   string        `json:"error,omitempty"`
+		Meta    *ResponseMeta `json:"meta,omitempty"`
+	}{Count: count, Stale: stale, Success: err == nil}
+	if err != nil {
+		tail.Error = err.Error()
+	}
+	data, _ := json.Marshal(tail)
+	hash.Write(data)
+	setETagSource(r, hash.Sum(nil))
+	tail.Meta = responseMeta(r)
+	data, _ = json.Marshal(tail)
+	// Splice the tail's fields in after the array
+	w.Write([]byte("],"))
+	w.Write(data[1:])
+	w.Write([]byte("\n"))
+	return true, err
+}
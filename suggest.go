@@ -0,0 +1,117 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+// maxSuggestions bounds the "did you mean" names returned for a search.
+const maxSuggestions = 5
+
+// searchSuggestions returns the brand names and words of car names closest
+// to query by edit distance, for searches that found nothing. Close means
+// at most one edit per three letters, compared after partasala.Fold.
+func searchSuggestions(query string, brands []Brand, cars []Car) []string {
+	query = partasala.Fold(strings.TrimSpace(query), searchFolding)
+	maxDistance := len([]rune(query)) / 3
+	if maxDistance < 1 {
+		maxDistance = 1
+	}
+
+	type candidate struct {
+		name     string
+		distance int
+	}
+	seen := map[string]bool{query: true}
+	candidates := []candidate{}
+	consider := func(name string) {
+		key := partasala.Fold(name, searchFolding)
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		if d := editDistance(query, key); d <= maxDistance {
+			candidates = append(candidates, candidate{name: name, distance: d})
+		}
+	}
+
+	for _, brand := range brands {
+		consider(brand.Name)
+	}
+	for _, car := range cars {
+		for _, word := range strings.Fields(car.Name) {
+			// Years, engine sizes, and the like make poor suggestions
+			if len([]rune(word)) >= 3 && strings.IndexFunc(word, unicode.IsLetter) == 0 {
+				consider(titleCase(word))
+			}
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].name < candidates[j].name
+	})
+	suggestions := []string{}
+	for i := 0; i < len(candidates) && i < maxSuggestions; i++ {
+		suggestions = append(suggestions, candidates[i].name)
+	}
+	return suggestions
+}
+
+// titleCase writes a word from an upper-case listing the way it reads in a
+// sentence, e.g. "Avensis" for "AVENSIS".
+func titleCase(word string) string {
+	runes := []rune(strings.ToLower(word))
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+// editDistance is the Levenshtein distance between a and b, counted in
+// runes so Icelandic letters are one edit each.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// suggestionsFor gathers names for searchSuggestions from the stored brands
+// and the indexed cars while the search index is in use, and otherwise from
+// the site's brand list, or the stored one, and the stored cars. Before the
+// first crawl has stored any cars, they are listed from the site.
+func suggestionsFor(site SiteScraper, query string) []string {
+	if ready, _ := searchIndex.Ready(); ready {
+		brands, _ := dataset.Brands()
+		cars, _ := searchIndex.Cars()
+		return searchSuggestions(query, brands, cars)
+	}
+
+	brands, err := site.GetBrands()
+	if err != nil {
+		brands, _ = dataset.Brands()
+	}
+	cars, _ := dataset.Cars()
+	if len(cars) == 0 && !upstreamDown() {
+		cars, _ = site.GetAllCars()
+	}
+	return searchSuggestions(query, brands, cars)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const bucketTokens = "api_tokens"
+
+// Scopes an APIToken can carry. The admin API key carries all of them.
+const (
+	// ScopeRead allows keeping a watchlist and saved searches
+	ScopeRead = "read"
+	// ScopeAdmin allows /admin and /jobs
+	ScopeAdmin = "admin"
+	// ScopeWebhooks allows managing /webhooks
+	ScopeWebhooks = "webhooks"
+)
+
+// APIToken is an API token issued through /admin/tokens. Only a hash of
+// the token is stored, so the token itself is shown once, on creation.
+// DailyQuota overrides quotas.daily_requests for the token.
+type APIToken struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name,omitempty"`
+	Scopes     []string  `json:"scopes"`
+	DailyQuota *int      `json:"daily_quota,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// HasScope reports whether the token carries scope.
+func (t APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenHash is how a token is keyed in the store, and how the watchlists
+// and saved searches a token owns name it.
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateToken issues a token with scopes, returning the token and its
+// record.
+func (d *Dataset) CreateToken(name string, scopes []string, dailyQuota *int) (string, APIToken, error) {
+	id, err := newID()
+	if err != nil {
+		return "", APIToken{}, err
+	}
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", APIToken{}, err
+	}
+	token := "pst_" + hex.EncodeToString(secret)
+
+	record := APIToken{ID: id, Name: name, Scopes: scopes, DailyQuota: dailyQuota, CreatedAt: time.Now().UTC()}
+	if err := putJSON(d.store, bucketTokens, tokenHash(token), record); err != nil {
+		return "", APIToken{}, err
+	}
+	return token, record, nil
+}
+
+// Token returns the record of token, or ErrNotFound if it wasn't issued
+// or has been revoked.
+func (d *Dataset) Token(token string) (*APIToken, error) {
+	var record APIToken
+	if err := getJSON(d.store, bucketTokens, tokenHash(token), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// Tokens returns every issued token, oldest first.
+func (d *Dataset) Tokens() ([]APIToken, error) {
+	tokens := []APIToken{}
+	err := d.store.ForEach(bucketTokens, func(key string, value []byte) error {
+		var record APIToken
+		if err := json.Unmarshal(value, &record); err != nil {
+			return fmt.Errorf("token %s: %v", key, err)
+		}
+		tokens = append(tokens, record)
+		return nil
+	})
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+	return tokens, err
+}
+
+// RevokeToken deletes the token with id along with its watchlist, and
+// returns the token's hash so its saved searches and webhooks can go too.
+// It returns ErrNotFound if there is no such token.
+func (d *Dataset) RevokeToken(id string) (string, error) {
+	owner := ""
+	err := d.store.ForEach(bucketTokens, func(key string, value []byte) error {
+		var record APIToken
+		if err := json.Unmarshal(value, &record); err != nil {
+			return fmt.Errorf("token %s: %v", key, err)
+		}
+		if record.ID == id {
+			owner = key
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if owner == "" {
+		return "", ErrNotFound
+	}
+
+	watched := []string{}
+	err = d.store.ForEach(bucketWatchlist, func(key string, value []byte) error {
+		if strings.HasPrefix(key, owner+"/") {
+			watched = append(watched, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := d.store.Batch(bucketWatchlist, nil, watched); err != nil {
+		return "", err
+	}
+	return owner, d.store.Delete(bucketTokens, owner)
+}
+
+// requireScope guards routes with credentials carrying scope, passed the
+// same ways as the admin API key: the admin API key itself, which carries
+// every scope, or a token from /admin/tokens. The admin scope stays off
+// while no admin API key is configured, and the read scope is open to
+// everyone, since no tokens can be issued: every request then shares one
+// watchlist and set of saved searches, owned by no one.
+func requireScope(adminKey, scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if scope == ScopeRead && adminKey == "" {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ownerKey{}, "")))
+				return
+			}
+			if scope == ScopeAdmin && adminKey == "" {
+				writeError(w, r, http.StatusForbidden, "Admin API is disabled; set admin_api_key to enable it")
+				return
+			}
+
+			provided := requestKey(r)
+			if adminKey != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(adminKey)) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			var token *APIToken
+			err := ErrNotFound
+			if provided != "" {
+				token, err = dataset.Token(provided)
+			}
+			if err == ErrNotFound {
+				if scope == ScopeAdmin {
+					w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+				}
+				writeError(w, r, http.StatusUnauthorized, "Invalid or missing API key")
+				return
+			}
+			if err != nil {
+				writeError(w, r, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if !token.HasScope(scope) {
+				writeError(w, r, http.StatusForbidden, fmt.Sprintf("This token lacks the %q scope", scope))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ownerKey holds the owner requireScope settled on for a request.
+type ownerKey struct{}
+
+// requestOwner names whose watchlist, saved searches, and webhooks a
+// request, already let through by requireScope, works on.
+func requestOwner(r *http.Request) string {
+	if owner, ok := r.Context().Value(ownerKey{}).(string); ok {
+		return owner
+	}
+	return tokenHash(requestKey(r))
+}
+
+type CreatedToken struct {
+	Token string `json:"token"`
+	APIToken
+}
+
+func createTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name       string   `json:"name"`
+		Scopes     []string `json:"scopes"`
+		DailyQuota *int     `json:"daily_quota"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "Request body must be JSON with a \"scopes\" field",
+		})
+		return
+	}
+	if len(req.Scopes) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "Missing \"scopes\"; choose from read, admin, and webhooks",
+		})
+		return
+	}
+	if req.DailyQuota != nil && *req.DailyQuota < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "\"daily_quota\" must not be negative",
+		})
+		return
+	}
+	for _, scope := range req.Scopes {
+		switch scope {
+		case ScopeRead, ScopeAdmin, ScopeWebhooks:
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Error:   fmt.Sprintf("Unknown scope %q; choose from read, admin, and webhooks", scope),
+			})
+			return
+		}
+	}
+
+	token, record, err := dataset.CreateToken(strings.TrimSpace(req.Name), req.Scopes, req.DailyQuota)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Data:    CreatedToken{Token: token, APIToken: record},
+	})
+}
+
+func listTokensHandler(w http.ResponseWriter, r *http.Request) {
+	tokens, err := dataset.Tokens()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Count:   len(tokens),
+		Data:    tokens,
+	})
+}
+
+func revokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	owner, err := dataset.RevokeToken(mux.Vars(r)["id"])
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "Token not found",
+		})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	err = alerts.DeleteOwner(owner)
+	if err == nil {
+		err = webhooks.DeleteOwner(owner)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+	})
+}
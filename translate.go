@@ -0,0 +1,191 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// defaultTranslations are the Icelandic words common in car descriptions
+// and opening hours, with their English translations. Keys are lower case.
+var defaultTranslations = map[string]string{
+	// Fuel and gearbox
+	"bensín":       "petrol",
+	"bensin":       "petrol",
+	"dísil":        "diesel",
+	"dísel":        "diesel",
+	"disel":        "diesel",
+	"díselvél":     "diesel engine",
+	"rafmagn":      "electric",
+	"rafmagnsbíll": "electric car",
+	"tvinnbíll":    "hybrid",
+	"sjálfskiptur": "automatic",
+	"sjálfskipt":   "automatic",
+	"ssk":          "automatic",
+	"beinskiptur":  "manual",
+	"beinskipt":    "manual",
+	"bsk":          "manual",
+
+	// Drivetrain and body
+	"fjórhjóladrif":     "four-wheel drive",
+	"fjórhjóladrifinn":  "four-wheel drive",
+	"framhjóladrif":     "front-wheel drive",
+	"framhjóladrifinn":  "front-wheel drive",
+	"afturhjóladrif":    "rear-wheel drive",
+	"afturhjóladrifinn": "rear-wheel drive",
+	"dyra":              "door",
+	"fólksbíll":         "sedan",
+	"skutbíll":          "estate",
+	"jeppi":             "SUV",
+	"sendibíll":         "van",
+	"vél":               "engine",
+	"gírkassi":          "gearbox",
+
+	// Condition
+	"árgerð":     "model year",
+	"ekinn":      "mileage",
+	"tjón":       "damage",
+	"tjónaður":   "damaged",
+	"skemmdur":   "damaged",
+	"óskemmdur":  "undamaged",
+	"loftpúðar":  "airbags",
+	"sprungnir":  "deployed",
+	"varahlutir": "parts",
+	"litur":      "colour",
+	"grind":      "frame",
+	"ryðguð":     "rusty",
+	"framendi":   "front end",
+	"afturendi":  "rear end",
+
+	// Colours
+	"hvítur":  "white",
+	"svartur": "black",
+	"grár":    "grey",
+	"silfur":  "silver",
+	"rauður":  "red",
+	"blár":    "blue",
+	"grænn":   "green",
+
+	// Opening hours
+	"mánudaga":    "Mondays",
+	"þriðjudaga":  "Tuesdays",
+	"miðvikudaga": "Wednesdays",
+	"fimmtudaga":  "Thursdays",
+	"föstudaga":   "Fridays",
+	"laugardaga":  "Saturdays",
+	"sunnudaga":   "Sundays",
+	"virka":       "week",
+	"daga":        "days",
+	"lokað":       "closed",
+	"opið":        "open",
+	"og":          "and",
+}
+
+// translatedFields are the JSON fields translated for ?lang=en: free text
+// taken from the site as written. Names, slugs, and URLs are left alone.
+var translatedFields = map[string]bool{
+	"description":   true,
+	"opening_hours": true,
+}
+
+var translationWord = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// Translator turns the Icelandic words it knows into English, word by word.
+type Translator struct {
+	words map[string]string
+}
+
+var translator = NewTranslator(nil)
+
+// NewTranslator makes a Translator of defaultTranslations, with extra
+// added to them or replacing them.
+func NewTranslator(extra map[string]string) *Translator {
+	words := make(map[string]string, len(defaultTranslations)+len(extra))
+	for word, translation := range defaultTranslations {
+		words[word] = translation
+	}
+	for word, translation := range extra {
+		words[strings.ToLower(word)] = translation
+	}
+	return &Translator{words: words}
+}
+
+// wantsEnglish reports whether a request asked for English with ?lang=en.
+func wantsEnglish(r *http.Request) bool {
+	return r.URL.Query().Get("lang") == "en"
+}
+
+// Text translates every word of s it knows, keeping its capitalization:
+// "Bensín" becomes "Petrol" and "SSK" "AUTOMATIC".
+func (t *Translator) Text(s string) string {
+	return translationWord.ReplaceAllStringFunc(s, func(word string) string {
+		translation, ok := t.words[strings.ToLower(word)]
+		if !ok {
+			return word
+		}
+		first, _ := utf8.DecodeRuneInString(word)
+		switch {
+		case utf8.RuneCountInString(word) > 1 && word == strings.ToUpper(word):
+			return strings.ToUpper(translation)
+		case unicode.IsUpper(first):
+			r, size := utf8.DecodeRuneInString(translation)
+			return string(unicode.ToUpper(r)) + translation[size:]
+		}
+		return translation
+	})
+}
+
+// Response returns a copy of the response v with its translatedFields
+// translated. The stored and cached data v shares slices with is left as
+// it is.
+func (t *Translator) Response(v interface{}) interface{} {
+	if v == nil {
+		return v
+	}
+	return t.value(reflect.ValueOf(v), false).Interface()
+}
+
+// value copies v, translating its strings if translate is set or they are
+// in a translated field.
+func (t *Translator) value(v reflect.Value, translate bool) reflect.Value {
+	switch v.Kind() {
+	case reflect.String:
+		if translate {
+			return reflect.ValueOf(t.Text(v.String())).Convert(v.Type())
+		}
+	case reflect.Pointer:
+		if !v.IsNil() {
+			out := reflect.New(v.Type().Elem())
+			out.Elem().Set(t.value(v.Elem(), translate))
+			return out
+		}
+	case reflect.Interface:
+		if !v.IsNil() {
+			out := reflect.New(v.Type()).Elem()
+			out.Set(t.value(v.Elem(), translate))
+			return out
+		}
+	case reflect.Struct:
+		out := reflect.New(v.Type()).Elem()
+		out.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if out.Field(i).CanSet() {
+				name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+				out.Field(i).Set(t.value(v.Field(i), translatedFields[name]))
+			}
+		}
+		return out
+	case reflect.Slice:
+		if !v.IsNil() {
+			out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+			for i := 0; i < v.Len(); i++ {
+				out.Index(i).Set(t.value(v.Index(i), translate))
+			}
+			return out
+		}
+	}
+	return v
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+)
+
+const (
+	// typesenseBatchSize is how many documents go into one import.
+	typesenseBatchSize = 1000
+	// typesensePerPage is the largest page Typesense serves, and
+	// typesenseSearchLimit the most hits a delegated search collects.
+	typesensePerPage     = 250
+	typesenseSearchLimit = 1000
+	// typesenseQueryBy are the fields searches match, best first.
+	typesenseQueryBy = "name,make,model,plate,vin,description"
+)
+
+// typesenseFields are the indexed fields; the rest of each document is
+// stored as is.
+var typesenseFields = []map[string]interface{}{
+	{"name": "name", "type": "string"},
+	{"name": "make", "type": "string", "optional": true},
+	{"name": "model", "type": "string", "optional": true},
+	{"name": "plate", "type": "string", "optional": true},
+	{"name": "vin", "type": "string", "optional": true},
+	{"name": "description", "type": "string", "optional": true},
+	{"name": "brand", "type": "string", "facet": true},
+	{"name": "year", "type": "int32", "optional": true},
+}
+
+// typesenseDocument adds the id Typesense requires.
+type typesenseDocument struct {
+	ID string `json:"id"`
+	SearchDocument
+}
+
+// typesenseSink imports every sync into a new timestamped collection and
+// then points the configured alias at it, so searches never see a
+// half-filled collection. The collection the alias left is deleted.
+type typesenseSink struct {
+	*engineClient
+	collection string
+}
+
+func (s *typesenseSink) Sync(docs []SearchDocument) error {
+	name := fmt.Sprintf("%s_%d", s.collection, time.Now().UnixMilli())
+	schema := map[string]interface{}{"name": name, "fields": typesenseFields}
+	if err := s.do("POST", "/collections", "application/json", schema, nil); err != nil {
+		return fmt.Errorf("typesense: create %s: %v", name, err)
+	}
+	if err := s.fill(name, docs); err != nil {
+		s.do("DELETE", "/collections/"+url.PathEscape(name), "", nil, nil)
+		return fmt.Errorf("typesense: %v", err)
+	}
+
+	var current struct {
+		CollectionName string `json:"collection_name"`
+	}
+	alias := "/aliases/" + url.PathEscape(s.collection)
+	if err := s.do("GET", alias, "", nil, &current); err != nil && !isEngineNotFound(err) {
+		s.do("DELETE", "/collections/"+url.PathEscape(name), "", nil, nil)
+		return fmt.Errorf("typesense: alias %s: %v", s.collection, err)
+	}
+	if err := s.do("PUT", alias, "application/json", map[string]string{"collection_name": name}, nil); err != nil {
+		s.do("DELETE", "/collections/"+url.PathEscape(name), "", nil, nil)
+		return fmt.Errorf("typesense: alias %s: %v", s.collection, err)
+	}
+
+	if current.CollectionName != "" && current.CollectionName != name {
+		if err := s.do("DELETE", "/collections/"+url.PathEscape(current.CollectionName), "", nil, nil); err != nil {
+			return fmt.Errorf("typesense: delete %s: %v", current.CollectionName, err)
+		}
+	}
+	return nil
+}
+
+// fill imports docs into the collection name, keyed by car slug.
+func (s *typesenseSink) fill(name string, docs []SearchDocument) error {
+	for start := 0; start < len(docs); start += typesenseBatchSize {
+		var body bytes.Buffer
+		encoder := json.NewEncoder(&body)
+		for _, doc := range docs[start:min(start+typesenseBatchSize, len(docs))] {
+			if err := encoder.Encode(rewriteImageURLs(typesenseDocument{ID: doc.Slug, SearchDocument: doc})); err != nil {
+				return err
+			}
+		}
+
+		// Typesense answers 200 with one result line per document
+		var results bytes.Buffer
+		path := "/collections/" + url.PathEscape(name) + "/documents/import?action=create"
+		if err := s.do("POST", path, "text/plain", body.Bytes(), &results); err != nil {
+			return fmt.Errorf("import: %v", err)
+		}
+		decoder := json.NewDecoder(&results)
+		for {
+			var result struct {
+				Success bool   `json:"success"`
+				Error   string `json:"error"`
+			}
+			if err := decoder.Decode(&result); err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("import: %v", err)
+			}
+			if !result.Success {
+				return fmt.Errorf("import: %s", result.Error)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *typesenseSink) Search(query string) ([]Car, error) {
+	cars := []Car{}
+	for page := 1; len(cars) < typesenseSearchLimit; page++ {
+		params := url.Values{
+			"q":        {query},
+			"query_by": {typesenseQueryBy},
+			"per_page": {fmt.Sprint(typesensePerPage)},
+			"page":     {fmt.Sprint(page)},
+		}
+		var result struct {
+			Found int `json:"found"`
+			Hits  []struct {
+				Document Car `json:"document"`
+			} `json:"hits"`
+		}
+		path := "/collections/" + url.PathEscape(s.collection) + "/documents/search?" + params.Encode()
+		if err := s.do("GET", path, "", nil, &result); err != nil {
+			return nil, fmt.Errorf("typesense: %v", err)
+		}
+		for _, hit := range result.Hits {
+			cars = append(cars, hit.Document)
+		}
+		if len(result.Hits) < typesensePerPage || len(cars) >= result.Found {
+			break
+		}
+	}
+	return cars, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"embed"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+//go:embed ui/*.html
+var uiFiles embed.FS
+
+// uiPages are the browse UI's templates, each parsed with the shared layout.
+var uiPages = map[string]*template.Template{}
+
+func init() {
+	funcs := template.FuncMap{"formatPrice": formatPrice, "link": link}
+	for _, page := range []string{"brands.html", "cars.html", "car.html", "admin.html"} {
+		uiPages[page] = template.Must(template.New(page).Funcs(funcs).ParseFS(uiFiles, "ui/layout.html", "ui/"+page))
+	}
+}
+
+// uiPage is what every UI template is rendered with.
+type uiPage struct {
+	Title       string
+	Query       string
+	Data        interface{}
+	Suggestions []string
+	Stale       bool
+}
+
+// formatPrice writes a price the way Icelandic listings do, e.g.
+// "45.000 kr." for 45000 ISK.
+func formatPrice(price *Price) string {
+	digits := strconv.FormatInt(price.Amount, 10)
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte('.')
+		}
+		b.WriteRune(d)
+	}
+	if price.Currency == "ISK" {
+		return b.String() + " kr."
+	}
+	return b.String() + " " + price.Currency
+}
+
+func renderUI(w http.ResponseWriter, status int, page string, data uiPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	data.Data = rewriteImageURLs(data.Data)
+	if err := uiPages[page].ExecuteTemplate(w, "layout", data); err != nil {
+		log.Printf("ui: %v", err)
+	}
+}
+
+// renderUIError shows message as an empty car list, so the header and
+// search stay usable.
+func renderUIError(w http.ResponseWriter, status int, message string) {
+	renderUI(w, status, "cars.html", uiPage{Title: message, Data: []Car{}})
+}
+
+func uiBrandsHandler(w http.ResponseWriter, r *http.Request) {
+	brands, err := siteFor(r).GetBrands()
+	stale := false
+	if err != nil && upstreamDown() {
+		brands, err = dataset.Brands()
+		stale = true
+	}
+	if err != nil {
+		renderUIError(w, http.StatusBadGateway, "The brand list could not be loaded")
+		return
+	}
+	renderUI(w, http.StatusOK, "brands.html", uiPage{Title: "Brands", Data: brands, Stale: stale})
+}
+
+func uiBrandCarsHandler(w http.ResponseWriter, r *http.Request) {
+	brandSlug := mux.Vars(r)["brand_slug"]
+	cars, err := siteFor(r).GetBrandCars(brandSlug)
+	stale := false
+	if err != nil && upstreamDown() {
+		cars, err = storedBrandCars(brandSlug)
+		stale = true
+	}
+	if err != nil {
+		renderUIError(w, http.StatusBadGateway, "The brand's cars could not be loaded")
+		return
+	}
+
+	title := brandSlug
+	if brands, err := dataset.Brands(); err == nil {
+		for _, brand := range brands {
+			if brand.Slug == brandSlug {
+				title = brand.Name
+			}
+		}
+	}
+	renderUI(w, http.StatusOK, "cars.html", uiPage{Title: title, Data: cars, Stale: stale})
+}
+
+func uiCarHandler(w http.ResponseWriter, r *http.Request) {
+	carSlug := mux.Vars(r)["car_slug"]
+	details, err := siteFor(r).GetCarDetails(carSlug)
+	stale := false
+	if err != nil && upstreamDown() {
+		details, err = dataset.Details(carSlug)
+		stale = true
+	}
+	if err != nil {
+		renderUIError(w, http.StatusBadGateway, "The car could not be loaded")
+		return
+	}
+	popularity.View(carSlug, time.Now())
+	if !stale {
+		if err := dataset.SaveDetails(details); err != nil {
+			log.Printf("dataset: %v", err)
+		}
+	}
+	renderUI(w, http.StatusOK, "car.html", uiPage{Title: details.Name, Data: details, Stale: stale})
+}
+
+// uiSearchHandler shows the first page of /search's results for q.
+func uiSearchHandler(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		http.Redirect(w, r, link("/ui"), http.StatusFound)
+		return
+	}
+
+	site := siteFor(r)
+	var cars []Car
+	var err error
+	stale := false
+	if ready, _ := searchIndex.Ready(); ready {
+		cars, err = searchIndex.Search(query)
+	} else if cars, err = site.SearchCars(strings.ToLower(query)); err != nil && upstreamDown() {
+		cars, err = searchStoredCars(query)
+		stale = true
+	}
+	if err != nil {
+		renderUIError(w, http.StatusBadGateway, "The search could not be run")
+		return
+	}
+
+	page := uiPage{Title: "Search: " + query, Query: query, Data: cars[:min(len(cars), defaultSearchLimit)], Stale: stale}
+	if len(cars) == 0 {
+		page.Suggestions = suggestionsFor(site, query)
+	}
+	renderUI(w, http.StatusOK, "cars.html", page)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// UploadConfig enables periodic uploads of dataset snapshots, and optionally
+// of every stored car image, to an S3-compatible bucket. For Google Cloud
+// Storage use endpoint https://storage.googleapis.com with HMAC keys.
+type UploadConfig struct {
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	Prefix    string `json:"prefix"`
+
+	Interval Duration `json:"interval"`
+	// Retention is how many snapshots to keep; older ones are deleted.
+	Retention    int  `json:"retention"`
+	MirrorImages bool `json:"mirror_images"`
+}
+
+// SnapshotUploader copies the dataset to object storage on a schedule.
+type SnapshotUploader struct {
+	config  UploadConfig
+	s3      *S3Client
+	dataset *Dataset
+	client  *http.Client
+}
+
+func NewSnapshotUploader(config UploadConfig, dataset *Dataset) *SnapshotUploader {
+	return &SnapshotUploader{
+		config:  config,
+		s3:      NewS3Client(config.Endpoint, config.Region, config.Bucket, config.AccessKey, config.SecretKey),
+		dataset: dataset,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Run uploads on every interval until ctx is cancelled. The first upload
+// happens one interval after startup, once the crawler has filled the
+// dataset.
+func (u *SnapshotUploader) Run(ctx context.Context) {
+	ticker := time.NewTicker(u.config.Interval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := u.Upload(); err != nil {
+			log.Printf("upload: %v", err)
+		}
+	}
+}
+
+// Upload writes a snapshot, prunes old ones, and mirrors new images.
+func (u *SnapshotUploader) Upload() error {
+	snapshot, err := u.dataset.Export()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	snapshotPrefix := u.key("snapshots/")
+	key := snapshotPrefix + "partasala-" + snapshot.ExportedAt.Format("20060102T150405Z") + ".json"
+	if err := u.s3.PutObject(key, data, "application/json"); err != nil {
+		return err
+	}
+	log.Printf("upload: wrote %s (%d bytes)", key, len(data))
+
+	if err := u.prune(snapshotPrefix); err != nil {
+		return err
+	}
+
+	if u.config.MirrorImages {
+		return u.mirrorImages(snapshot.Details)
+	}
+	return nil
+}
+
+// prune deletes all but the newest Retention snapshots. Snapshot keys embed
+// their timestamp, so key order is age order.
+func (u *SnapshotUploader) prune(prefix string) error {
+	keys, err := u.s3.ListObjects(prefix)
+	if err != nil {
+		return err
+	}
+
+	for len(keys) > u.config.Retention {
+		if err := u.s3.DeleteObject(keys[0]); err != nil {
+			return err
+		}
+		log.Printf("upload: pruned %s", keys[0])
+		keys = keys[1:]
+	}
+	return nil
+}
+
+// mirrorImages uploads every image that is not in the bucket yet, keyed by
+// its upstream path.
+func (u *SnapshotUploader) mirrorImages(details []CarDetails) error {
+	uploaded := 0
+	for _, car := range details {
+		for _, image := range car.Images {
+			parsed, err := url.Parse(image.URL)
+			if err != nil {
+				continue
+			}
+			key := u.key("images" + path.Clean("/"+parsed.Path))
+
+			exists, err := u.s3.HasObject(key)
+			if err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+
+			if err := u.mirrorImage(image.URL, key); err != nil {
+				log.Printf("upload: %v", err)
+				continue
+			}
+			uploaded++
+		}
+	}
+
+	log.Printf("upload: mirrored %d new images", uploaded)
+	return nil
+}
+
+func (u *SnapshotUploader) mirrorImage(imageURL, key string) error {
+	resp, err := u.client.Get(imageURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch %s: %s", imageURL, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return u.s3.PutObject(key, data, resp.Header.Get("Content-Type"))
+}
+
+func (u *SnapshotUploader) key(name string) string {
+	prefix := strings.Trim(u.config.Prefix, "/")
+	if prefix == "" {
+		return name
+	}
+	return prefix + "/" + name
+}
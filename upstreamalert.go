@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// UpstreamAlertConfig raises an alert when more than ErrorRate of the
+// upstream requests made in the last Window failed, and clears it once the
+// rate drops back below. Windows with fewer than MinRequests requests
+// change nothing. The alert goes to notifiers subscribed to upstream_down
+// and upstream_recovered, and to Email if SMTP is set up. A zero ErrorRate
+// disables it.
+type UpstreamAlertConfig struct {
+	ErrorRate   float64  `json:"error_rate"`
+	Window      Duration `json:"window"`
+	MinRequests int      `json:"min_requests"`
+	Email       string   `json:"email"`
+}
+
+// upstreamAlertInterval is how often the monitor checks the error rate.
+const upstreamAlertInterval = 30 * time.Second
+
+// UpstreamAlertMonitor watches the site scraper's upstream error rate.
+type UpstreamAlertMonitor struct {
+	config    UpstreamAlertConfig
+	health    UpstreamHealth
+	notifiers *NotifierSet
+	mailer    *Mailer
+
+	mu    sync.Mutex
+	since time.Time // when the alert was raised; zero while upstream is fine
+}
+
+var upstreamAlert *UpstreamAlertMonitor
+
+func NewUpstreamAlertMonitor(config UpstreamAlertConfig, health UpstreamHealth, notifiers *NotifierSet, mailer *Mailer) *UpstreamAlertMonitor {
+	return &UpstreamAlertMonitor{config: config, health: health, notifiers: notifiers, mailer: mailer}
+}
+
+// Firing reports whether the alert is raised and since when.
+func (m *UpstreamAlertMonitor) Firing() (bool, time.Time) {
+	if m == nil {
+		return false, time.Time{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return !m.since.IsZero(), m.since
+}
+
+// check raises or clears the alert for the current error rate, notifying
+// only when the state changes.
+func (m *UpstreamAlertMonitor) check(now time.Time) {
+	outcomes := m.health.UpstreamOutcomes(m.config.Window.Duration)
+	if outcomes.Requests < m.config.MinRequests {
+		return
+	}
+	failing := outcomes.ErrorRate() > m.config.ErrorRate
+
+	m.mu.Lock()
+	firing := !m.since.IsZero()
+	switch {
+	case failing && !firing:
+		m.since = now
+	case !failing && firing:
+		m.since = time.Time{}
+	}
+	m.mu.Unlock()
+	if failing == firing {
+		return
+	}
+
+	summary := fmt.Sprintf("%d of %d upstream requests failed in the last %s", outcomes.Failures, outcomes.Requests, m.config.Window.Duration)
+	if failing {
+		log.Printf("upstream alert: %s", summary)
+		recent.addError("upstream", fmt.Errorf("%s", summary))
+		m.notifiers.Notify(NotifierEvent{Type: EventUpstreamDown, Error: summary})
+		m.email("Partasala: upstream site failing", "The upstream site looks down or is blocking the scraper: "+summary+".\r\n")
+	} else {
+		log.Printf("upstream alert cleared: %s", summary)
+		m.notifiers.Notify(NotifierEvent{Type: EventUpstreamRecovered, Error: summary})
+		m.email("Partasala: upstream site recovered", "The upstream site has recovered: "+summary+".\r\n")
+	}
+}
+
+func (m *UpstreamAlertMonitor) email(subject, text string) {
+	if m.config.Email == "" || m.mailer == nil {
+		return
+	}
+	if err := m.mailer.SendText(m.config.Email, subject, text); err != nil {
+		log.Printf("upstream alert: %v", err)
+		recent.addError("upstream_alert", err)
+	}
+}
+
+// Run checks the error rate every upstreamAlertInterval until ctx is
+// cancelled.
+func (m *UpstreamAlertMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(upstreamAlertInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.check(now)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const bucketUsage = "usage"
+
+const (
+	// usageHistoryDays is how many days /me/usage reports, today included.
+	usageHistoryDays = 30
+	// usageDayLayout formats the UTC day counts are kept under.
+	usageDayLayout = "2006-01-02"
+)
+
+// QuotaConfig limits how many requests each API token may make per UTC
+// day. Zero means no limit. Tokens can be issued with a quota of their own,
+// and the admin API key is never limited. Requests without a valid key are
+// counted by client address against AnonymousDailyRequests, if it is set.
+type QuotaConfig struct {
+	DailyRequests          int `json:"daily_requests"`
+	AnonymousDailyRequests int `json:"anonymous_daily_requests"`
+	// TrustForwardedFor takes the client address from the last entry of
+	// X-Forwarded-For, for servers behind a reverse proxy that sets it
+	TrustForwardedFor bool `json:"trust_forwarded_for"`
+}
+
+// UsageMeter counts the requests of every API key per UTC day. Counts are
+// kept in memory and saved to the store by Run, keyed by the key's hash
+// and the day, so a restart loses at most one interval of them. Only the
+// last usageHistoryDays days are kept in the store.
+type UsageMeter struct {
+	store Store
+
+	mu     sync.Mutex
+	day    string
+	quotas QuotaConfig
+	counts map[string]int64
+	dirty  map[string]bool
+}
+
+var usage *UsageMeter
+
+func NewUsageMeter(store Store, quotas QuotaConfig) *UsageMeter {
+	return &UsageMeter{store: store, quotas: quotas, counts: map[string]int64{}, dirty: map[string]bool{}}
+}
+
+// SetQuotas replaces the quotas keys without a quota of their own get.
+func (m *UsageMeter) SetQuotas(quotas QuotaConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.quotas = quotas
+}
+
+// DailyQuota is the quota of keys without a quota of their own.
+func (m *UsageMeter) DailyQuota() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.quotas.DailyRequests
+}
+
+// Quotas returns the current quotas.
+func (m *UsageMeter) Quotas() QuotaConfig {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.quotas
+}
+
+// count returns the count under key, reading it from the store the first
+// time. The caller holds m.mu.
+func (m *UsageMeter) count(key string) int64 {
+	if n, ok := m.counts[key]; ok {
+		return n
+	}
+	var n int64
+	if err := getJSON(m.store, bucketUsage, key, &n); err != nil && err != ErrNotFound {
+		log.Printf("usage: %v", err)
+	}
+	m.counts[key] = n
+	return n
+}
+
+// forget drops the counts in memory, flushed or not, for when the stored
+// ones are replaced, as by a restore.
+func (m *UsageMeter) forget() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts, m.dirty = map[string]int64{}, map[string]bool{}
+}
+
+// Allow counts a request by owner at now unless owner has used up quota,
+// returning the requests counted today including this one. A quota of 0
+// means no limit.
+func (m *UsageMeter) Allow(owner string, quota int, now time.Time) (int64, bool) {
+	key := owner + "/" + now.UTC().Format(usageDayLayout)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := m.count(key)
+	if quota > 0 && n >= int64(quota) {
+		return n, false
+	}
+	m.counts[key] = n + 1
+	m.dirty[key] = true
+	return n + 1, true
+}
+
+// DailyUsage is how many requests a key made on one UTC day.
+type DailyUsage struct {
+	Date     string `json:"date"`
+	Requests int64  `json:"requests"`
+}
+
+// History returns the counts of owner for the last days days up to now,
+// oldest first.
+func (m *UsageMeter) History(owner string, days int, now time.Time) []DailyUsage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	history := make([]DailyUsage, 0, days)
+	for i := days - 1; i >= 0; i-- {
+		date := now.UTC().AddDate(0, 0, -i).Format(usageDayLayout)
+		history = append(history, DailyUsage{Date: date, Requests: m.count(owner + "/" + date)})
+	}
+	return history
+}
+
+// Flush saves the counts changed since the last flush. Once a day it also
+// drops past days from memory and deletes counts older than
+// usageHistoryDays from the store.
+func (m *UsageMeter) Flush(now time.Time) error {
+	today := now.UTC().Format(usageDayLayout)
+	m.mu.Lock()
+	puts := make(map[string][]byte, len(m.dirty))
+	for key := range m.dirty {
+		data, err := json.Marshal(m.counts[key])
+		if err != nil {
+			m.mu.Unlock()
+			return err
+		}
+		puts[key] = data
+	}
+	m.dirty = map[string]bool{}
+	newDay := m.day != today
+	if newDay {
+		m.day = today
+		for key := range m.counts {
+			if !strings.HasSuffix(key, "/"+today) {
+				delete(m.counts, key)
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	var deletes []string
+	if newDay {
+		oldest := now.UTC().AddDate(0, 0, -(usageHistoryDays - 1)).Format(usageDayLayout)
+		err := m.store.ForEach(bucketUsage, func(key string, value []byte) error {
+			if day := key[strings.LastIndexByte(key, '/')+1:]; day < oldest {
+				deletes = append(deletes, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(puts) == 0 && len(deletes) == 0 {
+		return nil
+	}
+	return m.store.Batch(bucketUsage, puts, deletes)
+}
+
+// Run flushes the counts every interval until ctx is cancelled.
+func (m *UsageMeter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := m.Flush(now); err != nil {
+				log.Printf("usage: %v", err)
+				recent.addError("usage", err)
+			}
+		}
+	}
+}
+
+// meteredKey finds the API key r was sent with: its hash, its daily quota,
+// and the token's id if it is an issued token rather than the admin API
+// key. ok is false for requests without a valid key, which aren't metered.
+func meteredKey(r *http.Request, adminKey string) (owner string, quota int, id string, ok bool, err error) {
+	provided := requestKey(r)
+	if provided == "" {
+		return "", 0, "", false, nil
+	}
+	if adminKey != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(adminKey)) == 1 {
+		return tokenHash(provided), 0, "", true, nil
+	}
+	token, err := dataset.Token(provided)
+	if err == ErrNotFound {
+		return "", 0, "", false, nil
+	}
+	if err != nil {
+		return "", 0, "", false, err
+	}
+	quota = usage.DailyQuota()
+	if token.DailyQuota != nil {
+		quota = *token.DailyQuota
+	}
+	return tokenHash(provided), quota, token.ID, true, nil
+}
+
+// anonymousClient names the client of a request without a valid API key,
+// for metering: "ip:" and its address, or for IPv6 its /64, which one
+// host usually has to itself.
+func anonymousClient(r *http.Request, trustForwardedFor bool) string {
+	addr := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	if forwarded := r.Header.Values("X-Forwarded-For"); trustForwardedFor && len(forwarded) > 0 {
+		// The last hop is the one the proxy added
+		hops := strings.Split(forwarded[len(forwarded)-1], ",")
+		addr = strings.TrimSpace(hops[len(hops)-1])
+	}
+	if ip := net.ParseIP(addr); ip != nil && ip.To4() == nil {
+		addr = ip.Mask(net.CIDRMask(64, 128)).String()
+	}
+	return "ip:" + addr
+}
+
+// nextUTCMidnight is when the daily counts after t start over.
+func nextUTCMidnight(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+// quotaMiddleware counts the requests made with an API key, or without a
+// valid one by client address while anonymous_daily_requests limits them,
+// and answers 429 once the daily quota is used up, until midnight UTC.
+// Limited clients get their quota in X-Quota-Limit and what is left in
+// X-Quota-Remaining, and the same again as X-RateLimit-Limit and
+// X-RateLimit-Remaining, with X-RateLimit-Reset, the Unix time of the
+// reset, for clients that throttle themselves by the usual names.
+// /me/usage is neither counted nor refused, so a key can always see why it
+// is being turned away.
+func quotaMiddleware(adminKey string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/me/usage" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			owner, quota, _, ok, err := meteredKey(r, adminKey)
+			if err != nil {
+				log.Printf("usage: %v", err)
+			}
+			if !ok {
+				// Unknown keys count as no key, or a made-up key per
+				// request would get around the quota
+				quotas := usage.Quotas()
+				if quotas.AnonymousDailyRequests == 0 {
+					// Without a limit there is nothing to count them for
+					next.ServeHTTP(w, r)
+					return
+				}
+				owner, quota = anonymousClient(r, quotas.TrustForwardedFor), quotas.AnonymousDailyRequests
+			}
+
+			now := time.Now()
+			count, allowed := usage.Allow(owner, quota, now)
+			reset := nextUTCMidnight(now)
+			if quota > 0 {
+				limit, remaining := strconv.Itoa(quota), strconv.FormatInt(max(int64(quota)-count, 0), 10)
+				w.Header().Set("X-Quota-Limit", limit)
+				w.Header().Set("X-Quota-Remaining", remaining)
+				w.Header().Set("X-RateLimit-Limit", limit)
+				w.Header().Set("X-RateLimit-Remaining", remaining)
+				w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+			}
+			if !allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(reset.Sub(now).Seconds())+1))
+				writeError(w, r, http.StatusTooManyRequests, "Daily quota of "+strconv.Itoa(quota)+" requests used up; it resets at "+reset.Format(time.RFC3339))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type UsageReport struct {
+	// TokenID is empty for the admin API key
+	TokenID   string       `json:"token_id,omitempty"`
+	Date      string       `json:"date"`
+	Requests  int64        `json:"requests"`
+	Quota     *int         `json:"quota"`
+	Remaining *int64       `json:"remaining"`
+	ResetsAt  time.Time    `json:"resets_at"`
+	History   []DailyUsage `json:"history"`
+}
+
+// usageHandler serves GET /me/usage: the metering of the key the request
+// is sent with.
+func usageHandler(adminKey string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner, quota, id, ok, err := meteredKey(r, adminKey)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Error:   "Invalid or missing API key",
+			})
+			return
+		}
+
+		now := time.Now()
+		history := usage.History(owner, usageHistoryDays, now)
+		report := UsageReport{
+			TokenID:  id,
+			Date:     now.UTC().Format(usageDayLayout),
+			Requests: history[len(history)-1].Requests,
+			ResetsAt: nextUTCMidnight(now),
+			History:  history,
+		}
+		if quota > 0 {
+			remaining := max(int64(quota)-report.Requests, 0)
+			report.Quota, report.Remaining = &quota, &remaining
+		}
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: true,
+			Data:    report,
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// withUsage points the usage and dataset globals at a fresh store for the
+// length of a test.
+func withUsage(t *testing.T, quotas QuotaConfig) Store {
+	store := NewMemoryStore()
+	savedUsage, savedDataset := usage, dataset
+	usage, dataset = NewUsageMeter(store, quotas), NewDataset(store)
+	t.Cleanup(func() { usage, dataset = savedUsage, savedDataset })
+	return store
+}
+
+func TestUsageMeterAllow(t *testing.T) {
+	m := NewUsageMeter(NewMemoryStore(), QuotaConfig{})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, want := range []bool{true, true, false, false} {
+		if _, allowed := m.Allow("owner", 2, now); allowed != want {
+			t.Errorf("request %d: allowed = %v, want %v", i+1, allowed, want)
+		}
+	}
+	if _, allowed := m.Allow("other", 2, now); !allowed {
+		t.Error("another owner was refused")
+	}
+	if n, allowed := m.Allow("owner", 2, now.Add(12*time.Hour)); !allowed || n != 1 {
+		t.Errorf("next day: count %d, allowed %v, want 1, true", n, allowed)
+	}
+	if n, allowed := m.Allow("unlimited", 0, now); !allowed || n != 1 {
+		t.Errorf("no quota: count %d, allowed %v, want 1, true", n, allowed)
+	}
+}
+
+func TestUsageMeterFlushPrunes(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC)
+	for _, key := range []string{"ip:192.0.2.1/2024-04-01", "ip:192.0.2.1/2024-05-02", "abc/2024-05-01"} {
+		if err := putJSON(store, bucketUsage, key, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := NewUsageMeter(store, QuotaConfig{})
+	m.Allow("abc", 0, now)
+	if err := m.Flush(now); err != nil {
+		t.Fatal(err)
+	}
+
+	kept := map[string]bool{}
+	store.ForEach(bucketUsage, func(key string, value []byte) error {
+		kept[key] = true
+		return nil
+	})
+	want := map[string]bool{"ip:192.0.2.1/2024-05-02": true, "abc/2024-05-31": true}
+	if len(kept) != len(want) {
+		t.Errorf("kept %v, want %v", kept, want)
+	}
+	for key := range want {
+		if !kept[key] {
+			t.Errorf("%s was deleted", key)
+		}
+	}
+}
+
+// quotaServer serves 200 behind quotaMiddleware with admin key "admin".
+func quotaServer() http.Handler {
+	return quotaMiddleware("admin")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+}
+
+func quotaRequest(key, remoteAddr string) *http.Request {
+	r := httptest.NewRequest("GET", "/brands", nil)
+	if key != "" {
+		r.Header.Set("X-API-Key", key)
+	}
+	r.RemoteAddr = remoteAddr
+	return r
+}
+
+func TestQuotaMiddlewareToken(t *testing.T) {
+	withUsage(t, QuotaConfig{DailyRequests: 100})
+	one := 1
+	token, _, err := dataset.CreateToken("test", []string{ScopeRead}, &one)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := quotaServer()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, quotaRequest(token, "192.0.2.1:1234"))
+	if w.Code != http.StatusOK || w.Header().Get("X-Quota-Limit") != "1" || w.Header().Get("X-Quota-Remaining") != "0" {
+		t.Errorf("first request: %d with quota %q, remaining %q", w.Code, w.Header().Get("X-Quota-Limit"), w.Header().Get("X-Quota-Remaining"))
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, quotaRequest(token, "192.0.2.1:1234"))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: %d, want 429", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" || w.Header().Get("X-RateLimit-Reset") == "" {
+		t.Error("429 without Retry-After and X-RateLimit-Reset")
+	}
+	var body APIResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body.Success || body.Error == "" {
+		t.Errorf("429 body = %+v, %v", body, err)
+	}
+
+	// The admin key is never limited
+	for i := 0; i < 3; i++ {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, quotaRequest("admin", "192.0.2.1:1234"))
+		if w.Code != http.StatusOK {
+			t.Fatalf("admin request %d: %d", i+1, w.Code)
+		}
+	}
+}
+
+func TestQuotaMiddlewareAnonymous(t *testing.T) {
+	withUsage(t, QuotaConfig{AnonymousDailyRequests: 1})
+	handler := quotaServer()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, quotaRequest("", "192.0.2.1:1234"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("first request: %d", w.Code)
+	}
+	// A made-up key counts as none, against the same address
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, quotaRequest("made-up", "192.0.2.1:5678"))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("made-up key: %d, want 429", w.Code)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, quotaRequest("", "192.0.2.2:1234"))
+	if w.Code != http.StatusOK {
+		t.Errorf("another address: %d", w.Code)
+	}
+}
+
+func TestQuotaMiddlewareAnonymousUnlimited(t *testing.T) {
+	store := withUsage(t, QuotaConfig{})
+	handler := quotaServer()
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, quotaRequest("", "192.0.2.1:1234"))
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: %d", i+1, w.Code)
+		}
+	}
+	if err := usage.Flush(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	store.ForEach(bucketUsage, func(key string, value []byte) error {
+		t.Errorf("%s was counted without an anonymous quota", key)
+		return nil
+	})
+}
+
+func TestAnonymousClient(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		trust      bool
+		want       string
+	}{
+		{"address", "192.0.2.1:1234", nil, false, "ip:192.0.2.1"},
+		{"untrusted X-Forwarded-For", "192.0.2.1:1234", []string{"198.51.100.7"}, false, "ip:192.0.2.1"},
+		{"last hop", "192.0.2.1:1234", []string{"203.0.113.5, 198.51.100.7"}, true, "ip:198.51.100.7"},
+		{"last header", "192.0.2.1:1234", []string{"203.0.113.5", "198.51.100.7"}, true, "ip:198.51.100.7"},
+		{"IPv6 /64", "[2001:db8:1:2:3:4:5:6]:443", nil, false, "ip:2001:db8:1:2::"},
+		{"forwarded IPv6 /64", "192.0.2.1:1234", []string{"2001:db8:1:2:ffff::1"}, true, "ip:2001:db8:1:2::"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		for _, value := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		if got := anonymousClient(r, tt.trust); got != tt.want {
+			t.Errorf("%s: anonymousClient = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// V2Response is the one envelope every /v2 endpoint answers with: the
+// payload in data, everything about it in meta, and on failure an error
+// with a stable code. Data is null when there is an error, except for a
+// list cut short, which keeps the cars read before it.
+type V2Response[T any] struct {
+	Data  T        `json:"data"`
+	Meta  V2Meta   `json:"meta"`
+	Error *V2Error `json:"error,omitempty"`
+}
+
+// V2Meta describes a /v2 response's data. Count is set for lists; Total,
+// Limit, and Offset for pages of search results. The ResponseMeta fields
+// say where the data came from, as the v1 "meta" object does.
+type V2Meta struct {
+	Count       *int       `json:"count,omitempty"`
+	Total       *int       `json:"total,omitempty"`
+	Limit       *int       `json:"limit,omitempty"`
+	Offset      *int       `json:"offset,omitempty"`
+	Brand       string     `json:"brand,omitempty"`
+	Query       string     `json:"query,omitempty"`
+	Suggestions []string   `json:"suggestions,omitempty"`
+	IndexedAt   *time.Time `json:"indexed_at,omitempty"`
+	Engine      string     `json:"engine,omitempty"`
+	Stale       bool       `json:"stale"`
+	*ResponseMeta
+}
+
+// V2Error is what went wrong with a /v2 request. Code is one of the
+// v2Code constants, for clients to branch on; Message is for people.
+type V2Error struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// The codes of V2Error, by the status they are sent with.
+const (
+	v2CodeBadRequest          = "bad_request"
+	v2CodeUnauthorized        = "unauthorized"
+	v2CodeForbidden           = "forbidden"
+	v2CodeNotFound            = "not_found"
+	v2CodeRateLimited         = "rate_limited"
+	v2CodeInternal            = "internal"
+	v2CodeNotImplemented      = "not_implemented"
+	v2CodeUpstreamUnavailable = "upstream_unavailable"
+	// v2CodeIncomplete is sent with a 200 list that failed part way
+	v2CodeIncomplete = "incomplete"
+)
+
+// v2Envelope is implemented by every V2Response, for respond to add the
+// request's ResponseMeta to whatever its payload type.
+type v2Envelope interface {
+	withRequest(meta *ResponseMeta, requestID string) interface{}
+	stale() bool
+	failure() (message string, failed bool)
+}
+
+func (e V2Response[T]) withRequest(meta *ResponseMeta, requestID string) interface{} {
+	e.Meta.ResponseMeta = meta
+	if e.Error != nil {
+		withID := *e.Error
+		withID.RequestID = requestID
+		e.Error = &withID
+	}
+	return e
+}
+
+func (e V2Response[T]) stale() bool {
+	return e.Meta.Stale
+}
+
+func (e V2Response[T]) failure() (string, bool) {
+	if e.Error == nil {
+		return "", false
+	}
+	return e.Error.Message, true
+}
+
+// isV2 reports whether r is for the /v2 surface.
+func isV2(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/v2/")
+}
+
+func newV2Response[T any](data T, meta V2Meta, err *V2Error) V2Response[T] {
+	return V2Response[T]{Data: data, Meta: meta, Error: err}
+}
+
+// v2List is the V2Response of a list, with its length as meta.Count.
+func v2List[T any](data []T, meta V2Meta) V2Response[[]T] {
+	count := len(data)
+	meta.Count = &count
+	return newV2Response(data, meta, nil)
+}
+
+// v2Failure is the V2Response of a request that failed with status.
+func v2Failure(status int, message string) V2Response[any] {
+	return newV2Response[any](nil, V2Meta{}, &V2Error{Code: v2Code(status), Message: message})
+}
+
+// v2Response returns v as it is sent under /v2. The /v2 handlers build
+// their V2Response themselves; what is left are the failed APIResponses
+// they share with v1, which become a V2Error here.
+func v2Response(status int, v interface{}) interface{} {
+	if resp, ok := v.(APIResponse); ok && !resp.Success {
+		return v2Failure(status, resp.Error)
+	}
+	return v
+}
+
+// writeError answers a request turned away before any handler, as
+// middleware does, with message in the error body of the surface it was
+// made to.
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if isV2(r) {
+		respond(w, r, status, v2Failure(status, message))
+		return
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: false,
+		Error:   message,
+	})
+}
+
+// v2NotFoundHandler answers /v2 requests that match no route. Every /v2
+// route is a GET, so that includes other methods on their paths.
+func v2NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, "No "+r.Method+" endpoint at "+r.URL.Path)
+}
+
+// v2Code returns the V2Error code of a failure sent with status.
+func v2Code(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return v2CodeUnauthorized
+	case status == http.StatusForbidden:
+		return v2CodeForbidden
+	case status == http.StatusNotFound:
+		return v2CodeNotFound
+	case status == http.StatusTooManyRequests:
+		return v2CodeRateLimited
+	case status == http.StatusNotImplemented:
+		return v2CodeNotImplemented
+	case status == http.StatusServiceUnavailable:
+		return v2CodeUpstreamUnavailable
+	case status >= 500:
+		return v2CodeInternal
+	}
+	return v2CodeBadRequest
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/KrissiBT/partasalaScraper/pkg/partasala"
+)
+
+// verifyParsersCommand runs the partasala parsers over a corpus of saved
+// pages and compares what they extract with golden JSON files. The corpus
+// directory holds pages/, laid out like the site (pages/index.html for the
+// homepage, pages/<brand_path>/<slug>/index.html,
+// pages/<car_path>/<slug>/index.html, and pages/<contact_path>/index.html),
+// and golden/ with brands.json, brand_cars/<slug>.json, details/<slug>.json,
+// and contact.json.
+func verifyParsersCommand(config partasala.Config, args []string) error {
+	fs := flag.NewFlagSet("verify-parsers", flag.ExitOnError)
+	dir := fs.String("dir", "testdata/parsers", "Corpus directory with pages/ and golden/")
+	update := fs.Bool("update", false, "Write the current output as the golden files")
+	fs.Parse(args)
+
+	pagesDir := filepath.Join(*dir, "pages")
+	goldenDir := filepath.Join(*dir, "golden")
+	if _, err := os.Stat(pagesDir); err != nil {
+		return fmt.Errorf("no pages to verify: %v", err)
+	}
+
+	parser, err := partasala.NewParser(config)
+	if err != nil {
+		return err
+	}
+
+	checked, failed := 0, 0
+	err = filepath.Walk(pagesDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || info.Name() != "index.html" {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		rel, _ := filepath.Rel(pagesDir, filepath.Dir(path))
+		page := "/" + filepath.ToSlash(rel) + "/"
+		if rel == "." {
+			page = "/"
+		}
+
+		var golden string
+		var parsed interface{}
+		switch {
+		case page == "/":
+			golden = "brands.json"
+			parsed, err = parser.ParseBrands(f)
+		case page == config.ContactPath:
+			golden = "contact.json"
+			parsed, err = parser.ParseContact(f)
+		case strings.HasPrefix(page, config.BrandPath):
+			slug := strings.Trim(strings.TrimPrefix(page, config.BrandPath), "/")
+			golden = filepath.Join("brand_cars", slug+".json")
+			parsed, err = parser.ParseBrandCars(f, slug)
+		case strings.HasPrefix(page, config.CarPath):
+			slug := strings.Trim(strings.TrimPrefix(page, config.CarPath), "/")
+			golden = filepath.Join("details", slug+".json")
+			parsed, err = parser.ParseCarDetails(f, slug)
+		default:
+			return fmt.Errorf("%s: not the homepage, a brand page, a car page, or the contact page", path)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+
+		got, err := json.MarshalIndent(parsed, "", "  ")
+		if err != nil {
+			return err
+		}
+		got = append(got, '\n')
+
+		goldenPath := filepath.Join(goldenDir, golden)
+		checked++
+		if *update {
+			if err := os.MkdirAll(filepath.Dir(goldenPath), 0o755); err != nil {
+				return err
+			}
+			return os.WriteFile(goldenPath, got, 0o644)
+		}
+
+		want, err := os.ReadFile(goldenPath)
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL %s: no golden file (run with -update to create it): %v\n", page, err)
+			return nil
+		}
+		if diff := jsonDiff(want, got); diff != "" {
+			failed++
+			fmt.Printf("FAIL %s: output differs from %s\n%s\n", page, goldenPath, diff)
+			return nil
+		}
+		fmt.Printf("ok   %s\n", page)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if *update {
+		fmt.Printf("Wrote %d golden files to %s\n", checked, goldenDir)
+		return nil
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d pages no longer parse as expected", failed, checked)
+	}
+	fmt.Printf("All %d pages parse as expected\n", checked)
+	return nil
+}
+
+// jsonDiff compares two JSON documents regardless of formatting and key
+// order, and describes the first line where they differ.
+func jsonDiff(want, got []byte) string {
+	normalize := func(data []byte) ([]string, error) {
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		out, err := json.MarshalIndent(v, "", "  ")
+		return strings.Split(string(out), "\n"), err
+	}
+
+	wantLines, err := normalize(want)
+	if err != nil {
+		return fmt.Sprintf("  golden file is not valid JSON: %v", err)
+	}
+	gotLines, _ := normalize(got)
+	for i := 0; i < len(wantLines) || i < len(gotLines); i++ {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w != g {
+			return fmt.Sprintf("  line %d\n  want: %s\n  got:  %s", i+1, strings.TrimSpace(w), strings.TrimSpace(g))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// version, commit, and buildDate are set when building a release:
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+//
+// Without them, commit and buildDate fall back to what the Go toolchain
+// recorded from the git checkout, if anything.
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// Features are the optional parts of the server a deployment runs with.
+type Features struct {
+	Site        string `json:"site"`
+	Store       string `json:"store"`
+	PageCache   bool   `json:"page_cache"`
+	Crawler     bool   `json:"crawler"`
+	Browser     bool   `json:"browser"`
+	SearchSinks int    `json:"search_sinks"`
+}
+
+type VersionInfo struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit"`
+	BuildDate string   `json:"build_date"`
+	GoVersion string   `json:"go_version"`
+	Features  Features `json:"features"`
+}
+
+var features Features
+
+// buildVersion returns the build's version details.
+func buildVersion() VersionInfo {
+	info := VersionInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Features:  features,
+	}
+	// The crawler may have started since, with the first alert or webhook
+	info.Features.Crawler = crawling.Load()
+	if build, ok := debug.ReadBuildInfo(); ok {
+		vcs := map[string]string{}
+		for _, setting := range build.Settings {
+			vcs[setting.Key] = setting.Value
+		}
+		if info.Commit == "" && vcs["vcs.revision"] != "" {
+			info.Commit = vcs["vcs.revision"]
+			if vcs["vcs.modified"] == "true" {
+				info.Commit += "-dirty"
+			}
+		}
+		if info.BuildDate == "" {
+			info.BuildDate = vcs["vcs.time"]
+		}
+	}
+	return info
+}
+
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	respond(w, r, http.StatusOK, buildVersion())
+}
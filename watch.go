@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// watchCommand searches the site for a query every interval and prints the
+// cars that weren't among the results before, until interrupted. The first
+// search only sets the baseline. With -notify, the command is also run
+// through sh for each new car, with the car in PARTASALA_CAR_* variables.
+func watchCommand(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	query := fs.String("query", "", "Search query to watch, e.g. \"land cruiser\"")
+	interval := fs.Duration("interval", 10*time.Minute, "Time between searches")
+	notify := fs.String("notify", "", "Shell command to run for each new car, e.g. notify-send \"$PARTASALA_CAR_NAME\"")
+	output := addOutputFlags(fs, OutputTable)
+	fs.Parse(args)
+
+	if *query == "" {
+		return fmt.Errorf("watch needs a -query")
+	}
+	if *interval < time.Second {
+		return fmt.Errorf("-interval must be at least 1s")
+	}
+
+	printer, err := output.printer(os.Stdout, []string{"seen", "name", "price", "url"})
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var seen map[string]bool
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		cars, err := scraper.SearchCars(strings.ToLower(*query))
+		if err != nil {
+			log.Printf("watch: %v", err)
+		} else if seen == nil {
+			seen = make(map[string]bool, len(cars))
+			for _, car := range cars {
+				seen[car.Slug] = true
+			}
+			log.Printf("watch: %d cars match %q; printing new ones every %s", len(cars), *query, *interval)
+		} else {
+			for _, car := range cars {
+				if seen[car.Slug] {
+					continue
+				}
+				seen[car.Slug] = true
+				record := []string{time.Now().Format("2006-01-02 15:04"), car.Name, watchPrice(car), car.URL}
+				if err := printer.Print(car, record); err != nil {
+					return err
+				}
+				if *notify != "" {
+					if err := runNotify(ctx, *notify, car); err != nil {
+						log.Printf("watch: notify %s: %v", car.Slug, err)
+					}
+				}
+			}
+			if err := printer.Flush(); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func watchPrice(car Car) string {
+	if car.Price == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d %s", car.Price.Amount, car.Price.Currency)
+}
+
+// runNotify runs the -notify command for car.
+func runNotify(ctx context.Context, command string, car Car) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"PARTASALA_CAR_NAME="+car.Name,
+		"PARTASALA_CAR_SLUG="+car.Slug,
+		"PARTASALA_CAR_URL="+car.URL,
+		"PARTASALA_CAR_PRICE="+watchPrice(car),
+	)
+	if car.Year != nil {
+		cmd.Env = append(cmd.Env, "PARTASALA_CAR_YEAR="+strconv.Itoa(*car.Year))
+	}
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
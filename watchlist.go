@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const bucketWatchlist = "watchlist"
+
+// WatchlistEntry is a car someone is watching. Car is its stored listing,
+// or its last-known one with removed_at set once it has been taken down,
+// and nil if no crawl has listed it.
+type WatchlistEntry struct {
+	Slug    string    `json:"slug"`
+	AddedAt time.Time `json:"added_at"`
+	Car     *Car      `json:"car"`
+}
+
+// Watch adds slug to the watchlist of owner, a token hash, returning the
+// entry and whether it is new. Watching a car twice keeps the first entry.
+func (d *Dataset) Watch(owner, slug string) (WatchlistEntry, bool, error) {
+	key := owner + "/" + slug
+	var entry WatchlistEntry
+	err := getJSON(d.store, bucketWatchlist, key, &entry)
+	if err == nil {
+		return entry, false, nil
+	}
+	if err != ErrNotFound {
+		return entry, false, err
+	}
+
+	entry = WatchlistEntry{Slug: slug, AddedAt: time.Now().UTC()}
+	if err := putJSON(d.store, bucketWatchlist, key, entry); err != nil {
+		return entry, false, err
+	}
+	return entry, true, nil
+}
+
+// Unwatch removes slug from the watchlist of owner, or returns ErrNotFound
+// if it isn't on it.
+func (d *Dataset) Unwatch(owner, slug string) error {
+	key := owner + "/" + slug
+	if _, err := d.store.Get(bucketWatchlist, key); err != nil {
+		return err
+	}
+	return d.store.Delete(bucketWatchlist, key)
+}
+
+// Watchlist returns the watchlist of owner in slug order, without Car set.
+func (d *Dataset) Watchlist(owner string) ([]WatchlistEntry, error) {
+	prefix := owner + "/"
+	entries := []WatchlistEntry{}
+	err := d.store.ForEach(bucketWatchlist, func(key string, value []byte) error {
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		var entry WatchlistEntry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return fmt.Errorf("watchlist %s: %v", key, err)
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// WatchlistUpdates returns up to limit changes to the cars on the
+// watchlist of owner since each was added and at or after since, newest
+// first: removals, price changes, and new image counts.
+func (d *Dataset) WatchlistUpdates(owner string, since time.Time, limit int) ([]ChangeEvent, error) {
+	entries, err := d.Watchlist(owner)
+	if err != nil {
+		return nil, err
+	}
+	added := make(map[string]time.Time, len(entries))
+	for _, entry := range entries {
+		added[entry.Slug] = entry.AddedAt
+	}
+
+	filter := EventFilter{Types: []string{EventCarRemoved, EventPriceChanged, EventImageCountChanged}, Since: since}
+	all, err := d.Events(filter, math.MaxInt)
+	if err != nil {
+		return nil, err
+	}
+	events := []ChangeEvent{}
+	for _, event := range all {
+		if at, ok := added[event.Car]; ok && !event.At.Before(at) && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func addWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Slug string `json:"slug"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Slug) == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "Request body must be JSON with a \"slug\" field",
+		})
+		return
+	}
+
+	entry, created, err := dataset.Watch(requestOwner(r), strings.TrimSpace(req.Slug))
+	entries := []WatchlistEntry{entry}
+	if err == nil {
+		err = addWatchedCars(entries)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(rewriteImageURLs(APIResponse{
+		Success: true,
+		Data:    entries[0],
+	}))
+}
+
+func listWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	entries, err := dataset.Watchlist(requestOwner(r))
+	if err == nil {
+		err = addWatchedCars(entries)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(rewriteImageURLs(APIResponse{
+		Success: true,
+		Count:   len(entries),
+		Data:    entries,
+	}))
+}
+
+// addWatchedCars sets Car on entries from the stored cars and the removed
+// car archive.
+func addWatchedCars(entries []WatchlistEntry) error {
+	for i := range entries {
+		var car Car
+		err := getJSON(dataset.store, bucketCars, entries[i].Slug, &car)
+		if err == ErrNotFound {
+			var removed RemovedCar
+			if err = getJSON(dataset.store, bucketRemoved, entries[i].Slug, &removed); err == nil {
+				car = removed.Car
+			}
+		}
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		sighted := []Car{car}
+		annotateCars(sighted)
+		entries[i].Car = &sighted[0]
+	}
+	return nil
+}
+
+func deleteWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	err := dataset.Unwatch(requestOwner(r), mux.Vars(r)["car_slug"])
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "Car is not on the watchlist",
+		})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+	})
+}
+
+func watchlistUpdatesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var since time.Time
+	if v := query.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Error:   "since must be an RFC 3339 time, e.g. 2024-05-01T00:00:00Z",
+			})
+			return
+		}
+		since = t
+	}
+	limit := defaultEventHistoryLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxEventHistoryLimit {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Error:   "limit must be between 1 and " + strconv.Itoa(maxEventHistoryLimit),
+			})
+			return
+		}
+		limit = n
+	}
+
+	events, err := dataset.WatchlistUpdates(requestOwner(r), since, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Count:   len(events),
+		Data:    events,
+	})
+}
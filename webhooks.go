@@ -0,0 +1,684 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	bucketWebhooks = "webhooks"
+	// bucketWebhookDeliveries holds each webhook's delivery log, under its
+	// ID.
+	bucketWebhookDeliveries = "webhook_deliveries"
+)
+
+const (
+	// webhookAttempts is how many times a delivery is tried, waiting
+	// webhookBackoff before the first retry and twice as long before each
+	// one after.
+	webhookAttempts = 5
+	webhookBackoff  = 10 * time.Second
+	// maxWebhookDeliveries is how many deliveries are kept per webhook.
+	maxWebhookDeliveries = 100
+	// webhookQueueSize is how many deliveries may wait for a webhook;
+	// more than that fail at once rather than hold up the crawler.
+	webhookQueueSize = 1000
+)
+
+// Webhook is a URL that gets a signed POST for every car event it is
+// subscribed to. Secret is only shown when the webhook is created. Owner
+// is the hash of the API token that registered it.
+type Webhook struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"-"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// storedWebhook is a Webhook as it is kept in the store, owner and all.
+type storedWebhook struct {
+	Webhook
+	Owner string `json:"owner"`
+}
+
+// Statuses of a WebhookDelivery.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is one event sent to a webhook, with every attempt made
+// so far.
+type WebhookDelivery struct {
+	ID        string           `json:"id"`
+	Event     string           `json:"event"`
+	Car       string           `json:"car"`
+	Status    string           `json:"status"`
+	CreatedAt time.Time        `json:"created_at"`
+	Attempts  []WebhookAttempt `json:"attempts"`
+}
+
+// WebhookAttempt is one try at a delivery. StatusCode is 0 if no response
+// came back.
+type WebhookAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// WebhookStore keeps webhooks and their last maxWebhookDeliveries
+// deliveries in the store. The deliveries are read the first time they are
+// needed and written back as they change. Each webhook has a queue of
+// deliveries and one worker that sends them in order, so one that is down
+// and being retried doesn't hold up the others.
+type WebhookStore struct {
+	store  Store
+	client *http.Client
+
+	mu         sync.Mutex
+	deliveries map[string][]*WebhookDelivery
+	queues     map[string]chan webhookJob
+}
+
+// webhookJob is a delivery waiting in a webhook's queue.
+type webhookJob struct {
+	webhook  Webhook
+	delivery *WebhookDelivery
+	event    CarEvent
+}
+
+func NewWebhookStore(store Store) *WebhookStore {
+	return &WebhookStore{
+		store:      store,
+		client:     newWebhookClient(),
+		deliveries: make(map[string][]*WebhookDelivery),
+		queues:     make(map[string]chan webhookJob),
+	}
+}
+
+func (s *WebhookStore) Add(owner, url string, events []string, secret string) (Webhook, error) {
+	id, err := newID()
+	if err != nil {
+		return Webhook{}, err
+	}
+	if secret == "" {
+		if secret, err = newSecret(); err != nil {
+			return Webhook{}, err
+		}
+	}
+
+	webhook := Webhook{ID: id, Owner: owner, URL: url, Events: events, Secret: secret, CreatedAt: time.Now().UTC()}
+	if err := putJSON(s.store, bucketWebhooks, id, storedWebhook{Webhook: webhook, Owner: owner}); err != nil {
+		return Webhook{}, err
+	}
+	return webhook, nil
+}
+
+// Get returns the webhook with id, without its secret, or ErrNotFound.
+func (s *WebhookStore) Get(id string) (Webhook, error) {
+	var stored storedWebhook
+	if err := getJSON(s.store, bucketWebhooks, id, &stored); err != nil {
+		return Webhook{}, err
+	}
+	webhook := stored.Webhook
+	webhook.Owner = stored.Owner
+	webhook.Secret = ""
+	return webhook, nil
+}
+
+// List returns the webhooks of owner, oldest first, without their
+// secrets.
+func (s *WebhookStore) List(owner string) ([]Webhook, error) {
+	all, err := s.all()
+	webhooks := []Webhook{}
+	for _, webhook := range all {
+		if webhook.Owner == owner {
+			webhook.Secret = ""
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	return webhooks, err
+}
+
+// Len returns how many webhooks there are, of every owner.
+func (s *WebhookStore) Len() (int, error) {
+	all, err := s.all()
+	return len(all), err
+}
+
+// all returns every webhook, secrets included, oldest first.
+func (s *WebhookStore) all() ([]Webhook, error) {
+	webhooks := []Webhook{}
+	err := s.store.ForEach(bucketWebhooks, func(key string, value []byte) error {
+		var stored storedWebhook
+		if err := json.Unmarshal(value, &stored); err != nil {
+			return fmt.Errorf("webhook %s: %v", key, err)
+		}
+		webhook := stored.Webhook
+		webhook.Owner = stored.Owner
+		webhooks = append(webhooks, webhook)
+		return nil
+	})
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+	})
+	return webhooks, err
+}
+
+// Delete deletes the webhook with id and its delivery log, and drops the
+// deliveries still queued for it.
+func (s *WebhookStore) Delete(id string) error {
+	if _, err := s.store.Get(bucketWebhooks, id); err != nil {
+		return err
+	}
+	if err := s.store.Delete(bucketWebhooks, id); err != nil {
+		return err
+	}
+	if err := s.store.Delete(bucketWebhookDeliveries, id); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forget(id)
+	return nil
+}
+
+// DeleteOwner deletes every webhook of owner.
+func (s *WebhookStore) DeleteOwner(owner string) error {
+	webhooks, err := s.List(owner)
+	if err != nil {
+		return err
+	}
+	for _, webhook := range webhooks {
+		if err := s.Delete(webhook.ID); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// forget drops the deliveries of the webhook with id and stops its worker
+// once the deliveries it has are dropped. s.mu must be held.
+func (s *WebhookStore) forget(id string) {
+	if queue, ok := s.queues[id]; ok {
+		close(queue)
+		delete(s.queues, id)
+	}
+	delete(s.deliveries, id)
+}
+
+// forgetDeliveries drops the delivery logs held in memory, so they are
+// read from the store again, as after a restore.
+func (s *WebhookStore) forgetDeliveries() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deliveries = make(map[string][]*WebhookDelivery)
+}
+
+// list returns the deliveries of the webhook with id, oldest first,
+// reading them from the store the first time. Deliveries still pending in
+// the store were cut off by a restart, so they are failed. s.mu must be
+// held.
+func (s *WebhookStore) list(id string) []*WebhookDelivery {
+	if list, ok := s.deliveries[id]; ok {
+		return list
+	}
+	list := []*WebhookDelivery{}
+	if err := getJSON(s.store, bucketWebhookDeliveries, id, &list); err != nil && err != ErrNotFound {
+		log.Printf("webhooks: %v", err)
+		recent.addError("webhook", err)
+	}
+	for _, delivery := range list {
+		if delivery.Status == DeliveryPending {
+			delivery.Status = DeliveryFailed
+			delivery.Attempts = append(delivery.Attempts, WebhookAttempt{At: time.Now().UTC(), Error: "the server restarted before the delivery was sent"})
+		}
+	}
+	s.deliveries[id] = list
+	return list
+}
+
+// save writes the deliveries of the webhook with id back to the store,
+// unless the webhook has been deleted since. s.mu must be held.
+func (s *WebhookStore) save(id string) {
+	if _, ok := s.queues[id]; !ok {
+		return
+	}
+	if err := putJSON(s.store, bucketWebhookDeliveries, id, s.list(id)); err != nil {
+		log.Printf("webhooks: %v", err)
+		recent.addError("webhook", err)
+	}
+}
+
+// Deliveries returns a webhook's deliveries, newest first.
+func (s *WebhookStore) Deliveries(id string) []WebhookDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := s.list(id)
+	deliveries := make([]WebhookDelivery, 0, len(list))
+	for i := len(list) - 1; i >= 0; i-- {
+		d := *list[i]
+		d.Attempts = append([]WebhookAttempt{}, d.Attempts...)
+		deliveries = append(deliveries, d)
+	}
+	return deliveries
+}
+
+// Dispatch queues events for every webhook subscribed to them. A webhook
+// whose queue is full fails the deliveries that don't fit.
+func (s *WebhookStore) Dispatch(events []CarEvent) {
+	webhooks, err := s.all()
+	if err != nil {
+		log.Printf("webhooks: %v", err)
+		recent.addError("webhook", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Webhooks gone from the store without Delete, as in a restore
+	exists := make(map[string]bool, len(webhooks))
+	for _, webhook := range webhooks {
+		exists[webhook.ID] = true
+	}
+	for id := range s.queues {
+		if !exists[id] {
+			s.forget(id)
+		}
+	}
+
+	for _, webhook := range webhooks {
+		queued := false
+		for _, event := range events {
+			if !webhookSubscribed(webhook, event.Type) {
+				continue
+			}
+			queued = true
+			deliveryID, err := newID()
+			if err != nil {
+				log.Printf("webhooks: %v", err)
+				return
+			}
+			delivery := &WebhookDelivery{
+				ID:        deliveryID,
+				Event:     event.Type,
+				Car:       event.Car.Slug,
+				Status:    DeliveryPending,
+				CreatedAt: time.Now().UTC(),
+				Attempts:  []WebhookAttempt{},
+			}
+			s.deliveries[webhook.ID] = append(s.list(webhook.ID), delivery)
+
+			select {
+			case s.queue(webhook.ID) <- webhookJob{webhook: webhook, delivery: delivery, event: event}:
+			default:
+				delivery.Status = DeliveryFailed
+				delivery.Attempts = append(delivery.Attempts, WebhookAttempt{At: delivery.CreatedAt, Error: "delivery queue is full"})
+				recent.addError("webhook", fmt.Errorf("%s: delivery queue is full", webhook.URL))
+			}
+		}
+		if n := len(s.list(webhook.ID)); n > maxWebhookDeliveries {
+			s.deliveries[webhook.ID] = s.deliveries[webhook.ID][n-maxWebhookDeliveries:]
+		}
+		if queued {
+			s.save(webhook.ID)
+		}
+	}
+}
+
+// queue returns the queue of the webhook with id, starting its worker if
+// it has none yet. s.mu must be held.
+func (s *WebhookStore) queue(id string) chan webhookJob {
+	queue, ok := s.queues[id]
+	if !ok {
+		queue = make(chan webhookJob, webhookQueueSize)
+		s.queues[id] = queue
+		go func() {
+			for job := range queue {
+				s.deliver(job.webhook, job.delivery, job.event)
+			}
+		}()
+	}
+	return queue
+}
+
+func webhookSubscribed(webhook Webhook, event string) bool {
+	for _, e := range webhook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// deliver posts event to the webhook until it is accepted, retrying
+// network errors, 429s, and 5xx answers with exponential backoff. Other
+// 4xx answers are final.
+func (s *WebhookStore) deliver(webhook Webhook, delivery *WebhookDelivery, event CarEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("webhooks: %v", err)
+		return
+	}
+
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		// A deleted webhook's deliveries are dropped
+		if _, err := s.store.Get(bucketWebhooks, webhook.ID); err != nil {
+			return
+		}
+		result := s.post(webhook, delivery.ID, event.Type, body)
+
+		s.mu.Lock()
+		delivery.Attempts = append(delivery.Attempts, result)
+		retry := result.StatusCode == 0 || result.StatusCode == http.StatusTooManyRequests || result.StatusCode >= 500
+		switch {
+		case result.Error == "":
+			delivery.Status = DeliveryDelivered
+		case !retry || attempt == webhookAttempts:
+			delivery.Status = DeliveryFailed
+		}
+		status := delivery.Status
+		s.save(webhook.ID)
+		s.mu.Unlock()
+
+		if status != DeliveryPending {
+			recent.addDelivery(Delivery{Notifier: "webhook", Event: event.Type, Error: result.Error})
+			if status == DeliveryFailed {
+				recent.addError("webhook", fmt.Errorf("%s: %s", webhook.URL, result.Error))
+			}
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends one signed attempt. The signature is the hex HMAC-SHA256,
+// keyed with the webhook's secret, of the timestamp header, a dot, and the
+// body, so a captured request can't be replayed with another timestamp.
+func (s *WebhookStore) post(webhook Webhook, deliveryID, event string, body []byte) WebhookAttempt {
+	start := time.Now()
+	attempt := WebhookAttempt{At: start.UTC()}
+
+	timestamp := strconv.FormatInt(start.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(webhook.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "partasala-scraper-webhooks")
+	req.Header.Set("X-Partasala-Event", event)
+	req.Header.Set("X-Partasala-Delivery", deliveryID)
+	req.Header.Set("X-Partasala-Timestamp", timestamp)
+	req.Header.Set("X-Partasala-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := s.client.Do(req)
+	attempt.DurationMS = time.Since(start).Milliseconds()
+	if err != nil {
+		if urlErr, ok := err.(*neturl.Error); ok {
+			err = urlErr.Err
+		}
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		attempt.Error = fmt.Sprintf("status code error: %d %s", resp.StatusCode, resp.Status)
+	}
+	return attempt
+}
+
+// errWebhookAddress is why a webhook URL that leads to a private address
+// is refused.
+var errWebhookAddress = errors.New("webhooks can't be sent to loopback, private, or link-local addresses")
+
+// publicIP reports whether ip is publicly routable, so a webhook can't be
+// aimed at the server itself, its network, or a cloud metadata service.
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip))
+}
+
+// sharedAddressSpace is carrier-grade NAT, RFC 6598, which is private too.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// checkWebhookHost returns errWebhookAddress if host is or resolves to an
+// address that isn't public. Deliveries are checked again as they are
+// dialled, since the name may resolve differently by then.
+func checkWebhookHost(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !publicIP(ip) {
+			return errWebhookAddress
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %v", host, err)
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return errWebhookAddress
+		}
+	}
+	return nil
+}
+
+// newWebhookClient returns the client deliveries are posted with, which
+// refuses to connect to addresses that aren't public, redirects included.
+// It ignores proxy settings, so the address checked is the one dialled.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return errWebhookAddress
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}
+
+func newSecret() (string, error) {
+	first, err := newID()
+	if err != nil {
+		return "", err
+	}
+	second, err := newID()
+	if err != nil {
+		return "", err
+	}
+	return first + second, nil
+}
+
+type WebhookDeliveriesResponse struct {
+	Success bool              `json:"success"`
+	Webhook Webhook           `json:"webhook"`
+	Count   int               `json:"count"`
+	Data    []WebhookDelivery `json:"data"`
+}
+
+func createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Secret string   `json:"secret"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "Request body must be JSON with a \"url\" field",
+		})
+		return
+	}
+
+	u, err := neturl.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "\"url\" must be an http or https URL",
+		})
+		return
+	}
+	if err := checkWebhookHost(r.Context(), u.Hostname()); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	if len(req.Events) == 0 {
+		req.Events = []string{CarEventAdded}
+	}
+	for _, event := range req.Events {
+		switch event {
+		case CarEventAdded, CarEventRemoved, CarEventUpdated:
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Success: false,
+				Error:   fmt.Sprintf("Unknown event %q", event),
+			})
+			return
+		}
+	}
+
+	webhook, err := webhooks.Add(requestOwner(r), u.String(), req.Events, req.Secret)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	startCrawler()
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Data:    webhook,
+	})
+}
+
+func listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := webhooks.List(requestOwner(r))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Count:   len(list),
+		Data:    list,
+	})
+}
+
+// ownWebhook returns the webhook with the request's id, or writes a 404 if
+// there is none. Other tokens' webhooks are answered as if they didn't
+// exist.
+func ownWebhook(w http.ResponseWriter, r *http.Request) (Webhook, bool) {
+	webhook, err := webhooks.Get(mux.Vars(r)["id"])
+	if err == ErrNotFound || err == nil && webhook.Owner != requestOwner(r) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "Webhook not found",
+		})
+		return webhook, false
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return webhook, false
+	}
+	return webhook, true
+}
+
+func deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := ownWebhook(w, r)
+	if !ok {
+		return
+	}
+	if err := webhooks.Delete(webhook.ID); err != nil && err != ErrNotFound {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+	})
+}
+
+func getWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := ownWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries := webhooks.Deliveries(webhook.ID)
+	json.NewEncoder(w).Encode(WebhookDeliveriesResponse{
+		Success: true,
+		Webhook: webhook,
+		Count:   len(deliveries),
+		Data:    deliveries,
+	})
+}
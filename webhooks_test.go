@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestWebhookSignature checks that a delivery is signed with the HMAC of
+// its timestamp and body.
+func TestWebhookSignature(t *testing.T) {
+	var header http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	s := NewWebhookStore(NewMemoryStore())
+	// The test server is on loopback, which the delivery client refuses
+	s.client = server.Client()
+	webhook := Webhook{ID: "hook", URL: server.URL, Secret: "secret"}
+	attempt := s.post(webhook, "delivery", CarEventAdded, []byte(`{"type":"car.added"}`))
+	if attempt.Error != "" || attempt.StatusCode != http.StatusOK {
+		t.Fatalf("attempt = %+v", attempt)
+	}
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(header.Get("X-Partasala-Timestamp") + "."))
+	mac.Write(body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); header.Get("X-Partasala-Signature") != want {
+		t.Errorf("X-Partasala-Signature = %q, want %q", header.Get("X-Partasala-Signature"), want)
+	}
+	if header.Get("X-Partasala-Event") != CarEventAdded || header.Get("X-Partasala-Delivery") != "delivery" {
+		t.Errorf("event and delivery headers = %q, %q", header.Get("X-Partasala-Event"), header.Get("X-Partasala-Delivery"))
+	}
+	if string(body) != `{"type":"car.added"}` {
+		t.Errorf("body = %s", body)
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"fc00::1", false},
+		{"fe80::1", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := publicIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("publicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestCheckWebhookHost(t *testing.T) {
+	for _, host := range []string{"127.0.0.1", "169.254.169.254", "::1"} {
+		if err := checkWebhookHost(context.Background(), host); err != errWebhookAddress {
+			t.Errorf("checkWebhookHost(%s) = %v, want errWebhookAddress", host, err)
+		}
+	}
+	if err := checkWebhookHost(context.Background(), "93.184.216.34"); err != nil {
+		t.Errorf("checkWebhookHost(93.184.216.34) = %v", err)
+	}
+}
+
+// TestWebhookClientRefusesPrivateAddresses checks that the address is
+// checked again as it is dialled, whatever the URL was checked as.
+func TestWebhookClientRefusesPrivateAddresses(t *testing.T) {
+	reached := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	defer server.Close()
+
+	client := newWebhookClient()
+	if _, err := client.Post(server.URL, "application/json", nil); !errors.Is(err, errWebhookAddress) {
+		t.Errorf("posting to %s: %v, want errWebhookAddress", server.URL, err)
+	}
+	if reached {
+		t.Error("the private server was reached")
+	}
+}
+
+// TestWebhookDeliveriesPersist checks that the delivery log is read back
+// by a new WebhookStore, with deliveries a restart cut off failed.
+func TestWebhookDeliveriesPersist(t *testing.T) {
+	store := NewMemoryStore()
+	s := NewWebhookStore(store)
+	webhook, err := s.Add("owner", "http://127.0.0.1:1/", []string{CarEventAdded}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Hold the worker back so the delivery stays pending
+	s.mu.Lock()
+	s.queues[webhook.ID] = make(chan webhookJob, webhookQueueSize)
+	s.mu.Unlock()
+	s.Dispatch([]CarEvent{{Type: CarEventAdded, At: time.Now(), Car: Car{Slug: "toyota-yaris"}}})
+	if deliveries := s.Deliveries(webhook.ID); len(deliveries) != 1 || deliveries[0].Status != DeliveryPending {
+		t.Fatalf("deliveries = %+v, want one pending", deliveries)
+	}
+
+	restarted := NewWebhookStore(store)
+	deliveries := restarted.Deliveries(webhook.ID)
+	if len(deliveries) != 1 || deliveries[0].Car != "toyota-yaris" {
+		t.Fatalf("deliveries after a restart = %+v", deliveries)
+	}
+	if deliveries[0].Status != DeliveryFailed || len(deliveries[0].Attempts) != 1 {
+		t.Errorf("cut off delivery = %+v, want failed with an attempt", deliveries[0])
+	}
+
+	if err := restarted.Delete(webhook.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(bucketWebhookDeliveries, webhook.ID); err != ErrNotFound {
+		t.Errorf("delivery log after Delete: %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// xlsxCell is one spreadsheet cell: a string, or an int written as a
+// number. Link makes a string cell a hyperlink to itself.
+type xlsxCell struct {
+	Value interface{}
+	Link  bool
+}
+
+// xlsxSheet is one worksheet whose first row is a bold header.
+type xlsxSheet struct {
+	Name string
+	Rows [][]xlsxCell
+}
+
+// writeXLSX writes sheets as an Office Open XML workbook. It only covers
+// what the exports need: inline strings, numbers, hyperlinks, and a bold
+// header row.
+func writeXLSX(w io.Writer, sheets []xlsxSheet) error {
+	type file struct {
+		name, body string
+	}
+	files := []file{
+		{"[Content_Types].xml", xlsxContentTypes(len(sheets))},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+		{"xl/workbook.xml", xlsxWorkbook(sheets)},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels(len(sheets))},
+		{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><fonts count="3"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font><font><u/><sz val="11"/><color rgb="FF0563C1"/><name val="Calibri"/></font></fonts><fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills><borders count="1"><border/></borders><cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs><cellXfs count="3"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/><xf numFmtId="0" fontId="2" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs></styleSheet>`},
+	}
+	for i, sheet := range sheets {
+		body, rels := xlsxWorksheet(sheet)
+		files = append(files, file{fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), body})
+		if rels != "" {
+			files = append(files, file{fmt.Sprintf("xl/worksheets/_rels/sheet%d.xml.rels", i+1), rels})
+		}
+	}
+
+	zw := zip.NewWriter(w)
+	for _, file := range files {
+		f, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, file.body); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func xlsxContentTypes(sheets int) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	for i := 1; i <= sheets; i++ {
+		fmt.Fprintf(&b, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i)
+	}
+	b.WriteString(`</Types>`)
+	return b.String()
+}
+
+func xlsxWorkbook(sheets []xlsxSheet) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	for i, sheet := range sheets {
+		fmt.Fprintf(&b, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlEscape(sheet.Name), i+1, i+1)
+	}
+	b.WriteString(`</sheets></workbook>`)
+	return b.String()
+}
+
+func xlsxWorkbookRels(sheets int) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i := 1; i <= sheets; i++ {
+		fmt.Fprintf(&b, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i, i)
+	}
+	fmt.Fprintf(&b, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, sheets+1)
+	b.WriteString(`</Relationships>`)
+	return b.String()
+}
+
+// xlsxWorksheet returns a sheet's XML and, if it has hyperlinks, the
+// relationships they point through.
+func xlsxWorksheet(sheet xlsxSheet) (string, string) {
+	var b, links, rels strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews><sheetData>`)
+	linkCount := 0
+	for i, row := range sheet.Rows {
+		fmt.Fprintf(&b, `<row r="%d">`, i+1)
+		for j, cell := range row {
+			ref := xlsxColumn(j) + strconv.Itoa(i+1)
+			style := 0
+			if i == 0 {
+				style = 1
+			}
+			switch v := cell.Value.(type) {
+			case int:
+				fmt.Fprintf(&b, `<c r="%s" s="%d"><v>%d</v></c>`, ref, style, v)
+			case string:
+				if v == "" {
+					continue
+				}
+				if cell.Link && i > 0 {
+					style = 2
+					linkCount++
+					fmt.Fprintf(&links, `<hyperlink ref="%s" r:id="rId%d"/>`, ref, linkCount)
+					fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/hyperlink" Target="%s" TargetMode="External"/>`, linkCount, xmlEscape(v))
+				}
+				fmt.Fprintf(&b, `<c r="%s" s="%d" t="inlineStr"><is><t>%s</t></is></c>`, ref, style, xmlEscape(v))
+			}
+		}
+		b.WriteString(`</row>`)
+	}
+	b.WriteString(`</sheetData>`)
+	if linkCount == 0 {
+		b.WriteString(`</worksheet>`)
+		return b.String(), ""
+	}
+	b.WriteString(`<hyperlinks>` + links.String() + `</hyperlinks></worksheet>`)
+	return b.String(), `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` + rels.String() + `</Relationships>`
+}
+
+// xlsxColumn returns the letters of column i, counting from 0: A, ..., Z,
+// AA, AB, ...
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// xlsxSheetName makes name a valid sheet name that isn't in used: at most 31
+// characters, none of []:*?/\, and unique regardless of case.
+func xlsxSheetName(name string, used map[string]bool) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return ' '
+		}
+		return r
+	}, name)
+	name = strings.Trim(strings.TrimSpace(name), "'")
+	if name == "" {
+		name = "Sheet"
+	}
+	base := []rune(name)
+	candidate := string(base[:min(len(base), 31)])
+	for n := 2; used[strings.ToLower(candidate)]; n++ {
+		suffix := fmt.Sprintf(" (%d)", n)
+		candidate = string(base[:min(len(base), 31-len(suffix))]) + suffix
+	}
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+var carSheetHeader = []xlsxCell{{Value: "Name"}, {Value: "Slug"}, {Value: "URL"}, {Value: "Year"}, {Value: "Thumbnail"}}
+
+// exportCarsXLSXHandler serves every car as an Excel workbook with one
+// sheet per brand, for people who work in spreadsheets rather than JSON.
+// Cars come from the search index once a crawl has filled it, and
+// otherwise from the site, or the store while upstream is down.
+func exportCarsXLSXHandler(w http.ResponseWriter, r *http.Request) {
+	cars, err := searchIndex.Cars()
+	if len(cars) == 0 {
+		cars, err = siteFor(r).GetAllCars()
+		if err != nil && upstreamDown() {
+			cars, err = dataset.Cars()
+		}
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	cars = rewriteImageURLs(cars).([]Car)
+
+	brandNames := map[string]string{}
+	if brands, err := dataset.Brands(); err == nil {
+		for _, brand := range brands {
+			brandNames[brand.Slug] = brand.Name
+		}
+	}
+	byBrand := map[string][]Car{}
+	for _, car := range cars {
+		byBrand[car.Brand] = append(byBrand[car.Brand], car)
+	}
+	slugs := make([]string, 0, len(byBrand))
+	for slug := range byBrand {
+		slugs = append(slugs, slug)
+	}
+	// Sheets go in brand order, with cars no brand lists last
+	sort.Slice(slugs, func(i, j int) bool {
+		if (slugs[i] == "") != (slugs[j] == "") {
+			return slugs[j] == ""
+		}
+		return slugs[i] < slugs[j]
+	})
+
+	sheets := []xlsxSheet{}
+	used := map[string]bool{}
+	for _, slug := range slugs {
+		name := brandNames[slug]
+		if name == "" {
+			name = slug
+		}
+		if slug == "" {
+			name = "Other"
+		}
+		rows := [][]xlsxCell{carSheetHeader}
+		for _, car := range byBrand[slug] {
+			row := []xlsxCell{{Value: car.Name}, {Value: car.Slug}, {Value: car.URL, Link: true}, {Value: ""}, {Value: "", Link: true}}
+			if car.Year != nil {
+				row[3].Value = *car.Year
+			}
+			if car.Thumbnail != nil {
+				row[4].Value = *car.Thumbnail
+			}
+			rows = append(rows, row)
+		}
+		sheets = append(sheets, xlsxSheet{Name: xlsxSheetName(name, used), Rows: rows})
+	}
+	if len(sheets) == 0 {
+		sheets = append(sheets, xlsxSheet{Name: "Cars", Rows: [][]xlsxCell{carSheetHeader}})
+	}
+
+	var buf bytes.Buffer
+	if err := writeXLSX(&buf, sheets); err != nil {
+		log.Printf("xlsx: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Success: false,
+			Error:   "Failed to write the workbook",
+		})
+		return
+	}
+	filename := fmt.Sprintf("partasala-cars-%s.xlsx", time.Now().UTC().Format("20060102"))
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Write(buf.Bytes())
+}